@@ -2,6 +2,7 @@ package main
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 	"log"
 	"os"
@@ -13,6 +14,7 @@ import (
 	"pihole-analyzer/internal/cli"
 	"pihole-analyzer/internal/config"
 	"pihole-analyzer/internal/dhcp"
+	"pihole-analyzer/internal/dns"
 	"pihole-analyzer/internal/interfaces"
 	"pihole-analyzer/internal/logger"
 	"pihole-analyzer/internal/metrics"
@@ -40,6 +42,9 @@ func main() {
 	if err != nil {
 		log.Fatalf("Error loading configuration: %v", err)
 	}
+	if err := loadDNSConfigFile(*flags.DNSConfig, &cfg.DNS); err != nil {
+		log.Fatalf("Error loading DNS configuration: %v", err)
+	}
 
 	// Initialize structured logger
 	loggerConfig := &logger.Config{
@@ -72,6 +77,15 @@ func main() {
 	// Print startup information
 	cli.PrintStartupInfo(flags, cfg)
 
+	// Handle DNS server mode, which runs the web and DHCP servers alongside
+	if cfg.DNS.Enabled {
+		if err := runDNSMode(flags, configPath, cfg, appLogger); err != nil {
+			appLogger.Error("Error running DNS server: %v", err)
+			os.Exit(1)
+		}
+		return
+	}
+
 	// Handle web mode
 	if cfg.Web.Enabled {
 		if err := runWebMode(flags, cfg, appLogger); err != nil {
@@ -81,16 +95,6 @@ func main() {
 		return
 	}
 
-	// Handle DNS server mode
-	// DNS server mode temporarily disabled due to missing runDNSMode implementation
-	// if cfg.DNS.Enabled {
-	//     if err := runDNSMode(flags, cfg, appLogger); err != nil {
-	//         appLogger.Error("Error running DNS server: %v", err)
-	//         os.Exit(1)
-	//     }
-	//     return
-	// }
-
 	// Handle Pi-hole specific operations
 	if *flags.Pihole != "" {
 		if err := analyzePihole(*flags.Pihole, cfg, appLogger); err != nil {
@@ -288,18 +292,8 @@ func runWebMode(flags *cli.Flags, cfg *types.Config, appLogger *logger.Logger) e
 		return fmt.Errorf("failed to create data source adapter: %w", err)
 	}
 
-	// Create web server configuration
-	webConfig := &web.Config{
-		Port:         cfg.Web.Port,
-		Host:         cfg.Web.Host,
-		EnableWeb:    cfg.Web.Enabled,
-		ReadTimeout:  time.Duration(cfg.Web.ReadTimeout) * time.Second,
-		WriteTimeout: time.Duration(cfg.Web.WriteTimeout) * time.Second,
-		IdleTimeout:  time.Duration(cfg.Web.IdleTimeout) * time.Second,
-	}
-
 	// Create and start web server
-	server, err := web.NewServer(webConfig, adapter, webLogger)
+	server, err := web.NewServer(webServerConfig(cfg), adapter, webLogger)
 	if err != nil {
 		return fmt.Errorf("failed to create web server: %w", err)
 	}
@@ -375,6 +369,287 @@ func runWebMode(flags *cli.Flags, cfg *types.Config, appLogger *logger.Logger) e
 	return nil
 }
 
+// dnsDrainTimeout bounds how long in-flight DNS queries may take to finish
+// on shutdown
+const dnsDrainTimeout = 10 * time.Second
+
+func runDNSMode(flags *cli.Flags, configPath string, cfg *types.Config, appLogger *logger.Logger) error {
+	dnsLogger := appLogger.Component("dns-server")
+
+	dnsLogger.InfoFields("Starting DNS server mode", map[string]any{
+		"host":        cfg.DNS.Host,
+		"port":        cfg.DNS.Port,
+		"web_enabled": cfg.Web.Enabled,
+		"dhcp":        cfg.DHCP.Enabled,
+	})
+
+	dnsConfig := dns.ConvertConfig(cfg.DNS)
+	if err := dnsConfig.Validate(); err != nil {
+		return fmt.Errorf("invalid DNS configuration: %w", err)
+	}
+	dnsServer := dns.NewServer(dnsConfig, dnsLogger).(*dns.Server)
+
+	// Create context for the servers running alongside
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// SIGHUP reloads the configuration, SIGINT and SIGTERM shut down
+	sigChan := make(chan os.Signal, 1)
+	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP)
+	defer signal.Stop(sigChan)
+
+	serverErrChan := make(chan error, 2)
+	go func() {
+		if err := dnsServer.Start(ctx); err != nil {
+			serverErrChan <- fmt.Errorf("DNS server failed: %w", err)
+		}
+	}()
+
+	// Expose server statistics through the metrics endpoint
+	var metricsCollector *metrics.Collector
+	if cfg.Metrics.Enabled && cfg.Metrics.EnableEndpoint {
+		metricsCollector = metrics.New(appLogger.GetSlogger())
+		err := metricsCollector.RegisterDNSServer(func() metrics.DNSServerStats {
+			return dnsServerMetrics(dnsServer.GetStats(), dnsServer.GetUpstreamStats())
+		})
+		if err != nil {
+			return fmt.Errorf("failed to register DNS server metrics: %w", err)
+		}
+
+		metricsServer := metrics.NewServer(metrics.ServerConfig{
+			Port:    cfg.Metrics.Port,
+			Host:    cfg.Metrics.Host,
+			Enabled: cfg.Metrics.EnableEndpoint,
+		}, metricsCollector, appLogger.GetSlogger())
+		metricsServer.StartInBackground()
+		defer metricsServer.Stop(context.Background())
+	}
+
+	// Serve DHCP leases' hostnames as local records
+	var dhcpServer dhcp.DHCPServer
+	if cfg.DHCP.Enabled {
+		dhcpLogger := dnsLogger.Component("dhcp-server")
+		server, err := createDHCPServer(cfg, dhcpLogger)
+		if err != nil {
+			dnsLogger.Error("Failed to create DHCP server: %v", err)
+		} else if err := server.Start(ctx); err != nil {
+			dnsLogger.Error("DHCP server failed to start: %v", err)
+		} else {
+			dhcpServer = server
+			defer func() {
+				dhcpLogger.Info("Stopping DHCP server")
+				stopCtx, stopCancel := context.WithTimeout(context.Background(), 5*time.Second)
+				defer stopCancel()
+
+				if err := dhcpServer.Stop(stopCtx); err != nil {
+					dhcpLogger.Error("Error stopping DHCP server: %v", err)
+				}
+			}()
+
+			leaseRecords := dns.NewLeaseRecords(dnsServer.LocalRecords(), cfg.DHCP.Options.DomainName, dnsLogger)
+			if detach, err := leaseRecords.Attach(ctx, dhcpServer.LeaseManager()); err != nil {
+				dnsLogger.Warn("DHCP hostnames will not resolve: %v", err)
+			} else {
+				defer detach()
+			}
+		}
+	}
+
+	// Run ML and alerts over the server's query log and rate limiting
+	if cfg.Alerts.Enabled || cfg.ML.AnomalyDetection.Enabled || cfg.ML.TrendAnalysis.Enabled {
+		analysisLogger := appLogger.Component("dns-analysis")
+
+		enhancedAnalyzer := analyzer.NewEnhancedAnalyzer(cfg, analysisLogger, metricsCollector)
+		enhancedAnalyzer.SetDataSource(dns.NewServerDataSource(dnsServer, analysisLogger))
+		if err := enhancedAnalyzer.Initialize(ctx); err != nil {
+			dnsLogger.Warn("Analysis and alerts are disabled: %v", err)
+		} else {
+			analysisDone := make(chan struct{})
+			go func() {
+				defer close(analysisDone)
+				enhancedAnalyzer.Run(ctx, dnsAnalysisInterval(cfg))
+			}()
+			defer func() {
+				cancel()
+				<-analysisDone
+				enhancedAnalyzer.Close()
+			}()
+		}
+	}
+
+	// Serve the dashboard from the server's query log, with DoH, the
+	// local records API and the block page
+	webDone := make(chan struct{})
+	if cfg.Web.Enabled {
+		webLogger := appLogger.Component("web-mode")
+
+		dataSource := dns.NewServerDataSource(dnsServer, webLogger)
+		if err := dataSource.Connect(ctx); err != nil {
+			webLogger.Warn("Dashboard will show no queries: %v", err)
+		}
+
+		adapter, err := web.NewDataSourceAdapter(dataSource, cfg, webLogger)
+		if err != nil {
+			return fmt.Errorf("failed to create data source adapter: %w", err)
+		}
+
+		server, err := web.NewServer(webServerConfig(cfg), adapter, webLogger)
+		if err != nil {
+			return fmt.Errorf("failed to create web server: %w", err)
+		}
+		server.RegisterDoHRoutes(dnsServer)
+		server.RegisterDNSRecordRoutes(dnsServer.LocalRecords())
+		server.RegisterBlockPage(dnsServer)
+		server.RegisterScheduleRoutes(dnsServer.Rules())
+		if dhcpServer != nil {
+			server.RegisterDHCPRoutes(dhcpServer)
+		}
+
+		go func() {
+			defer close(webDone)
+			webLogger.Success("Web interface starting on http://%s:%d", cfg.Web.Host, cfg.Web.Port)
+			if err := server.Start(ctx); err != nil {
+				serverErrChan <- fmt.Errorf("web server failed: %w", err)
+			}
+		}()
+	} else {
+		close(webDone)
+	}
+
+	for {
+		select {
+		case sig := <-sigChan:
+			if sig == syscall.SIGHUP {
+				reloadDNSConfig(flags, configPath, dnsServer, dnsLogger)
+				continue
+			}
+
+			dnsLogger.Info("Received shutdown signal: %v", sig)
+			stopCtx, stopCancel := context.WithTimeout(context.Background(), dnsDrainTimeout)
+			err := dnsServer.Stop(stopCtx)
+			stopCancel()
+
+			cancel()
+			<-webDone
+			return err
+
+		case err := <-serverErrChan:
+			stopCtx, stopCancel := context.WithTimeout(context.Background(), dnsDrainTimeout)
+			dnsServer.Stop(stopCtx)
+			stopCancel()
+			return err
+		}
+	}
+}
+
+// reloadDNSConfig re-reads the configuration files with the same
+// command-line overrides and applies them to the running DNS server. On
+// error the current configuration stays in effect.
+func reloadDNSConfig(flags *cli.Flags, configPath string, server *dns.Server, dnsLogger *logger.Logger) {
+	dnsLogger.Info("Reloading configuration from %s", configPath)
+
+	cfg, err := config.LoadConfig(configPath)
+	if err != nil {
+		dnsLogger.Error("Failed to reload configuration: %v", err)
+		return
+	}
+	if err := loadDNSConfigFile(*flags.DNSConfig, &cfg.DNS); err != nil {
+		dnsLogger.Error("Failed to reload DNS configuration: %v", err)
+		return
+	}
+	cli.ApplyFlags(flags, cfg)
+
+	if err := server.Reload(dns.ConvertConfig(cfg.DNS)); err != nil {
+		dnsLogger.Error("Failed to apply DNS configuration: %v", err)
+		return
+	}
+	dnsLogger.Success("DNS configuration reloaded")
+}
+
+// loadDNSConfigFile overlays a DNS server configuration file, given with
+// --dns-config, on the DNS section of the configuration
+func loadDNSConfigFile(path string, dnsConfig *types.DNSConfig) error {
+	if path == "" {
+		return nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("error reading DNS config file: %w", err)
+	}
+	if err := json.Unmarshal(data, dnsConfig); err != nil {
+		return fmt.Errorf("error parsing DNS config file: %w", err)
+	}
+	return nil
+}
+
+// dnsAnalysisInterval returns how often the DNS server's queries are
+// analyzed, which is the alert evaluation interval
+func dnsAnalysisInterval(cfg *types.Config) time.Duration {
+	if interval, err := time.ParseDuration(cfg.Alerts.Performance.EvaluationInterval); err == nil && interval > 0 {
+		return interval
+	}
+	return 30 * time.Second
+}
+
+// dnsServerMetrics converts DNS server and upstream statistics for the
+// metrics collector
+func dnsServerMetrics(stats *dns.ServerStats, upstreams []dns.UpstreamStats) metrics.DNSServerStats {
+	converted := metrics.DNSServerStats{
+		StartTime:            stats.StartTime,
+		AverageLatency:       stats.AverageLatency,
+		QueriesReceived:      stats.QueriesReceived,
+		QueriesAnswered:      stats.QueriesAnswered,
+		QueriesForwarded:     stats.QueriesForwarded,
+		QueriesBlocked:       stats.QueriesBlocked,
+		CNAMEBlocked:         stats.CNAMEBlocked,
+		QueriesAllowed:       stats.QueriesAllowed,
+		LocalAnswers:         stats.LocalAnswers,
+		RewrittenAnswers:     stats.RewrittenAnswers,
+		CacheHits:            stats.CacheHits,
+		CacheMisses:          stats.CacheMisses,
+		Errors:               stats.Errors,
+		TruncatedReplies:     stats.TruncatedReplies,
+		UDPQueries:           stats.UDPQueries,
+		TCPQueries:           stats.TCPQueries,
+		DoTQueries:           stats.DoTQueries,
+		DoHQueries:           stats.DoHQueries,
+		DNSSECSecure:         stats.DNSSECSecure,
+		DNSSECInsecure:       stats.DNSSECInsecure,
+		DNSSECBogus:          stats.DNSSECBogus,
+		RateLimitedQueries:   stats.RateLimitedQueries,
+		RateLimitedResponses: stats.RateLimitedResponses,
+		RateLimitDropped:     stats.RateLimitDropped,
+		RateLimitRefused:     stats.RateLimitRefused,
+		RateLimitTruncated:   stats.RateLimitTruncated,
+	}
+
+	for _, upstream := range upstreams {
+		converted.Upstreams = append(converted.Upstreams, metrics.DNSUpstreamStats{
+			Upstream:       upstream.Upstream,
+			Zone:           upstream.Zone,
+			Healthy:        upstream.Healthy,
+			Queries:        upstream.Queries,
+			Failures:       upstream.Failures,
+			AverageLatency: upstream.AverageLatency,
+			FailureRate:    upstream.FailureRate,
+		})
+	}
+	return converted
+}
+
+// webServerConfig returns the web server settings of the configuration
+func webServerConfig(cfg *types.Config) *web.Config {
+	return &web.Config{
+		Port:         cfg.Web.Port,
+		Host:         cfg.Web.Host,
+		EnableWeb:    cfg.Web.Enabled,
+		ReadTimeout:  time.Duration(cfg.Web.ReadTimeout) * time.Second,
+		WriteTimeout: time.Duration(cfg.Web.WriteTimeout) * time.Second,
+		IdleTimeout:  time.Duration(cfg.Web.IdleTimeout) * time.Second,
+	}
+}
+
 func loadPiholeConfig(configFile string) (*types.PiholeConfig, error) {
 	// This is a simplified version - in a real implementation,
 	// you would parse the Pi-hole configuration file
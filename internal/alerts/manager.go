@@ -201,6 +201,15 @@ func (m *Manager) prepareEvaluationData(data *types.AnalysisResult, mlResults *m
 		evaluationData["slow_queries"] = data.Performance.SlowQueries
 	}
 
+	// Add DNS server rate limiting if available
+	if data.RateLimit != nil {
+		evaluationData["rate_limited_queries"] = data.RateLimit.LimitedQueries
+		evaluationData["rate_limited_responses"] = data.RateLimit.LimitedResponses
+		evaluationData["rate_limit_dropped"] = data.RateLimit.Dropped
+		evaluationData["rate_limit_refused"] = data.RateLimit.Refused
+		evaluationData["rate_limit_truncated"] = data.RateLimit.Truncated
+	}
+
 	// Add ML results if available
 	if mlResults != nil {
 		evaluationData["anomaly_count"] = len(mlResults.Anomalies)
@@ -263,9 +272,33 @@ func (m *Manager) evaluateRule(ctx context.Context, rule AlertRule, data map[str
 		return m.FireAlert(ctx, alert)
 	}
 
+	m.resolveRuleAlerts(ctx, rule.ID)
 	return nil
 }
 
+// resolveRuleAlerts resolves the active alerts fired by a rule whose
+// conditions no longer hold
+func (m *Manager) resolveRuleAlerts(ctx context.Context, ruleID string) {
+	source := fmt.Sprintf("rule:%s", ruleID)
+
+	m.activeAlertsMux.RLock()
+	var resolved []string
+	for id, alert := range m.activeAlerts {
+		if alert.Source == source {
+			resolved = append(resolved, id)
+		}
+	}
+	m.activeAlertsMux.RUnlock()
+
+	for _, id := range resolved {
+		if err := m.ResolveAlert(ctx, id); err != nil {
+			m.logger.GetSlogger().Debug("Failed to resolve alert",
+				slog.String("alert_id", id),
+				slog.String("error", err.Error()))
+		}
+	}
+}
+
 // FireAlert fires an alert and sends notifications
 func (m *Manager) FireAlert(ctx context.Context, alert *Alert) error {
 	m.logger.GetSlogger().Info("Firing alert",
@@ -2,9 +2,11 @@ package alerts
 
 import (
 	"context"
+	"net"
 	"testing"
 	"time"
 
+	"pihole-analyzer/internal/dns"
 	"pihole-analyzer/internal/logger"
 	"pihole-analyzer/internal/ml"
 	"pihole-analyzer/internal/types"
@@ -499,3 +501,114 @@ func TestRuleManagement(t *testing.T) {
 		t.Error("expected error when removing non-existent rule")
 	}
 }
+
+// TestRateLimitEvaluationData tests that DNS server rate limiting reaches rule evaluation
+func TestRateLimitEvaluationData(t *testing.T) {
+	logger := logger.New(logger.DefaultConfig())
+	manager := NewManager(DefaultAlertConfig(), logger)
+
+	data := manager.prepareEvaluationData(&types.AnalysisResult{
+		TotalQueries: 100,
+		RateLimit: &types.RateLimitStats{
+			LimitedQueries:   40,
+			LimitedResponses: 5,
+			Refused:          45,
+		},
+	}, nil)
+
+	triggered, err := NewEvaluator(logger).EvaluateConditions([]AlertCondition{
+		{Field: "rate_limited_queries", Operator: "gt", Value: 30},
+	}, data)
+	if err != nil {
+		t.Fatalf("failed to evaluate conditions: %v", err)
+	}
+	if !triggered {
+		t.Error("expected rate limit condition to trigger")
+	}
+	if data["rate_limit_refused"] != int64(45) {
+		t.Errorf("expected 45 refused queries, got %v", data["rate_limit_refused"])
+	}
+
+	data = manager.prepareEvaluationData(&types.AnalysisResult{TotalQueries: 100}, nil)
+	if _, ok := data["rate_limited_queries"]; ok {
+		t.Error("expected no rate limit data without a DNS server")
+	}
+}
+
+// TestRateLimitAlertFromDNSServer tests that an alert fires on the rate
+// limiting of a running DNS server and clears once it stops
+func TestRateLimitAlertFromDNSServer(t *testing.T) {
+	logger := logger.New(&logger.Config{Level: logger.LevelError})
+
+	dnsConfig := dns.DefaultConfig()
+	dnsConfig.LogQueries = false
+	dnsConfig.Forwarder.HealthCheck = false
+	dnsConfig.Local.Zones = []string{"home.example"}
+	dnsConfig.RateLimit.Enabled = true
+	dnsConfig.RateLimit.QueriesPerSecond = 0.01
+	dnsConfig.RateLimit.Burst = 1
+	dnsConfig.RateLimit.Action = dns.RateLimitActionDrop
+	server := dns.NewServer(dnsConfig, logger).(*dns.Server)
+	if err := server.ReloadLocalRecords(); err != nil {
+		t.Fatalf("failed to load local records: %v", err)
+	}
+
+	config := DefaultAlertConfig()
+	config.Rules = []AlertRule{{
+		ID:         "dns-rate-limited",
+		Name:       "Clients are rate limited",
+		Enabled:    true,
+		Type:       AlertTypeThreshold,
+		Severity:   SeverityWarning,
+		Conditions: []AlertCondition{{Field: "rate_limit_dropped", Operator: "gte", Value: 3}},
+	}}
+	manager := NewManager(config, logger)
+	if err := manager.Initialize(context.Background(), config); err != nil {
+		t.Fatalf("failed to initialize manager: %v", err)
+	}
+	defer manager.Close()
+
+	dataSource := dns.NewServerDataSource(server, logger)
+	process := func() []*Alert {
+		rateLimit, err := dataSource.GetRateLimitStats(context.Background())
+		if err != nil {
+			t.Fatalf("failed to get rate limit stats: %v", err)
+		}
+		if err := manager.ProcessData(context.Background(), &types.AnalysisResult{RateLimit: rateLimit}, nil); err != nil {
+			t.Fatalf("failed to process data: %v", err)
+		}
+		active, err := manager.GetActiveAlerts()
+		if err != nil {
+			t.Fatalf("failed to get active alerts: %v", err)
+		}
+		return active
+	}
+
+	query := func(n int) {
+		for i := 0; i < n; i++ {
+			server.HandleQuery(context.Background(), &dns.DNSQuery{
+				ID:       uint16(i),
+				Question: dns.DNSQuestion{Name: "nas.home.example", Type: dns.TypeA, Class: dns.ClassIN},
+				Client:   &net.UDPAddr{IP: net.ParseIP("192.168.1.20"), Port: 5000},
+				Protocol: "udp",
+			})
+		}
+	}
+
+	// One query within the burst and two dropped stay below the threshold
+	query(3)
+	if active := process(); len(active) != 0 {
+		t.Fatalf("expected no alerts with %d dropped queries, got %d", server.GetStats().RateLimitDropped, len(active))
+	}
+
+	query(3)
+	active := process()
+	if len(active) != 1 || active[0].Title != "Clients are rate limited" {
+		t.Errorf("expected the rate limit alert with %d dropped queries, got %+v", server.GetStats().RateLimitDropped, active)
+	}
+
+	// No queries dropped since the previous evaluation
+	if active := process(); len(active) != 0 {
+		t.Errorf("expected the rate limit alert to clear, got %+v", active)
+	}
+}
@@ -32,17 +32,30 @@ func NewEnhancedAnalyzer(config *types.Config, logger *logger.Logger, metricsCol
 	}
 }
 
+// SetDataSource makes the analyzer use the given data source, such as the
+// query log of the embedded DNS server, instead of creating an API one.
+// It must be called before Initialize.
+func (a *EnhancedAnalyzer) SetDataSource(dataSource interfaces.DataSource) {
+	a.dataSource = dataSource
+}
+
 // Initialize creates and connects the appropriate data source
 func (a *EnhancedAnalyzer) Initialize(ctx context.Context) error {
-	a.logger.Info("🔄 Initializing enhanced analyzer with API data source")
+	dataSource := a.dataSource
+	if dataSource == nil {
+		a.logger.Info("🔄 Initializing enhanced analyzer with API data source")
 
-	// Create API data source factory
-	factory := interfaces.NewDataSourceFactory(a.logger)
+		// Create API data source factory
+		factory := interfaces.NewDataSourceFactory(a.logger)
 
-	// Create data source
-	dataSource, err := factory.CreateDataSource(a.config)
-	if err != nil {
-		return fmt.Errorf("failed to create data source: %w", err)
+		// Create data source
+		var err error
+		dataSource, err = factory.CreateDataSource(a.config)
+		if err != nil {
+			return fmt.Errorf("failed to create data source: %w", err)
+		}
+	} else {
+		a.logger.Info("🔄 Initializing enhanced analyzer with %s data source", dataSource.GetDataSourceType())
 	}
 
 	// Connect to data source
@@ -194,6 +207,16 @@ func (a *EnhancedAnalyzer) AnalyzeData(ctx context.Context) (*types.AnalysisResu
 		Timestamp:      time.Now().Format(time.RFC3339),
 	}
 
+	// Include rate limiting when the data source is our own DNS server
+	if reporter, ok := a.dataSource.(interfaces.RateLimitReporter); ok {
+		rateLimit, err := reporter.GetRateLimitStats(ctx)
+		if err != nil {
+			a.logger.Debug("Rate limit statistics unavailable: %v", err)
+		} else {
+			result.RateLimit = rateLimit
+		}
+	}
+
 	// Process ML analysis if enabled
 	var mlResults *ml.MLResults
 	if a.mlEngine != nil {
@@ -258,6 +281,25 @@ func (a *EnhancedAnalyzer) AnalyzeData(ctx context.Context) (*types.AnalysisResu
 	return result, nil
 }
 
+// Run analyzes the data every interval until the context is cancelled, so
+// ML and alerts follow a long-running data source such as the embedded
+// DNS server. It must be called after Initialize.
+func (a *EnhancedAnalyzer) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if _, err := a.AnalyzeData(ctx); err != nil {
+				a.logger.Warn("Periodic analysis failed: %v", err)
+			}
+		}
+	}
+}
+
 // Close releases resources used by the analyzer
 func (a *EnhancedAnalyzer) Close() error {
 	var err error
@@ -0,0 +1,93 @@
+package analyzer
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"pihole-analyzer/internal/config"
+	"pihole-analyzer/internal/dns"
+	"pihole-analyzer/internal/logger"
+	"pihole-analyzer/internal/types"
+)
+
+// TestEnhancedAnalyzer_DNSServer tests analysis and alerts over the query
+// log and rate limiting of the embedded DNS server
+func TestEnhancedAnalyzer_DNSServer(t *testing.T) {
+	testLogger := logger.New(&logger.Config{Level: logger.LevelError})
+
+	dnsConfig := dns.DefaultConfig()
+	dnsConfig.LogQueries = false
+	dnsConfig.Forwarder.HealthCheck = false
+	dnsConfig.Local.Zones = []string{"home.example"}
+	dnsConfig.Local.Records = []dns.LocalRecordConfig{{Name: "nas.home.example", Type: "A", Value: "192.168.1.10"}}
+	dnsConfig.RateLimit.Enabled = true
+	dnsConfig.RateLimit.QueriesPerSecond = 0.01
+	dnsConfig.RateLimit.Burst = 2
+	dnsConfig.RateLimit.Action = dns.RateLimitActionRefuse
+	if err := dnsConfig.Validate(); err != nil {
+		t.Fatalf("Validate failed: %v", err)
+	}
+
+	server := dns.NewServer(dnsConfig, testLogger).(*dns.Server)
+	if err := server.ReloadLocalRecords(); err != nil {
+		t.Fatalf("ReloadLocalRecords failed: %v", err)
+	}
+
+	// Two queries within the burst are answered, three are refused
+	for i := 0; i < 5; i++ {
+		_, err := server.HandleQuery(context.Background(), &dns.DNSQuery{
+			ID:       uint16(i),
+			Question: dns.DNSQuestion{Name: "nas.home.example", Type: dns.TypeA, Class: dns.ClassIN},
+			Client:   &net.UDPAddr{IP: net.ParseIP("192.168.1.20"), Port: 5000},
+			Protocol: "udp",
+		})
+		if err != nil {
+			t.Fatalf("HandleQuery failed: %v", err)
+		}
+	}
+
+	cfg := config.DefaultConfig()
+	cfg.ML.AnomalyDetection.Enabled = false
+	cfg.ML.TrendAnalysis.Enabled = false
+	cfg.Alerts.Enabled = true
+	cfg.Alerts.Rules = []types.AlertRule{{
+		ID:         "dns-rate-limited",
+		Name:       "Clients are rate limited",
+		Enabled:    true,
+		Type:       "performance",
+		Severity:   "warning",
+		Conditions: []types.AlertCondition{{Field: "rate_limited_queries", Operator: "gt", Value: 2}},
+	}}
+
+	analyzer := NewEnhancedAnalyzer(cfg, testLogger, nil)
+	analyzer.SetDataSource(dns.NewServerDataSource(server, testLogger))
+	if err := analyzer.Initialize(context.Background()); err != nil {
+		t.Fatalf("Initialize failed: %v", err)
+	}
+	defer analyzer.Close()
+
+	result, err := analyzer.AnalyzeData(context.Background())
+	if err != nil {
+		t.Fatalf("AnalyzeData failed: %v", err)
+	}
+
+	if result.DataSourceType != "dns" || result.TotalQueries == 0 {
+		t.Errorf("Expected queries from the DNS server, got %s with %d queries",
+			result.DataSourceType, result.TotalQueries)
+	}
+	if result.ClientStats["192.168.1.20"] == nil {
+		t.Errorf("Expected statistics for the querying client, got %v", result.ClientStats)
+	}
+	if result.RateLimit == nil || result.RateLimit.LimitedQueries != 3 || result.RateLimit.Refused != 3 {
+		t.Fatalf("Expected 3 refused queries, got %+v", result.RateLimit)
+	}
+
+	active, err := analyzer.alertManager.GetActiveAlerts()
+	if err != nil {
+		t.Fatalf("GetActiveAlerts failed: %v", err)
+	}
+	if len(active) != 1 || active[0].Title != "Clients are rate limited" {
+		t.Errorf("Expected the rate limit alert to fire, got %+v", active)
+	}
+}
@@ -629,6 +629,124 @@ func TestPacketHandler(t *testing.T) {
 	})
 }
 
+func TestLeaseEvents(t *testing.T) {
+	config := DefaultDHCPConfig()
+
+	loggerInstance := logger.New(&logger.Config{Component: "test-lease-events"})
+	storage := &memoryStorage{
+		config: &config.Storage,
+		logger: loggerInstance.GetSlogger(),
+	}
+	ctx := context.Background()
+	storage.Initialize(ctx)
+	defer storage.Close()
+
+	lm := &leaseManager{
+		config:  config,
+		storage: storage,
+		logger:  loggerInstance.GetSlogger(),
+	}
+	ph := &packetHandler{
+		config:       config,
+		leaseManager: lm,
+		logger:       loggerInstance.GetSlogger(),
+	}
+
+	var events []LeaseEvent
+	unsubscribe := lm.Subscribe(func(event LeaseEvent) {
+		// Handlers run unlocked and may query the lease manager
+		if _, err := lm.GetLeaseByIP(ctx, event.Lease.IP); err != nil {
+			t.Errorf("GetLeaseByIP in handler failed: %v", err)
+		}
+		events = append(events, event)
+	})
+
+	lastEvent := func(want LeaseEventType) LeaseEvent {
+		t.Helper()
+		if len(events) == 0 || events[len(events)-1].Type != want {
+			t.Fatalf("Expected %s event, got %+v", want, events)
+		}
+		return events[len(events)-1]
+	}
+
+	// Offers are not commits
+	offer, err := ph.ProcessDiscover(ctx, &types.DHCPRequest{
+		MessageType:   1,
+		TransactionID: 1,
+		ClientMAC:     "00:11:22:33:44:01",
+		Options:       make(map[int]string),
+	})
+	if err != nil {
+		t.Fatalf("Failed to process discover: %v", err)
+	}
+	if len(events) != 0 {
+		t.Fatalf("Expected no events for an offer, got %+v", events)
+	}
+
+	if _, err := ph.ProcessRequest(ctx, &types.DHCPRequest{
+		MessageType:   3,
+		TransactionID: 2,
+		ClientMAC:     "00:11:22:33:44:01",
+		RequestedIP:   offer.YourIP,
+		Options:       map[int]string{12: "laptop"},
+	}); err != nil {
+		t.Fatalf("Failed to process request: %v", err)
+	}
+	committed := lastEvent(LeaseEventCommitted)
+	if committed.Lease.IP != offer.YourIP || committed.Lease.Hostname != "laptop" {
+		t.Errorf("Unexpected committed lease: %+v", committed.Lease)
+	}
+
+	// Without a client hostname the reservation's is used
+	reservation := &types.DHCPReservation{MAC: "00:11:22:33:44:02", IP: "192.168.1.150", Hostname: "printer", Enabled: true}
+	if err := lm.AddReservation(ctx, reservation); err != nil {
+		t.Fatalf("Failed to add reservation: %v", err)
+	}
+	ip, err := lm.AllocateIP(ctx, reservation.MAC, "", "")
+	if err != nil {
+		t.Fatalf("Failed to allocate reserved IP: %v", err)
+	}
+	if _, err := lm.CommitLease(ctx, ip, reservation.MAC, ""); err != nil {
+		t.Fatalf("Failed to commit lease: %v", err)
+	}
+	if lease := lastEvent(LeaseEventCommitted).Lease; lease.Hostname != "printer" {
+		t.Errorf("Expected reservation hostname, got %q", lease.Hostname)
+	}
+	if _, err := lm.CommitLease(ctx, ip, "00:11:22:33:44:99", ""); err == nil {
+		t.Error("Expected committing another client's lease to fail")
+	}
+
+	if err := lm.ReleaseIP(ctx, offer.YourIP, "00:11:22:33:44:01"); err != nil {
+		t.Fatalf("Failed to release IP: %v", err)
+	}
+	if lease := lastEvent(LeaseEventReleased).Lease; lease.IP != offer.YourIP || lease.Hostname != "laptop" {
+		t.Errorf("Unexpected released lease: %+v", lease)
+	}
+
+	// Leases past their end time expire during cleanup
+	stale := lm.createLease("192.168.1.160", "00:11:22:33:44:03", "", types.LeaseTypeDynamic)
+	stale.Hostname = "phone"
+	stale.EndTime = time.Now().Add(-time.Minute).Format(time.RFC3339)
+	if err := storage.SaveLease(ctx, stale); err != nil {
+		t.Fatalf("Failed to save lease: %v", err)
+	}
+	if err := lm.CleanupExpiredLeases(ctx); err != nil {
+		t.Fatalf("Failed to clean up leases: %v", err)
+	}
+	if lease := lastEvent(LeaseEventExpired).Lease; lease.IP != stale.IP || lease.State != types.LeaseStateExpired {
+		t.Errorf("Unexpected expired lease: %+v", lease)
+	}
+
+	unsubscribe()
+	count := len(events)
+	if _, err := lm.CommitLease(ctx, ip, reservation.MAC, "printer"); err != nil {
+		t.Fatalf("Failed to commit lease: %v", err)
+	}
+	if len(events) != count {
+		t.Error("Expected no events after unsubscribing")
+	}
+}
+
 func TestDefaultConfig(t *testing.T) {
 	config := DefaultDHCPConfig()
 
@@ -22,6 +22,7 @@ type DHCPServer interface {
 	// Lease management
 	GetLeases(ctx context.Context) ([]types.DHCPLease, error)
 	GetLease(ctx context.Context, identifier string) (*types.DHCPLease, error)
+	LeaseManager() DHCPLeaseManager
 	CreateReservation(ctx context.Context, reservation *types.DHCPReservation) error
 	DeleteReservation(ctx context.Context, mac string) error
 
@@ -39,6 +40,7 @@ type DHCPLeaseManager interface {
 	AllocateIP(ctx context.Context, clientMAC string, requestedIP string, clientID string) (string, error)
 	ReleaseIP(ctx context.Context, ip string, clientMAC string) error
 	RenewLease(ctx context.Context, ip string, clientMAC string, duration time.Duration) error
+	CommitLease(ctx context.Context, ip string, clientMAC string, hostname string) (*types.DHCPLease, error)
 
 	// Lease queries
 	GetActiveLease(ctx context.Context, clientMAC string) (*types.DHCPLease, error)
@@ -55,6 +57,9 @@ type DHCPLeaseManager interface {
 	RemoveReservation(ctx context.Context, mac string) error
 	GetReservations(ctx context.Context) ([]types.DHCPReservation, error)
 	IsReserved(ctx context.Context, ip string) (bool, *types.DHCPReservation, error)
+
+	// Lease events
+	Subscribe(handler LeaseEventHandler) (unsubscribe func())
 }
 
 // DHCPPacketHandler defines the interface for DHCP packet processing
@@ -159,6 +164,27 @@ type SecurityEvent struct {
 	Context     map[string]interface{}
 }
 
+// LeaseEventType identifies a change in a lease's lifecycle
+type LeaseEventType string
+
+const (
+	LeaseEventCommitted LeaseEventType = "committed" // Acknowledged to the client
+	LeaseEventReleased  LeaseEventType = "released"  // Released by the client
+	LeaseEventExpired   LeaseEventType = "expired"   // Ran out without renewal
+)
+
+// LeaseEvent reports a lease change to subscribers
+type LeaseEvent struct {
+	Type      LeaseEventType
+	Lease     types.DHCPLease
+	Timestamp time.Time
+}
+
+// LeaseEventHandler receives lease events. Handlers are called in order of
+// subscription after the lease manager released its lock, so they may query
+// it, but they should return quickly.
+type LeaseEventHandler func(event LeaseEvent)
+
 // DHCPFactory defines the interface for creating DHCP components
 type DHCPFactory interface {
 	// Component creation
@@ -17,6 +17,17 @@ type leaseManager struct {
 	storage DHCPStorage
 	logger  *slog.Logger
 	mu      sync.RWMutex
+
+	// Lease event subscribers, guarded separately so handlers run unlocked
+	subMu          sync.Mutex
+	subscribers    []leaseSubscriber
+	nextSubscriber int
+}
+
+// leaseSubscriber is a registered lease event handler
+type leaseSubscriber struct {
+	id      int
+	handler LeaseEventHandler
 }
 
 // AllocateIP allocates an IP address for a client
@@ -103,6 +114,9 @@ func (lm *leaseManager) AllocateIP(ctx context.Context, clientMAC string, reques
 
 // ReleaseIP releases an IP address from a client
 func (lm *leaseManager) ReleaseIP(ctx context.Context, ip string, clientMAC string) error {
+	var released []LeaseEvent
+	defer func() { lm.publish(released) }()
+
 	lm.mu.Lock()
 	defer lm.mu.Unlock()
 
@@ -131,6 +145,7 @@ func (lm *leaseManager) ReleaseIP(ctx context.Context, ip string, clientMAC stri
 		return fmt.Errorf("failed to save released lease: %w", err)
 	}
 
+	released = append(released, LeaseEvent{Type: LeaseEventReleased, Lease: *lease, Timestamp: time.Now()})
 	return nil
 }
 
@@ -175,6 +190,51 @@ func (lm *leaseManager) RenewLease(ctx context.Context, ip string, clientMAC str
 	return nil
 }
 
+// CommitLease marks a lease as acknowledged to its client, recording the
+// hostname the client sent or, failing that, the one of its reservation
+func (lm *leaseManager) CommitLease(ctx context.Context, ip string, clientMAC string, hostname string) (*types.DHCPLease, error) {
+	var committed []LeaseEvent
+	defer func() { lm.publish(committed) }()
+
+	lm.mu.Lock()
+	defer lm.mu.Unlock()
+
+	lease, err := lm.storage.LoadLeaseByIP(ctx, ip)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load lease for IP %s: %w", ip, err)
+	}
+
+	if lease == nil {
+		return nil, fmt.Errorf("no lease found for IP %s", ip)
+	}
+
+	if lease.MAC != clientMAC {
+		return nil, fmt.Errorf("lease for IP %s belongs to different client", ip)
+	}
+
+	if hostname == "" {
+		if reservation, err := lm.storage.LoadReservation(ctx, clientMAC); err == nil && reservation != nil && reservation.Enabled {
+			hostname = reservation.Hostname
+		}
+	}
+	if hostname != "" {
+		lease.Hostname = hostname
+	}
+	lease.State = types.LeaseStateActive
+
+	if err := lm.storage.SaveLease(ctx, lease); err != nil {
+		return nil, fmt.Errorf("failed to save committed lease: %w", err)
+	}
+
+	lm.logger.Debug("Lease committed",
+		slog.String("ip", ip),
+		slog.String("client_mac", clientMAC),
+		slog.String("hostname", lease.Hostname))
+
+	committed = append(committed, LeaseEvent{Type: LeaseEventCommitted, Lease: *lease, Timestamp: time.Now()})
+	return lease, nil
+}
+
 // GetActiveLease returns the active lease for a client
 func (lm *leaseManager) GetActiveLease(ctx context.Context, clientMAC string) (*types.DHCPLease, error) {
 	lm.mu.RLock()
@@ -215,17 +275,25 @@ func (lm *leaseManager) GetAllLeases(ctx context.Context) ([]types.DHCPLease, er
 // ExpireLeases marks expired leases as expired
 func (lm *leaseManager) ExpireLeases(ctx context.Context) error {
 	lm.mu.Lock()
-	defer lm.mu.Unlock()
+	expired, err := lm.expireLeasesLocked(ctx)
+	lm.mu.Unlock()
+
+	lm.publish(expired)
+	return err
+}
 
+// expireLeasesLocked marks expired leases as expired and returns their
+// events; the caller must hold the write lock
+func (lm *leaseManager) expireLeasesLocked(ctx context.Context) ([]LeaseEvent, error) {
 	lm.logger.Debug("Checking for expired leases")
 
 	leases, err := lm.storage.LoadAllLeases(ctx)
 	if err != nil {
-		return fmt.Errorf("failed to load leases: %w", err)
+		return nil, fmt.Errorf("failed to load leases: %w", err)
 	}
 
 	now := time.Now()
-	expiredCount := 0
+	var expired []LeaseEvent
 
 	for _, lease := range leases {
 		if lease.State == types.LeaseStateActive {
@@ -245,28 +313,32 @@ func (lm *leaseManager) ExpireLeases(ctx context.Context) error {
 						slog.String("lease_id", lease.ID),
 						slog.String("error", err.Error()))
 				} else {
-					expiredCount++
+					expired = append(expired, LeaseEvent{Type: LeaseEventExpired, Lease: lease, Timestamp: now})
 				}
 			}
 		}
 	}
 
-	if expiredCount > 0 {
-		lm.logger.Info("Expired leases", slog.Int("count", expiredCount))
+	if len(expired) > 0 {
+		lm.logger.Info("Expired leases", slog.Int("count", len(expired)))
 	}
 
-	return nil
+	return expired, nil
 }
 
 // CleanupExpiredLeases removes old expired leases
 func (lm *leaseManager) CleanupExpiredLeases(ctx context.Context) error {
+	var expired []LeaseEvent
+	defer func() { lm.publish(expired) }()
+
 	lm.mu.Lock()
 	defer lm.mu.Unlock()
 
 	lm.logger.Debug("Cleaning up expired leases")
 
 	// First expire any leases that should be expired
-	if err := lm.ExpireLeases(ctx); err != nil {
+	expired, err := lm.expireLeasesLocked(ctx)
+	if err != nil {
 		return fmt.Errorf("failed to expire leases: %w", err)
 	}
 
@@ -420,6 +492,47 @@ func (lm *leaseManager) IsReserved(ctx context.Context, ip string) (bool, *types
 	return false, nil, nil
 }
 
+// Subscribe registers a handler for lease events until the returned
+// function is called
+func (lm *leaseManager) Subscribe(handler LeaseEventHandler) func() {
+	lm.subMu.Lock()
+	defer lm.subMu.Unlock()
+
+	lm.nextSubscriber++
+	id := lm.nextSubscriber
+	lm.subscribers = append(lm.subscribers, leaseSubscriber{id: id, handler: handler})
+
+	return func() {
+		lm.subMu.Lock()
+		defer lm.subMu.Unlock()
+
+		for i, subscriber := range lm.subscribers {
+			if subscriber.id == id {
+				lm.subscribers = append(lm.subscribers[:i:i], lm.subscribers[i+1:]...)
+				return
+			}
+		}
+	}
+}
+
+// publish delivers events to the current subscribers. It must be called
+// without holding lm.mu.
+func (lm *leaseManager) publish(events []LeaseEvent) {
+	if len(events) == 0 {
+		return
+	}
+
+	lm.subMu.Lock()
+	subscribers := lm.subscribers
+	lm.subMu.Unlock()
+
+	for _, event := range events {
+		for _, subscriber := range subscribers {
+			subscriber.handler(event)
+		}
+	}
+}
+
 // Helper methods
 
 func (lm *leaseManager) createLease(ip, mac, clientID string, leaseType types.DHCPLeaseType) *types.DHCPLease {
@@ -90,6 +90,12 @@ func (ph *packetHandler) ProcessRequest(ctx context.Context, request *types.DHCP
 		assignedIP = allocatedIP
 	}
 
+	// Commit the lease before acknowledging it
+	if _, err := ph.leaseManager.CommitLease(ctx, assignedIP, request.ClientMAC, requestHostname(request)); err != nil {
+		ph.logger.Error("Failed to commit lease", slog.String("error", err.Error()))
+		return ph.buildNAK(request, "Failed to commit lease")
+	}
+
 	// Build DHCP ACK response
 	response := &types.DHCPResponse{
 		MessageType:   5, // DHCP ACK
@@ -329,3 +335,12 @@ func (ph *packetHandler) generateFingerprint(options map[int]string) string {
 
 	return fingerprint
 }
+
+// requestHostname returns the hostname a client sent, from the parsed field
+// or option 12
+func requestHostname(request *types.DHCPRequest) string {
+	if request.ClientHostname != "" {
+		return request.ClientHostname
+	}
+	return request.Options[12]
+}
@@ -163,6 +163,12 @@ func (s *server) GetLease(ctx context.Context, identifier string) (*types.DHCPLe
 	return s.storage.LoadLeaseByIP(ctx, identifier)
 }
 
+// LeaseManager returns the server's lease manager, e.g. to subscribe to
+// lease events
+func (s *server) LeaseManager() DHCPLeaseManager {
+	return s.leaseManager
+}
+
 // CreateReservation creates a new static IP reservation
 func (s *server) CreateReservation(ctx context.Context, reservation *types.DHCPReservation) error {
 	s.logger.Info("Creating DHCP reservation",
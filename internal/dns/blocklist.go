@@ -0,0 +1,312 @@
+package dns
+
+import (
+	"bufio"
+	"fmt"
+	"math"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Blocklist implements the DNSBlocklist interface.
+//
+// Entries are compiled into two hash sets: exact domains (hosts and plain
+// domain lists) and wildcard domains (Adblock-style "||example.com^" rules,
+// which also block every subdomain). Lookups cost one map probe per label
+// of the queried name, independent of how many entries are loaded. Each
+// entry records the first source that listed it.
+type Blocklist struct {
+	mu       sync.RWMutex
+	config   BlocklistConfig
+	exact    map[string]uint16
+	wildcard map[string]uint16
+	stats    BlocklistStats
+}
+
+// BlocklistMatch describes the list that blocks a domain
+type BlocklistMatch struct {
+	Source   string         // List file that lists the domain
+	Sinkhole SinkholeConfig // The list's answer, or the blocklist default
+}
+
+// NewBlocklist creates a new, empty DNS blocklist
+func NewBlocklist(config BlocklistConfig) DNSBlocklist {
+	return &Blocklist{
+		config:   config,
+		exact:    make(map[string]uint16),
+		wildcard: make(map[string]uint16),
+	}
+}
+
+// Load compiles all configured sources, replacing the current entries.
+// The previous entries stay in effect until every source has been read.
+func (b *Blocklist) Load() error {
+	b.mu.RLock()
+	config := b.config
+	b.mu.RUnlock()
+
+	return b.load(config)
+}
+
+// Reconfigure replaces the configured sources and compiles them. On error
+// the previous sources and entries stay in effect.
+func (b *Blocklist) Reconfigure(config BlocklistConfig) error {
+	return b.load(config)
+}
+
+// load compiles the sources of a configuration and makes it current
+func (b *Blocklist) load(config BlocklistConfig) error {
+	if len(config.Sources) > math.MaxUint16 {
+		return fmt.Errorf("too many blocklist sources: %d", len(config.Sources))
+	}
+
+	exact := make(map[string]uint16)
+	wildcard := make(map[string]uint16)
+	invalid := 0
+
+	for i, source := range config.Sources {
+		n, err := loadBlocklistFile(source, uint16(i), exact, wildcard)
+		if err != nil {
+			return fmt.Errorf("failed to load blocklist %s: %w", source, err)
+		}
+		invalid += n
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.config = config
+	b.exact = exact
+	b.wildcard = wildcard
+	b.stats.Domains = len(exact) + len(wildcard)
+	b.stats.Sources = len(config.Sources)
+	b.stats.InvalidLines = invalid
+	b.stats.LastLoaded = time.Now()
+
+	return nil
+}
+
+// IsBlocked reports whether a domain is on the blocklist
+func (b *Blocklist) IsBlocked(name string) bool {
+	return b.Match(name) != nil
+}
+
+// Match returns the list that blocks a domain and the answer configured
+// for it, or nil if the domain is not blocked
+func (b *Blocklist) Match(name string) *BlocklistMatch {
+	name = normalizeDomain(name)
+	if name == "" {
+		return nil
+	}
+
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	source, ok := lookupBlocklistEntry(name, b.exact, b.wildcard)
+	if !ok {
+		return nil
+	}
+
+	return &BlocklistMatch{
+		Source:   b.config.Sources[source],
+		Sinkhole: b.listSinkholeLocked(b.config.Sources[source]),
+	}
+}
+
+// Sinkhole returns the answer for blocked queries that no list or group
+// sets one for
+func (b *Blocklist) Sinkhole() SinkholeConfig {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	return b.config.Sinkhole
+}
+
+// ListSinkhole returns the answer configured for the domains of a list,
+// which may also be a group list, or the default
+func (b *Blocklist) ListSinkhole(source string) SinkholeConfig {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	return b.listSinkholeLocked(source)
+}
+
+func (b *Blocklist) listSinkholeLocked(source string) SinkholeConfig {
+	if sinkhole, ok := b.config.ListSinkholes[source]; ok && sinkhole.Mode != "" {
+		return sinkhole
+	}
+	return b.config.Sinkhole
+}
+
+// Size returns the number of compiled entries
+func (b *Blocklist) Size() int {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	return len(b.exact) + len(b.wildcard)
+}
+
+// GetStats returns blocklist statistics
+func (b *Blocklist) GetStats() *BlocklistStats {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	stats := b.stats
+	return &stats
+}
+
+// loadBlocklistFile reads a single list file into the given sets, keeping
+// the source of entries already listed, and returns the number of lines
+// that could not be parsed
+func loadBlocklistFile(path string, source uint16, exact, wildcard map[string]uint16) (int, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+
+	invalid := 0
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+
+	for scanner.Scan() {
+		domains, isWildcard, ok := parseBlocklistLine(scanner.Text())
+		if !ok {
+			invalid++
+			continue
+		}
+		entries := exact
+		if isWildcard {
+			entries = wildcard
+		}
+		for _, domain := range domains {
+			if _, ok := entries[domain]; !ok {
+				entries[domain] = source
+			}
+		}
+	}
+
+	return invalid, scanner.Err()
+}
+
+// lookupBlocklistEntry returns the source of a normalized domain's entry
+// in compiled list sets, walking up the label hierarchy for wildcard entries
+func lookupBlocklistEntry(name string, exact, wildcard map[string]uint16) (uint16, bool) {
+	if source, ok := exact[name]; ok {
+		return source, true
+	}
+
+	for suffix := name; suffix != ""; {
+		if source, ok := wildcard[suffix]; ok {
+			return source, true
+		}
+		dot := strings.IndexByte(suffix, '.')
+		if dot < 0 {
+			break
+		}
+		suffix = suffix[dot+1:]
+	}
+	return 0, false
+}
+
+// parseBlocklistLine parses one line of a hosts-format, plain-domain or
+// Adblock-style list. Blank lines and comments yield no domains and ok=true.
+func parseBlocklistLine(line string) (domains []string, isWildcard bool, ok bool) {
+	line = strings.TrimSpace(line)
+	if line == "" || line[0] == '#' || line[0] == '!' || line[0] == '[' {
+		return nil, false, true
+	}
+
+	// Adblock-style rule: ||example.com^ with optional $important
+	if strings.HasPrefix(line, "||") {
+		rule := strings.TrimPrefix(line, "||")
+		end := strings.IndexByte(rule, '^')
+		if end < 0 {
+			return nil, false, false
+		}
+		if opts := rule[end+1:]; opts != "" && opts != "$important" {
+			return nil, false, false
+		}
+		domain := normalizeDomain(rule[:end])
+		if !isValidDomain(domain) {
+			return nil, false, false
+		}
+		return []string{domain}, true, true
+	}
+
+	// Strip trailing comments
+	if idx := strings.IndexByte(line, '#'); idx >= 0 {
+		line = line[:idx]
+	}
+
+	fields := strings.Fields(line)
+	if len(fields) == 0 {
+		return nil, false, true
+	}
+
+	// Hosts format: <ip> <hostname> [hostname...]
+	if net.ParseIP(fields[0]) != nil {
+		for _, field := range fields[1:] {
+			domain := normalizeDomain(field)
+			if isHostsLocalName(domain) {
+				continue
+			}
+			if !isValidDomain(domain) {
+				return nil, false, false
+			}
+			domains = append(domains, domain)
+		}
+		return domains, false, true
+	}
+
+	// Plain domain list
+	if len(fields) != 1 {
+		return nil, false, false
+	}
+	domain := normalizeDomain(fields[0])
+	if !isValidDomain(domain) {
+		return nil, false, false
+	}
+	return []string{domain}, false, true
+}
+
+// normalizeDomain lowercases a domain and strips any trailing dot
+func normalizeDomain(name string) string {
+	return strings.TrimSuffix(strings.ToLower(strings.TrimSpace(name)), ".")
+}
+
+// isValidDomain performs a lightweight syntax check on a normalized domain
+func isValidDomain(domain string) bool {
+	if domain == "" || len(domain) > 253 {
+		return false
+	}
+
+	for _, label := range strings.Split(domain, ".") {
+		if label == "" || len(label) > 63 {
+			return false
+		}
+		for i := 0; i < len(label); i++ {
+			c := label[i]
+			if (c < 'a' || c > 'z') && (c < '0' || c > '9') && c != '-' && c != '_' {
+				return false
+			}
+		}
+	}
+
+	return true
+}
+
+// isHostsLocalName reports whether a hosts-file name refers to the local
+// machine and must never be blocked
+func isHostsLocalName(domain string) bool {
+	switch domain {
+	case "localhost", "localhost.localdomain", "local", "broadcasthost",
+		"ip6-localhost", "ip6-loopback", "ip6-localnet", "ip6-mcastprefix",
+		"ip6-allnodes", "ip6-allrouters", "ip6-allhosts", "0.0.0.0":
+		return true
+	}
+	return false
+}
@@ -0,0 +1,335 @@
+package dns
+
+import (
+	"context"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"pihole-analyzer/internal/logger"
+)
+
+func writeTestList(t *testing.T, name, content string) string {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatalf("Failed to write test list: %v", err)
+	}
+	return path
+}
+
+func TestParseBlocklistLine(t *testing.T) {
+	tests := []struct {
+		line     string
+		domains  []string
+		wildcard bool
+		ok       bool
+	}{
+		{"", nil, false, true},
+		{"# comment", nil, false, true},
+		{"! adblock comment", nil, false, true},
+		{"[Adblock Plus 2.0]", nil, false, true},
+		{"ads.example.com", []string{"ads.example.com"}, false, true},
+		{"Ads.Example.COM.", []string{"ads.example.com"}, false, true},
+		{"0.0.0.0 ads.example.com tracker.example.com", []string{"ads.example.com", "tracker.example.com"}, false, true},
+		{"127.0.0.1 localhost", nil, false, true},
+		{"0.0.0.0 ads.example.com # inline comment", []string{"ads.example.com"}, false, true},
+		{"::1 ip6-localhost", nil, false, true},
+		{"||doubleclick.net^", []string{"doubleclick.net"}, true, true},
+		{"||doubleclick.net^$important", []string{"doubleclick.net"}, true, true},
+		{"||doubleclick.net^$third-party", nil, false, false},
+		{"||doubleclick.net/path", nil, false, false},
+		{"two words", nil, false, false},
+		{"bad domain!", nil, false, false},
+	}
+
+	for _, tt := range tests {
+		domains, wildcard, ok := parseBlocklistLine(tt.line)
+		if ok != tt.ok {
+			t.Errorf("parseBlocklistLine(%q) ok = %v, want %v", tt.line, ok, tt.ok)
+			continue
+		}
+		if wildcard != tt.wildcard {
+			t.Errorf("parseBlocklistLine(%q) wildcard = %v, want %v", tt.line, wildcard, tt.wildcard)
+		}
+		if len(domains) != len(tt.domains) {
+			t.Errorf("parseBlocklistLine(%q) = %v, want %v", tt.line, domains, tt.domains)
+			continue
+		}
+		for i := range domains {
+			if domains[i] != tt.domains[i] {
+				t.Errorf("parseBlocklistLine(%q)[%d] = %q, want %q", tt.line, i, domains[i], tt.domains[i])
+			}
+		}
+	}
+}
+
+func TestBlocklist_LoadAndLookup(t *testing.T) {
+	hosts := writeTestList(t, "hosts.txt", "# hosts list\n0.0.0.0 ads.example.com\n127.0.0.1 localhost\n")
+	plain := writeTestList(t, "plain.txt", "tracker.example.org\nnot a domain\n")
+	adblock := writeTestList(t, "adblock.txt", "! adblock list\n||doubleclick.net^\n")
+
+	blocklist := NewBlocklist(BlocklistConfig{
+		Enabled: true,
+		Sources: []string{hosts, plain, adblock},
+	})
+
+	if err := blocklist.Load(); err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+
+	tests := []struct {
+		name    string
+		blocked bool
+	}{
+		{"ads.example.com", true},
+		{"ADS.example.com.", true},
+		{"sub.ads.example.com", false}, // hosts entries are exact
+		{"example.com", false},
+		{"tracker.example.org", true},
+		{"doubleclick.net", true},
+		{"ad.g.doubleclick.net", true}, // adblock entries cover subdomains
+		{"notdoubleclick.net", false},
+		{"localhost", false},
+	}
+
+	for _, tt := range tests {
+		if got := blocklist.IsBlocked(tt.name); got != tt.blocked {
+			t.Errorf("IsBlocked(%q) = %v, want %v", tt.name, got, tt.blocked)
+		}
+	}
+
+	if blocklist.Size() != 3 {
+		t.Errorf("Expected 3 entries, got %d", blocklist.Size())
+	}
+
+	stats := blocklist.GetStats()
+	if stats.Sources != 3 {
+		t.Errorf("Expected 3 sources, got %d", stats.Sources)
+	}
+	if stats.InvalidLines != 1 {
+		t.Errorf("Expected 1 invalid line, got %d", stats.InvalidLines)
+	}
+}
+
+func TestBlocklist_LoadMissingFile(t *testing.T) {
+	blocklist := NewBlocklist(BlocklistConfig{
+		Enabled: true,
+		Sources: []string{filepath.Join(t.TempDir(), "missing.txt")},
+	})
+
+	if err := blocklist.Load(); err == nil {
+		t.Error("Expected error loading missing blocklist file")
+	}
+}
+
+func TestServer_HandleQueryBlocked(t *testing.T) {
+	list := writeTestList(t, "list.txt", "blocked.example.com\n")
+
+	config := DefaultConfig()
+	config.LogQueries = false
+	config.Forwarder.HealthCheck = false
+	config.Blocklist.Sources = []string{list}
+
+	testLogger := logger.New(&logger.Config{
+		Level:     logger.LogLevel("ERROR"),
+		Component: "dns-test",
+	})
+
+	server := NewServer(config, testLogger).(*Server)
+	if err := server.blocklist.Load(); err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+
+	query := &DNSQuery{
+		ID: 42,
+		Question: DNSQuestion{
+			Name:  "blocked.example.com",
+			Type:  TypeA,
+			Class: ClassIN,
+		},
+		Client:   &net.UDPAddr{IP: net.ParseIP("192.168.1.10"), Port: 5000},
+		Protocol: "udp",
+	}
+
+	response, err := server.HandleQuery(context.Background(), query)
+	if err != nil {
+		t.Fatalf("HandleQuery failed: %v", err)
+	}
+
+	if response.ID != query.ID {
+		t.Errorf("Expected response ID %d, got %d", query.ID, response.ID)
+	}
+	if response.ResponseCode != RCodeNoError {
+		t.Errorf("Expected NOERROR, got %d", response.ResponseCode)
+	}
+	if len(response.Answers) != 1 {
+		t.Fatalf("Expected 1 answer, got %d", len(response.Answers))
+	}
+	if !net.IP(response.Answers[0].Data).Equal(net.IPv4zero) {
+		t.Errorf("Expected 0.0.0.0, got %v", net.IP(response.Answers[0].Data))
+	}
+	if response.Answers[0].TTL != uint32(config.Blocklist.BlockedTTL/time.Second) {
+		t.Errorf("Expected TTL %v, got %d", config.Blocklist.BlockedTTL, response.Answers[0].TTL)
+	}
+
+	stats := server.GetStats()
+	if stats.QueriesBlocked != 1 {
+		t.Errorf("Expected 1 blocked query, got %d", stats.QueriesBlocked)
+	}
+	if stats.QueriesForwarded != 0 {
+		t.Errorf("Expected no forwarded queries, got %d", stats.QueriesForwarded)
+	}
+}
+
+// cnameForwarder answers with fixed CNAME chains ending in an address
+type cnameForwarder map[string][]string
+
+func (f cnameForwarder) Forward(ctx context.Context, query *DNSQuery) (*DNSResponse, error) {
+	response := &DNSResponse{ID: query.ID, Question: query.Question, ResponseCode: RCodeNoError}
+
+	name := query.Question.Name
+	for _, target := range f[normalizeDomain(name)] {
+		record, err := NewRecord(name, TypeCNAME, 300, &TargetData{Target: target})
+		if err != nil {
+			return nil, err
+		}
+		response.Answers = append(response.Answers, record)
+		name = target
+	}
+	response.Answers = append(response.Answers, DNSRecord{
+		Name: name, Type: TypeA, Class: ClassIN, TTL: 300, Data: []byte{192, 0, 2, 1},
+	})
+	return response, nil
+}
+
+func (f cnameForwarder) GetUpstreams() []string            { return nil }
+func (f cnameForwarder) SetUpstreams(upstreams []string)   {}
+func (f cnameForwarder) GetUpstreamStats() []UpstreamStats { return nil }
+
+func TestCNAMETargets(t *testing.T) {
+	forwarder := cnameForwarder{
+		"metrics.shop.example": {"shop.edge.example", "Tracker.Example.NET."},
+	}
+	response, _ := forwarder.Forward(context.Background(), &DNSQuery{
+		Question: DNSQuestion{Name: "Metrics.shop.example", Type: TypeA, Class: ClassIN},
+	})
+
+	targets := cnameTargets("metrics.shop.example.", response.Answers)
+	if len(targets) != 2 || targets[0] != "shop.edge.example" || targets[1] != "tracker.example.net" {
+		t.Errorf("cnameTargets = %v", targets)
+	}
+
+	// Records off the chain are ignored and loops end
+	loop, _ := NewRecord("a.example", TypeCNAME, 300, &TargetData{Target: "b.example"})
+	back, _ := NewRecord("b.example", TypeCNAME, 300, &TargetData{Target: "a.example"})
+	if targets := cnameTargets("a.example", []DNSRecord{loop, back}); len(targets) != 2 {
+		t.Errorf("Expected a loop to end after 2 targets, got %v", targets)
+	}
+	if targets := cnameTargets("other.example", response.Answers); len(targets) != 0 {
+		t.Errorf("Expected no targets for an unrelated name, got %v", targets)
+	}
+}
+
+func TestServer_CNAMEUncloaking(t *testing.T) {
+	list := writeTestList(t, "list.txt", "tracker.example.net\n")
+
+	config := DefaultConfig()
+	config.LogQueries = false
+	config.Forwarder.HealthCheck = false
+	config.Blocklist.Sources = []string{list}
+	config.Rules = testRulesConfig()
+
+	testLogger := logger.New(&logger.Config{
+		Level:     logger.LogLevel("ERROR"),
+		Component: "dns-test",
+	})
+
+	server := NewServer(config, testLogger).(*Server)
+	server.forwarder = cnameForwarder{
+		"metrics.shop.example": {"shop.edge.example", "tracker.example.net"},
+		"cdn.shop.example":     {"ads.example.com"},
+		"www.shop.example":     {"shop.edge.example"},
+	}
+	if err := server.blocklist.Load(); err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	if err := server.ReloadRules(config.Rules); err != nil {
+		t.Fatalf("ReloadRules failed: %v", err)
+	}
+
+	adult := udpClient("192.168.1.10")
+	kid := udpClient("192.168.1.50")
+
+	tests := []struct {
+		name   string
+		client net.Addr
+		cname  string
+		status int
+	}{
+		{"metrics.shop.example", adult, "tracker.example.net", QueryStatusGravityCNAME},
+		{"metrics.shop.example", adult, "tracker.example.net", QueryStatusGravityCNAME}, // From the cache
+		{"cdn.shop.example", adult, "ads.example.com", QueryStatusDenylistCNAME},
+		{"cdn.shop.example", kid, "", QueryStatusCached}, // Allowed for the kids group
+		{"www.shop.example", adult, "", QueryStatusForwarded},
+	}
+
+	for i, tt := range tests {
+		response, err := server.HandleQuery(context.Background(), &DNSQuery{
+			ID:       uint16(i),
+			Question: DNSQuestion{Name: tt.name, Type: TypeA, Class: ClassIN},
+			Client:   tt.client,
+			Protocol: "udp",
+		})
+		if err != nil {
+			t.Fatalf("HandleQuery(%s) failed: %v", tt.name, err)
+		}
+
+		if tt.cname == "" {
+			if len(response.Answers) == 0 || response.Answers[0].Type != TypeCNAME {
+				t.Errorf("%d: expected the upstream answer for %s, got %+v", i, tt.name, response.Answers)
+			}
+			continue
+		}
+		if len(response.Answers) != 1 || !net.IP(response.Answers[0].Data).Equal(net.IPv4zero) {
+			t.Errorf("%d: expected %s to be blocked, got %+v", i, tt.name, response.Answers)
+		}
+		if response.Answers[0].Name != tt.name {
+			t.Errorf("%d: expected the answer for %s, got %s", i, tt.name, response.Answers[0].Name)
+		}
+	}
+
+	records := server.QueryLog().Records()
+	if len(records) != len(tests) {
+		t.Fatalf("Expected %d logged queries, got %d", len(tests), len(records))
+	}
+	for i, tt := range tests {
+		if records[i].CNAME != tt.cname || records[i].Status != tt.status {
+			t.Errorf("%d: logged CNAME %q status %d, want %q %d",
+				i, records[i].CNAME, records[i].Status, tt.cname, tt.status)
+		}
+	}
+
+	stats := server.GetStats()
+	if stats.CNAMEBlocked != 3 || stats.QueriesBlocked != 3 {
+		t.Errorf("Expected 3 CNAME blocked queries, got %d of %d blocked", stats.CNAMEBlocked, stats.QueriesBlocked)
+	}
+
+	// Without inspection the chain is not checked
+	server.config.Blocklist.CNAMEInspection = false
+	response, err := server.HandleQuery(context.Background(), &DNSQuery{
+		ID:       9,
+		Question: DNSQuestion{Name: "metrics.shop.example", Type: TypeA, Class: ClassIN},
+		Client:   adult,
+		Protocol: "udp",
+	})
+	if err != nil {
+		t.Fatalf("HandleQuery failed: %v", err)
+	}
+	if len(response.Answers) != 3 {
+		t.Errorf("Expected the upstream answer without CNAME inspection, got %+v", response.Answers)
+	}
+}
@@ -6,47 +6,69 @@ import (
 	"time"
 )
 
-// Cache implements the DNSCache interface with LRU eviction
+// Cache implements the DNSCache interface with LRU, LFU or TTL eviction
+// bounded by entry count and approximate memory use
 type Cache struct {
 	mu          sync.RWMutex
 	entries     map[string]*cacheNode
-	head        *cacheNode
-	tail        *cacheNode
+	policy      evictionPolicy
+	parser      DNSParser
 	maxSize     int
+	maxMemory   int64
 	currentSize int
+	memoryUsage int64
 	stats       CacheStats
 	config      CacheConfig
 }
 
-// cacheNode represents a node in the LRU cache
+// cacheNode represents a cached entry tracked by the eviction policy
 type cacheNode struct {
 	key   string
 	entry *CacheEntry
+	size  int64 // Approximate memory footprint in bytes
+
+	// Set while a prefetch refresh is in flight
+	prefetching bool
+
+	// NXDOMAIN or NODATA answer
+	negative bool
+
+	// Position in the eviction policy (list links or heap index)
 	prev  *cacheNode
 	next  *cacheNode
+	index int
 }
 
+// cacheEntryOverhead approximates the bookkeeping memory of one entry on
+// top of its encoded response
+const cacheEntryOverhead = 256
+
 // NewCache creates a new DNS cache
 func NewCache(config CacheConfig) DNSCache {
+	policy := config.EvictionPolicy
+	if policy == "" {
+		policy = EvictionLRU
+	}
+
 	cache := &Cache{
-		entries: make(map[string]*cacheNode),
-		maxSize: config.MaxSize,
-		config:  config,
+		entries:   make(map[string]*cacheNode),
+		policy:    newEvictionPolicy(policy),
+		parser:    NewParser(),
+		maxSize:   config.MaxSize,
+		maxMemory: int64(config.MaxMemoryMB) << 20,
+		config:    config,
 		stats: CacheStats{
-			MaxSize: config.MaxSize,
+			MaxSize:        config.MaxSize,
+			MaxMemory:      int64(config.MaxMemoryMB) << 20,
+			EvictionPolicy: policy,
 		},
 	}
 
-	// Initialize doubly linked list with sentinel nodes
-	cache.head = &cacheNode{}
-	cache.tail = &cacheNode{}
-	cache.head.next = cache.tail
-	cache.tail.prev = cache.head
-
 	return cache
 }
 
-// Get retrieves a cached response
+// Get retrieves a cached response. The entry is a copy taken under the
+// lock, so it stays consistent while the key is refreshed.
 func (c *Cache) Get(question DNSQuestion) (*CacheEntry, bool) {
 	if !c.config.Enabled {
 		return nil, false
@@ -63,26 +85,88 @@ func (c *Cache) Get(question DNSQuestion) (*CacheEntry, bool) {
 		return nil, false
 	}
 
-	// Check if entry is expired
-	if time.Now().After(node.entry.ExpiresAt) {
-		c.removeNode(node)
-		delete(c.entries, key)
-		c.currentSize--
+	// Check if entry is expired, keeping it around if it may be served stale
+	now := time.Now()
+	if now.After(node.entry.ExpiresAt) {
+		if !c.withinStaleWindow(node.entry, now) {
+			c.removeEntry(node)
+			c.stats.Expirations++
+		}
 		c.stats.Misses++
 		return nil, false
 	}
 
-	// Move to front (most recently used)
-	c.moveToFront(node)
-
 	// Update access statistics
-	node.entry.AccessTime = time.Now()
+	node.entry.AccessTime = now
 	node.entry.HitCount++
+	c.policy.touch(node)
 
 	c.stats.Hits++
+	if node.negative {
+		c.stats.NegativeHits++
+	}
 	c.updateHitRate()
 
-	return node.entry, true
+	entry := *node.entry
+	return &entry, true
+}
+
+// GetStale retrieves an entry that may have expired but is still within the
+// serve-stale window (RFC 8767). It is meant as a fallback when upstreams
+// fail, so it does not count as a regular hit.
+func (c *Cache) GetStale(question DNSQuestion) (*CacheEntry, bool) {
+	if !c.config.Enabled || !c.config.ServeStale {
+		return nil, false
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	node, exists := c.entries[c.makeKey(question)]
+	if !exists || !c.withinStaleWindow(node.entry, time.Now()) {
+		return nil, false
+	}
+
+	c.stats.StaleHits++
+	entry := *node.entry
+	return &entry, true
+}
+
+// Prefetch reports whether an entry has been hit often enough and is close
+// enough to expiry to be refreshed in the background. Only the first caller
+// is told to refresh until the entry is Set again.
+func (c *Cache) Prefetch(question DNSQuestion) bool {
+	if !c.config.Enabled || !c.config.Prefetch {
+		return false
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	node, exists := c.entries[c.makeKey(question)]
+	if !exists || node.prefetching || node.entry.HitCount < c.config.PrefetchThreshold {
+		return false
+	}
+
+	remaining := time.Until(node.entry.ExpiresAt)
+	if remaining <= 0 || remaining > c.config.PrefetchWindow {
+		return false
+	}
+
+	node.prefetching = true
+	c.stats.Prefetches++
+	return true
+}
+
+// CancelPrefetch releases a refresh claimed by Prefetch that did not end
+// in a Set, so the entry can be prefetched again
+func (c *Cache) CancelPrefetch(question DNSQuestion) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if node, exists := c.entries[c.makeKey(question)]; exists {
+		node.prefetching = false
+	}
 }
 
 // Set stores a response in cache
@@ -95,40 +179,59 @@ func (c *Cache) Set(question DNSQuestion, response *DNSResponse, ttl time.Durati
 	defer c.mu.Unlock()
 
 	key := c.makeKey(question)
+	size := c.entrySize(key, response)
+	node, exists := c.entries[key]
 
-	// Check if entry already exists
-	if node, exists := c.entries[key]; exists {
-		// Update existing entry
-		node.entry.Response = response
-		node.entry.ExpiresAt = time.Now().Add(ttl)
-		node.entry.AccessTime = time.Now()
-		c.moveToFront(node)
+	// Negative answers live under their own TTL ceiling
+	negative := isNegativeResponse(response)
+	if negative && c.config.NegativeMaxTTL > 0 && ttl > c.config.NegativeMaxTTL {
+		ttl = c.config.NegativeMaxTTL
+	}
+
+	// A negative answer filling a miss, rather than refreshing a live entry
+	if negative && (!exists || time.Now().After(node.entry.ExpiresAt)) {
+		c.stats.NegativeMisses++
+	}
+
+	// A response larger than the whole budget is never cached
+	if c.maxMemory > 0 && size > c.maxMemory {
+		if exists {
+			c.removeEntry(node)
+		}
 		return
 	}
 
-	// Evict if cache is full
-	if c.currentSize >= c.maxSize {
-		c.evictLRU()
+	// A refresh gets a new entry rather than updating the old one in place
+	var hits int64
+	if exists {
+		// Keep the entry out of eviction while making room for its new size
+		c.policy.remove(node)
+		c.memoryUsage -= node.size
+		hits = node.entry.HitCount
+	} else {
+		node = &cacheNode{key: key}
 	}
 
-	// Create new entry
-	entry := &CacheEntry{
+	c.makeRoom(size, exists)
+
+	now := time.Now()
+	node.entry = &CacheEntry{
 		Response:   response,
-		ExpiresAt:  time.Now().Add(ttl),
-		AccessTime: time.Now(),
-		HitCount:   0,
+		ExpiresAt:  now.Add(ttl),
+		AccessTime: now,
+		HitCount:   hits,
 	}
+	node.size = size
+	node.prefetching = false
+	node.negative = negative
 
-	// Create new node and add to front
-	node := &cacheNode{
-		key:   key,
-		entry: entry,
+	c.policy.add(node)
+	c.memoryUsage += size
+	if !exists {
+		c.entries[key] = node
+		c.currentSize++
 	}
 
-	c.addToFront(node)
-	c.entries[key] = node
-	c.currentSize++
-
 	c.stats.Size = c.currentSize
 }
 
@@ -139,9 +242,7 @@ func (c *Cache) Delete(question DNSQuestion) {
 
 	key := c.makeKey(question)
 	if node, exists := c.entries[key]; exists {
-		c.removeNode(node)
-		delete(c.entries, key)
-		c.currentSize--
+		c.removeEntry(node)
 		c.stats.Size = c.currentSize
 	}
 }
@@ -152,14 +253,21 @@ func (c *Cache) Clear() {
 	defer c.mu.Unlock()
 
 	c.entries = make(map[string]*cacheNode)
-	c.head.next = c.tail
-	c.tail.prev = c.head
+	c.policy.reset()
 	c.currentSize = 0
+	c.memoryUsage = 0
 
 	c.stats.Size = 0
 	c.stats.Hits = 0
 	c.stats.Misses = 0
 	c.stats.Evictions = 0
+	c.stats.CapacityEvictions = 0
+	c.stats.MemoryEvictions = 0
+	c.stats.Expirations = 0
+	c.stats.StaleHits = 0
+	c.stats.Prefetches = 0
+	c.stats.NegativeHits = 0
+	c.stats.NegativeMisses = 0
 	c.stats.HitRate = 0.0
 }
 
@@ -170,6 +278,7 @@ func (c *Cache) GetStats() *CacheStats {
 
 	stats := c.stats
 	stats.Size = c.currentSize
+	stats.MemoryUsage = c.memoryUsage
 	return &stats
 }
 
@@ -181,9 +290,9 @@ func (c *Cache) Cleanup() {
 	now := time.Now()
 	var toRemove []string
 
-	// Find expired entries
+	// Find expired entries past their stale window
 	for key, node := range c.entries {
-		if now.After(node.entry.ExpiresAt) {
+		if now.After(node.entry.ExpiresAt) && !c.withinStaleWindow(node.entry, now) {
 			toRemove = append(toRemove, key)
 		}
 	}
@@ -191,9 +300,8 @@ func (c *Cache) Cleanup() {
 	// Remove expired entries
 	for _, key := range toRemove {
 		if node, exists := c.entries[key]; exists {
-			c.removeNode(node)
-			delete(c.entries, key)
-			c.currentSize--
+			c.removeEntry(node)
+			c.stats.Expirations++
 		}
 	}
 
@@ -206,35 +314,60 @@ func (c *Cache) makeKey(question DNSQuestion) string {
 	return fmt.Sprintf("%s:%d:%d", question.Name, question.Type, question.Class)
 }
 
-// addToFront adds a node to the front of the LRU list
-func (c *Cache) addToFront(node *cacheNode) {
-	node.prev = c.head
-	node.next = c.head.next
-	c.head.next.prev = node
-	c.head.next = node
+// withinStaleWindow reports whether an entry may still be served, either
+// fresh or stale
+func (c *Cache) withinStaleWindow(entry *CacheEntry, now time.Time) bool {
+	if !c.config.ServeStale {
+		return !now.After(entry.ExpiresAt)
+	}
+	return now.Before(entry.ExpiresAt.Add(c.config.StaleWindow))
 }
 
-// removeNode removes a node from the LRU list
-func (c *Cache) removeNode(node *cacheNode) {
-	node.prev.next = node.next
-	node.next.prev = node.prev
+// entrySize approximates the memory held by an entry from the encoded
+// size of its response
+func (c *Cache) entrySize(key string, response *DNSResponse) int64 {
+	size := int64(len(key) + cacheEntryOverhead)
+	if data, err := c.parser.SerializeResponse(response); err == nil {
+		size += int64(len(data))
+	}
+	return size
 }
 
-// moveToFront moves a node to the front of the LRU list
-func (c *Cache) moveToFront(node *cacheNode) {
-	c.removeNode(node)
-	c.addToFront(node)
+// makeRoom evicts entries until one more entry of the given size fits
+// within the entry count and memory limits
+func (c *Cache) makeRoom(size int64, replacing bool) {
+	for !replacing && c.currentSize >= c.maxSize {
+		if !c.evict(&c.stats.CapacityEvictions) {
+			break
+		}
+	}
+
+	for c.maxMemory > 0 && c.memoryUsage+size > c.maxMemory {
+		if !c.evict(&c.stats.MemoryEvictions) {
+			break
+		}
+	}
 }
 
-// evictLRU evicts the least recently used entry
-func (c *Cache) evictLRU() {
-	if c.tail.prev != c.head {
-		lru := c.tail.prev
-		c.removeNode(lru)
-		delete(c.entries, lru.key)
-		c.currentSize--
-		c.stats.Evictions++
+// evict removes the policy's next victim, counting it under reason
+func (c *Cache) evict(reason *int64) bool {
+	victim := c.policy.victim()
+	if victim == nil {
+		return false
 	}
+
+	c.removeEntry(victim)
+	c.stats.Evictions++
+	*reason++
+	return true
+}
+
+// removeEntry removes a node from the index and the eviction policy
+func (c *Cache) removeEntry(node *cacheNode) {
+	c.policy.remove(node)
+	delete(c.entries, node.key)
+	c.currentSize--
+	c.memoryUsage -= node.size
 }
 
 // updateHitRate calculates the current cache hit rate
@@ -0,0 +1,136 @@
+package dns
+
+import "container/heap"
+
+// Cache eviction policies
+const (
+	EvictionLRU = "lru" // Least recently used
+	EvictionLFU = "lfu" // Least frequently used
+	EvictionTTL = "ttl" // Soonest to expire
+)
+
+// evictionPolicy orders cache nodes and picks the next one to evict
+type evictionPolicy interface {
+	// add starts tracking a new node
+	add(node *cacheNode)
+
+	// touch records a hit or update of a tracked node
+	touch(node *cacheNode)
+
+	// remove stops tracking a node
+	remove(node *cacheNode)
+
+	// victim returns the node to evict next, or nil if empty
+	victim() *cacheNode
+
+	// reset forgets all nodes
+	reset()
+}
+
+// newEvictionPolicy returns the policy for a configured name, LRU by default
+func newEvictionPolicy(name string) evictionPolicy {
+	switch name {
+	case EvictionLFU:
+		// Fewest hits first; among equals the one idle the longest
+		return &heapPolicy{less: func(a, b *cacheNode) bool {
+			if a.entry.HitCount != b.entry.HitCount {
+				return a.entry.HitCount < b.entry.HitCount
+			}
+			return a.entry.AccessTime.Before(b.entry.AccessTime)
+		}}
+	case EvictionTTL:
+		return &heapPolicy{less: func(a, b *cacheNode) bool {
+			return a.entry.ExpiresAt.Before(b.entry.ExpiresAt)
+		}}
+	default:
+		return newLRUPolicy()
+	}
+}
+
+// lruPolicy keeps nodes in a doubly linked list, most recently used first
+type lruPolicy struct {
+	head *cacheNode
+	tail *cacheNode
+}
+
+func newLRUPolicy() *lruPolicy {
+	// Sentinel nodes avoid nil checks at the ends of the list
+	p := &lruPolicy{head: &cacheNode{}, tail: &cacheNode{}}
+	p.reset()
+	return p
+}
+
+func (p *lruPolicy) add(node *cacheNode) {
+	node.prev = p.head
+	node.next = p.head.next
+	p.head.next.prev = node
+	p.head.next = node
+}
+
+func (p *lruPolicy) touch(node *cacheNode) {
+	p.remove(node)
+	p.add(node)
+}
+
+func (p *lruPolicy) remove(node *cacheNode) {
+	node.prev.next = node.next
+	node.next.prev = node.prev
+	node.prev, node.next = nil, nil
+}
+
+func (p *lruPolicy) victim() *cacheNode {
+	if p.tail.prev == p.head {
+		return nil
+	}
+	return p.tail.prev
+}
+
+func (p *lruPolicy) reset() {
+	p.head.next = p.tail
+	p.tail.prev = p.head
+}
+
+// heapPolicy keeps nodes in a binary heap ordered by less, the node to
+// evict first at the root
+type heapPolicy struct {
+	nodes []*cacheNode
+	less  func(a, b *cacheNode) bool
+}
+
+func (p *heapPolicy) add(node *cacheNode)    { heap.Push(p, node) }
+func (p *heapPolicy) touch(node *cacheNode)  { heap.Fix(p, node.index) }
+func (p *heapPolicy) remove(node *cacheNode) { heap.Remove(p, node.index) }
+func (p *heapPolicy) reset()                 { p.nodes = nil }
+
+func (p *heapPolicy) victim() *cacheNode {
+	if len(p.nodes) == 0 {
+		return nil
+	}
+	return p.nodes[0]
+}
+
+// heap.Interface implementation
+
+func (p *heapPolicy) Len() int           { return len(p.nodes) }
+func (p *heapPolicy) Less(i, j int) bool { return p.less(p.nodes[i], p.nodes[j]) }
+
+func (p *heapPolicy) Swap(i, j int) {
+	p.nodes[i], p.nodes[j] = p.nodes[j], p.nodes[i]
+	p.nodes[i].index = i
+	p.nodes[j].index = j
+}
+
+func (p *heapPolicy) Push(x any) {
+	node := x.(*cacheNode)
+	node.index = len(p.nodes)
+	p.nodes = append(p.nodes, node)
+}
+
+func (p *heapPolicy) Pop() any {
+	last := len(p.nodes) - 1
+	node := p.nodes[last]
+	p.nodes[last] = nil
+	p.nodes = p.nodes[:last]
+	node.index = -1
+	return node
+}
@@ -1,9 +1,14 @@
 package dns
 
 import (
+	"context"
 	"fmt"
+	"net"
+	"sync"
 	"testing"
 	"time"
+
+	"pihole-analyzer/internal/logger"
 )
 
 func TestCache_GetSet(t *testing.T) {
@@ -298,3 +303,365 @@ func TestCache_Stats(t *testing.T) {
 		t.Errorf("Expected hit rate %f, got %f", expectedHitRate, stats.HitRate)
 	}
 }
+
+func cacheTestEntry(name string) (DNSQuestion, *DNSResponse) {
+	question := DNSQuestion{Name: name, Type: TypeA, Class: ClassIN}
+	return question, &DNSResponse{
+		Question: question,
+		Answers: []DNSRecord{
+			{Name: name, Type: TypeA, Class: ClassIN, TTL: 300, Data: []byte{192, 0, 2, 1}},
+		},
+		ResponseCode: RCodeNoError,
+	}
+}
+
+func TestCache_LFUEviction(t *testing.T) {
+	cache := NewCache(CacheConfig{Enabled: true, MaxSize: 3, EvictionPolicy: EvictionLFU})
+
+	for i := 1; i <= 3; i++ {
+		question, response := cacheTestEntry(fmt.Sprintf("example%d.com", i))
+		cache.Set(question, response, 300*time.Second)
+	}
+
+	// example1 and example3 become popular, example2 is used once
+	for i := 0; i < 3; i++ {
+		cache.Get(DNSQuestion{Name: "example1.com", Type: TypeA, Class: ClassIN})
+		cache.Get(DNSQuestion{Name: "example3.com", Type: TypeA, Class: ClassIN})
+	}
+	cache.Get(DNSQuestion{Name: "example2.com", Type: TypeA, Class: ClassIN})
+
+	question, response := cacheTestEntry("example4.com")
+	cache.Set(question, response, 300*time.Second)
+
+	if _, found := cache.Get(DNSQuestion{Name: "example2.com", Type: TypeA, Class: ClassIN}); found {
+		t.Error("Expected the least frequently used entry to be evicted")
+	}
+	for _, name := range []string{"example1.com", "example3.com", "example4.com"} {
+		if _, found := cache.Get(DNSQuestion{Name: name, Type: TypeA, Class: ClassIN}); !found {
+			t.Errorf("Expected %s to be cached", name)
+		}
+	}
+
+	stats := cache.GetStats()
+	if stats.CapacityEvictions != 1 || stats.Evictions != 1 {
+		t.Errorf("Expected 1 capacity eviction, got %+v", stats)
+	}
+	if stats.EvictionPolicy != EvictionLFU {
+		t.Errorf("Expected policy lfu, got %s", stats.EvictionPolicy)
+	}
+}
+
+func TestCache_TTLEviction(t *testing.T) {
+	cache := NewCache(CacheConfig{Enabled: true, MaxSize: 3, EvictionPolicy: EvictionTTL})
+
+	ttls := map[string]time.Duration{
+		"long.com":   time.Hour,
+		"short.com":  time.Minute,
+		"medium.com": 10 * time.Minute,
+	}
+	for name, ttl := range ttls {
+		question, response := cacheTestEntry(name)
+		cache.Set(question, response, ttl)
+	}
+
+	// Refreshing an entry moves its expiry and its eviction order
+	question, response := cacheTestEntry("short.com")
+	cache.Set(question, response, 2*time.Hour)
+
+	question, response = cacheTestEntry("new.com")
+	cache.Set(question, response, 30*time.Minute)
+
+	if _, found := cache.Get(DNSQuestion{Name: "medium.com", Type: TypeA, Class: ClassIN}); found {
+		t.Error("Expected the soonest expiring entry to be evicted")
+	}
+	if _, found := cache.Get(DNSQuestion{Name: "short.com", Type: TypeA, Class: ClassIN}); !found {
+		t.Error("Expected the refreshed entry to be kept")
+	}
+}
+
+func TestCache_MemoryBudget(t *testing.T) {
+	cache := NewCache(CacheConfig{Enabled: true, MaxSize: 100000, MaxMemoryMB: 1}).(*Cache)
+
+	question, response := cacheTestEntry("example0.com")
+	entrySize := cache.entrySize(cache.makeKey(question), response)
+
+	total := int(cache.maxMemory/entrySize) + 10
+	for i := 0; i < total; i++ {
+		question, response := cacheTestEntry(fmt.Sprintf("example%d.com", i))
+		cache.Set(question, response, 300*time.Second)
+	}
+
+	stats := cache.GetStats()
+	if stats.MemoryUsage > stats.MaxMemory {
+		t.Errorf("Expected memory use within %d bytes, got %d", stats.MaxMemory, stats.MemoryUsage)
+	}
+	if stats.MemoryEvictions < 10 {
+		t.Errorf("Expected at least 10 memory evictions, got %d", stats.MemoryEvictions)
+	}
+	if stats.CapacityEvictions != 0 {
+		t.Errorf("Expected no capacity evictions, got %d", stats.CapacityEvictions)
+	}
+
+	// Oldest entries went first
+	if _, found := cache.Get(DNSQuestion{Name: "example0.com", Type: TypeA, Class: ClassIN}); found {
+		t.Error("Expected example0.com to be evicted")
+	}
+
+	// Memory accounting returns to zero once everything is gone
+	cache.Clear()
+	if stats := cache.GetStats(); stats.MemoryUsage != 0 || stats.Size != 0 {
+		t.Errorf("Expected empty cache after Clear, got %+v", stats)
+	}
+}
+
+func TestCache_ExpirationCounted(t *testing.T) {
+	cache := NewCache(CacheConfig{Enabled: true, MaxSize: 10})
+
+	question, response := cacheTestEntry("example.com")
+	cache.Set(question, response, time.Millisecond)
+	other, response := cacheTestEntry("other.com")
+	cache.Set(other, response, time.Millisecond)
+	time.Sleep(5 * time.Millisecond)
+
+	cache.Get(question)
+	cache.Cleanup()
+
+	stats := cache.GetStats()
+	if stats.Expirations != 2 {
+		t.Errorf("Expected 2 expirations, got %d", stats.Expirations)
+	}
+	if stats.Evictions != 0 || stats.MemoryUsage != 0 {
+		t.Errorf("Expected no evictions and no memory in use, got %+v", stats)
+	}
+}
+
+func TestCache_ServeStale(t *testing.T) {
+	cache := NewCache(CacheConfig{
+		Enabled:     true,
+		MaxSize:     10,
+		ServeStale:  true,
+		StaleWindow: time.Hour,
+	})
+
+	question, response := cacheTestEntry("example.com")
+	cache.Set(question, response, time.Millisecond)
+	time.Sleep(5 * time.Millisecond)
+
+	// Expired entries miss but are kept for the stale window
+	if _, found := cache.Get(question); found {
+		t.Error("Expected expired entry to miss")
+	}
+	cache.Cleanup()
+
+	entry, found := cache.GetStale(question)
+	if !found || entry.Response != response {
+		t.Fatal("Expected expired entry to be served stale")
+	}
+
+	stats := cache.GetStats()
+	if stats.StaleHits != 1 || stats.Expirations != 0 || stats.Size != 1 {
+		t.Errorf("Expected 1 stale hit and the entry kept, got %+v", stats)
+	}
+
+	// Without serve-stale expired entries are dropped
+	cache = NewCache(CacheConfig{Enabled: true, MaxSize: 10})
+	cache.Set(question, response, time.Millisecond)
+	time.Sleep(5 * time.Millisecond)
+	if _, found := cache.GetStale(question); found {
+		t.Error("Expected no stale answer with serve-stale disabled")
+	}
+}
+
+func TestCache_Prefetch(t *testing.T) {
+	cache := NewCache(CacheConfig{
+		Enabled:           true,
+		MaxSize:           10,
+		Prefetch:          true,
+		PrefetchThreshold: 2,
+		PrefetchWindow:    time.Minute,
+	})
+
+	question, response := cacheTestEntry("example.com")
+	cache.Set(question, response, 30*time.Second)
+
+	cache.Get(question)
+	if cache.Prefetch(question) {
+		t.Error("Expected no prefetch below the hit threshold")
+	}
+
+	cache.Get(question)
+	if !cache.Prefetch(question) {
+		t.Fatal("Expected prefetch of a popular entry close to expiry")
+	}
+	if cache.Prefetch(question) {
+		t.Error("Expected only one prefetch while the refresh is in flight")
+	}
+
+	// A refresh that stored nothing releases its claim
+	cache.CancelPrefetch(question)
+	if !cache.Prefetch(question) {
+		t.Error("Expected prefetch again after a failed refresh")
+	}
+
+	// A refresh re-arms prefetching; the long TTL puts it outside the window
+	cache.Set(question, response, time.Hour)
+	if cache.Prefetch(question) {
+		t.Error("Expected no prefetch far from expiry")
+	}
+
+	if stats := cache.GetStats(); stats.Prefetches != 2 {
+		t.Errorf("Expected 2 prefetches, got %d", stats.Prefetches)
+	}
+}
+
+func TestCache_RefreshWhileHit(t *testing.T) {
+	cache := NewCache(CacheConfig{Enabled: true, MaxSize: 10})
+
+	question, response := cacheTestEntry("example.com")
+	cache.Set(question, response, time.Minute)
+
+	// Entries handed out keep their contents while the key is refreshed;
+	// run with -race to catch in-place updates
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 200; i++ {
+			cache.Set(question, response, time.Duration(i+1)*time.Minute)
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 200; i++ {
+			if entry, ok := cache.Get(question); ok && (entry.Response == nil || entry.ExpiresAt.IsZero()) {
+				t.Error("Expected a complete cache entry")
+				return
+			}
+		}
+	}()
+	wg.Wait()
+
+	if entry, ok := cache.Get(question); !ok || entry.HitCount < 201 {
+		t.Errorf("Expected hits to carry over refreshes, got %+v", entry)
+	}
+}
+
+func TestServer_ServeStaleAndPrefetch(t *testing.T) {
+	config := DefaultConfig()
+	config.LogQueries = false
+	config.Forwarder.HealthCheck = false
+
+	testLogger := logger.New(&logger.Config{
+		Level:     logger.LogLevel("ERROR"),
+		Component: "dns-test",
+	})
+
+	upstream := &fakeTransport{fail: true}
+	server := NewServer(config, testLogger).(*Server)
+	server.forwarder = newFakeForwarder("round_robin", map[string]*fakeTransport{"upstream:53": upstream})
+
+	query := testForwardQuery()
+	question, response := cacheTestEntry(query.Question.Name)
+	server.cache.Set(question, response, time.Millisecond)
+	time.Sleep(5 * time.Millisecond)
+
+	// Upstreams are down, the expired answer is served with the stale TTL
+	stale, err := server.HandleQuery(context.Background(), query)
+	if err != nil {
+		t.Fatalf("HandleQuery failed: %v", err)
+	}
+	if stale.ResponseCode != RCodeNoError || len(stale.Answers) != 1 {
+		t.Fatalf("Expected stale answer, got rcode %d with %d answers", stale.ResponseCode, len(stale.Answers))
+	}
+	if stale.Answers[0].TTL != 30 {
+		t.Errorf("Expected stale TTL 30, got %d", stale.Answers[0].TTL)
+	}
+	if response.Answers[0].TTL != 300 {
+		t.Error("Expected the cached entry to keep its TTL")
+	}
+
+	// Once upstreams recover, a popular entry close to expiry is refreshed
+	upstream.fail = false
+	upstream.calls.Store(0)
+	server.cache.Set(question, response, 5*time.Second)
+	for i := int64(0); i < config.Cache.PrefetchThreshold; i++ {
+		server.HandleQuery(context.Background(), query)
+	}
+	server.wg.Wait()
+
+	if upstream.calls.Load() != 1 {
+		t.Errorf("Expected one prefetch query upstream, got %d", upstream.calls.Load())
+	}
+	stats := server.cache.GetStats()
+	if stats.StaleHits != 1 || stats.Prefetches != 1 {
+		t.Errorf("Expected 1 stale hit and 1 prefetch, got %d and %d", stats.StaleHits, stats.Prefetches)
+	}
+	if entry, _ := server.cache.Get(question); entry.ExpiresAt.Before(time.Now().Add(time.Minute)) {
+		t.Error("Expected the prefetch to extend the entry")
+	}
+
+	// Queries answered while the server stops start no more prefetches
+	server.running.Store(true)
+	if err := server.Stop(context.Background()); err != nil {
+		t.Fatalf("Stop failed: %v", err)
+	}
+	upstream.calls.Store(0)
+	server.cache.Set(question, response, 5*time.Second)
+	for i := int64(0); i < config.Cache.PrefetchThreshold; i++ {
+		server.HandleQuery(context.Background(), query)
+	}
+	server.wg.Wait()
+
+	if upstream.calls.Load() != 0 {
+		t.Errorf("Expected no prefetch after Stop, got %d upstream queries", upstream.calls.Load())
+	}
+}
+
+func TestServer_ConcurrentCacheHits(t *testing.T) {
+	config := DefaultConfig()
+	config.LogQueries = false
+	config.Forwarder.HealthCheck = false
+	config.Cache.Prefetch = false
+
+	testLogger := logger.New(&logger.Config{
+		Level:     logger.LogLevel("ERROR"),
+		Component: "dns-test",
+	})
+
+	server := NewServer(config, testLogger).(*Server)
+	server.forwarder = newFakeForwarder("round_robin", map[string]*fakeTransport{"upstream:53": {}})
+
+	question, response := cacheTestEntry("example.com")
+	server.cache.Set(question, response, time.Minute)
+
+	// Each hit gets its own ID; run with -race to catch shared writes
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(worker int) {
+			defer wg.Done()
+			for j := 0; j < 50; j++ {
+				id := uint16(worker*100 + j)
+				reply, err := server.HandleQuery(context.Background(), &DNSQuery{
+					ID:       id,
+					Question: question,
+					Client:   &net.UDPAddr{IP: net.ParseIP("192.0.2.1"), Port: 5000},
+					Protocol: "udp",
+				})
+				if err != nil {
+					t.Errorf("HandleQuery failed: %v", err)
+					return
+				}
+				if reply.ID != id || !reply.Cached {
+					t.Errorf("Expected cached reply with ID %d, got ID %d cached %v", id, reply.ID, reply.Cached)
+					return
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	if entry, _ := server.cache.Get(question); entry.Response.Cached || entry.Response.ResponseTime != 0 {
+		t.Error("Expected cache hits to leave the cached entry unchanged")
+	}
+}
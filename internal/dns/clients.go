@@ -0,0 +1,197 @@
+package dns
+
+import (
+	"bufio"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultGroupName is the group every client belongs to unless a client
+// entry assigns it to other groups
+const DefaultGroupName = "default"
+
+// arpRefreshInterval bounds how often the ARP table is re-read
+const arpRefreshInterval = 30 * time.Second
+
+// MACResolver maps a client IP address to its hardware address
+type MACResolver interface {
+	// ResolveMAC returns the MAC address for an IP, if known
+	ResolveMAC(ip net.IP) (string, bool)
+}
+
+// ARPTableResolver resolves MAC addresses from the kernel ARP/neighbour
+// table (/proc/net/arp). The table is re-read at most once per refresh
+// interval.
+type ARPTableResolver struct {
+	mu        sync.Mutex
+	path      string
+	refresh   time.Duration
+	lastRead  time.Time
+	addresses map[string]string
+}
+
+// NewARPTableResolver creates a MAC resolver backed by /proc/net/arp
+func NewARPTableResolver(refresh time.Duration) *ARPTableResolver {
+	return &ARPTableResolver{
+		path:      "/proc/net/arp",
+		refresh:   refresh,
+		addresses: make(map[string]string),
+	}
+}
+
+// ResolveMAC returns the MAC address for an IP from the ARP table
+func (r *ARPTableResolver) ResolveMAC(ip net.IP) (string, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if time.Since(r.lastRead) > r.refresh {
+		r.reload()
+	}
+
+	mac, ok := r.addresses[ip.String()]
+	return mac, ok
+}
+
+// reload re-reads the ARP table. Failures leave the previous table intact.
+func (r *ARPTableResolver) reload() {
+	r.lastRead = time.Now()
+
+	file, err := os.Open(r.path)
+	if err != nil {
+		return
+	}
+	defer file.Close()
+
+	addresses := make(map[string]string)
+	scanner := bufio.NewScanner(file)
+	scanner.Scan() // Skip header line
+
+	for scanner.Scan() {
+		// IP address, HW type, Flags, HW address, Mask, Device
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 4 || fields[3] == "00:00:00:00:00:00" {
+			continue
+		}
+		addresses[fields[0]] = normalizeMAC(fields[3])
+	}
+
+	r.addresses = addresses
+}
+
+// clientMatcher identifies clients belonging to a client entry
+type clientMatcher struct {
+	ip      net.IP
+	network *net.IPNet
+	mac     string
+	groups  []string
+}
+
+// clientTable resolves client addresses to their groups. Precedence
+// follows specificity: MAC, then exact IP, then the longest matching network.
+type clientTable struct {
+	byMAC    map[string]*clientMatcher
+	byIP     map[string]*clientMatcher
+	networks []*clientMatcher
+}
+
+// newClientTable compiles client entries into a lookup table
+func newClientTable(clients []ClientConfig) (*clientTable, error) {
+	table := &clientTable{
+		byMAC: make(map[string]*clientMatcher),
+		byIP:  make(map[string]*clientMatcher),
+	}
+
+	for _, client := range clients {
+		matcher := &clientMatcher{groups: client.Groups}
+
+		switch {
+		case client.MAC != "":
+			hw, err := net.ParseMAC(client.MAC)
+			if err != nil {
+				return nil, ErrInvalidClient
+			}
+			matcher.mac = normalizeMAC(hw.String())
+			table.byMAC[matcher.mac] = matcher
+		case client.IP != "":
+			ip := net.ParseIP(client.IP)
+			if ip == nil {
+				return nil, ErrInvalidClient
+			}
+			matcher.ip = ip
+			table.byIP[ip.String()] = matcher
+		case client.Network != "":
+			_, network, err := net.ParseCIDR(client.Network)
+			if err != nil {
+				return nil, ErrInvalidClient
+			}
+			matcher.network = network
+			table.networks = append(table.networks, matcher)
+		default:
+			return nil, ErrInvalidClient
+		}
+	}
+
+	return table, nil
+}
+
+// groupsFor returns the groups for a client, falling back to the default group
+func (t *clientTable) groupsFor(ip net.IP, resolver MACResolver) []string {
+	if ip == nil {
+		return []string{DefaultGroupName}
+	}
+
+	if len(t.byMAC) > 0 && resolver != nil {
+		if mac, ok := resolver.ResolveMAC(ip); ok {
+			if matcher, ok := t.byMAC[normalizeMAC(mac)]; ok {
+				return matcher.groups
+			}
+		}
+	}
+
+	if matcher, ok := t.byIP[ip.String()]; ok {
+		return matcher.groups
+	}
+
+	var best *clientMatcher
+	bestBits := -1
+	for _, matcher := range t.networks {
+		if !matcher.network.Contains(ip) {
+			continue
+		}
+		if bits, _ := matcher.network.Mask.Size(); bits > bestBits {
+			best = matcher
+			bestBits = bits
+		}
+	}
+	if best != nil {
+		return best.groups
+	}
+
+	return []string{DefaultGroupName}
+}
+
+// clientIP extracts the IP address from a client network address
+func clientIP(addr net.Addr) net.IP {
+	switch a := addr.(type) {
+	case *net.UDPAddr:
+		return a.IP
+	case *net.TCPAddr:
+		return a.IP
+	case nil:
+		return nil
+	}
+
+	host, _, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		host = addr.String()
+	}
+	return net.ParseIP(host)
+}
+
+// normalizeMAC lowercases a MAC address for comparison
+func normalizeMAC(mac string) string {
+	return strings.ToLower(strings.TrimSpace(mac))
+}
@@ -0,0 +1,201 @@
+package dns
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// conditionalRoute is a forwarder dedicated to the domains of one
+// conditional forwarding rule
+type conditionalRoute struct {
+	name      string // Configured domains, for statistics
+	forwarder *Forwarder
+}
+
+// validate checks a conditional forwarding rule
+func (rule ConditionalForwardConfig) validate() error {
+	if len(rule.Domains) == 0 {
+		return fmt.Errorf("%w: no domains", ErrInvalidConditional)
+	}
+	if len(rule.Upstreams) == 0 {
+		return fmt.Errorf("%w: no upstreams for %s", ErrInvalidConditional, strings.Join(rule.Domains, ", "))
+	}
+
+	for _, domain := range rule.Domains {
+		if _, err := conditionalZones(domain); err != nil {
+			return err
+		}
+	}
+	for _, upstream := range rule.Upstreams {
+		if _, err := parseUpstream(upstream); err != nil {
+			return err
+		}
+	}
+
+	if rule.Timeout < 0 || rule.HealthInterval < 0 || rule.Retries < 0 {
+		return fmt.Errorf("%w: negative timeout, retries or health interval", ErrInvalidConditional)
+	}
+
+	switch rule.LoadBalancing {
+	case "", "round_robin", "random", "fastest", "parallel":
+	default:
+		return ErrInvalidLoadBalancing
+	}
+	return nil
+}
+
+// forwarderConfig derives the configuration of the rule's own forwarder
+// from the parent forwarder's
+func (rule ConditionalForwardConfig) forwarderConfig(parent ForwarderConfig) ForwarderConfig {
+	config := parent
+	config.Enabled = true
+	config.Upstreams = rule.Upstreams
+	config.Retries = rule.Retries
+	config.HealthCheck = rule.HealthCheck
+	config.Conditional = nil
+
+	if rule.Timeout > 0 {
+		config.Timeout = rule.Timeout
+	}
+	if rule.HealthInterval > 0 {
+		config.HealthInterval = rule.HealthInterval
+	}
+	if rule.LoadBalancing != "" {
+		config.LoadBalancing = rule.LoadBalancing
+	}
+	return config
+}
+
+// newConditionalRoutes creates a forwarder per rule, in rule order, and
+// indexes it by every zone the rule covers. Rules are expected to be
+// validated; invalid domains are skipped.
+func newConditionalRoutes(config ForwarderConfig) ([]*conditionalRoute, map[string]*conditionalRoute) {
+	if len(config.Conditional) == 0 {
+		return nil, nil
+	}
+
+	var ordered []*conditionalRoute
+	routes := make(map[string]*conditionalRoute)
+	for _, rule := range config.Conditional {
+		route := &conditionalRoute{
+			name:      strings.Join(rule.Domains, ","),
+			forwarder: NewForwarder(rule.forwarderConfig(config)).(*Forwarder),
+		}
+		ordered = append(ordered, route)
+
+		for _, domain := range rule.Domains {
+			zones, err := conditionalZones(domain)
+			if err != nil {
+				continue
+			}
+			for _, zone := range zones {
+				routes[zone] = route
+			}
+		}
+	}
+	return ordered, routes
+}
+
+// closeConditionalRoutes stops the forwarders of replaced routes
+func closeConditionalRoutes(routes []*conditionalRoute) {
+	for _, route := range routes {
+		route.forwarder.Close()
+	}
+}
+
+// SetConditional replaces the conditional forwarding rules, closing the
+// forwarders of the previous rules. Rules are expected to be validated.
+func (f *Forwarder) SetConditional(rules []ConditionalForwardConfig) {
+	config := f.config
+	config.Conditional = rules
+	routes, conditional := newConditionalRoutes(config)
+
+	if tap := f.tap.Load(); tap != nil {
+		for _, route := range routes {
+			route.forwarder.SetDnstap(tap)
+		}
+	}
+
+	f.mu.Lock()
+	previous := f.routes
+	f.routes, f.conditional = routes, conditional
+	f.mu.Unlock()
+
+	closeConditionalRoutes(previous)
+}
+
+// conditionalFor returns the route of the longest conditional zone
+// containing name, or nil if the default upstreams apply
+func (f *Forwarder) conditionalFor(name string) *conditionalRoute {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+
+	if len(f.conditional) == 0 {
+		return nil
+	}
+
+	domain := normalizeDomain(name)
+	for {
+		if route, ok := f.conditional[domain]; ok {
+			return route
+		}
+		i := strings.IndexByte(domain, '.')
+		if i < 0 {
+			return nil
+		}
+		domain = domain[i+1:]
+	}
+}
+
+// conditionalZones returns the zones covered by a configured domain. A
+// network in CIDR notation covers its reverse zones.
+func conditionalZones(domain string) ([]string, error) {
+	if _, network, err := net.ParseCIDR(strings.TrimSpace(domain)); err == nil {
+		return reverseZones(network), nil
+	}
+
+	zone := normalizeDomain(domain)
+	if !isValidDomain(zone) {
+		return nil, fmt.Errorf("%w: invalid domain %q", ErrInvalidConditional, domain)
+	}
+	return []string{zone}, nil
+}
+
+// reverseZones returns the in-addr.arpa or ip6.arpa zones covering a
+// network. Prefixes that do not fall on an octet (IPv4) or nibble (IPv6)
+// boundary are split into the zones one level below.
+func reverseZones(network *net.IPNet) []string {
+	ones, bits := network.Mask.Size()
+
+	var digits []int
+	unit, base, suffix := 8, 10, "in-addr.arpa"
+	if ip4 := network.IP.To4(); ip4 != nil && bits == 32 {
+		for _, b := range ip4 {
+			digits = append(digits, int(b))
+		}
+	} else {
+		unit, base, suffix = 4, 16, "ip6.arpa"
+		for _, b := range network.IP.To16() {
+			digits = append(digits, int(b>>4), int(b&0x0F))
+		}
+	}
+
+	n := (ones + unit - 1) / unit
+	count := 1 << (n*unit - ones)
+
+	zones := make([]string, 0, count)
+	for i := 0; i < count; i++ {
+		labels := make([]string, 0, n+1)
+		for j := n - 1; j >= 0; j-- {
+			digit := digits[j]
+			if j == n-1 {
+				digit += i
+			}
+			labels = append(labels, strconv.FormatInt(int64(digit), base))
+		}
+		zones = append(zones, strings.Join(append(labels, suffix), "."))
+	}
+	return zones
+}
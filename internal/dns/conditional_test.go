@@ -0,0 +1,192 @@
+package dns
+
+import (
+	"context"
+	"errors"
+	"net"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestReverseZones(t *testing.T) {
+	tests := []struct {
+		cidr  string
+		zones []string
+	}{
+		{"192.168.0.0/16", []string{"168.192.in-addr.arpa"}},
+		{"10.1.2.0/24", []string{"2.1.10.in-addr.arpa"}},
+		{"172.16.0.0/14", []string{
+			"16.172.in-addr.arpa", "17.172.in-addr.arpa", "18.172.in-addr.arpa", "19.172.in-addr.arpa",
+		}},
+		{"fd00::/8", []string{"d.f.ip6.arpa"}},
+		{"2001:db8::/31", []string{"8.b.d.0.1.0.0.2.ip6.arpa", "9.b.d.0.1.0.0.2.ip6.arpa"}},
+	}
+
+	for _, tt := range tests {
+		_, network, err := net.ParseCIDR(tt.cidr)
+		if err != nil {
+			t.Fatalf("ParseCIDR(%s) failed: %v", tt.cidr, err)
+		}
+		if zones := reverseZones(network); !reflect.DeepEqual(zones, tt.zones) {
+			t.Errorf("reverseZones(%s) = %v, want %v", tt.cidr, zones, tt.zones)
+		}
+	}
+}
+
+func TestForwarder_ConditionalRouting(t *testing.T) {
+	transports := map[string]*fakeTransport{
+		"public:53": {},
+		"router:53": {},
+		"ad1:53":    {},
+		"ad2:53":    {},
+	}
+
+	forwarder := NewForwarder(ForwarderConfig{
+		Enabled:       true,
+		Upstreams:     []string{"public:53"},
+		Timeout:       time.Second,
+		Retries:       2,
+		LoadBalancing: "round_robin",
+		Conditional: []ConditionalForwardConfig{
+			{Domains: []string{"lan", "192.168.0.0/16"}, Upstreams: []string{"router:53"}},
+			{Domains: []string{"corp.example"}, Upstreams: []string{"ad1:53", "ad2:53"}, Timeout: 2 * time.Second},
+			{Domains: []string{"dev.corp.example"}, Upstreams: []string{"router:53"}},
+		},
+	}).(*Forwarder)
+
+	for _, route := range forwarder.routes {
+		for _, upstream := range route.forwarder.upstreams {
+			route.forwarder.transports[upstream] = transports[upstream]
+		}
+	}
+	forwarder.transports["public:53"] = transports["public:53"]
+
+	if timeout := forwarder.conditionalFor("dc.corp.example").forwarder.config.Timeout; timeout != 2*time.Second {
+		t.Errorf("Expected rule timeout of 2s, got %v", timeout)
+	}
+	if timeout := forwarder.conditionalFor("nas.lan").forwarder.config.Timeout; timeout != time.Second {
+		t.Errorf("Expected inherited timeout of 1s, got %v", timeout)
+	}
+
+	tests := []struct {
+		name     string
+		upstream []string
+	}{
+		{"nas.lan", []string{"router:53"}},
+		{"LAN.", []string{"router:53"}},
+		{"10.1.168.192.in-addr.arpa", []string{"router:53"}},
+		{"dc.corp.example", []string{"ad1:53", "ad2:53"}},
+		{"build.dev.corp.example", []string{"router:53"}}, // Longest match wins
+		{"example.com", []string{"public:53"}},
+		{"plan", []string{"public:53"}},
+		{"10.0.0.10.in-addr.arpa", []string{"public:53"}},
+	}
+
+	for _, tt := range tests {
+		for _, transport := range transports {
+			transport.calls.Store(0)
+		}
+
+		query := testForwardQuery()
+		query.Question.Name = tt.name
+		if _, err := forwarder.Forward(context.Background(), query); err != nil {
+			t.Fatalf("Forward(%s) failed: %v", tt.name, err)
+		}
+
+		var calls int64
+		for _, upstream := range tt.upstream {
+			calls += transports[upstream].calls.Load()
+		}
+		if calls != 1 {
+			t.Errorf("Forward(%s) expected one query to %v, got %d", tt.name, tt.upstream, calls)
+		}
+	}
+
+	var zones []string
+	for _, stats := range forwarder.GetUpstreamStats() {
+		zones = append(zones, stats.Zone)
+	}
+	want := []string{"", "lan,192.168.0.0/16", "corp.example", "corp.example", "dev.corp.example"}
+	if !reflect.DeepEqual(zones, want) {
+		t.Errorf("Unexpected upstream stats zones %q, want %q", zones, want)
+	}
+}
+
+func TestForwarder_SetConditional(t *testing.T) {
+	forwarder := NewForwarder(ForwarderConfig{
+		Enabled:        true,
+		Upstreams:      []string{"public:53"},
+		Timeout:        time.Second,
+		HealthInterval: time.Hour,
+		LoadBalancing:  "round_robin",
+		Conditional: []ConditionalForwardConfig{
+			{Domains: []string{"lan"}, Upstreams: []string{"router:53"}, HealthCheck: true},
+		},
+	}).(*Forwarder)
+	defer forwarder.Close()
+
+	previous := forwarder.conditionalFor("nas.lan")
+	if previous == nil {
+		t.Fatal("Expected a route for lan")
+	}
+
+	forwarder.SetConditional([]ConditionalForwardConfig{
+		{Domains: []string{"home.arpa"}, Upstreams: []string{"router:53"}, HealthCheck: true},
+	})
+
+	// The replaced route's health checker is stopped
+	select {
+	case <-previous.forwarder.done:
+	default:
+		t.Error("Expected the replaced route to be closed")
+	}
+	if forwarder.conditionalFor("nas.lan") != nil {
+		t.Error("Expected the lan route to be removed")
+	}
+
+	current := forwarder.conditionalFor("nas.home.arpa")
+	if current == nil {
+		t.Fatal("Expected a route for home.arpa")
+	}
+
+	// Closing the forwarder stops the routes too
+	forwarder.Close()
+	select {
+	case <-current.forwarder.done:
+	default:
+		t.Error("Expected Close to close the routes")
+	}
+}
+
+func TestConfig_ConditionalValidation(t *testing.T) {
+	invalid := []ConditionalForwardConfig{
+		{Upstreams: []string{"192.168.1.1:53"}},
+		{Domains: []string{"lan"}},
+		{Domains: []string{"bad..lan"}, Upstreams: []string{"192.168.1.1:53"}},
+		{Domains: []string{"lan"}, Upstreams: []string{"192.168.1.1:53"}, Timeout: -time.Second},
+		{Domains: []string{"lan"}, Upstreams: []string{"192.168.1.1:53"}, LoadBalancing: "weighted"},
+		{Domains: []string{"lan"}, Upstreams: []string{"ftp://192.168.1.1"}},
+	}
+
+	for _, rule := range invalid {
+		config := DefaultConfig()
+		config.Forwarder.Conditional = []ConditionalForwardConfig{rule}
+		if err := config.Validate(); err == nil {
+			t.Errorf("Expected validation error for %+v", rule)
+		}
+	}
+
+	config := DefaultConfig()
+	config.Forwarder.Conditional = []ConditionalForwardConfig{
+		{Domains: []string{"lan", "192.168.0.0/16", "fd00::/8"}, Upstreams: []string{"192.168.1.1:53"}},
+	}
+	if err := config.Validate(); err != nil {
+		t.Errorf("Expected valid rule, got %v", err)
+	}
+
+	config.Forwarder.Conditional[0].Domains = nil
+	if err := config.Validate(); !errors.Is(err, ErrInvalidConditional) {
+		t.Errorf("Expected ErrInvalidConditional, got %v", err)
+	}
+}
@@ -1,6 +1,7 @@
 package dns
 
 import (
+	"fmt"
 	"time"
 )
 
@@ -13,6 +14,9 @@ type Config struct {
 	TCPEnabled bool   `json:"tcp_enabled"`
 	UDPEnabled bool   `json:"udp_enabled"`
 
+	// DNS-over-TLS listener
+	TLS TLSConfig `json:"tls"`
+
 	// Timeouts
 	ReadTimeout  time.Duration `json:"read_timeout"`
 	WriteTimeout time.Duration `json:"write_timeout"`
@@ -24,6 +28,33 @@ type Config struct {
 	// Forwarder configuration
 	Forwarder ForwarderConfig `json:"forwarder"`
 
+	// Iterative resolution from the root servers instead of forwarding
+	Recursion RecursionConfig `json:"recursion"`
+
+	// Blocklist configuration
+	Blocklist BlocklistConfig `json:"blocklist"`
+
+	// Domain rules and client groups
+	Rules RulesConfig `json:"rules"`
+
+	// Local records and authoritative zones
+	Local LocalRecordsConfig `json:"local"`
+
+	// Group-scoped domain rewrites and safe search
+	Rewrites RewritesConfig `json:"rewrites"`
+
+	// DNSSEC validation of forwarded answers
+	DNSSEC DNSSECConfig `json:"dnssec"`
+
+	// In-memory log of handled queries
+	QueryLog QueryLogConfig `json:"query_log"`
+
+	// Per-client query limits and response rate limiting
+	RateLimit RateLimitConfig `json:"rate_limit"`
+
+	// dnstap logging of client and forwarder messages
+	Dnstap DnstapConfig `json:"dnstap"`
+
 	// Logging
 	LogQueries bool `json:"log_queries"`
 	LogLevel   int  `json:"log_level"`
@@ -33,6 +64,14 @@ type Config struct {
 	BufferSize           int `json:"buffer_size"`
 }
 
+// TLSConfig represents DNS-over-TLS (RFC 7858) listener configuration
+type TLSConfig struct {
+	Enabled  bool   `json:"enabled"`
+	Port     int    `json:"port"`
+	CertFile string `json:"cert_file"`
+	KeyFile  string `json:"key_file"`
+}
+
 // CacheConfig represents DNS cache configuration
 type CacheConfig struct {
 	Enabled         bool          `json:"enabled"`
@@ -47,23 +86,372 @@ type CacheConfig struct {
 
 	// Memory limits
 	MaxMemoryMB int `json:"max_memory_mb"`
+
+	// Serve-stale (RFC 8767): keep expired entries for StaleWindow and
+	// answer with them, using StaleTTL, when every upstream fails
+	ServeStale  bool          `json:"serve_stale"`
+	StaleWindow time.Duration `json:"stale_window"`
+	StaleTTL    time.Duration `json:"stale_ttl"`
+
+	// Prefetch: refresh entries hit at least PrefetchThreshold times when
+	// they are within PrefetchWindow of expiring
+	Prefetch          bool          `json:"prefetch"`
+	PrefetchThreshold int64         `json:"prefetch_threshold"`
+	PrefetchWindow    time.Duration `json:"prefetch_window"`
+
+	// Negative caching (RFC 2308) of NXDOMAIN and NODATA answers, with
+	// TTLs taken from the SOA record and capped at NegativeMaxTTL
+	NegativeCache  bool          `json:"negative_cache"`
+	NegativeMaxTTL time.Duration `json:"negative_max_ttl"`
 }
 
 // ForwarderConfig represents DNS forwarder configuration
 type ForwarderConfig struct {
-	Enabled        bool          `json:"enabled"`
+	Enabled bool `json:"enabled"`
+
+	// Upstreams are "host:port" (plain UDP) or URLs such as
+	// "tls://1.1.1.1:853" and "https://dns.example/dns-query"
 	Upstreams      []string      `json:"upstreams"`
 	Timeout        time.Duration `json:"timeout"`
 	Retries        int           `json:"retries"`
 	HealthCheck    bool          `json:"health_check"`
 	HealthInterval time.Duration `json:"health_interval"`
 
-	// Load balancing: "round_robin", "random", "fastest"
+	// Load balancing: "round_robin", "random", "fastest", "parallel"
 	LoadBalancing string `json:"load_balancing"`
 
+	// Number of best-ranked upstreams raced by the "parallel" strategy
+	ParallelUpstreams int `json:"parallel_upstreams"`
+
 	// EDNS0 support
 	EDNS0Enabled bool `json:"edns0_enabled"`
 	UDPSize      int  `json:"udp_size"`
+
+	// Pass the EDNS Client Subnet option upstream instead of stripping it
+	ForwardClientSubnet bool `json:"forward_client_subnet"`
+
+	// CA bundle for verifying DoT/DoH upstreams (system roots if empty)
+	CAFile string `json:"ca_file"`
+
+	// Conditional forwarding rules; the longest matching domain wins
+	Conditional []ConditionalForwardConfig `json:"conditional"`
+}
+
+// ConditionalForwardConfig sends queries below some domains to dedicated
+// upstreams. Zero Timeout, HealthInterval and LoadBalancing values inherit
+// the forwarder's settings.
+type ConditionalForwardConfig struct {
+	// Domain suffixes ("lan", "corp.example", "168.192.in-addr.arpa") or
+	// networks ("192.168.0.0/16") whose reverse zone is forwarded
+	Domains   []string `json:"domains"`
+	Upstreams []string `json:"upstreams"`
+
+	Timeout        time.Duration `json:"timeout"`
+	Retries        int           `json:"retries"`
+	HealthCheck    bool          `json:"health_check"`
+	HealthInterval time.Duration `json:"health_interval"`
+	LoadBalancing  string        `json:"load_balancing"`
+}
+
+// RecursionConfig represents iterative resolution starting at the root
+// servers. When enabled it replaces the default upstreams; conditional
+// forwarding rules still apply.
+type RecursionConfig struct {
+	Enabled bool `json:"enabled"`
+
+	// Root server addresses, "ip" or "ip:port"
+	RootHints []string `json:"root_hints"`
+
+	// Send authoritative servers only as much of the name as they need
+	// (RFC 9156)
+	QNAMEMinimisation bool `json:"qname_minimisation"`
+
+	// Timeout per authoritative server query
+	Timeout time.Duration `json:"timeout"`
+
+	// Upper bound on the queries sent to resolve one client query,
+	// including nameserver addresses and CNAME targets
+	MaxQueries int `json:"max_queries"`
+
+	// Delegations and nameserver addresses kept from referrals
+	CacheSize int `json:"cache_size"`
+}
+
+// BlocklistConfig represents DNS blocklist (gravity) configuration
+type BlocklistConfig struct {
+	Enabled bool `json:"enabled"`
+
+	// Local list files in hosts, plain-domain or Adblock format
+	Sources []string `json:"sources"`
+
+	// TTL for blocked answers
+	BlockedTTL time.Duration `json:"blocked_ttl"`
+
+	// Answer for blocked queries, unless the list or the client's group
+	// sets its own
+	Sinkhole SinkholeConfig `json:"sinkhole"`
+
+	// Answers for domains of particular lists, by source
+	ListSinkholes map[string]SinkholeConfig `json:"list_sinkholes"`
+
+	// Check the CNAME targets of forwarded answers too, blocking trackers
+	// cloaked behind first-party names
+	CNAMEInspection bool `json:"cname_inspection"`
+}
+
+// Sinkhole modes: what a blocked query is answered with
+const (
+	SinkholeNull     = "null"     // 0.0.0.0 or ::, NODATA for other types
+	SinkholeNXDomain = "nxdomain" // NXDOMAIN
+	SinkholeNoData   = "nodata"   // An empty NOERROR answer
+	SinkholeRefused  = "refused"  // REFUSED
+	SinkholeIP       = "ip"       // The configured addresses, NODATA for other types
+)
+
+// SinkholeConfig selects the answer for blocked queries. An empty mode
+// defers to the next level: group, then list, then the blocklist default.
+type SinkholeConfig struct {
+	Mode string `json:"mode"`
+	IPv4 string `json:"ipv4"` // Answer to A questions in "ip" mode
+	IPv6 string `json:"ipv6"` // Answer to AAAA questions in "ip" mode
+}
+
+// RulesConfig represents domain allow/deny rules and client groups
+type RulesConfig struct {
+	Enabled   bool               `json:"enabled"`
+	Groups    []GroupConfig      `json:"groups"`
+	Clients   []ClientConfig     `json:"clients"`
+	Domains   []DomainRuleConfig `json:"domains"`
+	Lists     []GroupListConfig  `json:"lists"`
+	Schedules []ScheduleConfig   `json:"schedules"`
+}
+
+// GroupConfig represents a client group
+type GroupConfig struct {
+	Name        string `json:"name"`
+	Enabled     bool   `json:"enabled"`
+	Description string `json:"description"`
+
+	// Answer for queries of the group's clients that are blocked
+	Sinkhole SinkholeConfig `json:"sinkhole"`
+}
+
+// ClientConfig assigns a client, identified by exactly one of IP, network
+// (CIDR) or MAC address, to groups
+type ClientConfig struct {
+	IP          string   `json:"ip"`
+	Network     string   `json:"network"`
+	MAC         string   `json:"mac"`
+	Groups      []string `json:"groups"`
+	Description string   `json:"description"`
+}
+
+// DomainRuleConfig represents an allow or deny rule for domains
+type DomainRuleConfig struct {
+	Domain  string   `json:"domain"`
+	Kind    string   `json:"kind"`   // "exact", "regex" or "wildcard"
+	Action  string   `json:"action"` // "deny" or "allow"
+	Groups  []string `json:"groups"`
+	Enabled bool     `json:"enabled"`
+	Comment string   `json:"comment"`
+
+	// Name of the schedule the rule applies during, always if empty
+	Schedule string `json:"schedule"`
+}
+
+// GroupListConfig blocks the domains of a list file, in the formats the
+// blocklist reads, for the clients of some groups only
+type GroupListConfig struct {
+	Source  string   `json:"source"`
+	Groups  []string `json:"groups"`
+	Enabled bool     `json:"enabled"`
+	Comment string   `json:"comment"`
+
+	// Name of the schedule the list applies during, always if empty
+	Schedule string `json:"schedule"`
+}
+
+// ScheduleConfig is a named weekly schedule. Rules and lists that refer
+// to it only apply while one of its ranges is active.
+type ScheduleConfig struct {
+	Name     string                `json:"name"`
+	Timezone string                `json:"timezone"` // IANA name, local time if empty
+	Ranges   []ScheduleRangeConfig `json:"ranges"`
+	Comment  string                `json:"comment"`
+}
+
+// ScheduleRangeConfig is a daily time range on some days of the week. A
+// range that ends before it starts runs past midnight into the next day;
+// equal start and end times cover the whole day.
+type ScheduleRangeConfig struct {
+	Days  []string `json:"days"`  // "mon" to "sun", every day if empty
+	Start string   `json:"start"` // "HH:MM"
+	End   string   `json:"end"`   // "HH:MM", "24:00" for the end of the day
+}
+
+// LocalRecordsConfig represents locally served records. Names under Zones
+// are answered authoritatively, with NXDOMAIN for unknown names.
+type LocalRecordsConfig struct {
+	Enabled    bool                `json:"enabled"`
+	Zones      []string            `json:"zones"`
+	Records    []LocalRecordConfig `json:"records"`
+	HostsFiles []string            `json:"hosts_files"`
+
+	// TTL of local answers unless a record sets its own
+	TTL time.Duration `json:"ttl"`
+}
+
+// RewritesConfig represents domain rewrites: names answered with a CNAME
+// target or a fixed address for the clients of some groups, ahead of the
+// cache and upstreams
+type RewritesConfig struct {
+	Enabled bool                `json:"enabled"`
+	Rules   []RewriteRuleConfig `json:"rules"`
+
+	// Restricted search engine and YouTube endpoints for some groups
+	SafeSearch SafeSearchConfig `json:"safe_search"`
+
+	// TTL of rewritten records
+	TTL time.Duration `json:"ttl"`
+}
+
+// RewriteRuleConfig rewrites a domain to a CNAME target or an address. A
+// name may have one CNAME target or any number of addresses.
+type RewriteRuleConfig struct {
+	Domain  string   `json:"domain"` // Exact name, or "*.name" for the name and its subdomains
+	Target  string   `json:"target"` // CNAME target, or an IPv4 or IPv6 address
+	Groups  []string `json:"groups"`
+	Enabled bool     `json:"enabled"`
+	Comment string   `json:"comment"`
+}
+
+// Safe search services
+const (
+	SafeSearchGoogle     = "google"
+	SafeSearchBing       = "bing"
+	SafeSearchDuckDuckGo = "duckduckgo"
+	SafeSearchYouTube    = "youtube"
+)
+
+// YouTube restricted modes
+const (
+	YouTubeStrict   = "strict"
+	YouTubeModerate = "moderate"
+)
+
+// SafeSearchConfig rewrites search engines to their safe search endpoints
+// for the clients of some groups. Rewrite rules for the same names win.
+type SafeSearchConfig struct {
+	Enabled  bool     `json:"enabled"`
+	Groups   []string `json:"groups"`
+	Services []string `json:"services"` // All services if empty
+	YouTube  string   `json:"youtube"`  // "strict" or "moderate"
+}
+
+// DNSSECConfig represents DNSSEC validation configuration
+type DNSSECConfig struct {
+	Enabled bool `json:"enabled"`
+
+	// DS records in presentation format,
+	// "<owner> <key tag> <algorithm> <digest type> <digest>"
+	TrustAnchors []string `json:"trust_anchors"`
+}
+
+// QueryLogConfig represents the in-memory query log configuration
+type QueryLogConfig struct {
+	Enabled bool `json:"enabled"`
+	Size    int  `json:"size"` // Most recent queries kept
+}
+
+// Rate limit actions
+const (
+	RateLimitActionDrop     = "drop"     // Send no answer
+	RateLimitActionRefuse   = "refuse"   // Answer REFUSED
+	RateLimitActionTruncate = "truncate" // Answer empty with TC set, forcing TCP
+)
+
+// RateLimitConfig represents per-client token bucket query limits and
+// response rate limiting (RRL) of identical UDP responses. Clients are
+// grouped by address prefix.
+type RateLimitConfig struct {
+	Enabled bool `json:"enabled"`
+
+	// Queries per client, by address unless the query prefix lengths are
+	// shortened; zero disables query limits
+	QueriesPerSecond      float64 `json:"queries_per_second"`
+	Burst                 int     `json:"burst"`
+	Action                string  `json:"action"` // "drop", "refuse" or "truncate"
+	QueryIPv4PrefixLength int     `json:"query_ipv4_prefix_length"`
+	QueryIPv6PrefixLength int     `json:"query_ipv6_prefix_length"`
+
+	// Identical responses per client prefix; zero disables RRL. RRL is
+	// meant for listeners exposed to the internet, where spoofed queries
+	// can turn the server into an amplifier; on a LAN resolver it pushes
+	// clients that ask for the same popular names to TCP.
+	ResponsesPerSecond float64 `json:"responses_per_second"`
+	ResponseAction     string  `json:"response_action"`
+	IPv4PrefixLength   int     `json:"ipv4_prefix_length"`
+	IPv6PrefixLength   int     `json:"ipv6_prefix_length"`
+
+	Exempt     []string `json:"exempt"`      // Addresses or CIDRs never limited
+	MaxTracked int      `json:"max_tracked"` // Buckets kept per limit; the least recently used are evicted
+}
+
+// dnstap output networks
+const (
+	DnstapNetworkUnix = "unix" // Frame Streams over a unix socket
+	DnstapNetworkTCP  = "tcp"  // Frame Streams over TCP
+	DnstapNetworkFile = "file" // Frame Streams file
+)
+
+// DnstapConfig represents dnstap logging (protobuf over Frame Streams) of
+// the messages the server exchanges with clients and upstreams
+type DnstapConfig struct {
+	Enabled bool   `json:"enabled"`
+	Network string `json:"network"` // "unix", "tcp" or "file"
+	Address string `json:"address"` // Socket path, host:port or file path
+
+	Identity string `json:"identity"` // Server identity, the hostname if empty
+	Version  string `json:"version"`
+
+	ClientMessages    bool `json:"client_messages"`    // Client queries and responses
+	ForwarderMessages bool `json:"forwarder_messages"` // Upstream and authoritative server queries and responses
+
+	// Frames queued for the writer; further frames are dropped, so a slow
+	// or absent receiver never delays queries
+	BufferSize        int           `json:"buffer_size"`
+	ReconnectInterval time.Duration `json:"reconnect_interval"`
+}
+
+// rootTrustAnchors are the DS records of the root zone KSKs (IANA)
+var rootTrustAnchors = []string{
+	". 20326 8 2 E06D44B80B8F1D39A95C0B0D7C65D08458E880409BBC683457104237C7F8EC8D", // KSK-2017
+	". 38696 8 2 683D2D0ACB8C9B712A1948B27F741219298D0A450D612C483AF444A4C0FB2B16", // KSK-2024
+}
+
+// rootHints are the IPv4 and IPv6 addresses of the root servers (IANA)
+var rootHints = []string{
+	"198.41.0.4", "2001:503:ba3e::2:30", // a.root-servers.net
+	"170.247.170.2", "2801:1b8:10::b", // b.root-servers.net
+	"192.33.4.12", "2001:500:2::c", // c.root-servers.net
+	"199.7.91.13", "2001:500:2d::d", // d.root-servers.net
+	"192.203.230.10", "2001:500:a8::e", // e.root-servers.net
+	"192.5.5.241", "2001:500:2f::f", // f.root-servers.net
+	"192.112.36.4", "2001:500:12::d0d", // g.root-servers.net
+	"198.97.190.53", "2001:500:1::53", // h.root-servers.net
+	"192.36.148.17", "2001:7fe::53", // i.root-servers.net
+	"192.58.128.30", "2001:503:c27::2:30", // j.root-servers.net
+	"193.0.14.129", "2001:7fd::1", // k.root-servers.net
+	"199.7.83.42", "2001:500:9f::42", // l.root-servers.net
+	"202.12.27.33", "2001:dc3::35", // m.root-servers.net
+}
+
+// LocalRecordConfig represents a single local record
+type LocalRecordConfig struct {
+	Name  string        `json:"name"` // PTR records may be given by address
+	Type  string        `json:"type"` // "A", "AAAA", "CNAME", "PTR", "TXT" or "SRV"
+	Value string        `json:"value"`
+	TTL   time.Duration `json:"ttl"`
 }
 
 // DefaultConfig returns a default DNS server configuration
@@ -75,6 +463,11 @@ func DefaultConfig() *Config {
 		TCPEnabled: true,
 		UDPEnabled: true,
 
+		TLS: TLSConfig{
+			Enabled: false,
+			Port:    853,
+		},
+
 		ReadTimeout:  30 * time.Second,
 		WriteTimeout: 30 * time.Second,
 		IdleTimeout:  60 * time.Second,
@@ -88,6 +481,17 @@ func DefaultConfig() *Config {
 			CleanupInterval: 5 * time.Minute,
 			EvictionPolicy:  "lru",
 			MaxMemoryMB:     100,
+
+			ServeStale:  true,
+			StaleWindow: time.Hour,
+			StaleTTL:    30 * time.Second,
+
+			Prefetch:          true,
+			PrefetchThreshold: 5,
+			PrefetchWindow:    10 * time.Second,
+
+			NegativeCache:  true,
+			NegativeMaxTTL: time.Hour,
 		},
 
 		Forwarder: ForwarderConfig{
@@ -98,13 +502,94 @@ func DefaultConfig() *Config {
 				"1.1.1.1:53", // Cloudflare DNS
 				"1.0.0.1:53", // Cloudflare DNS
 			},
-			Timeout:        5 * time.Second,
-			Retries:        2,
-			HealthCheck:    true,
-			HealthInterval: 30 * time.Second,
-			LoadBalancing:  "round_robin",
-			EDNS0Enabled:   true,
-			UDPSize:        4096,
+			Timeout:           5 * time.Second,
+			Retries:           2,
+			HealthCheck:       true,
+			HealthInterval:    30 * time.Second,
+			LoadBalancing:     "round_robin",
+			ParallelUpstreams: 2,
+			EDNS0Enabled:      true,
+			UDPSize:           4096,
+		},
+
+		Recursion: RecursionConfig{
+			Enabled:           false,
+			RootHints:         append([]string(nil), rootHints...),
+			QNAMEMinimisation: true,
+			Timeout:           2 * time.Second,
+			MaxQueries:        64,
+			CacheSize:         10000,
+		},
+
+		Blocklist: BlocklistConfig{
+			Enabled:         true,
+			Sources:         []string{},
+			BlockedTTL:      2 * time.Second,
+			Sinkhole:        SinkholeConfig{Mode: SinkholeNull},
+			ListSinkholes:   map[string]SinkholeConfig{},
+			CNAMEInspection: true,
+		},
+
+		Rules: RulesConfig{
+			Enabled: true,
+			Groups: []GroupConfig{
+				{Name: DefaultGroupName, Enabled: true, Description: "The default group"},
+			},
+			Clients:   []ClientConfig{},
+			Domains:   []DomainRuleConfig{},
+			Lists:     []GroupListConfig{},
+			Schedules: []ScheduleConfig{},
+		},
+
+		Local: LocalRecordsConfig{
+			Enabled:    true,
+			Zones:      []string{},
+			Records:    []LocalRecordConfig{},
+			HostsFiles: []string{},
+			TTL:        60 * time.Second,
+		},
+
+		Rewrites: RewritesConfig{
+			Enabled: true,
+			Rules:   []RewriteRuleConfig{},
+			SafeSearch: SafeSearchConfig{
+				Enabled: false,
+				YouTube: YouTubeStrict,
+			},
+			TTL: 300 * time.Second,
+		},
+
+		DNSSEC: DNSSECConfig{
+			Enabled:      false,
+			TrustAnchors: append([]string(nil), rootTrustAnchors...),
+		},
+		QueryLog: QueryLogConfig{
+			Enabled: true,
+			Size:    100000,
+		},
+		RateLimit: RateLimitConfig{
+			Enabled:               false,
+			QueriesPerSecond:      100,
+			Burst:                 200,
+			Action:                RateLimitActionRefuse,
+			QueryIPv4PrefixLength: 32,
+			QueryIPv6PrefixLength: 128,
+			ResponsesPerSecond:    10,
+			ResponseAction:        RateLimitActionTruncate,
+			IPv4PrefixLength:      24,
+			IPv6PrefixLength:      56,
+			Exempt:                []string{"127.0.0.0/8", "::1"},
+			MaxTracked:            100000,
+		},
+		Dnstap: DnstapConfig{
+			Enabled:           false,
+			Network:           DnstapNetworkUnix,
+			Address:           "/var/run/pihole-analyzer/dnstap.sock",
+			Version:           "pihole-analyzer",
+			ClientMessages:    true,
+			ForwarderMessages: true,
+			BufferSize:        10000,
+			ReconnectInterval: 5 * time.Second,
 		},
 
 		LogQueries:           true,
@@ -125,8 +610,23 @@ const (
 	TypeTXT   uint16 = 16
 	TypeAAAA  uint16 = 28
 	TypeSRV   uint16 = 33
+	TypeOPT   uint16 = 41
+	TypeSVCB  uint16 = 64
+	TypeHTTPS uint16 = 65
+	TypeCAA   uint16 = 257
+
+	// DNSSEC (RFC 4034, RFC 5155)
+	TypeDS         uint16 = 43
+	TypeRRSIG      uint16 = 46
+	TypeNSEC       uint16 = 47
+	TypeDNSKEY     uint16 = 48
+	TypeNSEC3      uint16 = 50
+	TypeNSEC3PARAM uint16 = 51
 )
 
+// minUDPPayloadSize is the classic DNS UDP message limit (RFC 1035)
+const minUDPPayloadSize = 512
+
 // DNS Classes
 const (
 	ClassIN uint16 = 1 // Internet
@@ -134,12 +634,13 @@ const (
 
 // DNS Response Codes
 const (
-	RCodeNoError  uint8 = 0 // No error
-	RCodeFormErr  uint8 = 1 // Format error
-	RCodeServFail uint8 = 2 // Server failure
-	RCodeNXDomain uint8 = 3 // Non-existent domain
-	RCodeNotImp   uint8 = 4 // Not implemented
-	RCodeRefused  uint8 = 5 // Query refused
+	RCodeNoError  uint8 = 0  // No error
+	RCodeFormErr  uint8 = 1  // Format error
+	RCodeServFail uint8 = 2  // Server failure
+	RCodeNXDomain uint8 = 3  // Non-existent domain
+	RCodeNotImp   uint8 = 4  // Not implemented
+	RCodeRefused  uint8 = 5  // Query refused
+	RCodeBadVers  uint8 = 16 // Bad EDNS version (extended)
 )
 
 // DNS Header flags
@@ -149,6 +650,8 @@ const (
 	FlagTC uint16 = 1 << 9  // Truncated
 	FlagRD uint16 = 1 << 8  // Recursion Desired
 	FlagRA uint16 = 1 << 7  // Recursion Available
+	FlagAD uint16 = 1 << 5  // Authentic Data
+	FlagCD uint16 = 1 << 4  // Checking Disabled
 )
 
 // Validate validates the DNS configuration
@@ -157,17 +660,134 @@ func (c *Config) Validate() error {
 		return ErrInvalidPort
 	}
 
-	if !c.UDPEnabled && !c.TCPEnabled {
+	if !c.UDPEnabled && !c.TCPEnabled && !c.TLS.Enabled {
 		return ErrNoProtocolEnabled
 	}
 
+	if c.TLS.Enabled {
+		if c.TLS.Port < 1 || c.TLS.Port > 65535 {
+			return ErrInvalidTLSPort
+		}
+		if c.TLS.CertFile == "" || c.TLS.KeyFile == "" {
+			return ErrMissingTLSCertificate
+		}
+	}
+
 	if c.Cache.MaxSize < 0 {
 		return ErrInvalidCacheSize
 	}
 
+	switch c.Cache.EvictionPolicy {
+	case "", EvictionLRU, EvictionLFU, EvictionTTL:
+	default:
+		return ErrInvalidEvictionPolicy
+	}
+
+	if c.Cache.MaxMemoryMB < 0 {
+		return ErrInvalidCacheMemory
+	}
+
+	if c.Cache.StaleWindow < 0 || c.Cache.StaleTTL < 0 || c.Cache.PrefetchWindow < 0 || c.Cache.NegativeMaxTTL < 0 {
+		return ErrInvalidCacheTiming
+	}
+
 	if c.MaxConcurrentQueries < 1 {
 		return ErrInvalidConcurrency
 	}
 
+	switch c.Forwarder.LoadBalancing {
+	case "", "round_robin", "random", "fastest", "parallel":
+	default:
+		return ErrInvalidLoadBalancing
+	}
+
+	for _, upstream := range c.Forwarder.Upstreams {
+		if _, err := parseUpstream(upstream); err != nil {
+			return err
+		}
+	}
+
+	for _, rule := range c.Forwarder.Conditional {
+		if err := rule.validate(); err != nil {
+			return err
+		}
+	}
+
+	if c.Forwarder.EDNS0Enabled && (c.Forwarder.UDPSize < minUDPPayloadSize || c.Forwarder.UDPSize > maxUDPMessageSize) {
+		return ErrInvalidUDPSize
+	}
+
+	if c.Recursion.Enabled {
+		if err := c.Recursion.validate(); err != nil {
+			return err
+		}
+	}
+
+	if c.Blocklist.BlockedTTL < 0 {
+		return ErrInvalidBlockedTTL
+	}
+	if err := c.Blocklist.Sinkhole.validate(); err != nil {
+		return err
+	}
+	for source, sinkhole := range c.Blocklist.ListSinkholes {
+		if err := sinkhole.validate(); err != nil {
+			return fmt.Errorf("%w (list %s)", err, source)
+		}
+	}
+
+	if c.Local.TTL < 0 {
+		return ErrInvalidLocalRecord
+	}
+	for _, zone := range c.Local.Zones {
+		if !isValidDomain(normalizeDomain(zone)) {
+			return fmt.Errorf("%w: invalid zone %q", ErrInvalidLocalRecord, zone)
+		}
+	}
+	for _, rc := range c.Local.Records {
+		rtype, ok := ParseRecordType(rc.Type)
+		if !ok {
+			return fmt.Errorf("%w: unsupported type %q for %s", ErrInvalidLocalRecord, rc.Type, rc.Name)
+		}
+		if _, err := newLocalRecord(LocalRecord{Name: rc.Name, Type: rtype, Value: rc.Value, TTL: rc.TTL}); err != nil {
+			return err
+		}
+	}
+
+	if c.Rewrites.Enabled {
+		if err := c.Rewrites.validate(c.Rules); err != nil {
+			return err
+		}
+	}
+
+	if c.DNSSEC.Enabled {
+		if !c.Forwarder.EDNS0Enabled {
+			return ErrDNSSECRequiresEDNS
+		}
+		if len(c.DNSSEC.TrustAnchors) == 0 {
+			return fmt.Errorf("%w: none configured", ErrInvalidTrustAnchor)
+		}
+		for _, anchor := range c.DNSSEC.TrustAnchors {
+			if _, _, err := ParseTrustAnchor(anchor); err != nil {
+				return err
+			}
+		}
+	}
+
+	if c.QueryLog.Enabled && c.QueryLog.Size < 1 {
+		return ErrInvalidQueryLogSize
+	}
+
+	if c.RateLimit.Enabled {
+		if err := c.RateLimit.validate(); err != nil {
+			return err
+		}
+	}
+
+	if c.Dnstap.Enabled {
+		if err := c.Dnstap.validate(); err != nil {
+			return err
+		}
+	}
+
 	return nil
 }
@@ -14,6 +14,13 @@ func ConvertConfig(typesConfig types.DNSConfig) *Config {
 		TCPEnabled: typesConfig.TCPEnabled,
 		UDPEnabled: typesConfig.UDPEnabled,
 
+		TLS: TLSConfig{
+			Enabled:  typesConfig.TLS.Enabled,
+			Port:     typesConfig.TLS.Port,
+			CertFile: typesConfig.TLS.CertFile,
+			KeyFile:  typesConfig.TLS.KeyFile,
+		},
+
 		ReadTimeout:  time.Duration(typesConfig.ReadTimeout) * time.Second,
 		WriteTimeout: time.Duration(typesConfig.WriteTimeout) * time.Second,
 		IdleTimeout:  time.Duration(typesConfig.IdleTimeout) * time.Second,
@@ -27,18 +34,81 @@ func ConvertConfig(typesConfig types.DNSConfig) *Config {
 			CleanupInterval: time.Duration(typesConfig.Cache.CleanupInterval) * time.Second,
 			EvictionPolicy:  typesConfig.Cache.EvictionPolicy,
 			MaxMemoryMB:     typesConfig.Cache.MaxMemoryMB,
+
+			ServeStale:  typesConfig.Cache.ServeStale,
+			StaleWindow: time.Duration(typesConfig.Cache.StaleWindow) * time.Second,
+			StaleTTL:    time.Duration(typesConfig.Cache.StaleTTL) * time.Second,
+
+			Prefetch:          typesConfig.Cache.Prefetch,
+			PrefetchThreshold: typesConfig.Cache.PrefetchThreshold,
+			PrefetchWindow:    time.Duration(typesConfig.Cache.PrefetchWindow) * time.Second,
+
+			NegativeCache:  typesConfig.Cache.NegativeCache,
+			NegativeMaxTTL: time.Duration(typesConfig.Cache.NegativeMaxTTL) * time.Second,
 		},
 
 		Forwarder: ForwarderConfig{
-			Enabled:        typesConfig.Forwarder.Enabled,
-			Upstreams:      typesConfig.Forwarder.Upstreams,
-			Timeout:        time.Duration(typesConfig.Forwarder.Timeout) * time.Second,
-			Retries:        typesConfig.Forwarder.Retries,
-			HealthCheck:    typesConfig.Forwarder.HealthCheck,
-			HealthInterval: time.Duration(typesConfig.Forwarder.HealthInterval) * time.Second,
-			LoadBalancing:  typesConfig.Forwarder.LoadBalancing,
-			EDNS0Enabled:   typesConfig.Forwarder.EDNS0Enabled,
-			UDPSize:        typesConfig.Forwarder.UDPSize,
+			Enabled:             typesConfig.Forwarder.Enabled,
+			Upstreams:           typesConfig.Forwarder.Upstreams,
+			Timeout:             time.Duration(typesConfig.Forwarder.Timeout) * time.Second,
+			Retries:             typesConfig.Forwarder.Retries,
+			HealthCheck:         typesConfig.Forwarder.HealthCheck,
+			HealthInterval:      time.Duration(typesConfig.Forwarder.HealthInterval) * time.Second,
+			LoadBalancing:       typesConfig.Forwarder.LoadBalancing,
+			ParallelUpstreams:   typesConfig.Forwarder.ParallelUpstreams,
+			EDNS0Enabled:        typesConfig.Forwarder.EDNS0Enabled,
+			UDPSize:             typesConfig.Forwarder.UDPSize,
+			ForwardClientSubnet: typesConfig.Forwarder.ForwardClientSubnet,
+			CAFile:              typesConfig.Forwarder.CAFile,
+			Conditional:         convertConditionalForwardConfig(typesConfig.Forwarder.Conditional),
+		},
+
+		Recursion: RecursionConfig{
+			Enabled:           typesConfig.Recursion.Enabled,
+			RootHints:         typesConfig.Recursion.RootHints,
+			QNAMEMinimisation: typesConfig.Recursion.QNAMEMinimisation,
+			Timeout:           time.Duration(typesConfig.Recursion.Timeout) * time.Second,
+			MaxQueries:        typesConfig.Recursion.MaxQueries,
+			CacheSize:         typesConfig.Recursion.CacheSize,
+		},
+
+		Blocklist: BlocklistConfig{
+			Enabled:         typesConfig.Blocklist.Enabled,
+			Sources:         typesConfig.Blocklist.Sources,
+			BlockedTTL:      time.Duration(typesConfig.Blocklist.BlockedTTL) * time.Second,
+			Sinkhole:        SinkholeConfig(typesConfig.Blocklist.Sinkhole),
+			ListSinkholes:   convertListSinkholes(typesConfig.Blocklist.ListSinkholes),
+			CNAMEInspection: typesConfig.Blocklist.CNAMEInspection,
+		},
+
+		Rules: convertRulesConfig(typesConfig.Rules),
+
+		Local: convertLocalRecordsConfig(typesConfig.Local),
+
+		Rewrites: convertRewritesConfig(typesConfig.Rewrites),
+
+		DNSSEC: DNSSECConfig{
+			Enabled:      typesConfig.DNSSEC.Enabled,
+			TrustAnchors: convertTrustAnchors(typesConfig.DNSSEC.TrustAnchors),
+		},
+
+		QueryLog: QueryLogConfig{
+			Enabled: typesConfig.QueryLog.Enabled,
+			Size:    typesConfig.QueryLog.Size,
+		},
+
+		RateLimit: convertRateLimitConfig(typesConfig.RateLimit),
+
+		Dnstap: DnstapConfig{
+			Enabled:           typesConfig.Dnstap.Enabled,
+			Network:           typesConfig.Dnstap.Network,
+			Address:           typesConfig.Dnstap.Address,
+			Identity:          typesConfig.Dnstap.Identity,
+			Version:           typesConfig.Dnstap.Version,
+			ClientMessages:    typesConfig.Dnstap.ClientMessages,
+			ForwarderMessages: typesConfig.Dnstap.ForwarderMessages,
+			BufferSize:        typesConfig.Dnstap.BufferSize,
+			ReconnectInterval: time.Duration(typesConfig.Dnstap.ReconnectInterval) * time.Second,
 		},
 
 		LogQueries:           typesConfig.LogQueries,
@@ -57,6 +127,13 @@ func ConvertToTypesConfig(dnsConfig *Config) types.DNSConfig {
 		TCPEnabled: dnsConfig.TCPEnabled,
 		UDPEnabled: dnsConfig.UDPEnabled,
 
+		TLS: types.DNSTLSConfig{
+			Enabled:  dnsConfig.TLS.Enabled,
+			Port:     dnsConfig.TLS.Port,
+			CertFile: dnsConfig.TLS.CertFile,
+			KeyFile:  dnsConfig.TLS.KeyFile,
+		},
+
 		ReadTimeout:  int(dnsConfig.ReadTimeout.Seconds()),
 		WriteTimeout: int(dnsConfig.WriteTimeout.Seconds()),
 		IdleTimeout:  int(dnsConfig.IdleTimeout.Seconds()),
@@ -70,18 +147,81 @@ func ConvertToTypesConfig(dnsConfig *Config) types.DNSConfig {
 			CleanupInterval: int(dnsConfig.Cache.CleanupInterval.Seconds()),
 			EvictionPolicy:  dnsConfig.Cache.EvictionPolicy,
 			MaxMemoryMB:     dnsConfig.Cache.MaxMemoryMB,
+
+			ServeStale:  dnsConfig.Cache.ServeStale,
+			StaleWindow: int(dnsConfig.Cache.StaleWindow.Seconds()),
+			StaleTTL:    int(dnsConfig.Cache.StaleTTL.Seconds()),
+
+			Prefetch:          dnsConfig.Cache.Prefetch,
+			PrefetchThreshold: dnsConfig.Cache.PrefetchThreshold,
+			PrefetchWindow:    int(dnsConfig.Cache.PrefetchWindow.Seconds()),
+
+			NegativeCache:  dnsConfig.Cache.NegativeCache,
+			NegativeMaxTTL: int(dnsConfig.Cache.NegativeMaxTTL.Seconds()),
 		},
 
 		Forwarder: types.DNSForwarderConfig{
-			Enabled:        dnsConfig.Forwarder.Enabled,
-			Upstreams:      dnsConfig.Forwarder.Upstreams,
-			Timeout:        int(dnsConfig.Forwarder.Timeout.Seconds()),
-			Retries:        dnsConfig.Forwarder.Retries,
-			HealthCheck:    dnsConfig.Forwarder.HealthCheck,
-			HealthInterval: int(dnsConfig.Forwarder.HealthInterval.Seconds()),
-			LoadBalancing:  dnsConfig.Forwarder.LoadBalancing,
-			EDNS0Enabled:   dnsConfig.Forwarder.EDNS0Enabled,
-			UDPSize:        dnsConfig.Forwarder.UDPSize,
+			Enabled:             dnsConfig.Forwarder.Enabled,
+			Upstreams:           dnsConfig.Forwarder.Upstreams,
+			Timeout:             int(dnsConfig.Forwarder.Timeout.Seconds()),
+			Retries:             dnsConfig.Forwarder.Retries,
+			HealthCheck:         dnsConfig.Forwarder.HealthCheck,
+			HealthInterval:      int(dnsConfig.Forwarder.HealthInterval.Seconds()),
+			LoadBalancing:       dnsConfig.Forwarder.LoadBalancing,
+			ParallelUpstreams:   dnsConfig.Forwarder.ParallelUpstreams,
+			EDNS0Enabled:        dnsConfig.Forwarder.EDNS0Enabled,
+			UDPSize:             dnsConfig.Forwarder.UDPSize,
+			ForwardClientSubnet: dnsConfig.Forwarder.ForwardClientSubnet,
+			CAFile:              dnsConfig.Forwarder.CAFile,
+			Conditional:         convertToTypesConditionalForwardConfig(dnsConfig.Forwarder.Conditional),
+		},
+
+		Recursion: types.DNSRecursionConfig{
+			Enabled:           dnsConfig.Recursion.Enabled,
+			RootHints:         dnsConfig.Recursion.RootHints,
+			QNAMEMinimisation: dnsConfig.Recursion.QNAMEMinimisation,
+			Timeout:           int(dnsConfig.Recursion.Timeout.Seconds()),
+			MaxQueries:        dnsConfig.Recursion.MaxQueries,
+			CacheSize:         dnsConfig.Recursion.CacheSize,
+		},
+
+		Blocklist: types.DNSBlocklistConfig{
+			Enabled:         dnsConfig.Blocklist.Enabled,
+			Sources:         dnsConfig.Blocklist.Sources,
+			BlockedTTL:      int(dnsConfig.Blocklist.BlockedTTL.Seconds()),
+			Sinkhole:        types.DNSSinkholeConfig(dnsConfig.Blocklist.Sinkhole),
+			ListSinkholes:   convertToTypesListSinkholes(dnsConfig.Blocklist.ListSinkholes),
+			CNAMEInspection: dnsConfig.Blocklist.CNAMEInspection,
+		},
+
+		Rules: convertToTypesRulesConfig(dnsConfig.Rules),
+
+		Local: convertToTypesLocalRecordsConfig(dnsConfig.Local),
+
+		Rewrites: convertToTypesRewritesConfig(dnsConfig.Rewrites),
+
+		DNSSEC: types.DNSSECConfig{
+			Enabled:      dnsConfig.DNSSEC.Enabled,
+			TrustAnchors: dnsConfig.DNSSEC.TrustAnchors,
+		},
+
+		QueryLog: types.DNSQueryLogConfig{
+			Enabled: dnsConfig.QueryLog.Enabled,
+			Size:    dnsConfig.QueryLog.Size,
+		},
+
+		RateLimit: convertToTypesRateLimitConfig(dnsConfig.RateLimit),
+
+		Dnstap: types.DNSDnstapConfig{
+			Enabled:           dnsConfig.Dnstap.Enabled,
+			Network:           dnsConfig.Dnstap.Network,
+			Address:           dnsConfig.Dnstap.Address,
+			Identity:          dnsConfig.Dnstap.Identity,
+			Version:           dnsConfig.Dnstap.Version,
+			ClientMessages:    dnsConfig.Dnstap.ClientMessages,
+			ForwarderMessages: dnsConfig.Dnstap.ForwarderMessages,
+			BufferSize:        dnsConfig.Dnstap.BufferSize,
+			ReconnectInterval: int(dnsConfig.Dnstap.ReconnectInterval.Seconds()),
 		},
 
 		LogQueries:           dnsConfig.LogQueries,
@@ -91,8 +231,285 @@ func ConvertToTypesConfig(dnsConfig *Config) types.DNSConfig {
 	}
 }
 
+// convertRulesConfig converts types.DNSRulesConfig to dns.RulesConfig
+func convertRulesConfig(typesRules types.DNSRulesConfig) RulesConfig {
+	rules := RulesConfig{Enabled: typesRules.Enabled}
+
+	for _, g := range typesRules.Groups {
+		rules.Groups = append(rules.Groups, GroupConfig{
+			Name:        g.Name,
+			Enabled:     g.Enabled,
+			Description: g.Description,
+			Sinkhole:    SinkholeConfig(g.Sinkhole),
+		})
+	}
+
+	for _, c := range typesRules.Clients {
+		rules.Clients = append(rules.Clients, ClientConfig{
+			IP:          c.IP,
+			Network:     c.Network,
+			MAC:         c.MAC,
+			Groups:      c.Groups,
+			Description: c.Description,
+		})
+	}
+
+	for _, d := range typesRules.Domains {
+		rules.Domains = append(rules.Domains, DomainRuleConfig{
+			Domain:   d.Domain,
+			Kind:     d.Kind,
+			Action:   d.Action,
+			Groups:   d.Groups,
+			Enabled:  d.Enabled,
+			Comment:  d.Comment,
+			Schedule: d.Schedule,
+		})
+	}
+
+	for _, l := range typesRules.Lists {
+		rules.Lists = append(rules.Lists, GroupListConfig(l))
+	}
+
+	for _, sc := range typesRules.Schedules {
+		schedule := ScheduleConfig{
+			Name:     sc.Name,
+			Timezone: sc.Timezone,
+			Comment:  sc.Comment,
+		}
+		for _, r := range sc.Ranges {
+			schedule.Ranges = append(schedule.Ranges, ScheduleRangeConfig(r))
+		}
+		rules.Schedules = append(rules.Schedules, schedule)
+	}
+
+	return rules
+}
+
+// convertToTypesRulesConfig converts dns.RulesConfig to types.DNSRulesConfig
+func convertToTypesRulesConfig(rules RulesConfig) types.DNSRulesConfig {
+	typesRules := types.DNSRulesConfig{Enabled: rules.Enabled}
+
+	for _, g := range rules.Groups {
+		typesRules.Groups = append(typesRules.Groups, types.DNSGroupConfig{
+			Name:        g.Name,
+			Enabled:     g.Enabled,
+			Description: g.Description,
+			Sinkhole:    types.DNSSinkholeConfig(g.Sinkhole),
+		})
+	}
+
+	for _, c := range rules.Clients {
+		typesRules.Clients = append(typesRules.Clients, types.DNSClientConfig{
+			IP:          c.IP,
+			Network:     c.Network,
+			MAC:         c.MAC,
+			Groups:      c.Groups,
+			Description: c.Description,
+		})
+	}
+
+	for _, d := range rules.Domains {
+		typesRules.Domains = append(typesRules.Domains, types.DNSDomainRuleConfig{
+			Domain:   d.Domain,
+			Kind:     d.Kind,
+			Action:   d.Action,
+			Groups:   d.Groups,
+			Enabled:  d.Enabled,
+			Comment:  d.Comment,
+			Schedule: d.Schedule,
+		})
+	}
+
+	for _, l := range rules.Lists {
+		typesRules.Lists = append(typesRules.Lists, types.DNSGroupListConfig(l))
+	}
+
+	for _, s := range rules.Schedules {
+		schedule := types.DNSScheduleConfig{
+			Name:     s.Name,
+			Timezone: s.Timezone,
+			Comment:  s.Comment,
+		}
+		for _, r := range s.Ranges {
+			schedule.Ranges = append(schedule.Ranges, types.DNSScheduleRangeConfig(r))
+		}
+		typesRules.Schedules = append(typesRules.Schedules, schedule)
+	}
+
+	return typesRules
+}
+
+// convertListSinkholes converts per-list sinkholes to dns.SinkholeConfig
+func convertListSinkholes(typesSinkholes map[string]types.DNSSinkholeConfig) map[string]SinkholeConfig {
+	sinkholes := make(map[string]SinkholeConfig, len(typesSinkholes))
+	for source, sinkhole := range typesSinkholes {
+		sinkholes[source] = SinkholeConfig(sinkhole)
+	}
+	return sinkholes
+}
+
+// convertToTypesListSinkholes converts per-list sinkholes to types.DNSSinkholeConfig
+func convertToTypesListSinkholes(sinkholes map[string]SinkholeConfig) map[string]types.DNSSinkholeConfig {
+	typesSinkholes := make(map[string]types.DNSSinkholeConfig, len(sinkholes))
+	for source, sinkhole := range sinkholes {
+		typesSinkholes[source] = types.DNSSinkholeConfig(sinkhole)
+	}
+	return typesSinkholes
+}
+
+// convertLocalRecordsConfig converts types.DNSLocalRecordsConfig to dns.LocalRecordsConfig
+func convertLocalRecordsConfig(typesLocal types.DNSLocalRecordsConfig) LocalRecordsConfig {
+	local := LocalRecordsConfig{
+		Enabled:    typesLocal.Enabled,
+		Zones:      typesLocal.Zones,
+		HostsFiles: typesLocal.HostsFiles,
+		TTL:        time.Duration(typesLocal.TTL) * time.Second,
+	}
+
+	for _, r := range typesLocal.Records {
+		local.Records = append(local.Records, LocalRecordConfig{
+			Name:  r.Name,
+			Type:  r.Type,
+			Value: r.Value,
+			TTL:   time.Duration(r.TTL) * time.Second,
+		})
+	}
+
+	return local
+}
+
+// convertToTypesLocalRecordsConfig converts dns.LocalRecordsConfig to types.DNSLocalRecordsConfig
+func convertToTypesLocalRecordsConfig(local LocalRecordsConfig) types.DNSLocalRecordsConfig {
+	typesLocal := types.DNSLocalRecordsConfig{
+		Enabled:    local.Enabled,
+		Zones:      local.Zones,
+		HostsFiles: local.HostsFiles,
+		TTL:        int(local.TTL.Seconds()),
+	}
+
+	for _, r := range local.Records {
+		typesLocal.Records = append(typesLocal.Records, types.DNSLocalRecordConfig{
+			Name:  r.Name,
+			Type:  r.Type,
+			Value: r.Value,
+			TTL:   int(r.TTL.Seconds()),
+		})
+	}
+
+	return typesLocal
+}
+
+// convertRewritesConfig converts types.DNSRewritesConfig to dns.RewritesConfig
+func convertRewritesConfig(typesRewrites types.DNSRewritesConfig) RewritesConfig {
+	rewrites := RewritesConfig{
+		Enabled:    typesRewrites.Enabled,
+		SafeSearch: SafeSearchConfig(typesRewrites.SafeSearch),
+		TTL:        time.Duration(typesRewrites.TTL) * time.Second,
+	}
+
+	for _, r := range typesRewrites.Rules {
+		rewrites.Rules = append(rewrites.Rules, RewriteRuleConfig(r))
+	}
+
+	return rewrites
+}
+
+// convertToTypesRewritesConfig converts dns.RewritesConfig to types.DNSRewritesConfig
+func convertToTypesRewritesConfig(rewrites RewritesConfig) types.DNSRewritesConfig {
+	typesRewrites := types.DNSRewritesConfig{
+		Enabled:    rewrites.Enabled,
+		SafeSearch: types.DNSSafeSearchConfig(rewrites.SafeSearch),
+		TTL:        int(rewrites.TTL.Seconds()),
+	}
+
+	for _, r := range rewrites.Rules {
+		typesRewrites.Rules = append(typesRewrites.Rules, types.DNSRewriteRuleConfig(r))
+	}
+
+	return typesRewrites
+}
+
+// convertConditionalForwardConfig converts conditional forwarding rules from types to dns
+func convertConditionalForwardConfig(typesRules []types.DNSConditionalForwardConfig) []ConditionalForwardConfig {
+	var rules []ConditionalForwardConfig
+	for _, r := range typesRules {
+		rules = append(rules, ConditionalForwardConfig{
+			Domains:        r.Domains,
+			Upstreams:      r.Upstreams,
+			Timeout:        time.Duration(r.Timeout) * time.Second,
+			Retries:        r.Retries,
+			HealthCheck:    r.HealthCheck,
+			HealthInterval: time.Duration(r.HealthInterval) * time.Second,
+			LoadBalancing:  r.LoadBalancing,
+		})
+	}
+	return rules
+}
+
+// convertToTypesConditionalForwardConfig converts conditional forwarding rules from dns to types
+func convertToTypesConditionalForwardConfig(rules []ConditionalForwardConfig) []types.DNSConditionalForwardConfig {
+	var typesRules []types.DNSConditionalForwardConfig
+	for _, r := range rules {
+		typesRules = append(typesRules, types.DNSConditionalForwardConfig{
+			Domains:        r.Domains,
+			Upstreams:      r.Upstreams,
+			Timeout:        int(r.Timeout.Seconds()),
+			Retries:        r.Retries,
+			HealthCheck:    r.HealthCheck,
+			HealthInterval: int(r.HealthInterval.Seconds()),
+			LoadBalancing:  r.LoadBalancing,
+		})
+	}
+	return typesRules
+}
+
+// convertTrustAnchors returns the configured trust anchors, or the root
+// zone's if none are given
+func convertTrustAnchors(anchors []string) []string {
+	if len(anchors) == 0 {
+		return append([]string(nil), rootTrustAnchors...)
+	}
+	return anchors
+}
+
 // GetDefaultTypesConfig returns a default DNS configuration for types.DNSConfig
 func GetDefaultTypesConfig() types.DNSConfig {
 	defaultConfig := DefaultConfig()
 	return ConvertToTypesConfig(defaultConfig)
 }
+
+// convertRateLimitConfig converts types.DNSRateLimitConfig to dns.RateLimitConfig
+func convertRateLimitConfig(typesRateLimit types.DNSRateLimitConfig) RateLimitConfig {
+	return RateLimitConfig{
+		Enabled:               typesRateLimit.Enabled,
+		QueriesPerSecond:      typesRateLimit.QueriesPerSecond,
+		Burst:                 typesRateLimit.Burst,
+		Action:                typesRateLimit.Action,
+		QueryIPv4PrefixLength: typesRateLimit.QueryIPv4PrefixLength,
+		QueryIPv6PrefixLength: typesRateLimit.QueryIPv6PrefixLength,
+		ResponsesPerSecond:    typesRateLimit.ResponsesPerSecond,
+		ResponseAction:        typesRateLimit.ResponseAction,
+		IPv4PrefixLength:      typesRateLimit.IPv4PrefixLength,
+		IPv6PrefixLength:      typesRateLimit.IPv6PrefixLength,
+		Exempt:                typesRateLimit.Exempt,
+		MaxTracked:            typesRateLimit.MaxTracked,
+	}
+}
+
+// convertToTypesRateLimitConfig converts dns.RateLimitConfig to types.DNSRateLimitConfig
+func convertToTypesRateLimitConfig(rateLimit RateLimitConfig) types.DNSRateLimitConfig {
+	return types.DNSRateLimitConfig{
+		Enabled:               rateLimit.Enabled,
+		QueriesPerSecond:      rateLimit.QueriesPerSecond,
+		Burst:                 rateLimit.Burst,
+		Action:                rateLimit.Action,
+		QueryIPv4PrefixLength: rateLimit.QueryIPv4PrefixLength,
+		QueryIPv6PrefixLength: rateLimit.QueryIPv6PrefixLength,
+		ResponsesPerSecond:    rateLimit.ResponsesPerSecond,
+		ResponseAction:        rateLimit.ResponseAction,
+		IPv4PrefixLength:      rateLimit.IPv4PrefixLength,
+		IPv6PrefixLength:      rateLimit.IPv6PrefixLength,
+		Exempt:                rateLimit.Exempt,
+		MaxTracked:            rateLimit.MaxTracked,
+	}
+}
@@ -0,0 +1,416 @@
+package dns
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"pihole-analyzer/internal/interfaces"
+	"pihole-analyzer/internal/logger"
+	"pihole-analyzer/internal/types"
+)
+
+// slowQueryThreshold is the reply time above which a query counts as slow
+const slowQueryThreshold = 500 * time.Millisecond
+
+// maxTopDomains bounds the domain lists of a domain analysis
+const maxTopDomains = 10
+
+// QueryLogDataSource implements the analyzer DataSource interface over the
+// query log of the embedded DNS server, so analysis works without a Pi-hole
+type QueryLogDataSource struct {
+	queryLog DNSQueryLog
+	server   DNSServer // Source of server statistics, if known
+	resolver MACResolver
+	logger   *logger.Logger
+
+	mu          sync.RWMutex
+	connected   bool
+	connectedAt time.Time
+
+	// Server counters at the previous rate limit report
+	rateLimitMu   sync.Mutex
+	lastRateLimit types.RateLimitStats
+}
+
+// NewQueryLogDataSource creates a data source reading from a query log
+func NewQueryLogDataSource(queryLog DNSQueryLog, logger *logger.Logger) *QueryLogDataSource {
+	return &QueryLogDataSource{
+		queryLog: queryLog,
+		resolver: NewARPTableResolver(arpRefreshInterval),
+		logger:   logger.Component("dns-datasource"),
+	}
+}
+
+// NewServerDataSource creates a data source reading from a server's query
+// log, which also reports the server's rate limiting
+func NewServerDataSource(server *Server, logger *logger.Logger) *QueryLogDataSource {
+	dataSource := NewQueryLogDataSource(server.QueryLog(), logger)
+	dataSource.server = server
+	return dataSource
+}
+
+// Connect marks the data source as connected. It fails if the server has
+// no query log.
+func (d *QueryLogDataSource) Connect(ctx context.Context) error {
+	if d.queryLog == nil {
+		return fmt.Errorf("DNS server query log is disabled")
+	}
+
+	d.mu.Lock()
+	d.connected = true
+	d.connectedAt = time.Now()
+	d.mu.Unlock()
+
+	d.logger.Info("Using embedded DNS server query log: max_size=%d", d.queryLog.GetStats().MaxSize)
+	return nil
+}
+
+// Close disconnects the data source; the query log itself is unaffected
+func (d *QueryLogDataSource) Close() error {
+	d.mu.Lock()
+	d.connected = false
+	d.mu.Unlock()
+	return nil
+}
+
+// IsConnected returns true once Connect succeeded
+func (d *QueryLogDataSource) IsConnected() bool {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	return d.connected
+}
+
+// GetQueries returns logged queries matching the parameters, oldest first.
+// A limit keeps the most recent matches.
+func (d *QueryLogDataSource) GetQueries(ctx context.Context, params interfaces.QueryParams) ([]types.PiholeRecord, error) {
+	if !d.IsConnected() {
+		return nil, fmt.Errorf("not connected to DNS query log")
+	}
+
+	domainFilter := normalizeDomain(params.DomainFilter)
+	statuses := make(map[int]bool, len(params.StatusFilter))
+	for _, status := range params.StatusFilter {
+		statuses[status] = true
+	}
+	queryTypes := make(map[string]bool, len(params.TypeFilter))
+	for _, qtype := range params.TypeFilter {
+		queryTypes[QueryTypeName(uint16(qtype))] = true
+	}
+
+	var records []types.PiholeRecord
+	for _, record := range d.queryLog.Records() {
+		if !params.StartTime.IsZero() || !params.EndTime.IsZero() {
+			at := recordTime(record)
+			if (!params.StartTime.IsZero() && at.Before(params.StartTime)) ||
+				(!params.EndTime.IsZero() && at.After(params.EndTime)) {
+				continue
+			}
+		}
+		if params.ClientFilter != "" && record.Client != params.ClientFilter {
+			continue
+		}
+		if domainFilter != "" && !strings.Contains(record.Domain, domainFilter) {
+			continue
+		}
+		if len(statuses) > 0 && !statuses[record.Status] {
+			continue
+		}
+		if len(queryTypes) > 0 && !queryTypes[record.QueryType] {
+			continue
+		}
+		records = append(records, record)
+	}
+
+	if params.Limit > 0 && len(records) > params.Limit {
+		records = records[len(records)-params.Limit:]
+	}
+
+	d.logger.Debug("Query log read complete: record_count=%d", len(records))
+	return records, nil
+}
+
+// GetClientStats builds client statistics from the whole query log
+func (d *QueryLogDataSource) GetClientStats(ctx context.Context) (map[string]*types.ClientStats, error) {
+	queries, err := d.GetQueries(ctx, interfaces.QueryParams{})
+	if err != nil {
+		return nil, err
+	}
+
+	clientStats := make(map[string]*types.ClientStats)
+	for _, query := range queries {
+		client, exists := clientStats[query.Client]
+		if !exists {
+			client = &types.ClientStats{
+				Client:      query.Client,
+				IP:          query.Client,
+				Domains:     make(map[string]int),
+				QueryTypes:  make(map[int]int),
+				StatusCodes: make(map[int]int),
+				TopDomains:  []types.DomainStat{},
+				FirstSeen:   query.DateTime,
+			}
+			if mac, ok := d.resolver.ResolveMAC(net.ParseIP(query.Client)); ok {
+				client.MACAddress = mac
+				client.HWAddr = mac
+			}
+			clientStats[query.Client] = client
+		}
+
+		client.QueryCount++
+		client.TotalQueries++
+		client.LastSeen = query.DateTime
+		client.Domains[query.Domain]++
+		if client.Domains[query.Domain] == 1 {
+			client.UniqueQueries++
+		}
+		if qtype, ok := parseQueryTypeName(query.QueryType); ok {
+			client.QueryTypes[int(qtype)]++
+		}
+		client.StatusCodes[query.Status]++
+		if query.CNAME != "" {
+			if client.CloakedDomains == nil {
+				client.CloakedDomains = make(map[string]int)
+			}
+			client.CloakedDomains[query.CNAME]++
+		}
+		client.TotalReplyTime += query.ReplyTime
+	}
+
+	for _, client := range clientStats {
+		client.DomainCount = len(client.Domains)
+		client.Uniquedomains = client.DomainCount
+		client.AvgReplyTime = client.TotalReplyTime / float64(client.TotalQueries)
+		client.TopDomains = topDomainStats(client.Domains, maxTopDomains)
+	}
+
+	d.logger.Info("Client statistics complete: client_count=%d", len(clientStats))
+	return clientStats, nil
+}
+
+// GetNetworkInfo returns the clients seen in the query log, with hardware
+// addresses from the ARP table where known
+func (d *QueryLogDataSource) GetNetworkInfo(ctx context.Context) ([]types.NetworkDevice, error) {
+	queries, err := d.GetQueries(ctx, interfaces.QueryParams{})
+	if err != nil {
+		return nil, err
+	}
+
+	devices := make(map[string]*types.NetworkDevice)
+	var order []string
+	for _, query := range queries {
+		device, exists := devices[query.Client]
+		if !exists {
+			device = &types.NetworkDevice{IP: query.Client, FirstSeen: query.DateTime}
+			if mac, ok := d.resolver.ResolveMAC(net.ParseIP(query.Client)); ok {
+				device.Hardware = mac
+				device.MAC = mac
+				device.IsOnline = true
+			}
+			devices[query.Client] = device
+			order = append(order, query.Client)
+		}
+		device.LastSeen = query.DateTime
+	}
+
+	result := make([]types.NetworkDevice, 0, len(order))
+	for _, ip := range order {
+		result = append(result, *devices[ip])
+	}
+	return result, nil
+}
+
+// GetDomainAnalysis summarizes queried and blocked domains
+func (d *QueryLogDataSource) GetDomainAnalysis(ctx context.Context) (*types.DomainAnalysis, error) {
+	queries, err := d.GetQueries(ctx, interfaces.QueryParams{})
+	if err != nil {
+		return nil, err
+	}
+
+	domains := make(map[string]int)
+	blocked := make(map[string]int)
+	analysis := &types.DomainAnalysis{
+		TotalQueries: len(queries),
+		QueryTypes:   make(map[string]int),
+	}
+
+	for _, query := range queries {
+		domains[query.Domain]++
+		analysis.QueryTypes[query.QueryType]++
+		if isBlockedStatus(query.Status) {
+			blocked[query.Domain]++
+			analysis.TotalBlocked++
+		}
+	}
+
+	analysis.TopDomains = topDomainCounts(domains, maxTopDomains)
+	analysis.BlockedDomains = topDomainCounts(blocked, maxTopDomains)
+	if analysis.TotalQueries > 0 {
+		analysis.BlockedPercent = float64(analysis.TotalBlocked) / float64(analysis.TotalQueries) * 100
+	}
+
+	return analysis, nil
+}
+
+// GetQueryPerformance derives reply time and rate figures from the log
+func (d *QueryLogDataSource) GetQueryPerformance(ctx context.Context) (*types.QueryPerformance, error) {
+	queries, err := d.GetQueries(ctx, interfaces.QueryParams{})
+	if err != nil {
+		return nil, err
+	}
+
+	performance := &types.QueryPerformance{TotalQueries: len(queries)}
+	if len(queries) == 0 {
+		return performance, nil
+	}
+
+	var totalReplyTime float64
+	perSecond := make(map[string]int)
+	slow := float64(slowQueryThreshold) / float64(time.Millisecond)
+	for _, query := range queries {
+		totalReplyTime += query.ReplyTime
+		if query.ReplyTime > slow {
+			performance.SlowQueries++
+		}
+		perSecond[query.Timestamp]++
+	}
+
+	for _, count := range perSecond {
+		if count > performance.PeakQueries {
+			performance.PeakQueries = count
+		}
+	}
+
+	performance.AverageResponseTime = totalReplyTime / float64(len(queries))
+	span := recordTime(queries[len(queries)-1]).Sub(recordTime(queries[0])) + time.Second
+	performance.QueriesPerSecond = float64(len(queries)) / span.Seconds()
+
+	return performance, nil
+}
+
+// GetConnectionStatus returns the data source status with query log
+// statistics as metadata
+func (d *QueryLogDataSource) GetConnectionStatus(ctx context.Context) (*types.ConnectionStatus, error) {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	status := &types.ConnectionStatus{
+		Connected:   d.connected,
+		LastConnect: d.connectedAt.Format(time.RFC3339),
+		Metadata:    make(map[string]string),
+	}
+
+	if d.queryLog != nil {
+		stats := d.queryLog.GetStats()
+		status.Metadata["query_log_size"] = strconv.Itoa(stats.Size)
+		status.Metadata["query_log_max_size"] = strconv.Itoa(stats.MaxSize)
+		status.Metadata["queries_logged"] = strconv.FormatInt(stats.Logged, 10)
+	}
+
+	return status, nil
+}
+
+// GetRateLimitStats returns the rate limiting done by the server since the
+// previous call, so thresholds apply per analysis rather than to totals
+func (d *QueryLogDataSource) GetRateLimitStats(ctx context.Context) (*types.RateLimitStats, error) {
+	if d.server == nil {
+		return nil, fmt.Errorf("no DNS server to report rate limits")
+	}
+
+	stats := d.server.GetStats()
+	current := types.RateLimitStats{
+		LimitedQueries:   stats.RateLimitedQueries,
+		LimitedResponses: stats.RateLimitedResponses,
+		Dropped:          stats.RateLimitDropped,
+		Refused:          stats.RateLimitRefused,
+		Truncated:        stats.RateLimitTruncated,
+	}
+
+	d.rateLimitMu.Lock()
+	defer d.rateLimitMu.Unlock()
+
+	last := d.lastRateLimit
+	d.lastRateLimit = current
+	return &types.RateLimitStats{
+		LimitedQueries:   counterDelta(current.LimitedQueries, last.LimitedQueries),
+		LimitedResponses: counterDelta(current.LimitedResponses, last.LimitedResponses),
+		Dropped:          counterDelta(current.Dropped, last.Dropped),
+		Refused:          counterDelta(current.Refused, last.Refused),
+		Truncated:        counterDelta(current.Truncated, last.Truncated),
+	}, nil
+}
+
+// counterDelta returns the growth of a counter, treating a decrease as a
+// reset of the server's statistics
+func counterDelta(current, last int64) int64 {
+	if current < last {
+		return current
+	}
+	return current - last
+}
+
+// GetDataSourceType returns the data source type
+func (d *QueryLogDataSource) GetDataSourceType() interfaces.DataSourceType {
+	return interfaces.DataSourceTypeDNS
+}
+
+// GetConnectionInfo returns connection metadata
+func (d *QueryLogDataSource) GetConnectionInfo() *interfaces.ConnectionInfo {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	info := &interfaces.ConnectionInfo{
+		Type:        interfaces.DataSourceTypeDNS,
+		Host:        "embedded",
+		Connected:   d.connected,
+		ConnectedAt: d.connectedAt,
+		Metadata:    map[string]interface{}{},
+	}
+	if d.queryLog != nil {
+		info.Metadata["query_log_max_size"] = d.queryLog.GetStats().MaxSize
+	}
+	return info
+}
+
+// recordTime returns when a logged query was received
+func recordTime(record types.PiholeRecord) time.Time {
+	seconds, err := strconv.ParseInt(record.Timestamp, 10, 64)
+	if err != nil {
+		return time.Time{}
+	}
+	return time.Unix(seconds, 0)
+}
+
+// topDomainCounts returns the most frequent domains, most frequent first
+func topDomainCounts(domains map[string]int, limit int) []types.DomainCount {
+	counts := make([]types.DomainCount, 0, len(domains))
+	for domain, count := range domains {
+		counts = append(counts, types.DomainCount{Domain: domain, Count: count})
+	}
+	sort.Slice(counts, func(i, j int) bool {
+		if counts[i].Count != counts[j].Count {
+			return counts[i].Count > counts[j].Count
+		}
+		return counts[i].Domain < counts[j].Domain
+	})
+
+	if len(counts) > limit {
+		counts = counts[:limit]
+	}
+	return counts
+}
+
+// topDomainStats returns the most frequent domains as domain statistics
+func topDomainStats(domains map[string]int, limit int) []types.DomainStat {
+	counts := topDomainCounts(domains, limit)
+	stats := make([]types.DomainStat, len(counts))
+	for i, count := range counts {
+		stats[i] = types.DomainStat{Domain: count.Domain, Count: count.Count}
+	}
+	return stats
+}
@@ -0,0 +1,185 @@
+package dns
+
+import (
+	"context"
+	"reflect"
+	"strconv"
+	"testing"
+	"time"
+
+	"pihole-analyzer/internal/interfaces"
+	"pihole-analyzer/internal/logger"
+	"pihole-analyzer/internal/types"
+)
+
+// testQueryLogDataSource returns a connected data source over a log of
+// queries from two clients, one per second from base
+func testQueryLogDataSource(t *testing.T, base time.Time) *QueryLogDataSource {
+	t.Helper()
+
+	log := NewQueryLog(100)
+	entries := []struct {
+		client, domain, qtype string
+		status                int
+		reply                 float64
+	}{
+		{"192.168.1.10", "example.com", "A", QueryStatusForwarded, 20},
+		{"192.168.1.10", "example.com", "AAAA", QueryStatusCached, 0.1},
+		{"192.168.1.10", "ads.example", "A", QueryStatusGravity, 0.1},
+		{"192.168.1.20", "ads.example", "A", QueryStatusGravity, 0.1},
+		{"192.168.1.20", "slow.example", "HTTPS", QueryStatusForwarded, 900},
+	}
+	for i, e := range entries {
+		at := base.Add(time.Duration(i) * time.Second)
+		log.Add(types.PiholeRecord{
+			DateTime:  at.Format(queryLogTimeFormat),
+			Timestamp: strconv.FormatInt(at.Unix(), 10),
+			Client:    e.client,
+			Domain:    e.domain,
+			QueryType: e.qtype,
+			Status:    e.status,
+			ReplyTime: e.reply,
+		})
+	}
+
+	testLogger := logger.New(&logger.Config{
+		Level:     logger.LogLevel("ERROR"),
+		Component: "dns-test",
+	})
+	dataSource := NewQueryLogDataSource(log, testLogger)
+	dataSource.resolver = staticMACResolver{"192.168.1.10": "aa:bb:cc:dd:ee:ff"}
+
+	if err := dataSource.Connect(context.Background()); err != nil {
+		t.Fatalf("Connect failed: %v", err)
+	}
+	return dataSource
+}
+
+func TestQueryLogDataSource_GetQueries(t *testing.T) {
+	base := time.Unix(1700000000, 0)
+	dataSource := testQueryLogDataSource(t, base)
+	var _ interfaces.DataSource = dataSource
+
+	tests := []struct {
+		name    string
+		params  interfaces.QueryParams
+		domains []string
+	}{
+		{"all", interfaces.QueryParams{}, []string{"example.com", "example.com", "ads.example", "ads.example", "slow.example"}},
+		{"limit keeps newest", interfaces.QueryParams{Limit: 2}, []string{"ads.example", "slow.example"}},
+		{"client", interfaces.QueryParams{ClientFilter: "192.168.1.20"}, []string{"ads.example", "slow.example"}},
+		{"domain", interfaces.QueryParams{DomainFilter: "Ads.Example."}, []string{"ads.example", "ads.example"}},
+		{"status", interfaces.QueryParams{StatusFilter: []int{QueryStatusCached}}, []string{"example.com"}},
+		{"type", interfaces.QueryParams{TypeFilter: []int{65}}, []string{"slow.example"}},
+		{"time window", interfaces.QueryParams{StartTime: base.Add(time.Second), EndTime: base.Add(2 * time.Second)},
+			[]string{"example.com", "ads.example"}},
+	}
+
+	for _, tt := range tests {
+		records, err := dataSource.GetQueries(context.Background(), tt.params)
+		if err != nil {
+			t.Fatalf("%s: GetQueries failed: %v", tt.name, err)
+		}
+		var domains []string
+		for _, record := range records {
+			domains = append(domains, record.Domain)
+		}
+		if !reflect.DeepEqual(domains, tt.domains) {
+			t.Errorf("%s: got %v, want %v", tt.name, domains, tt.domains)
+		}
+	}
+
+	dataSource.Close()
+	if _, err := dataSource.GetQueries(context.Background(), interfaces.QueryParams{}); err == nil {
+		t.Error("Expected error after Close")
+	}
+}
+
+func TestQueryLogDataSource_Analysis(t *testing.T) {
+	dataSource := testQueryLogDataSource(t, time.Unix(1700000000, 0))
+	ctx := context.Background()
+
+	clients, err := dataSource.GetClientStats(ctx)
+	if err != nil {
+		t.Fatalf("GetClientStats failed: %v", err)
+	}
+	client := clients["192.168.1.10"]
+	if client == nil || client.TotalQueries != 3 || client.UniqueQueries != 2 || client.MACAddress != "aa:bb:cc:dd:ee:ff" {
+		t.Fatalf("Unexpected client stats: %+v", client)
+	}
+	if client.QueryTypes[int(TypeAAAA)] != 1 || client.StatusCodes[QueryStatusGravity] != 1 {
+		t.Errorf("Unexpected query types %v or statuses %v", client.QueryTypes, client.StatusCodes)
+	}
+	if len(client.TopDomains) == 0 || client.TopDomains[0].Domain != "example.com" {
+		t.Errorf("Unexpected top domains %v", client.TopDomains)
+	}
+
+	analysis, err := dataSource.GetDomainAnalysis(ctx)
+	if err != nil {
+		t.Fatalf("GetDomainAnalysis failed: %v", err)
+	}
+	if analysis.TotalQueries != 5 || analysis.TotalBlocked != 2 || analysis.BlockedPercent != 40 {
+		t.Errorf("Unexpected domain analysis: %+v", analysis)
+	}
+	if len(analysis.BlockedDomains) != 1 || analysis.BlockedDomains[0].Domain != "ads.example" {
+		t.Errorf("Unexpected blocked domains %v", analysis.BlockedDomains)
+	}
+
+	performance, err := dataSource.GetQueryPerformance(ctx)
+	if err != nil {
+		t.Fatalf("GetQueryPerformance failed: %v", err)
+	}
+	if performance.TotalQueries != 5 || performance.SlowQueries != 1 || performance.PeakQueries != 1 || performance.QueriesPerSecond != 1 {
+		t.Errorf("Unexpected performance: %+v", performance)
+	}
+
+	devices, err := dataSource.GetNetworkInfo(ctx)
+	if err != nil {
+		t.Fatalf("GetNetworkInfo failed: %v", err)
+	}
+	if len(devices) != 2 || devices[0].IP != "192.168.1.10" || !devices[0].IsOnline || devices[1].IsOnline {
+		t.Errorf("Unexpected devices %+v", devices)
+	}
+
+	if dataSource.GetDataSourceType() != interfaces.DataSourceTypeDNS {
+		t.Errorf("Unexpected data source type %s", dataSource.GetDataSourceType())
+	}
+}
+
+func TestServerDataSource_RateLimitStats(t *testing.T) {
+	testLogger := logger.New(&logger.Config{
+		Level:     logger.LogLevel("ERROR"),
+		Component: "dns-test",
+	})
+
+	server := NewServer(DefaultConfig(), testLogger).(*Server)
+	server.updateStats(func(stats *ServerStats) {
+		stats.RateLimitedQueries = 7
+		stats.RateLimitDropped = 7
+	})
+
+	var reporter interfaces.RateLimitReporter = NewServerDataSource(server, testLogger)
+	stats, err := reporter.GetRateLimitStats(context.Background())
+	if err != nil {
+		t.Fatalf("GetRateLimitStats failed: %v", err)
+	}
+	if stats.LimitedQueries != 7 || stats.Dropped != 7 {
+		t.Errorf("Unexpected rate limit stats: %+v", stats)
+	}
+
+	// Later reports only count what was limited since the previous one
+	server.updateStats(func(stats *ServerStats) {
+		stats.RateLimitedQueries = 9
+		stats.RateLimitDropped = 9
+	})
+	if stats, _ := reporter.GetRateLimitStats(context.Background()); stats.LimitedQueries != 2 || stats.Dropped != 2 {
+		t.Errorf("Expected 2 newly limited queries, got %+v", stats)
+	}
+	if stats, _ := reporter.GetRateLimitStats(context.Background()); stats.LimitedQueries != 0 || stats.Dropped != 0 {
+		t.Errorf("Expected no newly limited queries, got %+v", stats)
+	}
+
+	if _, err := testQueryLogDataSource(t, time.Now()).GetRateLimitStats(context.Background()); err == nil {
+		t.Error("Expected error without a server")
+	}
+}
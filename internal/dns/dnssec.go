@@ -0,0 +1,620 @@
+package dns
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base32"
+	"encoding/binary"
+	"fmt"
+	"math/big"
+	"sort"
+	"strings"
+	"time"
+)
+
+// DNSSEC algorithm numbers (RFC 8624)
+const (
+	AlgorithmRSASHA1         uint8 = 5
+	AlgorithmRSASHA1NSEC3    uint8 = 7
+	AlgorithmRSASHA256       uint8 = 8
+	AlgorithmRSASHA512       uint8 = 10
+	AlgorithmECDSAP256SHA256 uint8 = 13
+	AlgorithmECDSAP384SHA384 uint8 = 14
+	AlgorithmED25519         uint8 = 15
+)
+
+// DS digest types
+const (
+	DigestSHA1   uint8 = 1
+	DigestSHA256 uint8 = 2
+	DigestSHA384 uint8 = 4
+)
+
+// DNSKEY flags
+const (
+	DNSKEYFlagZone uint16 = 1 << 8 // Zone key, may sign RRsets
+	DNSKEYFlagSEP  uint16 = 1      // Secure entry point (key signing key)
+)
+
+// nsec3FlagOptOut marks NSEC3 records that may skip unsigned delegations
+const nsec3FlagOptOut uint8 = 1
+
+// rrsigFixedSize is the length of the RRSIG fields before the signer name
+const rrsigFixedSize = 18
+
+// nsec3Base32 is the base32hex alphabet NSEC3 owner names are written in
+var nsec3Base32 = base32.HexEncoding.WithPadding(base32.NoPadding)
+
+// DNSKEYData is the decoded data of a DNSKEY record
+type DNSKEYData struct {
+	Flags     uint16
+	Protocol  uint8 // Always 3
+	Algorithm uint8
+	PublicKey []byte
+}
+
+// DSData is the decoded data of a DS record
+type DSData struct {
+	KeyTag     uint16
+	Algorithm  uint8
+	DigestType uint8
+	Digest     []byte
+}
+
+// RRSIGData is the decoded data of an RRSIG record
+type RRSIGData struct {
+	TypeCovered uint16
+	Algorithm   uint8
+	Labels      uint8 // Owner labels, fewer for wildcard expansions
+	OriginalTTL uint32
+	Expiration  uint32 // Seconds since the epoch, serial number arithmetic
+	Inception   uint32
+	KeyTag      uint16
+	SignerName  string
+	Signature   []byte
+}
+
+// NSECData is the decoded data of an NSEC record
+type NSECData struct {
+	NextName string
+	Types    []uint16
+}
+
+// NSEC3Data is the decoded data of an NSEC3 record
+type NSEC3Data struct {
+	HashAlgorithm uint8 // 1 is SHA-1
+	Flags         uint8
+	Iterations    uint16
+	Salt          []byte
+	NextHashed    []byte
+	Types         []uint16
+}
+
+// ParseDNSKEY decodes the data of a DNSKEY record
+func ParseDNSKEY(record DNSRecord) (*DNSKEYData, error) {
+	if record.Type != TypeDNSKEY || len(record.Data) < 5 {
+		return nil, ErrInvalidRecord
+	}
+	return &DNSKEYData{
+		Flags:     binary.BigEndian.Uint16(record.Data[0:2]),
+		Protocol:  record.Data[2],
+		Algorithm: record.Data[3],
+		PublicKey: record.Data[4:],
+	}, nil
+}
+
+// ParseDS decodes the data of a DS record
+func ParseDS(record DNSRecord) (*DSData, error) {
+	if record.Type != TypeDS || len(record.Data) < 5 {
+		return nil, ErrInvalidRecord
+	}
+	return &DSData{
+		KeyTag:     binary.BigEndian.Uint16(record.Data[0:2]),
+		Algorithm:  record.Data[2],
+		DigestType: record.Data[3],
+		Digest:     record.Data[4:],
+	}, nil
+}
+
+// ParseRRSIG decodes the data of an RRSIG record
+func ParseRRSIG(record DNSRecord) (*RRSIGData, error) {
+	data := record.Data
+	if record.Type != TypeRRSIG || len(data) < rrsigFixedSize+1 {
+		return nil, ErrInvalidRecord
+	}
+
+	signer, offset, err := (&Parser{}).parseName(data, rrsigFixedSize)
+	if err != nil {
+		return nil, err
+	}
+	if offset >= len(data) {
+		return nil, ErrInvalidRecord
+	}
+
+	return &RRSIGData{
+		TypeCovered: binary.BigEndian.Uint16(data[0:2]),
+		Algorithm:   data[2],
+		Labels:      data[3],
+		OriginalTTL: binary.BigEndian.Uint32(data[4:8]),
+		Expiration:  binary.BigEndian.Uint32(data[8:12]),
+		Inception:   binary.BigEndian.Uint32(data[12:16]),
+		KeyTag:      binary.BigEndian.Uint16(data[16:18]),
+		SignerName:  signer,
+		Signature:   data[offset:],
+	}, nil
+}
+
+// ParseNSEC decodes the data of an NSEC record
+func ParseNSEC(record DNSRecord) (*NSECData, error) {
+	if record.Type != TypeNSEC {
+		return nil, ErrInvalidRecord
+	}
+
+	next, offset, err := (&Parser{}).parseName(record.Data, 0)
+	if err != nil {
+		return nil, err
+	}
+	types, err := parseTypeBitmap(record.Data[offset:])
+	if err != nil {
+		return nil, err
+	}
+	return &NSECData{NextName: next, Types: types}, nil
+}
+
+// ParseNSEC3 decodes the data of an NSEC3 record
+func ParseNSEC3(record DNSRecord) (*NSEC3Data, error) {
+	data := record.Data
+	if record.Type != TypeNSEC3 || len(data) < 5 {
+		return nil, ErrInvalidRecord
+	}
+
+	nsec3 := &NSEC3Data{
+		HashAlgorithm: data[0],
+		Flags:         data[1],
+		Iterations:    binary.BigEndian.Uint16(data[2:4]),
+	}
+
+	offset := 4
+	saltLen := int(data[offset])
+	offset++
+	if offset+saltLen+1 > len(data) {
+		return nil, ErrInvalidRecord
+	}
+	nsec3.Salt = data[offset : offset+saltLen]
+	offset += saltLen
+
+	hashLen := int(data[offset])
+	offset++
+	if hashLen == 0 || offset+hashLen > len(data) {
+		return nil, ErrInvalidRecord
+	}
+	nsec3.NextHashed = data[offset : offset+hashLen]
+	offset += hashLen
+
+	types, err := parseTypeBitmap(data[offset:])
+	if err != nil {
+		return nil, err
+	}
+	nsec3.Types = types
+	return nsec3, nil
+}
+
+// encode returns the wire form of the DNSKEY data
+func (k *DNSKEYData) encode() []byte {
+	data := binary.BigEndian.AppendUint16(nil, k.Flags)
+	data = append(data, k.Protocol, k.Algorithm)
+	return append(data, k.PublicKey...)
+}
+
+// KeyTag computes the key tag that DS and RRSIG records refer to the key
+// by (RFC 4034 appendix B)
+func (k *DNSKEYData) KeyTag() uint16 {
+	var sum uint32
+	for i, b := range k.encode() {
+		if i&1 == 0 {
+			sum += uint32(b) << 8
+		} else {
+			sum += uint32(b)
+		}
+	}
+	sum += sum >> 16 & 0xFFFF
+	return uint16(sum)
+}
+
+// ToDS computes the DS record data for the key owned by owner
+func (k *DNSKEYData) ToDS(owner string, digestType uint8) (*DSData, error) {
+	h, ok := digestHash(digestType)
+	if !ok {
+		return nil, fmt.Errorf("%w: digest type %d", ErrDNSSECUnsupported, digestType)
+	}
+
+	hasher := h.New()
+	hasher.Write(canonicalWireName(owner))
+	hasher.Write(k.encode())
+
+	return &DSData{
+		KeyTag:     k.KeyTag(),
+		Algorithm:  k.Algorithm,
+		DigestType: digestType,
+		Digest:     hasher.Sum(nil),
+	}, nil
+}
+
+// encode returns the wire form of the DS data
+func (d *DSData) encode() []byte {
+	data := binary.BigEndian.AppendUint16(nil, d.KeyTag)
+	data = append(data, d.Algorithm, d.DigestType)
+	return append(data, d.Digest...)
+}
+
+// matches reports whether the DS record refers to a DNSKEY owned by owner
+func (d *DSData) matches(owner string, key *DNSKEYData) bool {
+	if d.KeyTag != key.KeyTag() || d.Algorithm != key.Algorithm {
+		return false
+	}
+	computed, err := key.ToDS(owner, d.DigestType)
+	return err == nil && bytes.Equal(computed.Digest, d.Digest)
+}
+
+// encode returns the wire form of the RRSIG data
+func (r *RRSIGData) encode() []byte {
+	return append(r.signedPrefix(), r.Signature...)
+}
+
+// signedPrefix returns the RRSIG fields covered by the signature: the
+// data without the signature, with the signer name in canonical form
+func (r *RRSIGData) signedPrefix() []byte {
+	data := binary.BigEndian.AppendUint16(nil, r.TypeCovered)
+	data = append(data, r.Algorithm, r.Labels)
+	data = binary.BigEndian.AppendUint32(data, r.OriginalTTL)
+	data = binary.BigEndian.AppendUint32(data, r.Expiration)
+	data = binary.BigEndian.AppendUint32(data, r.Inception)
+	data = binary.BigEndian.AppendUint16(data, r.KeyTag)
+	return append(data, canonicalWireName(r.SignerName)...)
+}
+
+// validAt reports whether the signature validity period includes t, using
+// serial number arithmetic (RFC 4034 section 3.1.5)
+func (r *RRSIGData) validAt(t time.Time) bool {
+	now := uint32(t.Unix())
+	return int32(now-r.Inception) >= 0 && int32(r.Expiration-now) >= 0
+}
+
+// encode returns the wire form of the NSEC data
+func (n *NSECData) encode() []byte {
+	var buf bytes.Buffer
+	(&Parser{}).writeName(&buf, n.NextName)
+	buf.Write(encodeTypeBitmap(n.Types))
+	return buf.Bytes()
+}
+
+// encode returns the wire form of the NSEC3 data
+func (n *NSEC3Data) encode() []byte {
+	data := []byte{n.HashAlgorithm, n.Flags}
+	data = binary.BigEndian.AppendUint16(data, n.Iterations)
+	data = append(data, byte(len(n.Salt)))
+	data = append(data, n.Salt...)
+	data = append(data, byte(len(n.NextHashed)))
+	data = append(data, n.NextHashed...)
+	return append(data, encodeTypeBitmap(n.Types)...)
+}
+
+// hasType reports whether a type bitmap lists rtype
+func hasType(types []uint16, rtype uint16) bool {
+	for _, t := range types {
+		if t == rtype {
+			return true
+		}
+	}
+	return false
+}
+
+// parseTypeBitmap decodes the type bitmap of NSEC and NSEC3 records
+// (RFC 4034 section 4.1.2)
+func parseTypeBitmap(data []byte) ([]uint16, error) {
+	var types []uint16
+	for len(data) > 0 {
+		if len(data) < 2 {
+			return nil, ErrInvalidRecord
+		}
+		window, length := uint16(data[0]), int(data[1])
+		if length < 1 || length > 32 || len(data) < 2+length {
+			return nil, ErrInvalidRecord
+		}
+		for i, b := range data[2 : 2+length] {
+			for bit := 0; bit < 8; bit++ {
+				if b&(0x80>>bit) != 0 {
+					types = append(types, window<<8|uint16(i*8+bit))
+				}
+			}
+		}
+		data = data[2+length:]
+	}
+	return types, nil
+}
+
+// encodeTypeBitmap encodes a type bitmap for NSEC and NSEC3 records
+func encodeTypeBitmap(types []uint16) []byte {
+	sorted := make([]uint16, len(types))
+	copy(sorted, types)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+
+	var data []byte
+	for i := 0; i < len(sorted); {
+		window := sorted[i] >> 8
+		var bitmap [32]byte
+		length := 0
+		for ; i < len(sorted) && sorted[i]>>8 == window; i++ {
+			low := int(sorted[i] & 0xFF)
+			bitmap[low/8] |= 0x80 >> (low % 8)
+			length = low/8 + 1
+		}
+		data = append(data, byte(window), byte(length))
+		data = append(data, bitmap[:length]...)
+	}
+	return data
+}
+
+// supportedAlgorithm reports whether signatures of an algorithm can be
+// verified
+func supportedAlgorithm(algorithm uint8) bool {
+	switch algorithm {
+	case AlgorithmRSASHA1, AlgorithmRSASHA1NSEC3, AlgorithmRSASHA256, AlgorithmRSASHA512,
+		AlgorithmECDSAP256SHA256, AlgorithmECDSAP384SHA384, AlgorithmED25519:
+		return true
+	default:
+		return false
+	}
+}
+
+// digestHash returns the hash function of a DS digest type
+func digestHash(digestType uint8) (crypto.Hash, bool) {
+	switch digestType {
+	case DigestSHA1:
+		return crypto.SHA1, true
+	case DigestSHA256:
+		return crypto.SHA256, true
+	case DigestSHA384:
+		return crypto.SHA384, true
+	default:
+		return 0, false
+	}
+}
+
+// verifyRRSIG checks a signature over an RRset with a DNSKEY
+func verifyRRSIG(sig *RRSIGData, rrset []DNSRecord, key *DNSKEYData) error {
+	data := signedData(sig, rrset)
+
+	switch sig.Algorithm {
+	case AlgorithmRSASHA1, AlgorithmRSASHA1NSEC3, AlgorithmRSASHA256, AlgorithmRSASHA512:
+		pub, err := parseRSAKey(key.PublicKey)
+		if err != nil {
+			return err
+		}
+		h := crypto.SHA1
+		switch sig.Algorithm {
+		case AlgorithmRSASHA256:
+			h = crypto.SHA256
+		case AlgorithmRSASHA512:
+			h = crypto.SHA512
+		}
+		hasher := h.New()
+		hasher.Write(data)
+		if err := rsa.VerifyPKCS1v15(pub, h, hasher.Sum(nil), sig.Signature); err != nil {
+			return fmt.Errorf("%w: bad RSA signature", ErrDNSSECBogus)
+		}
+		return nil
+
+	case AlgorithmECDSAP256SHA256, AlgorithmECDSAP384SHA384:
+		curve, size := elliptic.P256(), 32
+		var digest []byte
+		if sig.Algorithm == AlgorithmECDSAP256SHA256 {
+			sum := sha256.Sum256(data)
+			digest = sum[:]
+		} else {
+			curve, size = elliptic.P384(), 48
+			sum := sha512.Sum384(data)
+			digest = sum[:]
+		}
+		if len(key.PublicKey) != 2*size || len(sig.Signature) != 2*size {
+			return fmt.Errorf("%w: bad ECDSA key or signature length", ErrDNSSECBogus)
+		}
+		pub := &ecdsa.PublicKey{
+			Curve: curve,
+			X:     new(big.Int).SetBytes(key.PublicKey[:size]),
+			Y:     new(big.Int).SetBytes(key.PublicKey[size:]),
+		}
+		r := new(big.Int).SetBytes(sig.Signature[:size])
+		s := new(big.Int).SetBytes(sig.Signature[size:])
+		if !ecdsa.Verify(pub, digest, r, s) {
+			return fmt.Errorf("%w: bad ECDSA signature", ErrDNSSECBogus)
+		}
+		return nil
+
+	case AlgorithmED25519:
+		if len(key.PublicKey) != ed25519.PublicKeySize {
+			return fmt.Errorf("%w: bad Ed25519 key length", ErrDNSSECBogus)
+		}
+		if !ed25519.Verify(ed25519.PublicKey(key.PublicKey), data, sig.Signature) {
+			return fmt.Errorf("%w: bad Ed25519 signature", ErrDNSSECBogus)
+		}
+		return nil
+
+	default:
+		return fmt.Errorf("%w: algorithm %d", ErrDNSSECUnsupported, sig.Algorithm)
+	}
+}
+
+// parseRSAKey decodes an RSA public key in DNSKEY format (RFC 3110)
+func parseRSAKey(key []byte) (*rsa.PublicKey, error) {
+	if len(key) < 3 {
+		return nil, fmt.Errorf("%w: short RSA key", ErrDNSSECBogus)
+	}
+
+	expLen, offset := int(key[0]), 1
+	if expLen == 0 {
+		expLen, offset = int(binary.BigEndian.Uint16(key[1:3])), 3
+	}
+	if expLen == 0 || expLen > 4 || offset+expLen >= len(key) {
+		return nil, fmt.Errorf("%w: unsupported RSA exponent", ErrDNSSECBogus)
+	}
+
+	var exponent int
+	for _, b := range key[offset : offset+expLen] {
+		exponent = exponent<<8 | int(b)
+	}
+	if exponent > 1<<31-1 {
+		return nil, fmt.Errorf("%w: unsupported RSA exponent", ErrDNSSECBogus)
+	}
+
+	return &rsa.PublicKey{
+		N: new(big.Int).SetBytes(key[offset+expLen:]),
+		E: exponent,
+	}, nil
+}
+
+// signedData builds the data an RRSIG signs: its own fields followed by
+// the RRset in canonical form and order (RFC 4034 section 3.1.8.1)
+func signedData(sig *RRSIGData, rrset []DNSRecord) []byte {
+	data := sig.signedPrefix()
+
+	owner := canonicalName(rrset[0].Name)
+	if labels := strings.Split(owner, "."); owner != "" && len(labels) > int(sig.Labels) {
+		// Wildcard expansion: sign the wildcard owner name
+		owner = strings.Join(append([]string{"*"}, labels[len(labels)-int(sig.Labels):]...), ".")
+		if sig.Labels == 0 {
+			owner = "*"
+		}
+	}
+	ownerWire := canonicalWireName(owner)
+
+	rdatas := make([][]byte, 0, len(rrset))
+	for _, record := range rrset {
+		rdatas = append(rdatas, canonicalRData(record.Type, record.Data))
+	}
+	sort.Slice(rdatas, func(i, j int) bool { return bytes.Compare(rdatas[i], rdatas[j]) < 0 })
+
+	for i, rdata := range rdatas {
+		if i > 0 && bytes.Equal(rdata, rdatas[i-1]) {
+			continue // Duplicate records are signed once
+		}
+		data = append(data, ownerWire...)
+		data = binary.BigEndian.AppendUint16(data, rrset[0].Type)
+		data = binary.BigEndian.AppendUint16(data, rrset[0].Class)
+		data = binary.BigEndian.AppendUint32(data, sig.OriginalTTL)
+		data = binary.BigEndian.AppendUint16(data, uint16(len(rdata)))
+		data = append(data, rdata...)
+	}
+	return data
+}
+
+// canonicalRData returns record data with embedded domain names lowercased
+// (RFC 4034 section 6.2, as updated by RFC 6840)
+func canonicalRData(rtype uint16, rdata []byte) []byte {
+	data := make([]byte, len(rdata))
+	copy(data, rdata)
+
+	var offset, names int
+	switch rtype {
+	case TypeNS, TypeCNAME, TypePTR:
+		names = 1
+	case TypeMX:
+		offset, names = 2, 1
+	case TypeSRV:
+		offset, names = 6, 1
+	case TypeSOA:
+		names = 2
+	case TypeRRSIG:
+		offset, names = rrsigFixedSize, 1
+	}
+
+	for ; names > 0; names-- {
+		for offset < len(data) && data[offset] != 0 {
+			length := int(data[offset])
+			if length > 63 || offset+1+length > len(data) {
+				return data // Compressed or malformed; leave as is
+			}
+			for i := offset + 1; i <= offset+length; i++ {
+				if data[i] >= 'A' && data[i] <= 'Z' {
+					data[i] += 'a' - 'A'
+				}
+			}
+			offset += 1 + length
+		}
+		offset++
+	}
+	return data
+}
+
+// canonicalName returns a name lowercased without its trailing dot; the
+// root is the empty string
+func canonicalName(name string) string {
+	return strings.TrimSuffix(strings.ToLower(name), ".")
+}
+
+// canonicalWireName returns the uncompressed, lowercased wire form of a name
+func canonicalWireName(name string) []byte {
+	var buf bytes.Buffer
+	(&Parser{}).writeName(&buf, canonicalName(name))
+	return buf.Bytes()
+}
+
+// compareCanonical orders names canonically: label by label from the
+// right, lowercased, shorter names first (RFC 4034 section 6.1)
+func compareCanonical(a, b string) int {
+	la, lb := nameLabels(canonicalName(a)), nameLabels(canonicalName(b))
+	for i := 1; i <= len(la) && i <= len(lb); i++ {
+		if c := strings.Compare(la[len(la)-i], lb[len(lb)-i]); c != 0 {
+			return c
+		}
+	}
+	return len(la) - len(lb)
+}
+
+// nameLabels splits a canonical name into labels; the root has none
+func nameLabels(name string) []string {
+	if name == "" {
+		return nil
+	}
+	return strings.Split(name, ".")
+}
+
+// isSubdomain reports whether child equals parent or lies below it
+func isSubdomain(child, parent string) bool {
+	child, parent = canonicalName(child), canonicalName(parent)
+	return parent == "" || child == parent || strings.HasSuffix(child, "."+parent)
+}
+
+// parentName returns the name with its first label removed
+func parentName(name string) string {
+	name = canonicalName(name)
+	if i := strings.IndexByte(name, '.'); i >= 0 {
+		return name[i+1:]
+	}
+	return ""
+}
+
+// displayName returns a name for logs, showing the root as "."
+func displayName(name string) string {
+	if name == "" {
+		return "."
+	}
+	return name
+}
+
+// nsec3Hash returns the base32hex encoded hash of a name (RFC 5155
+// section 5)
+func nsec3Hash(name string, iterations uint16, salt []byte) string {
+	sum := sha1.Sum(append(canonicalWireName(name), salt...))
+	for i := 0; i < int(iterations); i++ {
+		sum = sha1.Sum(append(sum[:], salt...))
+	}
+	return strings.ToLower(nsec3Base32.EncodeToString(sum[:]))
+}
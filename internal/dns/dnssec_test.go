@@ -0,0 +1,469 @@
+package dns
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"fmt"
+	"net"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+
+	"pihole-analyzer/internal/logger"
+)
+
+// testZone is a zone of the in-process stand-in resolver, signed with a
+// single ECDSA P-256 key unless key is nil
+type testZone struct {
+	name    string
+	key     *ecdsa.PrivateKey
+	dnskey  *DNSKEYData
+	rrsets  map[string][]DNSRecord
+	types   map[string][]uint16 // Types present at each owner name
+	nsecs   []DNSRecord
+	corrupt map[string]bool // RRsets served with a broken signature
+}
+
+func newTestZone(t *testing.T, name string, signed bool) *testZone {
+	t.Helper()
+
+	z := &testZone{
+		name:    name,
+		rrsets:  make(map[string][]DNSRecord),
+		types:   make(map[string][]uint16),
+		corrupt: make(map[string]bool),
+	}
+	if signed {
+		key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		if err != nil {
+			t.Fatalf("Failed to generate key: %v", err)
+		}
+		z.key = key
+		z.dnskey = &DNSKEYData{
+			Flags:     DNSKEYFlagZone | DNSKEYFlagSEP,
+			Protocol:  3,
+			Algorithm: AlgorithmECDSAP256SHA256,
+			PublicKey: append(key.X.FillBytes(make([]byte, 32)), key.Y.FillBytes(make([]byte, 32))...),
+		}
+		z.add(name, TypeDNSKEY, z.dnskey.encode())
+	}
+
+	soa := &SOAData{MName: "ns." + name, RName: "hostmaster." + name, Serial: 1, Refresh: 3600, Retry: 600, Expire: 86400, Minimum: 300}
+	soaData, _ := soa.Encode()
+	z.add(name, TypeSOA, soaData)
+	return z
+}
+
+func (z *testZone) add(name string, rtype uint16, data []byte) {
+	record := DNSRecord{Name: name, Type: rtype, Class: ClassIN, TTL: 300, Data: data}
+	key := rrsetKey(record)
+	if _, ok := z.rrsets[key]; !ok {
+		z.types[name] = append(z.types[name], rtype)
+	}
+	z.rrsets[key] = append(z.rrsets[key], record)
+}
+
+func (z *testZone) addName(name string, rtype uint16, target string) {
+	z.add(name, rtype, canonicalWireName(target))
+}
+
+// delegate adds a delegation to child, with a DS record if it is signed
+func (z *testZone) delegate(child *testZone) {
+	z.addName(child.name, TypeNS, "ns."+child.name)
+	if child.dnskey != nil {
+		ds, _ := child.dnskey.ToDS(child.name, DigestSHA256)
+		z.add(child.name, TypeDS, ds.encode())
+	}
+}
+
+// finish builds the NSEC chain of a signed zone
+func (z *testZone) finish() {
+	if z.key == nil {
+		return
+	}
+
+	var names []string
+	for name := range z.types {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool { return compareCanonical(names[i], names[j]) < 0 })
+
+	for i, name := range names {
+		nsec := &NSECData{
+			NextName: names[(i+1)%len(names)],
+			Types:    append(append([]uint16(nil), z.types[name]...), TypeRRSIG, TypeNSEC),
+		}
+		z.nsecs = append(z.nsecs, DNSRecord{Name: name, Type: TypeNSEC, Class: ClassIN, TTL: 300, Data: nsec.encode()})
+	}
+}
+
+// signed returns an RRset with its signature, named owner for wildcard
+// expansions
+func (z *testZone) signed(set []DNSRecord, owner string) []DNSRecord {
+	expanded := make([]DNSRecord, len(set))
+	for i, record := range set {
+		record.Name = owner
+		expanded[i] = record
+	}
+	if z.key == nil {
+		return expanded
+	}
+
+	labels := len(nameLabels(canonicalName(set[0].Name)))
+	if len(set[0].Name) > 1 && set[0].Name[:2] == "*." {
+		labels--
+	}
+	now := time.Now()
+	sig := &RRSIGData{
+		TypeCovered: set[0].Type,
+		Algorithm:   AlgorithmECDSAP256SHA256,
+		Labels:      uint8(labels),
+		OriginalTTL: set[0].TTL,
+		Expiration:  uint32(now.Add(time.Hour).Unix()),
+		Inception:   uint32(now.Add(-time.Hour).Unix()),
+		KeyTag:      z.dnskey.KeyTag(),
+		SignerName:  z.name,
+	}
+
+	digest := sha256.Sum256(signedData(sig, set))
+	r, s, err := ecdsa.Sign(rand.Reader, z.key, digest[:])
+	if err != nil {
+		panic(err)
+	}
+	sig.Signature = append(r.FillBytes(make([]byte, 32)), s.FillBytes(make([]byte, 32))...)
+	if z.corrupt[rrsetKey(set[0])] {
+		sig.Signature[0] ^= 0xFF
+	}
+
+	return append(expanded, DNSRecord{Name: owner, Type: TypeRRSIG, Class: ClassIN, TTL: set[0].TTL, Data: sig.encode()})
+}
+
+// nsecFor returns the signed NSEC owned by or covering name
+func (z *testZone) nsecFor(name string) []DNSRecord {
+	for _, record := range z.nsecs {
+		if canonicalName(record.Name) == name {
+			return z.signed([]DNSRecord{record}, record.Name)
+		}
+	}
+	if covering := coverNSEC(name, z.nsecs); covering != nil {
+		return z.signed([]DNSRecord{*covering}, covering.Name)
+	}
+	return nil
+}
+
+// testResolver stands in for a recursive resolver serving test zones
+type testResolver struct {
+	zones []*testZone
+}
+
+// zoneFor returns the deepest zone holding name; DS records live in the
+// parent zone
+func (r *testResolver) zoneFor(name string, qtype uint16) *testZone {
+	var best *testZone
+	for _, z := range r.zones {
+		if !isSubdomain(name, z.name) || (qtype == TypeDS && name == z.name && z.name != "") {
+			continue
+		}
+		if best == nil || len(nameLabels(z.name)) > len(nameLabels(best.name)) {
+			best = z
+		}
+	}
+	return best
+}
+
+func (r *testResolver) answer(question DNSQuestion) *DNSResponse {
+	response := &DNSResponse{Question: question}
+	name := canonicalName(question.Name)
+
+	for hops := 0; hops < 8; hops++ {
+		z := r.zoneFor(name, question.Type)
+		soa := z.signed(z.rrsets[rrsetKey(DNSRecord{Name: z.name, Type: TypeSOA})], z.name)
+
+		if set, ok := z.rrsets[rrsetKey(DNSRecord{Name: name, Type: question.Type})]; ok {
+			response.Answers = append(response.Answers, z.signed(set, name)...)
+			return response
+		}
+		if set, ok := z.rrsets[rrsetKey(DNSRecord{Name: name, Type: TypeCNAME})]; ok {
+			response.Answers = append(response.Answers, z.signed(set, name)...)
+			target, _, _ := (&Parser{}).parseName(set[0].Data, 0)
+			name = canonicalName(target)
+			continue
+		}
+		if _, exists := z.types[name]; exists {
+			response.Authorities = append(soa, z.nsecFor(name)...)
+			return response
+		}
+
+		// Wildcard expansion, proven by the NSEC covering the name
+		for ancestor := parentName(name); isSubdomain(ancestor, z.name); ancestor = parentName(ancestor) {
+			if set, ok := z.rrsets[rrsetKey(DNSRecord{Name: "*." + ancestor, Type: question.Type})]; ok {
+				response.Answers = append(response.Answers, z.signed(set, name)...)
+				response.Authorities = z.nsecFor(name)
+				return response
+			}
+			if ancestor == z.name {
+				break
+			}
+		}
+
+		response.ResponseCode = RCodeNXDomain
+		response.Authorities = append(soa, z.nsecFor(name)...)
+		response.Authorities = append(response.Authorities, z.nsecFor("*."+z.name)...)
+		return response
+	}
+	return response
+}
+
+// serve answers queries on a loopback UDP socket
+func (r *testResolver) serve(t *testing.T) string {
+	t.Helper()
+
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen on UDP: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	parser := NewParser()
+	go func() {
+		buffer := make([]byte, maxUDPMessageSize)
+		for {
+			n, addr, err := conn.ReadFrom(buffer)
+			if err != nil {
+				return
+			}
+			query, err := parser.ParseQuery(buffer[:n])
+			if err != nil {
+				continue
+			}
+			response := r.answer(query.Question)
+			response.ID = query.ID
+			if data, err := parser.SerializeResponse(response); err == nil {
+				conn.WriteTo(data, addr)
+			}
+		}
+	}()
+	return conn.LocalAddr().String()
+}
+
+// newTestSignedZones builds a signed root and "test" zone with an
+// unsigned delegation to "insecure.test"
+func newTestSignedZones(t *testing.T) (*testResolver, string) {
+	root := newTestZone(t, "", true)
+	zone := newTestZone(t, "test", true)
+	insecure := newTestZone(t, "insecure.test", false)
+
+	zone.add("www.test", TypeA, []byte{192, 0, 2, 1})
+	zone.addName("alias.test", TypeCNAME, "www.test")
+	zone.add("*.wild.test", TypeA, []byte{192, 0, 2, 2})
+	zone.add("bad.test", TypeA, []byte{192, 0, 2, 3})
+	zone.corrupt[rrsetKey(DNSRecord{Name: "bad.test", Type: TypeA})] = true
+	zone.delegate(insecure)
+	insecure.add("host.insecure.test", TypeA, []byte{192, 0, 2, 4})
+
+	root.delegate(zone)
+	for _, z := range []*testZone{root, zone, insecure} {
+		z.finish()
+	}
+
+	ds, _ := root.dnskey.ToDS("", DigestSHA256)
+	anchor := fmt.Sprintf(". %d %d %d %X", ds.KeyTag, ds.Algorithm, ds.DigestType, ds.Digest)
+	return &testResolver{zones: []*testZone{root, zone, insecure}}, anchor
+}
+
+func TestDNSSECRecords_RoundTrip(t *testing.T) {
+	key := &DNSKEYData{Flags: DNSKEYFlagZone | DNSKEYFlagSEP, Protocol: 3, Algorithm: AlgorithmED25519, PublicKey: make([]byte, 32)}
+	parsedKey, err := ParseDNSKEY(DNSRecord{Type: TypeDNSKEY, Data: key.encode()})
+	if err != nil || !reflect.DeepEqual(parsedKey, key) {
+		t.Errorf("DNSKEY round trip = %+v, %v", parsedKey, err)
+	}
+
+	ds, err := key.ToDS("example.com", DigestSHA256)
+	if err != nil {
+		t.Fatalf("ToDS failed: %v", err)
+	}
+	parsedDS, err := ParseDS(DNSRecord{Type: TypeDS, Data: ds.encode()})
+	if err != nil || !parsedDS.matches("EXAMPLE.com.", key) {
+		t.Errorf("Expected DS to match its key, got %+v, %v", parsedDS, err)
+	}
+
+	sig := &RRSIGData{TypeCovered: TypeA, Algorithm: AlgorithmED25519, Labels: 2, OriginalTTL: 300,
+		Expiration: 2000, Inception: 1000, KeyTag: key.KeyTag(), SignerName: "example.com", Signature: []byte{1, 2, 3}}
+	parsedSig, err := ParseRRSIG(DNSRecord{Type: TypeRRSIG, Data: sig.encode()})
+	if err != nil || !reflect.DeepEqual(parsedSig, sig) {
+		t.Errorf("RRSIG round trip = %+v, %v", parsedSig, err)
+	}
+	if !sig.validAt(time.Unix(1500, 0)) || sig.validAt(time.Unix(2001, 0)) {
+		t.Error("Unexpected RRSIG validity period check")
+	}
+
+	nsec := &NSECData{NextName: "b.example.com", Types: []uint16{TypeA, TypeMX, TypeRRSIG, TypeNSEC, 1234}}
+	parsedNSEC, err := ParseNSEC(DNSRecord{Type: TypeNSEC, Data: nsec.encode()})
+	if err != nil || !reflect.DeepEqual(parsedNSEC, nsec) {
+		t.Errorf("NSEC round trip = %+v, %v", parsedNSEC, err)
+	}
+
+	nsec3 := &NSEC3Data{HashAlgorithm: 1, Flags: nsec3FlagOptOut, Iterations: 12, Salt: []byte{0xAA, 0xBB},
+		NextHashed: make([]byte, 20), Types: []uint16{TypeNS, TypeDS}}
+	parsedNSEC3, err := ParseNSEC3(DNSRecord{Type: TypeNSEC3, Data: nsec3.encode()})
+	if err != nil || !reflect.DeepEqual(parsedNSEC3, nsec3) {
+		t.Errorf("NSEC3 round trip = %+v, %v", parsedNSEC3, err)
+	}
+
+	// RFC 5155 appendix A
+	if hash := nsec3Hash("example", 12, []byte{0xAA, 0xBB, 0xCC, 0xDD}); hash != "0p9mhaveqvm6t7vbl5lop2u3t2rp3tom" {
+		t.Errorf("Unexpected NSEC3 hash %s", hash)
+	}
+
+	if _, _, err := ParseTrustAnchor(". 20326 8 2 E06D44B8"); err != nil {
+		t.Errorf("Expected valid trust anchor, got %v", err)
+	}
+	if _, _, err := ParseTrustAnchor(". 20326 8 2"); err == nil {
+		t.Error("Expected error for trust anchor without digest")
+	}
+}
+
+func TestCompareCanonical(t *testing.T) {
+	// RFC 4034 section 6.1
+	ordered := []string{"example", "a.example", "yljkjljk.a.example", "Z.a.example",
+		"zABC.a.EXAMPLE", "z.example", "*.z.example"}
+
+	for i := 0; i+1 < len(ordered); i++ {
+		if compareCanonical(ordered[i], ordered[i+1]) >= 0 {
+			t.Errorf("Expected %q before %q", ordered[i], ordered[i+1])
+		}
+	}
+}
+
+func TestServer_DNSSECValidation(t *testing.T) {
+	resolver, anchor := newTestSignedZones(t)
+
+	config := DefaultConfig()
+	config.LogQueries = false
+	config.Cache.Enabled = false
+	config.Forwarder.Upstreams = []string{resolver.serve(t)}
+	config.Forwarder.HealthCheck = false
+	config.Forwarder.Retries = 0
+	config.Forwarder.Timeout = 2 * time.Second
+	config.DNSSEC.Enabled = true
+	config.DNSSEC.TrustAnchors = []string{anchor}
+	if err := config.Validate(); err != nil {
+		t.Fatalf("Validate failed: %v", err)
+	}
+
+	testLogger := logger.New(&logger.Config{
+		Level:     logger.LogLevel("ERROR"),
+		Component: "dns-test",
+	})
+	server := NewServer(config, testLogger).(*Server)
+
+	query := func(name string, qtype uint16, do, cd bool) *DNSResponse {
+		t.Helper()
+		q := &DNSQuery{
+			ID:               7,
+			Question:         DNSQuestion{Name: name, Type: qtype, Class: ClassIN},
+			Client:           &net.UDPAddr{IP: net.ParseIP("192.168.1.50"), Port: 5000},
+			Protocol:         "udp",
+			CheckingDisabled: cd,
+		}
+		if do {
+			q.EDNS = &EDNS{UDPSize: 4096, DNSSECOK: true}
+		}
+		response, err := server.HandleQuery(context.Background(), q)
+		if err != nil {
+			t.Fatalf("HandleQuery(%s) failed: %v", name, err)
+		}
+		return response
+	}
+
+	tests := []struct {
+		name    string
+		qtype   uint16
+		rcode   uint8
+		answers int // Excluding signatures
+		ad      bool
+	}{
+		{"www.test", TypeA, RCodeNoError, 1, true},
+		{"alias.test", TypeA, RCodeNoError, 2, true},
+		{"foo.wild.test", TypeA, RCodeNoError, 1, true},
+		{"www.test", TypeAAAA, RCodeNoError, 0, true},
+		{"missing.test", TypeA, RCodeNXDomain, 0, true},
+		{"host.insecure.test", TypeA, RCodeNoError, 1, false},
+		{"bad.test", TypeA, RCodeServFail, 0, false},
+	}
+
+	for _, tt := range tests {
+		response := query(tt.name, tt.qtype, true, false)
+		answers := withoutDNSSEC(response.Answers, tt.qtype)
+		if response.ResponseCode != tt.rcode || len(answers) != tt.answers || response.AuthenticData != tt.ad {
+			t.Errorf("%s/%d: got rcode %d, %d answers, AD %v; want rcode %d, %d answers, AD %v",
+				tt.name, tt.qtype, response.ResponseCode, len(answers), response.AuthenticData, tt.rcode, tt.answers, tt.ad)
+		}
+	}
+
+	// Clients without DO get neither signatures nor the AD bit
+	response := query("www.test", TypeA, false, false)
+	if response.AuthenticData || len(response.Answers) != 1 || response.Answers[0].Type != TypeA {
+		t.Errorf("Expected a plain answer without DO, got AD %v and %d records", response.AuthenticData, len(response.Answers))
+	}
+	data, err := server.parser.SerializeResponse(query("www.test", TypeA, true, false))
+	if err != nil {
+		t.Fatalf("Failed to serialize response: %v", err)
+	}
+	if flags := uint16(data[2])<<8 | uint16(data[3]); flags&FlagAD == 0 {
+		t.Error("Expected AD bit on the wire")
+	}
+
+	// Checking disabled returns bogus data as is
+	response = query("bad.test", TypeA, true, true)
+	if response.ResponseCode != RCodeNoError || response.AuthenticData || len(withoutDNSSEC(response.Answers, TypeA)) != 1 {
+		t.Errorf("Expected unvalidated answer with CD, got rcode %d, AD %v", response.ResponseCode, response.AuthenticData)
+	}
+
+	stats := server.GetStats()
+	if stats.DNSSECSecure < 5 || stats.DNSSECInsecure != 1 || stats.DNSSECBogus != 2 {
+		t.Errorf("Unexpected DNSSEC stats: %d secure, %d insecure, %d bogus",
+			stats.DNSSECSecure, stats.DNSSECInsecure, stats.DNSSECBogus)
+	}
+
+	// Expired signatures are bogus
+	server.validator.now = func() time.Time { return time.Now().Add(2 * time.Hour) }
+	server.validator.zones = make(map[string]*zoneKeys)
+	if response := query("www.test", TypeA, true, false); response.ResponseCode != RCodeServFail {
+		t.Errorf("Expected SERVFAIL for expired signatures, got rcode %d", response.ResponseCode)
+	}
+
+	// A trust anchor that does not match the root key breaks the chain
+	server.validator.now = time.Now
+	server.validator.zones = make(map[string]*zoneKeys)
+	for _, ds := range server.validator.anchors[""] {
+		ds.Digest[0] ^= 0xFF
+	}
+	if response := query("www.test", TypeA, true, false); response.ResponseCode != RCodeServFail {
+		t.Errorf("Expected SERVFAIL with a mismatched trust anchor, got rcode %d", response.ResponseCode)
+	}
+}
+
+func TestConfig_DNSSECValidation(t *testing.T) {
+	config := DefaultConfig()
+	config.DNSSEC.Enabled = true
+	if err := config.Validate(); err != nil {
+		t.Errorf("Expected root trust anchors to be valid, got %v", err)
+	}
+
+	config.Forwarder.EDNS0Enabled = false
+	if err := config.Validate(); err != ErrDNSSECRequiresEDNS {
+		t.Errorf("Expected ErrDNSSECRequiresEDNS, got %v", err)
+	}
+
+	config = DefaultConfig()
+	config.DNSSEC.Enabled = true
+	config.DNSSEC.TrustAnchors = []string{"example. 1 8 2 nothex"}
+	if err := config.Validate(); err == nil {
+		t.Error("Expected error for invalid trust anchor")
+	}
+}
@@ -0,0 +1,666 @@
+package dns
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"google.golang.org/protobuf/encoding/protowire"
+
+	"pihole-analyzer/internal/logger"
+)
+
+// dnstapContentType identifies dnstap payloads in Frame Streams
+const dnstapContentType = "protobuf:dnstap.Dnstap"
+
+const (
+	// dnstapWriteTimeout bounds a single write to a socket receiver
+	dnstapWriteTimeout = 5 * time.Second
+
+	// dnstapHandshakeTimeout bounds waiting for ACCEPT and FINISH frames
+	dnstapHandshakeTimeout = 5 * time.Second
+
+	// fstrmMaxControlSize is the largest control frame accepted
+	fstrmMaxControlSize = 512
+)
+
+// Frame Streams control frame types and fields
+const (
+	fstrmControlAccept = 1
+	fstrmControlStart  = 2
+	fstrmControlStop   = 3
+	fstrmControlReady  = 4
+	fstrmControlFinish = 5
+
+	fstrmFieldContentType = 1
+)
+
+// dnstap message types (dnstap.proto Message.Type)
+const (
+	dnstapResolverQuery     = 3
+	dnstapResolverResponse  = 4
+	dnstapClientQuery       = 5
+	dnstapClientResponse    = 6
+	dnstapForwarderQuery    = 7
+	dnstapForwarderResponse = 8
+)
+
+// dnstapSocketProtocols maps transport names to dnstap SocketProtocol values
+var dnstapSocketProtocols = map[string]uint64{
+	"udp": 1,
+	"tcp": 2,
+	"dot": 3,
+	"doh": 4,
+}
+
+// Dnstap writes dnstap frames for client and forwarder messages. Frames are
+// queued without blocking; when the queue is full they are dropped and
+// counted, so a slow or absent receiver never delays query handling.
+type Dnstap struct {
+	config   DnstapConfig
+	logger   *logger.Logger
+	identity []byte
+
+	frames chan []byte
+	stop   chan struct{}
+	done   chan struct{}
+	start  sync.Once
+	close  sync.Once
+
+	// Files are replaced when first opened and appended to after errors
+	opened bool
+
+	queued    atomic.Int64
+	dropped   atomic.Int64
+	connected atomic.Bool
+}
+
+// DnstapStats reports dnstap output counters
+type DnstapStats struct {
+	Frames    int64 `json:"frames"`  // Frames queued for the receiver
+	Dropped   int64 `json:"dropped"` // Frames dropped with the queue full
+	Connected bool  `json:"connected"`
+}
+
+// dnstapMessage holds the fields of a dnstap Message
+type dnstapMessage struct {
+	kind          uint64
+	protocol      string
+	queryAddr     net.Addr
+	responseAddr  net.Addr
+	queryTime     time.Time
+	responseTime  time.Time
+	queryMessage  []byte
+	responseBytes []byte
+}
+
+// NewDnstap creates a dnstap writer; Start begins delivering frames
+func NewDnstap(config DnstapConfig, logger *logger.Logger) *Dnstap {
+	identity := config.Identity
+	if identity == "" {
+		identity, _ = os.Hostname()
+	}
+
+	return &Dnstap{
+		config:   config,
+		logger:   logger,
+		identity: []byte(identity),
+		frames:   make(chan []byte, config.BufferSize),
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+}
+
+// validate checks the dnstap configuration
+func (c DnstapConfig) validate() error {
+	switch c.Network {
+	case DnstapNetworkUnix, DnstapNetworkTCP:
+		if c.ReconnectInterval <= 0 {
+			return fmt.Errorf("%w: reconnect interval must be positive", ErrInvalidDnstap)
+		}
+	case DnstapNetworkFile:
+	default:
+		return fmt.Errorf("%w: unknown network %q", ErrInvalidDnstap, c.Network)
+	}
+	if c.Address == "" {
+		return fmt.Errorf("%w: no address", ErrInvalidDnstap)
+	}
+	if c.BufferSize < 1 {
+		return fmt.Errorf("%w: buffer size must be positive", ErrInvalidDnstap)
+	}
+	return nil
+}
+
+// Start begins writing queued frames to the configured output
+func (d *Dnstap) Start() {
+	d.start.Do(func() {
+		go d.run()
+	})
+}
+
+// Close writes the frames still queued, ends the stream and closes the
+// output
+func (d *Dnstap) Close() error {
+	d.close.Do(func() {
+		close(d.stop)
+	})
+	// A writer that never started has no output to end
+	d.start.Do(func() {
+		close(d.done)
+	})
+	<-d.done
+	return nil
+}
+
+// GetStats returns dnstap output counters
+func (d *Dnstap) GetStats() *DnstapStats {
+	return &DnstapStats{
+		Frames:    d.queued.Load(),
+		Dropped:   d.dropped.Load(),
+		Connected: d.connected.Load(),
+	}
+}
+
+// ClientQuery logs a query received from a client on the server address
+func (d *Dnstap) ClientQuery(query *DNSQuery, server net.Addr, message []byte, received time.Time) {
+	if !d.config.ClientMessages {
+		return
+	}
+	d.send(&dnstapMessage{
+		kind:         dnstapClientQuery,
+		protocol:     query.Protocol,
+		queryAddr:    query.Client,
+		responseAddr: server,
+		queryTime:    received,
+		queryMessage: message,
+	})
+}
+
+// ClientResponse logs the response sent to a client
+func (d *Dnstap) ClientResponse(query *DNSQuery, server net.Addr, response []byte, received, sent time.Time) {
+	if !d.config.ClientMessages {
+		return
+	}
+	d.send(&dnstapMessage{
+		kind:          dnstapClientResponse,
+		protocol:      query.Protocol,
+		queryAddr:     query.Client,
+		responseAddr:  server,
+		queryTime:     received,
+		responseTime:  sent,
+		responseBytes: response,
+	})
+}
+
+// ForwarderQuery logs a query sent to an upstream over protocol
+func (d *Dnstap) ForwarderQuery(upstream net.Addr, protocol string, message []byte, sent time.Time) {
+	if !d.config.ForwarderMessages {
+		return
+	}
+	d.send(&dnstapMessage{
+		kind:         dnstapForwarderQuery,
+		protocol:     protocol,
+		responseAddr: upstream,
+		queryTime:    sent,
+		queryMessage: message,
+	})
+}
+
+// ForwarderResponse logs a response received from an upstream
+func (d *Dnstap) ForwarderResponse(upstream net.Addr, protocol string, response []byte, sent, received time.Time) {
+	if !d.config.ForwarderMessages {
+		return
+	}
+	d.send(&dnstapMessage{
+		kind:          dnstapForwarderResponse,
+		protocol:      protocol,
+		responseAddr:  upstream,
+		queryTime:     sent,
+		responseTime:  received,
+		responseBytes: response,
+	})
+}
+
+// ResolverQuery logs a query sent to an authoritative server while
+// resolving iteratively
+func (d *Dnstap) ResolverQuery(server net.Addr, protocol string, message []byte, sent time.Time) {
+	if !d.config.ForwarderMessages {
+		return
+	}
+	d.send(&dnstapMessage{
+		kind:         dnstapResolverQuery,
+		protocol:     protocol,
+		responseAddr: server,
+		queryTime:    sent,
+		queryMessage: message,
+	})
+}
+
+// ResolverResponse logs a response received from an authoritative server
+func (d *Dnstap) ResolverResponse(server net.Addr, protocol string, response []byte, sent, received time.Time) {
+	if !d.config.ForwarderMessages {
+		return
+	}
+	d.send(&dnstapMessage{
+		kind:          dnstapResolverResponse,
+		protocol:      protocol,
+		responseAddr:  server,
+		queryTime:     sent,
+		responseTime:  received,
+		responseBytes: response,
+	})
+}
+
+// send encodes a message and queues it without blocking
+func (d *Dnstap) send(message *dnstapMessage) {
+	frame := d.encode(message)
+
+	select {
+	case d.frames <- frame:
+		d.queued.Add(1)
+	default:
+		d.dropped.Add(1)
+	}
+}
+
+// encode returns the protobuf encoding of a Dnstap message
+func (d *Dnstap) encode(m *dnstapMessage) []byte {
+	var msg []byte
+	msg = protowire.AppendTag(msg, 1, protowire.VarintType)
+	msg = protowire.AppendVarint(msg, m.kind)
+
+	queryIP, queryPort := dnstapAddr(m.queryAddr)
+	responseIP, responsePort := dnstapAddr(m.responseAddr)
+	if queryIP != nil && len(responseIP) != len(queryIP) {
+		responseIP, responsePort = nil, 0 // Dual-stack listener, other family
+	}
+	if family := dnstapFamily(queryIP, responseIP); family != 0 {
+		msg = protowire.AppendTag(msg, 2, protowire.VarintType)
+		msg = protowire.AppendVarint(msg, family)
+	}
+	if protocol, ok := dnstapSocketProtocols[m.protocol]; ok {
+		msg = protowire.AppendTag(msg, 3, protowire.VarintType)
+		msg = protowire.AppendVarint(msg, protocol)
+	}
+	if queryIP != nil {
+		msg = protowire.AppendTag(msg, 4, protowire.BytesType)
+		msg = protowire.AppendBytes(msg, queryIP)
+	}
+	if responseIP != nil {
+		msg = protowire.AppendTag(msg, 5, protowire.BytesType)
+		msg = protowire.AppendBytes(msg, responseIP)
+	}
+	if queryPort != 0 {
+		msg = protowire.AppendTag(msg, 6, protowire.VarintType)
+		msg = protowire.AppendVarint(msg, uint64(queryPort))
+	}
+	if responsePort != 0 {
+		msg = protowire.AppendTag(msg, 7, protowire.VarintType)
+		msg = protowire.AppendVarint(msg, uint64(responsePort))
+	}
+	if !m.queryTime.IsZero() {
+		msg = protowire.AppendTag(msg, 8, protowire.VarintType)
+		msg = protowire.AppendVarint(msg, uint64(m.queryTime.Unix()))
+		msg = protowire.AppendTag(msg, 9, protowire.Fixed32Type)
+		msg = protowire.AppendFixed32(msg, uint32(m.queryTime.Nanosecond()))
+	}
+	if m.queryMessage != nil {
+		msg = protowire.AppendTag(msg, 10, protowire.BytesType)
+		msg = protowire.AppendBytes(msg, m.queryMessage)
+	}
+	if !m.responseTime.IsZero() {
+		msg = protowire.AppendTag(msg, 12, protowire.VarintType)
+		msg = protowire.AppendVarint(msg, uint64(m.responseTime.Unix()))
+		msg = protowire.AppendTag(msg, 13, protowire.Fixed32Type)
+		msg = protowire.AppendFixed32(msg, uint32(m.responseTime.Nanosecond()))
+	}
+	if m.responseBytes != nil {
+		msg = protowire.AppendTag(msg, 14, protowire.BytesType)
+		msg = protowire.AppendBytes(msg, m.responseBytes)
+	}
+
+	var frame []byte
+	frame = protowire.AppendTag(frame, 1, protowire.BytesType)
+	frame = protowire.AppendBytes(frame, d.identity)
+	if d.config.Version != "" {
+		frame = protowire.AppendTag(frame, 2, protowire.BytesType)
+		frame = protowire.AppendString(frame, d.config.Version)
+	}
+	frame = protowire.AppendTag(frame, 14, protowire.BytesType)
+	frame = protowire.AppendBytes(frame, msg)
+	frame = protowire.AppendTag(frame, 15, protowire.VarintType)
+	return protowire.AppendVarint(frame, 1) // MESSAGE
+}
+
+// dnstapAddr returns the address and port of a UDP or TCP address
+func dnstapAddr(addr net.Addr) (net.IP, int) {
+	var ip net.IP
+	var port int
+	switch a := addr.(type) {
+	case *net.UDPAddr:
+		ip, port = a.IP, a.Port
+	case *net.TCPAddr:
+		ip, port = a.IP, a.Port
+	default:
+		return nil, 0
+	}
+
+	if ip4 := ip.To4(); ip4 != nil {
+		return ip4, port
+	}
+	return ip.To16(), port
+}
+
+// dnstapUpstream returns the address and dnstap protocol name of an
+// upstream transport; upstreams given by hostname have no address
+func dnstapUpstream(transport upstreamTransport) (net.Addr, string) {
+	var address string
+	var protocol string
+	switch t := transport.(type) {
+	case *udpTransport:
+		address, protocol = t.address, "udp"
+	case *tcpTransport:
+		address, protocol = t.address, "tcp"
+	case *dotTransport:
+		address, protocol = t.address, "dot"
+	case *dohTransport:
+		return nil, "doh"
+	default:
+		return nil, ""
+	}
+
+	host, portStr, err := net.SplitHostPort(address)
+	if err != nil {
+		return nil, protocol
+	}
+	ip := net.ParseIP(host)
+	port, err := strconv.Atoi(portStr)
+	if ip == nil || err != nil {
+		return nil, protocol
+	}
+	if protocol == "udp" {
+		return &net.UDPAddr{IP: ip, Port: port}, protocol
+	}
+	return &net.TCPAddr{IP: ip, Port: port}, protocol
+}
+
+// dnstapFamily returns the dnstap SocketFamily of the message addresses
+func dnstapFamily(ips ...net.IP) uint64 {
+	for _, ip := range ips {
+		switch len(ip) {
+		case net.IPv4len:
+			return 1 // INET
+		case net.IPv6len:
+			return 2 // INET6
+		}
+	}
+	return 0
+}
+
+// run delivers frames until Close, reconnecting to socket receivers
+func (d *Dnstap) run() {
+	defer close(d.done)
+
+	failing := false
+	for {
+		out, err := d.open()
+		if err == nil {
+			if failing {
+				d.logger.InfoFields("dnstap output connected", map[string]any{
+					"network": d.config.Network,
+					"address": d.config.Address,
+				})
+			}
+			failing = false
+
+			d.connected.Store(true)
+			err = d.deliver(out)
+			d.connected.Store(false)
+			out.close()
+			if err == nil {
+				return
+			}
+		}
+
+		// Log the start of an outage only; frames are dropped meanwhile
+		if !failing {
+			d.logger.WarnFields("dnstap output unavailable", map[string]any{
+				"network": d.config.Network,
+				"address": d.config.Address,
+				"error":   err.Error(),
+			})
+			failing = true
+		}
+
+		select {
+		case <-d.stop:
+			return
+		case <-time.After(d.config.ReconnectInterval):
+		}
+	}
+}
+
+// deliver writes queued frames to an output until Close or a write error
+func (d *Dnstap) deliver(out *dnstapOutput) error {
+	for {
+		select {
+		case frame := <-d.frames:
+			if err := out.writeFrame(frame); err != nil {
+				return err
+			}
+			// Flush whenever the queue runs empty
+			if len(d.frames) == 0 {
+				if err := out.flush(); err != nil {
+					return err
+				}
+			}
+
+		case <-d.stop:
+			err := d.drain(out)
+			if err == nil {
+				err = out.finish()
+			}
+			if err != nil {
+				d.logger.WarnFields("Failed to end dnstap stream", map[string]any{
+					"error": err.Error(),
+				})
+			}
+			return nil
+		}
+	}
+}
+
+// drain writes the frames still queued
+func (d *Dnstap) drain(out *dnstapOutput) error {
+	for {
+		select {
+		case frame := <-d.frames:
+			if err := out.writeFrame(frame); err != nil {
+				return err
+			}
+		default:
+			return nil
+		}
+	}
+}
+
+// dnstapOutput is an open Frame Streams connection or file
+type dnstapOutput struct {
+	writer *bufio.Writer
+	closer io.Closer
+	conn   net.Conn // nil for files
+}
+
+// open connects to the receiver, or opens the file, and starts a stream
+func (d *Dnstap) open() (*dnstapOutput, error) {
+	if d.config.Network == DnstapNetworkFile {
+		flags := os.O_CREATE | os.O_WRONLY | os.O_APPEND
+		if !d.opened {
+			flags = os.O_CREATE | os.O_WRONLY | os.O_TRUNC
+		}
+		file, err := os.OpenFile(d.config.Address, flags, 0644)
+		if err != nil {
+			return nil, err
+		}
+		d.opened = true
+
+		out := &dnstapOutput{writer: bufio.NewWriter(file), closer: file}
+		if err := out.writeControl(fstrmControlStart, true); err != nil {
+			file.Close()
+			return nil, err
+		}
+		return out, nil
+	}
+
+	conn, err := net.DialTimeout(d.config.Network, d.config.Address, dnstapHandshakeTimeout)
+	if err != nil {
+		return nil, err
+	}
+	out := &dnstapOutput{writer: bufio.NewWriter(conn), closer: conn, conn: conn}
+	if err := out.handshake(); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("handshake failed: %w", err)
+	}
+	return out, nil
+}
+
+// handshake negotiates the content type with a bidirectional receiver:
+// READY, ACCEPT, then START
+func (o *dnstapOutput) handshake() error {
+	if err := o.writeControl(fstrmControlReady, true); err != nil {
+		return err
+	}
+
+	o.conn.SetReadDeadline(time.Now().Add(dnstapHandshakeTimeout))
+	ctype, types, err := readFstrmControl(o.conn)
+	if err != nil {
+		return err
+	}
+	if ctype != fstrmControlAccept {
+		return fmt.Errorf("expected ACCEPT, got control frame %d", ctype)
+	}
+	if len(types) > 0 && !containsBytes(types, []byte(dnstapContentType)) {
+		return fmt.Errorf("receiver does not accept %s", dnstapContentType)
+	}
+
+	return o.writeControl(fstrmControlStart, true)
+}
+
+// writeFrame writes a data frame
+func (o *dnstapOutput) writeFrame(frame []byte) error {
+	o.setDeadline()
+	binary.Write(o.writer, binary.BigEndian, uint32(len(frame)))
+	_, err := o.writer.Write(frame)
+	return err
+}
+
+// writeControl writes and flushes a control frame
+func (o *dnstapOutput) writeControl(ctype uint32, withContentType bool) error {
+	payload := binary.BigEndian.AppendUint32(nil, ctype)
+	if withContentType {
+		payload = binary.BigEndian.AppendUint32(payload, fstrmFieldContentType)
+		payload = binary.BigEndian.AppendUint32(payload, uint32(len(dnstapContentType)))
+		payload = append(payload, dnstapContentType...)
+	}
+
+	o.setDeadline()
+	binary.Write(o.writer, binary.BigEndian, uint32(0)) // Escape
+	binary.Write(o.writer, binary.BigEndian, uint32(len(payload)))
+	o.writer.Write(payload)
+	return o.writer.Flush()
+}
+
+// flush writes buffered frames out
+func (o *dnstapOutput) flush() error {
+	o.setDeadline()
+	return o.writer.Flush()
+}
+
+// finish ends the stream with STOP, waiting for a socket receiver's FINISH
+func (o *dnstapOutput) finish() error {
+	if err := o.writeControl(fstrmControlStop, false); err != nil {
+		return err
+	}
+	if o.conn == nil {
+		return nil
+	}
+
+	o.conn.SetReadDeadline(time.Now().Add(dnstapHandshakeTimeout))
+	ctype, _, err := readFstrmControl(o.conn)
+	if err != nil {
+		return err
+	}
+	if ctype != fstrmControlFinish {
+		return fmt.Errorf("expected FINISH, got control frame %d", ctype)
+	}
+	return nil
+}
+
+// close closes the underlying connection or file
+func (o *dnstapOutput) close() {
+	o.closer.Close()
+}
+
+// setDeadline bounds the next socket write
+func (o *dnstapOutput) setDeadline() {
+	if o.conn != nil {
+		o.conn.SetWriteDeadline(time.Now().Add(dnstapWriteTimeout))
+	}
+}
+
+// readFstrmControl reads a control frame, returning its type and the
+// content types it lists
+func readFstrmControl(r io.Reader) (uint32, [][]byte, error) {
+	var header [8]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return 0, nil, err
+	}
+	if binary.BigEndian.Uint32(header[0:4]) != 0 {
+		return 0, nil, fmt.Errorf("expected a control frame")
+	}
+	length := binary.BigEndian.Uint32(header[4:8])
+	if length < 4 || length > fstrmMaxControlSize {
+		return 0, nil, fmt.Errorf("invalid control frame length %d", length)
+	}
+
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return 0, nil, err
+	}
+
+	ctype := binary.BigEndian.Uint32(payload[0:4])
+	var types [][]byte
+	for fields := payload[4:]; len(fields) > 0; {
+		if len(fields) < 8 {
+			return 0, nil, fmt.Errorf("truncated control field")
+		}
+		field := binary.BigEndian.Uint32(fields[0:4])
+		size := binary.BigEndian.Uint32(fields[4:8])
+		if uint32(len(fields)-8) < size {
+			return 0, nil, fmt.Errorf("truncated control field")
+		}
+		if field == fstrmFieldContentType {
+			types = append(types, fields[8:8+size])
+		}
+		fields = fields[8+size:]
+	}
+	return ctype, types, nil
+}
+
+// containsBytes reports whether list holds value
+func containsBytes(list [][]byte, value []byte) bool {
+	for _, item := range list {
+		if bytes.Equal(item, value) {
+			return true
+		}
+	}
+	return false
+}
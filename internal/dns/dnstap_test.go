@@ -0,0 +1,445 @@
+package dns
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"google.golang.org/protobuf/encoding/protowire"
+
+	"pihole-analyzer/internal/logger"
+)
+
+// testDnstapMessage holds the decoded fields of a dnstap frame
+type testDnstapMessage struct {
+	identity        string
+	version         string
+	kind            uint64
+	family          uint64
+	protocol        uint64
+	queryAddress    net.IP
+	responseAddress net.IP
+	queryPort       uint64
+	responsePort    uint64
+	queryTime       uint64
+	responseTime    uint64
+	queryMessage    []byte
+	responseMessage []byte
+}
+
+// decodeDnstapFrame decodes a Dnstap protobuf message
+func decodeDnstapFrame(t *testing.T, frame []byte) testDnstapMessage {
+	t.Helper()
+
+	var decoded testDnstapMessage
+	var message []byte
+	walk := func(data []byte, field func(num protowire.Number, typ protowire.Type, data []byte) int) {
+		for len(data) > 0 {
+			num, typ, n := protowire.ConsumeTag(data)
+			if n < 0 {
+				t.Fatalf("Invalid tag: %v", protowire.ParseError(n))
+			}
+			data = data[n:]
+			n = field(num, typ, data)
+			if n < 0 {
+				t.Fatalf("Invalid field %d: %v", num, protowire.ParseError(n))
+			}
+			data = data[n:]
+		}
+	}
+
+	walk(frame, func(num protowire.Number, typ protowire.Type, data []byte) int {
+		switch num {
+		case 1:
+			value, n := protowire.ConsumeBytes(data)
+			decoded.identity = string(value)
+			return n
+		case 2:
+			value, n := protowire.ConsumeBytes(data)
+			decoded.version = string(value)
+			return n
+		case 14:
+			value, n := protowire.ConsumeBytes(data)
+			message = value
+			return n
+		}
+		return protowire.ConsumeFieldValue(num, typ, data)
+	})
+
+	walk(message, func(num protowire.Number, typ protowire.Type, data []byte) int {
+		switch typ {
+		case protowire.VarintType:
+			value, n := protowire.ConsumeVarint(data)
+			switch num {
+			case 1:
+				decoded.kind = value
+			case 2:
+				decoded.family = value
+			case 3:
+				decoded.protocol = value
+			case 6:
+				decoded.queryPort = value
+			case 7:
+				decoded.responsePort = value
+			case 8:
+				decoded.queryTime = value
+			case 12:
+				decoded.responseTime = value
+			}
+			return n
+		case protowire.BytesType:
+			value, n := protowire.ConsumeBytes(data)
+			switch num {
+			case 4:
+				decoded.queryAddress = net.IP(value)
+			case 5:
+				decoded.responseAddress = net.IP(value)
+			case 10:
+				decoded.queryMessage = value
+			case 14:
+				decoded.responseMessage = value
+			}
+			return n
+		}
+		return protowire.ConsumeFieldValue(num, typ, data)
+	})
+
+	return decoded
+}
+
+// readFstrmStream reads data frames up to a STOP control frame
+func readFstrmStream(r io.Reader) ([][]byte, error) {
+	var frames [][]byte
+	for {
+		var length uint32
+		if err := binary.Read(r, binary.BigEndian, &length); err != nil {
+			return frames, err
+		}
+
+		if length == 0 {
+			var size uint32
+			binary.Read(r, binary.BigEndian, &size)
+			control := make([]byte, size)
+			if _, err := io.ReadFull(r, control); err != nil {
+				return frames, err
+			}
+			if binary.BigEndian.Uint32(control) == fstrmControlStop {
+				return frames, nil
+			}
+			continue
+		}
+
+		frame := make([]byte, length)
+		if _, err := io.ReadFull(r, frame); err != nil {
+			return frames, err
+		}
+		frames = append(frames, frame)
+	}
+}
+
+// writeFstrmControl writes a control frame listing the dnstap content type
+func writeFstrmControl(w io.Writer, ctype uint32) {
+	out := &dnstapOutput{writer: bufio.NewWriter(w)}
+	out.writeControl(ctype, true)
+}
+
+func testDnstapLogger() *logger.Logger {
+	return logger.New(&logger.Config{
+		Level:     logger.LogLevel("ERROR"),
+		Component: "dns-test",
+	})
+}
+
+func testDnstapConfig(network, address string) DnstapConfig {
+	config := DefaultConfig().Dnstap
+	config.Enabled = true
+	config.Network = network
+	config.Address = address
+	config.Identity = "resolver1"
+	return config
+}
+
+func TestDnstap_Socket(t *testing.T) {
+	socket := filepath.Join(t.TempDir(), "dnstap.sock")
+	listener, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	defer listener.Close()
+
+	// A bidirectional receiver: READY, ACCEPT, START, frames, STOP, FINISH
+	received := make(chan [][]byte, 1)
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		ctype, types, err := readFstrmControl(conn)
+		if err != nil || ctype != fstrmControlReady || !containsBytes(types, []byte(dnstapContentType)) {
+			t.Errorf("Expected READY with the dnstap content type, got %d %q %v", ctype, types, err)
+			return
+		}
+		writeFstrmControl(conn, fstrmControlAccept)
+		if ctype, _, err := readFstrmControl(conn); err != nil || ctype != fstrmControlStart {
+			t.Errorf("Expected START, got %d %v", ctype, err)
+			return
+		}
+
+		frames, err := readFstrmStream(conn)
+		if err != nil {
+			t.Errorf("Failed to read frames: %v", err)
+		}
+		writeFstrmControl(conn, fstrmControlFinish)
+		received <- frames
+	}()
+
+	tap := NewDnstap(testDnstapConfig(DnstapNetworkUnix, socket), testDnstapLogger())
+	tap.Start()
+
+	client := &net.UDPAddr{IP: net.ParseIP("192.168.1.50"), Port: 40000}
+	server := &net.UDPAddr{IP: net.ParseIP("192.168.1.2"), Port: 53}
+	upstream := &net.UDPAddr{IP: net.ParseIP("2001:db8::53"), Port: 53}
+	query := &DNSQuery{Client: client, Protocol: "udp"}
+	now := time.Unix(1700000000, 500)
+
+	tap.ClientQuery(query, server, []byte("query"), now)
+	tap.ForwarderQuery(upstream, "dot", []byte("upstream query"), now)
+	tap.ForwarderResponse(upstream, "dot", []byte("upstream response"), now, now.Add(time.Second))
+	tap.ClientResponse(query, server, []byte("response"), now, now.Add(time.Second))
+
+	deadline := time.Now().Add(2 * time.Second)
+	for !tap.GetStats().Connected && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if err := tap.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+
+	var frames [][]byte
+	select {
+	case frames = <-received:
+	case <-time.After(2 * time.Second):
+		t.Fatal("Receiver did not get a complete stream")
+	}
+	if len(frames) != 4 {
+		t.Fatalf("Expected 4 frames, got %d", len(frames))
+	}
+
+	clientQuery := decodeDnstapFrame(t, frames[0])
+	if clientQuery.identity != "resolver1" || clientQuery.version != "pihole-analyzer" {
+		t.Errorf("Unexpected identity %q and version %q", clientQuery.identity, clientQuery.version)
+	}
+	if clientQuery.kind != dnstapClientQuery || clientQuery.family != 1 || clientQuery.protocol != 1 {
+		t.Errorf("Unexpected client query header %+v", clientQuery)
+	}
+	if !clientQuery.queryAddress.Equal(client.IP) || clientQuery.queryPort != 40000 ||
+		!clientQuery.responseAddress.Equal(server.IP) || clientQuery.responsePort != 53 {
+		t.Errorf("Unexpected client query addresses %+v", clientQuery)
+	}
+	if string(clientQuery.queryMessage) != "query" || clientQuery.queryTime != 1700000000 {
+		t.Errorf("Unexpected client query message %+v", clientQuery)
+	}
+
+	forwarderResponse := decodeDnstapFrame(t, frames[2])
+	if forwarderResponse.kind != dnstapForwarderResponse || forwarderResponse.family != 2 || forwarderResponse.protocol != 3 {
+		t.Errorf("Unexpected forwarder response header %+v", forwarderResponse)
+	}
+	if !forwarderResponse.responseAddress.Equal(upstream.IP) || string(forwarderResponse.responseMessage) != "upstream response" ||
+		forwarderResponse.responseTime != 1700000001 {
+		t.Errorf("Unexpected forwarder response %+v", forwarderResponse)
+	}
+
+	if kind := decodeDnstapFrame(t, frames[3]).kind; kind != dnstapClientResponse {
+		t.Errorf("Expected a client response last, got type %d", kind)
+	}
+}
+
+func TestDnstap_File(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "dnstap.fstrm")
+	os.WriteFile(path, []byte("previous capture"), 0644)
+
+	config := testDnstapConfig(DnstapNetworkFile, path)
+	config.ClientMessages = false
+	tap := NewDnstap(config, testDnstapLogger())
+	tap.Start()
+
+	query := &DNSQuery{Client: &net.UDPAddr{IP: net.ParseIP("192.168.1.50"), Port: 40000}, Protocol: "udp"}
+	tap.ClientQuery(query, nil, []byte("query"), time.Now())
+	tap.ForwarderQuery(nil, "udp", []byte("upstream query"), time.Now())
+	tap.Close()
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("Failed to read dnstap file: %v", err)
+	}
+
+	// A unidirectional stream: START with the content type, frames, STOP
+	reader := bytes.NewReader(data)
+	ctype, types, err := readFstrmControl(reader)
+	if err != nil || ctype != fstrmControlStart || !containsBytes(types, []byte(dnstapContentType)) {
+		t.Fatalf("Expected START with the dnstap content type, got %d %q %v", ctype, types, err)
+	}
+	frames, err := readFstrmStream(reader)
+	if err != nil {
+		t.Fatalf("Failed to read frames: %v", err)
+	}
+
+	// Client messages are disabled
+	if len(frames) != 1 {
+		t.Fatalf("Expected 1 frame, got %d", len(frames))
+	}
+	if message := decodeDnstapFrame(t, frames[0]); message.kind != dnstapForwarderQuery || string(message.queryMessage) != "upstream query" {
+		t.Errorf("Unexpected frame %+v", message)
+	}
+}
+
+func TestDnstap_DropsWhenFull(t *testing.T) {
+	config := testDnstapConfig(DnstapNetworkUnix, filepath.Join(t.TempDir(), "missing.sock"))
+	config.BufferSize = 2
+	config.ReconnectInterval = time.Hour
+	tap := NewDnstap(config, testDnstapLogger())
+	tap.Start()
+
+	// Without a receiver nothing is consumed, and logging must not block
+	done := make(chan struct{})
+	go func() {
+		for i := 0; i < 5; i++ {
+			tap.ForwarderQuery(nil, "udp", []byte("query"), time.Now())
+		}
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Logging blocked with the buffer full")
+	}
+
+	stats := tap.GetStats()
+	if stats.Frames != 2 || stats.Dropped != 3 || stats.Connected {
+		t.Errorf("Unexpected stats %+v", stats)
+	}
+
+	closed := make(chan struct{})
+	go func() {
+		tap.Close()
+		close(closed)
+	}()
+	select {
+	case <-closed:
+	case <-time.After(time.Second):
+		t.Fatal("Close blocked while waiting to reconnect")
+	}
+}
+
+func TestDnstapConfig_Validate(t *testing.T) {
+	valid := testDnstapConfig(DnstapNetworkTCP, "127.0.0.1:6000")
+	if err := valid.validate(); err != nil {
+		t.Fatalf("Expected valid configuration, got %v", err)
+	}
+
+	for name, mutate := range map[string]func(*DnstapConfig){
+		"network":   func(c *DnstapConfig) { c.Network = "udp" },
+		"address":   func(c *DnstapConfig) { c.Address = "" },
+		"buffer":    func(c *DnstapConfig) { c.BufferSize = 0 },
+		"reconnect": func(c *DnstapConfig) { c.ReconnectInterval = 0 },
+	} {
+		config := valid
+		mutate(&config)
+		if err := config.validate(); !errors.Is(err, ErrInvalidDnstap) {
+			t.Errorf("%s: expected ErrInvalidDnstap, got %v", name, err)
+		}
+	}
+}
+
+func TestServer_Dnstap(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "dnstap.fstrm")
+
+	config := DefaultConfig()
+	config.Host = "127.0.0.1"
+	config.Port = freeTestPort(t)
+	config.TCPEnabled = false
+	config.LogQueries = false
+	config.Forwarder.HealthCheck = false
+	config.Dnstap = testDnstapConfig(DnstapNetworkFile, path)
+
+	server := NewServer(config, testDnstapLogger()).(*Server)
+	forwarder := newFakeForwarder("round_robin", map[string]*fakeTransport{
+		"upstream:53": {},
+	})
+	forwarder.SetDnstap(server.Dnstap())
+	server.forwarder = forwarder
+
+	go server.Start(context.Background())
+	deadline := time.Now().Add(2 * time.Second)
+	for !server.running.Load() {
+		if time.Now().After(deadline) {
+			t.Fatal("Server did not start")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	conn, err := net.Dial("udp", server.udpConn.LocalAddr().String())
+	if err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	defer conn.Close()
+
+	parser := NewParser()
+	query, _ := parser.SerializeQuery(&DNSQuery{
+		ID:       42,
+		Question: DNSQuestion{Name: "example.com", Type: TypeA, Class: ClassIN},
+	})
+	conn.Write(query)
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	if _, err := conn.Read(make([]byte, 512)); err != nil {
+		t.Fatalf("No response: %v", err)
+	}
+
+	if err := server.Stop(context.Background()); err != nil {
+		t.Fatalf("Stop failed: %v", err)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("Failed to read dnstap file: %v", err)
+	}
+	reader := bytes.NewReader(data)
+	readFstrmControl(reader)
+	frames, err := readFstrmStream(reader)
+	if err != nil {
+		t.Fatalf("Failed to read frames: %v", err)
+	}
+
+	var kinds []uint64
+	for _, frame := range frames {
+		kinds = append(kinds, decodeDnstapFrame(t, frame).kind)
+	}
+	expected := []uint64{dnstapClientQuery, dnstapForwarderQuery, dnstapForwarderResponse, dnstapClientResponse}
+	if len(kinds) != len(expected) {
+		t.Fatalf("Expected message types %v, got %v", expected, kinds)
+	}
+	for i := range expected {
+		if kinds[i] != expected[i] {
+			t.Fatalf("Expected message types %v, got %v", expected, kinds)
+		}
+	}
+
+	clientQuery := decodeDnstapFrame(t, frames[0])
+	if !bytes.Equal(clientQuery.queryMessage, query) || clientQuery.protocol != 1 {
+		t.Errorf("Expected the client's query as sent, got %+v", clientQuery)
+	}
+	response, err := parser.ParseResponse(decodeDnstapFrame(t, frames[3]).responseMessage)
+	if err != nil || response.ID != 42 {
+		t.Errorf("Expected the response sent to the client, got %+v, %v", response, err)
+	}
+}
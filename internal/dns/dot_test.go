@@ -0,0 +1,190 @@
+package dns
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/binary"
+	"encoding/pem"
+	"errors"
+	"io"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"pihole-analyzer/internal/logger"
+)
+
+// writeTestCertificate writes a self-signed certificate for 127.0.0.1 and
+// returns the certificate and key paths
+func writeTestCertificate(t *testing.T) (string, string) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "dns-test"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("Failed to create certificate: %v", err)
+	}
+
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatalf("Failed to marshal key: %v", err)
+	}
+
+	dir := t.TempDir()
+	certFile := filepath.Join(dir, "cert.pem")
+	keyFile := filepath.Join(dir, "key.pem")
+
+	if err := os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600); err != nil {
+		t.Fatalf("Failed to write certificate: %v", err)
+	}
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600); err != nil {
+		t.Fatalf("Failed to write key: %v", err)
+	}
+
+	return certFile, keyFile
+}
+
+// exchangeStream sends a length-prefixed query and reads the response
+func exchangeStream(t *testing.T, conn net.Conn, query *DNSQuery) *DNSResponse {
+	t.Helper()
+
+	parser := NewParser()
+	data, err := parser.SerializeQuery(query)
+	if err != nil {
+		t.Fatalf("Failed to serialize query: %v", err)
+	}
+
+	prefix := make([]byte, 2)
+	binary.BigEndian.PutUint16(prefix, uint16(len(data)))
+	if _, err := conn.Write(append(prefix, data...)); err != nil {
+		t.Fatalf("Failed to send query: %v", err)
+	}
+
+	if _, err := io.ReadFull(conn, prefix); err != nil {
+		t.Fatalf("Failed to read response length: %v", err)
+	}
+	buf := make([]byte, binary.BigEndian.Uint16(prefix))
+	if _, err := io.ReadFull(conn, buf); err != nil {
+		t.Fatalf("Failed to read response: %v", err)
+	}
+
+	response, err := parser.ParseResponse(buf)
+	if err != nil {
+		t.Fatalf("Failed to parse response: %v", err)
+	}
+	return response
+}
+
+func TestConfig_ValidateTLS(t *testing.T) {
+	config := DefaultConfig()
+	config.TLS.Enabled = true
+
+	if err := config.Validate(); !errors.Is(err, ErrMissingTLSCertificate) {
+		t.Errorf("Expected ErrMissingTLSCertificate, got %v", err)
+	}
+
+	config.TLS.CertFile = "cert.pem"
+	config.TLS.KeyFile = "key.pem"
+	config.TLS.Port = 70000
+	if err := config.Validate(); !errors.Is(err, ErrInvalidTLSPort) {
+		t.Errorf("Expected ErrInvalidTLSPort, got %v", err)
+	}
+
+	// DoT alone is a valid protocol selection
+	config.TLS.Port = 853
+	config.UDPEnabled = false
+	config.TCPEnabled = false
+	if err := config.Validate(); err != nil {
+		t.Errorf("Expected DoT-only config to be valid, got %v", err)
+	}
+}
+
+func TestServer_DoTListener(t *testing.T) {
+	certFile, keyFile := writeTestCertificate(t)
+	list := writeTestList(t, "list.txt", "blocked.example.com\n")
+
+	config := DefaultConfig()
+	config.Host = "127.0.0.1"
+	config.LogQueries = false
+	config.Forwarder.HealthCheck = false
+	config.Blocklist.Sources = []string{list}
+	config.TLS = TLSConfig{
+		Enabled:  true,
+		Port:     0, // Ephemeral port for testing
+		CertFile: certFile,
+		KeyFile:  keyFile,
+	}
+	config.ReadTimeout = 5 * time.Second
+	config.IdleTimeout = 5 * time.Second
+
+	testLogger := logger.New(&logger.Config{
+		Level:     logger.LogLevel("ERROR"),
+		Component: "dns-test",
+	})
+
+	server := NewServer(config, testLogger).(*Server)
+	if err := server.blocklist.Load(); err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+
+	server.running.Store(true)
+	if err := server.startDoTServer(); err != nil {
+		t.Fatalf("Failed to start DoT server: %v", err)
+	}
+	addr := server.dotListener.Addr().String()
+	defer func() {
+		server.running.Store(false)
+		server.stopDoTServer()
+		server.wg.Wait()
+	}()
+
+	conn, err := tls.Dial("tcp", addr, &tls.Config{InsecureSkipVerify: true})
+	if err != nil {
+		t.Fatalf("Failed to connect to DoT server: %v", err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	// Two queries over the same connection exercise connection reuse
+	for id := uint16(1); id <= 2; id++ {
+		response := exchangeStream(t, conn, &DNSQuery{
+			ID:       id,
+			Question: DNSQuestion{Name: "blocked.example.com", Type: TypeA, Class: ClassIN},
+		})
+		if response.ID != id {
+			t.Errorf("Expected response ID %d, got %d", id, response.ID)
+		}
+		if len(response.Answers) != 1 {
+			t.Errorf("Expected 1 answer, got %d", len(response.Answers))
+		}
+	}
+
+	stats := server.GetStats()
+	if stats.DoTQueries != 2 {
+		t.Errorf("Expected 2 DoT queries, got %d", stats.DoTQueries)
+	}
+	if stats.TCPQueries != 0 || stats.UDPQueries != 0 {
+		t.Errorf("Expected no UDP/TCP queries, got %d/%d", stats.UDPQueries, stats.TCPQueries)
+	}
+}
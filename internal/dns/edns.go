@@ -0,0 +1,152 @@
+package dns
+
+import (
+	"encoding/binary"
+	"net"
+)
+
+// EDNS option codes
+const (
+	EDNSOptionClientSubnet uint16 = 8  // RFC 7871
+	EDNSOptionCookie       uint16 = 10 // RFC 7873
+	EDNSOptionPadding      uint16 = 12 // RFC 7830
+)
+
+// ednsFlagDO is the DNSSEC OK bit in the OPT record TTL field (RFC 3225)
+const ednsFlagDO uint32 = 1 << 15
+
+// ednsVersion is the highest EDNS version understood
+const ednsVersion uint8 = 0
+
+// Option returns the first option with the given code
+func (e *EDNS) Option(code uint16) (EDNSOption, bool) {
+	if e == nil {
+		return EDNSOption{}, false
+	}
+	for _, option := range e.Options {
+		if option.Code == code {
+			return option, true
+		}
+	}
+	return EDNSOption{}, false
+}
+
+// ClientSubnet is a decoded EDNS Client Subnet option (RFC 7871)
+type ClientSubnet struct {
+	Family       uint16 // 1 for IPv4, 2 for IPv6
+	SourcePrefix uint8
+	ScopePrefix  uint8
+	Address      net.IP
+}
+
+// parseClientSubnet decodes the payload of an EDNS Client Subnet option
+func parseClientSubnet(data []byte) (*ClientSubnet, error) {
+	if len(data) < 4 {
+		return nil, ErrInvalidRecord
+	}
+
+	subnet := &ClientSubnet{
+		Family:       binary.BigEndian.Uint16(data[0:2]),
+		SourcePrefix: data[2],
+		ScopePrefix:  data[3],
+	}
+
+	var size int
+	switch subnet.Family {
+	case 1:
+		size = net.IPv4len
+	case 2:
+		size = net.IPv6len
+	default:
+		return nil, ErrInvalidRecord
+	}
+
+	// Only the significant octets of the address are sent
+	address := data[4:]
+	if len(address) > size || len(address) != (int(subnet.SourcePrefix)+7)/8 {
+		return nil, ErrInvalidRecord
+	}
+	subnet.Address = make(net.IP, size)
+	copy(subnet.Address, address)
+
+	return subnet, nil
+}
+
+// subnetSpecific reports whether an answer is scoped to the client subnet
+// and must not be shared with other clients (RFC 7871 section 7.3)
+func subnetSpecific(response *DNSResponse) bool {
+	option, ok := response.EDNS.Option(EDNSOptionClientSubnet)
+	if !ok {
+		return false
+	}
+	subnet, err := parseClientSubnet(option.Data)
+	return err != nil || subnet.ScopePrefix > 0
+}
+
+// optRecord encodes EDNS parameters as an OPT pseudo-record (RFC 6891).
+// The upper eight bits of an extended response code travel in the TTL.
+func optRecord(edns *EDNS, rcode uint8) DNSRecord {
+	ttl := uint32(rcode>>4)<<24 | uint32(edns.Version)<<16
+	if edns.DNSSECOK {
+		ttl |= ednsFlagDO
+	}
+
+	var data []byte
+	for _, option := range edns.Options {
+		data = binary.BigEndian.AppendUint16(data, option.Code)
+		data = binary.BigEndian.AppendUint16(data, uint16(len(option.Data)))
+		data = append(data, option.Data...)
+	}
+
+	return DNSRecord{
+		Name:  ".",
+		Type:  TypeOPT,
+		Class: edns.UDPSize,
+		TTL:   ttl,
+		Data:  data,
+	}
+}
+
+// parseOPTRecord decodes an OPT pseudo-record, returning its EDNS parameters
+// and the upper bits of the extended response code
+func parseOPTRecord(record *DNSRecord) (*EDNS, uint8, error) {
+	if record.Name != "" && record.Name != "." {
+		return nil, 0, ErrInvalidRecord
+	}
+
+	edns := &EDNS{
+		UDPSize:  record.Class,
+		Version:  uint8(record.TTL >> 16),
+		DNSSECOK: record.TTL&ednsFlagDO != 0,
+	}
+
+	data := record.Data
+	for len(data) > 0 {
+		if len(data) < 4 {
+			return nil, 0, ErrInvalidRecord
+		}
+		code := binary.BigEndian.Uint16(data[0:2])
+		length := int(binary.BigEndian.Uint16(data[2:4]))
+		if 4+length > len(data) {
+			return nil, 0, ErrInvalidRecord
+		}
+
+		option := EDNSOption{Code: code, Data: make([]byte, length)}
+		copy(option.Data, data[4:4+length])
+		edns.Options = append(edns.Options, option)
+		data = data[4+length:]
+	}
+
+	return edns, uint8(record.TTL >> 24), nil
+}
+
+// ednsPayloadSize clamps a configured UDP payload size to the valid range
+func ednsPayloadSize(size int) uint16 {
+	if size < minUDPPayloadSize {
+		return minUDPPayloadSize
+	}
+	if size > maxUDPMessageSize {
+		return maxUDPMessageSize
+	}
+	return uint16(size)
+}
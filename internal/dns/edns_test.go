@@ -0,0 +1,159 @@
+package dns
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"pihole-analyzer/internal/logger"
+)
+
+func TestForwarder_UpstreamQueryEDNS(t *testing.T) {
+	subnet := EDNSOption{Code: EDNSOptionClientSubnet, Data: []byte{0, 1, 24, 0, 192, 0, 2}}
+	cookie := EDNSOption{Code: EDNSOptionCookie, Data: []byte{1, 2, 3, 4, 5, 6, 7, 8}}
+
+	query := testForwardQuery()
+	query.EDNS = &EDNS{UDPSize: 1232, DNSSECOK: true, Options: []EDNSOption{subnet, cookie}}
+
+	tests := []struct {
+		name          string
+		enabled       bool
+		forwardSubnet bool
+		wantSubnet    bool
+	}{
+		{"disabled", false, false, false},
+		{"strip subnet", true, false, false},
+		{"forward subnet", true, true, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			forwarder := NewForwarder(ForwarderConfig{
+				EDNS0Enabled:        tt.enabled,
+				UDPSize:             4096,
+				ForwardClientSubnet: tt.forwardSubnet,
+			}).(*Forwarder)
+
+			upstream := forwarder.upstreamQuery(query)
+			if !tt.enabled {
+				if upstream.EDNS != nil {
+					t.Errorf("Expected no OPT record, got %+v", upstream.EDNS)
+				}
+				return
+			}
+
+			if upstream.EDNS.UDPSize != 4096 {
+				t.Errorf("Expected our payload size 4096, got %d", upstream.EDNS.UDPSize)
+			}
+			if !upstream.EDNS.DNSSECOK {
+				t.Error("Expected the DO bit to be preserved")
+			}
+			if _, ok := upstream.EDNS.Option(EDNSOptionCookie); ok {
+				t.Error("Expected the client cookie not to be forwarded")
+			}
+			if _, ok := upstream.EDNS.Option(EDNSOptionClientSubnet); ok != tt.wantSubnet {
+				t.Errorf("Expected Client Subnet forwarded=%v", tt.wantSubnet)
+			}
+		})
+	}
+
+	// The client query itself is left untouched
+	if len(query.EDNS.Options) != 2 {
+		t.Errorf("Expected client options to be kept, got %d", len(query.EDNS.Options))
+	}
+}
+
+func TestSubnetSpecific(t *testing.T) {
+	response := func(scope byte) *DNSResponse {
+		return &DNSResponse{EDNS: &EDNS{Options: []EDNSOption{
+			{Code: EDNSOptionClientSubnet, Data: []byte{0, 1, 24, scope, 192, 0, 2}},
+		}}}
+	}
+
+	if subnetSpecific(&DNSResponse{}) {
+		t.Error("Expected plain response to be shareable")
+	}
+	if subnetSpecific(response(0)) {
+		t.Error("Expected scope /0 answer to be shareable")
+	}
+	if !subnetSpecific(response(24)) {
+		t.Error("Expected scope /24 answer to be subnet specific")
+	}
+}
+
+func TestServer_EDNSNegotiation(t *testing.T) {
+	list := writeTestList(t, "list.txt", "blocked.example.com\n")
+
+	config := DefaultConfig()
+	config.LogQueries = false
+	config.Forwarder.HealthCheck = false
+	config.Forwarder.UDPSize = 1232
+	config.Blocklist.Sources = []string{list}
+
+	testLogger := logger.New(&logger.Config{
+		Level:     logger.LogLevel("ERROR"),
+		Component: "dns-test",
+	})
+
+	server := NewServer(config, testLogger).(*Server)
+	if err := server.blocklist.Load(); err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+
+	query := func(edns *EDNS) *DNSQuery {
+		return &DNSQuery{
+			ID:       7,
+			Question: DNSQuestion{Name: "blocked.example.com", Type: TypeA, Class: ClassIN},
+			Client:   &net.UDPAddr{IP: net.ParseIP("192.168.1.10"), Port: 5000},
+			Protocol: "udp",
+			EDNS:     edns,
+		}
+	}
+
+	// Payload size is the smaller of the client's and ours, at least 512
+	limits := []struct {
+		edns *EDNS
+		want int
+	}{
+		{nil, 512},
+		{&EDNS{UDPSize: 256}, 512},
+		{&EDNS{UDPSize: 1000}, 1000},
+		{&EDNS{UDPSize: 4096}, 1232},
+	}
+	for _, tt := range limits {
+		if got := server.udpPayloadLimit(query(tt.edns)); got != tt.want {
+			t.Errorf("udpPayloadLimit(%+v) = %d, want %d", tt.edns, got, tt.want)
+		}
+	}
+
+	// EDNS clients get an OPT record with the DO bit echoed
+	response, err := server.HandleQuery(context.Background(), query(&EDNS{UDPSize: 4096, DNSSECOK: true}))
+	if err != nil {
+		t.Fatalf("HandleQuery failed: %v", err)
+	}
+	if response.EDNS == nil || response.EDNS.UDPSize != 1232 || !response.EDNS.DNSSECOK {
+		t.Errorf("Unexpected response EDNS %+v", response.EDNS)
+	}
+
+	// Plain clients get a plain answer
+	response, _ = server.HandleQuery(context.Background(), query(nil))
+	if response.EDNS != nil {
+		t.Errorf("Expected no OPT record for a plain query, got %+v", response.EDNS)
+	}
+
+	// Unknown EDNS versions are refused with BADVERS
+	response, _ = server.HandleQuery(context.Background(), query(&EDNS{UDPSize: 4096, Version: 1}))
+	if response.ResponseCode != RCodeBadVers || response.EDNS == nil {
+		t.Errorf("Expected BADVERS with OPT, got rcode %d", response.ResponseCode)
+	}
+
+	// With EDNS disabled the OPT record is ignored
+	server.config.Forwarder.EDNS0Enabled = false
+	response, _ = server.HandleQuery(context.Background(), query(&EDNS{UDPSize: 4096, Version: 1}))
+	if response.ResponseCode != RCodeNoError || response.EDNS != nil {
+		t.Errorf("Expected plain NOERROR with EDNS disabled, got rcode %d, EDNS %+v", response.ResponseCode, response.EDNS)
+	}
+	if got := server.udpPayloadLimit(query(&EDNS{UDPSize: 4096})); got != 512 {
+		t.Errorf("Expected 512 byte limit with EDNS disabled, got %d", got)
+	}
+}
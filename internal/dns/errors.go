@@ -4,32 +4,66 @@ import "errors"
 
 // DNS Server errors
 var (
-	ErrInvalidPort          = errors.New("invalid DNS server port")
-	ErrNoProtocolEnabled    = errors.New("no protocol enabled (UDP or TCP)")
-	ErrInvalidCacheSize     = errors.New("invalid cache size")
-	ErrInvalidConcurrency   = errors.New("invalid max concurrent queries")
-	ErrServerNotStarted     = errors.New("DNS server not started")
-	ErrServerAlreadyRunning = errors.New("DNS server already running")
-	ErrInvalidQuery         = errors.New("invalid DNS query")
-	ErrQueryTimeout         = errors.New("DNS query timeout")
-	ErrNoUpstreamServers    = errors.New("no upstream DNS servers configured")
-	ErrCacheFull            = errors.New("DNS cache is full")
-	ErrInvalidDNSMessage    = errors.New("invalid DNS message format")
-	ErrUnsupportedQType     = errors.New("unsupported DNS query type")
-	ErrServerShutdown       = errors.New("DNS server is shutting down")
+	ErrInvalidPort           = errors.New("invalid DNS server port")
+	ErrNoProtocolEnabled     = errors.New("no protocol enabled (UDP, TCP or TLS)")
+	ErrInvalidTLSPort        = errors.New("invalid DNS-over-TLS port")
+	ErrMissingTLSCertificate = errors.New("DNS-over-TLS requires a certificate and key file")
+	ErrInvalidCacheSize      = errors.New("invalid cache size")
+	ErrInvalidCacheMemory    = errors.New("invalid cache memory limit")
+	ErrInvalidEvictionPolicy = errors.New("invalid cache eviction policy")
+	ErrInvalidCacheTiming    = errors.New("invalid cache stale, prefetch or negative TTL setting")
+	ErrInvalidConcurrency    = errors.New("invalid max concurrent queries")
+	ErrServerNotStarted      = errors.New("DNS server not started")
+	ErrServerAlreadyRunning  = errors.New("DNS server already running")
+	ErrInvalidQuery          = errors.New("invalid DNS query")
+	ErrQueryTimeout          = errors.New("DNS query timeout")
+	ErrNoUpstreamServers     = errors.New("no upstream DNS servers configured")
+	ErrInvalidUpstream       = errors.New("invalid upstream DNS server")
+	ErrInvalidLoadBalancing  = errors.New("invalid load balancing strategy")
+	ErrInvalidUDPSize        = errors.New("invalid EDNS UDP payload size")
+	ErrCacheFull             = errors.New("DNS cache is full")
+	ErrInvalidDNSMessage     = errors.New("invalid DNS message format")
+	ErrUnsupportedQType      = errors.New("unsupported DNS query type")
+	ErrServerShutdown        = errors.New("DNS server is shutting down")
+	ErrInvalidBlockedTTL     = errors.New("invalid blocked response TTL")
+	ErrInvalidRule           = errors.New("invalid domain rule")
+	ErrUnknownGroup          = errors.New("unknown client group")
+	ErrInvalidClient         = errors.New("invalid client identifier")
+	ErrInvalidLocalRecord    = errors.New("invalid local DNS record")
+	ErrInvalidConditional    = errors.New("invalid conditional forwarding rule")
+	ErrInvalidTrustAnchor    = errors.New("invalid DNSSEC trust anchor")
+	ErrDNSSECRequiresEDNS    = errors.New("DNSSEC validation requires EDNS0")
+	ErrInvalidQueryLogSize   = errors.New("invalid query log size")
+	ErrInvalidRateLimit      = errors.New("invalid rate limit setting")
+	ErrRateLimited           = errors.New("query dropped by rate limit")
+	ErrNoLeaseDomain         = errors.New("DHCP lease records require a domain name")
+	ErrInvalidDnstap         = errors.New("invalid dnstap setting")
+	ErrInvalidRecursion      = errors.New("invalid recursion setting")
+	ErrRecursionFailed       = errors.New("iterative resolution failed")
+	ErrInvalidSinkhole       = errors.New("invalid sinkhole setting")
+	ErrInvalidRewrite        = errors.New("invalid rewrite rule")
+	ErrInvalidSchedule       = errors.New("invalid schedule")
+	ErrUnknownSchedule       = errors.New("unknown schedule")
 )
 
 // DNS Protocol errors
 var (
-	ErrShortMessage     = errors.New("DNS message too short")
-	ErrInvalidHeader    = errors.New("invalid DNS header")
-	ErrInvalidQuestion  = errors.New("invalid DNS question")
-	ErrInvalidRecord    = errors.New("invalid DNS record")
-	ErrTruncatedMessage = errors.New("DNS message truncated")
-	ErrInvalidName      = errors.New("invalid DNS name")
-	ErrNameTooLong      = errors.New("DNS name too long")
-	ErrInvalidLabel     = errors.New("invalid DNS label")
-	ErrCompressionLoop  = errors.New("DNS name compression loop detected")
+	ErrShortMessage      = errors.New("DNS message too short")
+	ErrInvalidHeader     = errors.New("invalid DNS header")
+	ErrInvalidQuestion   = errors.New("invalid DNS question")
+	ErrInvalidRecord     = errors.New("invalid DNS record")
+	ErrTruncatedMessage  = errors.New("DNS message truncated")
+	ErrInvalidName       = errors.New("invalid DNS name")
+	ErrNameTooLong       = errors.New("DNS name too long")
+	ErrInvalidLabel      = errors.New("invalid DNS label")
+	ErrCompressionLoop   = errors.New("DNS name compression loop detected")
+	ErrUnsupportedRRType = errors.New("unsupported resource record type")
+)
+
+// DNSSEC errors
+var (
+	ErrDNSSECBogus       = errors.New("DNSSEC validation failed")
+	ErrDNSSECUnsupported = errors.New("unsupported DNSSEC algorithm")
 )
 
 // Cache errors
@@ -31,6 +31,37 @@ func (f *Factory) CreateForwarder(config *ForwarderConfig) (DNSForwarder, error)
 	return NewForwarder(*config), nil
 }
 
+// CreateBlocklist creates a DNS blocklist instance
+func (f *Factory) CreateBlocklist(config *BlocklistConfig) (DNSBlocklist, error) {
+	return NewBlocklist(*config), nil
+}
+
+// CreateRuleEngine creates a DNS rule engine loaded with the given rules
+func (f *Factory) CreateRuleEngine(config *RulesConfig) (DNSRuleEngine, error) {
+	engine := NewRuleEngine(NewARPTableResolver(arpRefreshInterval))
+	if err := engine.Load(*config); err != nil {
+		return nil, err
+	}
+	return engine, nil
+}
+
+// CreateLocalRecords creates a local record store loaded from config
+func (f *Factory) CreateLocalRecords(config *LocalRecordsConfig) (DNSLocalRecords, error) {
+	records := NewLocalRecords(*config)
+	if err := records.Load(); err != nil {
+		return nil, err
+	}
+	return records, nil
+}
+
+// CreateQueryLog creates a query log holding up to config.Size records
+func (f *Factory) CreateQueryLog(config *QueryLogConfig) (DNSQueryLog, error) {
+	if config.Size < 1 {
+		return nil, ErrInvalidQueryLogSize
+	}
+	return NewQueryLog(config.Size), nil
+}
+
 // CreateParser creates a DNS parser instance
 func (f *Factory) CreateParser() DNSParser {
 	return NewParser()
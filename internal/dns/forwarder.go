@@ -4,8 +4,8 @@ import (
 	"context"
 	"fmt"
 	"math/rand"
-	"net"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -17,6 +17,24 @@ type Forwarder struct {
 	parser    DNSParser
 	lastUsed  int
 	healthMap map[string]bool
+
+	// Transport per upstream (plain UDP, DoT or DoH)
+	transports map[string]upstreamTransport
+
+	// Latency and failure tracking per upstream
+	upstreamStates map[string]*upstreamState
+
+	// Conditional forwarding routes, in rule order and by zone; guarded
+	// by mu as Reload replaces them
+	routes      []*conditionalRoute
+	conditional map[string]*conditionalRoute
+
+	// dnstap output for upstream messages, nil unless enabled
+	tap atomic.Pointer[Dnstap]
+
+	// Closed by Close to stop the health checker
+	done      chan struct{}
+	closeOnce sync.Once
 }
 
 // NewForwarder creates a new DNS forwarder
@@ -26,12 +44,17 @@ func NewForwarder(config ForwarderConfig) DNSForwarder {
 		config:    config,
 		parser:    NewParser(),
 		healthMap: make(map[string]bool),
+
+		upstreamStates: make(map[string]*upstreamState),
+		done:           make(chan struct{}),
 	}
 
 	// Initialize all upstreams as healthy
 	for _, upstream := range config.Upstreams {
 		f.healthMap[upstream] = true
 	}
+	f.transports = f.createTransports(config.Upstreams)
+	f.routes, f.conditional = newConditionalRoutes(config)
 
 	// Start health checker if enabled
 	if config.HealthCheck {
@@ -41,14 +64,19 @@ func NewForwarder(config ForwarderConfig) DNSForwarder {
 	return f
 }
 
-// Forward sends a query to upstream DNS servers
+// Forward sends a query to upstream DNS servers. Queries below a
+// conditional forwarding zone go to that zone's upstreams.
 func (f *Forwarder) Forward(ctx context.Context, query *DNSQuery) (*DNSResponse, error) {
+	if route := f.conditionalFor(query.Question.Name); route != nil {
+		return route.forwarder.Forward(ctx, query)
+	}
+
 	if !f.config.Enabled {
 		return nil, ErrNoUpstreamServers
 	}
 
 	// Serialize the query
-	queryData, err := f.parser.SerializeQuery(query)
+	queryData, err := f.parser.SerializeQuery(f.upstreamQuery(query))
 	if err != nil {
 		return nil, fmt.Errorf("failed to serialize query: %w", err)
 	}
@@ -59,9 +87,26 @@ func (f *Forwarder) Forward(ctx context.Context, query *DNSQuery) (*DNSResponse,
 		return nil, ErrNoUpstreamServers
 	}
 
+	if f.config.LoadBalancing == "parallel" {
+		return f.forwardParallel(ctx, upstreams, queryData)
+	}
+
 	var lastErr error
+	tried := make(map[string]bool)
 	for attempt := 0; attempt < f.config.Retries+1; attempt++ {
-		upstream := f.selectUpstream(upstreams)
+		// Prefer upstreams that have not failed this query yet
+		candidates := make([]string, 0, len(upstreams))
+		for _, upstream := range upstreams {
+			if !tried[upstream] {
+				candidates = append(candidates, upstream)
+			}
+		}
+		if len(candidates) == 0 {
+			candidates = upstreams
+		}
+
+		upstream := f.selectUpstream(candidates)
+		tried[upstream] = true
 
 		response, err := f.queryUpstream(ctx, upstream, queryData)
 		if err != nil {
@@ -75,6 +120,92 @@ func (f *Forwarder) Forward(ctx context.Context, query *DNSQuery) (*DNSResponse,
 	return nil, fmt.Errorf("all upstream queries failed: %w", lastErr)
 }
 
+// SetDnstap logs the queries sent to upstreams and their responses,
+// including those of conditional forwarding routes, to tap
+func (f *Forwarder) SetDnstap(tap *Dnstap) {
+	f.tap.Store(tap)
+
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	for _, route := range f.routes {
+		route.forwarder.SetDnstap(tap)
+	}
+}
+
+// upstreamQuery returns a copy of a client query carrying the forwarder's
+// own EDNS parameters. The client's DO bit is preserved; the Client Subnet
+// option is forwarded only if configured, other options are hop-by-hop.
+func (f *Forwarder) upstreamQuery(query *DNSQuery) *DNSQuery {
+	upstream := *query
+	upstream.EDNS = nil
+	if !f.config.EDNS0Enabled {
+		return &upstream
+	}
+
+	upstream.EDNS = &EDNS{UDPSize: ednsPayloadSize(f.config.UDPSize)}
+	if query.EDNS != nil {
+		upstream.EDNS.DNSSECOK = query.EDNS.DNSSECOK
+		if f.config.ForwardClientSubnet {
+			if option, ok := query.EDNS.Option(EDNSOptionClientSubnet); ok {
+				upstream.EDNS.Options = append(upstream.EDNS.Options, option)
+			}
+		}
+	}
+	return &upstream
+}
+
+// forwardParallel races the best-ranked upstreams and returns the first
+// valid answer. SERVFAIL and REFUSED answers only win if nothing better
+// arrives.
+func (f *Forwarder) forwardParallel(ctx context.Context, upstreams []string, queryData []byte) (*DNSResponse, error) {
+	ranked := f.rankUpstreams(upstreams)
+
+	n := f.config.ParallelUpstreams
+	if n < 1 {
+		n = 2
+	}
+	if n > len(ranked) {
+		n = len(ranked)
+	}
+
+	raceCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	type result struct {
+		response *DNSResponse
+		err      error
+	}
+	results := make(chan result, n)
+
+	for _, upstream := range ranked[:n] {
+		go func(upstream string) {
+			response, err := f.queryUpstream(raceCtx, upstream, queryData)
+			results <- result{response, err}
+		}(upstream)
+	}
+
+	var fallback *DNSResponse
+	var lastErr error
+	for i := 0; i < n; i++ {
+		r := <-results
+		switch {
+		case r.err != nil:
+			lastErr = r.err
+		case r.response.ResponseCode == RCodeServFail || r.response.ResponseCode == RCodeRefused:
+			if fallback == nil {
+				fallback = r.response
+			}
+		default:
+			return r.response, nil
+		}
+	}
+
+	if fallback != nil {
+		return fallback, nil
+	}
+	return nil, fmt.Errorf("all upstream queries failed: %w", lastErr)
+}
+
 // GetUpstreams returns the list of upstream servers
 func (f *Forwarder) GetUpstreams() []string {
 	f.mu.RLock()
@@ -97,6 +228,37 @@ func (f *Forwarder) SetUpstreams(upstreams []string) {
 	for _, upstream := range upstreams {
 		f.healthMap[upstream] = true
 	}
+
+	// Replace transports, closing pooled connections of the old ones
+	for _, transport := range f.transports {
+		transport.Close()
+	}
+	f.transports = f.createTransports(upstreams)
+}
+
+// createTransports creates a transport for each upstream. Invalid upstreams
+// get a transport that fails every exchange with the parse error.
+func (f *Forwarder) createTransports(upstreams []string) map[string]upstreamTransport {
+	transports := make(map[string]upstreamTransport, len(upstreams))
+	for _, upstream := range upstreams {
+		transport, err := newUpstreamTransport(upstream, f.config)
+		if err != nil {
+			transport = &failedTransport{err: err}
+		}
+		transports[upstream] = transport
+	}
+	return transports
+}
+
+// transportFor returns the transport for an upstream
+func (f *Forwarder) transportFor(upstream string) upstreamTransport {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+
+	if transport, ok := f.transports[upstream]; ok {
+		return transport
+	}
+	return &failedTransport{err: fmt.Errorf("%w: %s", ErrInvalidUpstream, upstream)}
 }
 
 // getHealthyUpstreams returns only healthy upstream servers
@@ -129,9 +291,7 @@ func (f *Forwarder) selectUpstream(upstreams []string) string {
 	case "random":
 		return upstreams[rand.Intn(len(upstreams))]
 	case "fastest":
-		// For now, use round robin. In a real implementation,
-		// we would track response times and select the fastest
-		fallthrough
+		return f.rankUpstreams(upstreams)[0]
 	case "round_robin":
 		fallthrough
 	default:
@@ -145,35 +305,61 @@ func (f *Forwarder) selectUpstream(upstreams []string) string {
 
 // queryUpstream sends a query to a specific upstream server
 func (f *Forwarder) queryUpstream(ctx context.Context, upstream string, queryData []byte) (*DNSResponse, error) {
-	// Create connection with timeout
-	conn, err := net.DialTimeout("udp", upstream, f.config.Timeout)
+	start := time.Now()
+
+	response, err := f.exchange(ctx, upstream, queryData)
+
+	// Queries abandoned because another upstream won a race say nothing
+	// about this upstream
+	if err == nil || ctx.Err() != context.Canceled {
+		f.recordResult(upstream, time.Since(start), err)
+	}
+	if err == nil {
+		response.Upstream = upstream
+	}
+
+	return response, err
+}
+
+// exchange sends a query to an upstream and parses the response. Truncated
+// UDP answers are retried over TCP.
+func (f *Forwarder) exchange(ctx context.Context, upstream string, queryData []byte) (*DNSResponse, error) {
+	transport := f.transportFor(upstream)
+
+	response, err := f.exchangeOn(ctx, transport, upstream, queryData)
 	if err != nil {
-		return nil, fmt.Errorf("failed to connect to upstream %s: %w", upstream, err)
+		return nil, err
 	}
-	defer conn.Close()
 
-	// Set deadline for the entire operation
-	deadline, ok := ctx.Deadline()
-	if !ok {
-		deadline = time.Now().Add(f.config.Timeout)
+	if response.Truncated {
+		if fallback, ok := transport.(truncationFallback); ok {
+			return f.exchangeOn(ctx, fallback.StreamFallback(), upstream, queryData)
+		}
 	}
-	conn.SetDeadline(deadline)
 
-	// Send query
-	_, err = conn.Write(queryData)
-	if err != nil {
-		return nil, fmt.Errorf("failed to send query to %s: %w", upstream, err)
+	return response, nil
+}
+
+// exchangeOn sends a query over a transport and parses the response
+func (f *Forwarder) exchangeOn(ctx context.Context, transport upstreamTransport, upstream string, queryData []byte) (*DNSResponse, error) {
+	tap := f.tap.Load()
+	addr, protocol := dnstapUpstream(transport)
+	sent := time.Now()
+	if tap != nil {
+		tap.ForwarderQuery(addr, protocol, queryData, sent)
 	}
 
-	// Read response
-	buffer := make([]byte, 4096)
-	n, err := conn.Read(buffer)
+	responseData, err := transport.Exchange(ctx, queryData)
 	if err != nil {
-		return nil, fmt.Errorf("failed to read response from %s: %w", upstream, err)
+		return nil, err
+	}
+
+	if tap != nil {
+		tap.ForwarderResponse(addr, protocol, responseData, sent, time.Now())
 	}
 
 	// Parse response
-	response, err := f.parser.ParseResponse(buffer[:n])
+	response, err := f.parser.ParseResponse(responseData)
 	if err != nil {
 		return nil, fmt.Errorf("failed to parse response from %s: %w", upstream, err)
 	}
@@ -186,25 +372,57 @@ func (f *Forwarder) healthChecker() {
 	ticker := time.NewTicker(f.config.HealthInterval)
 	defer ticker.Stop()
 
-	for range ticker.C {
-		f.checkUpstreamHealth()
+	for {
+		select {
+		case <-f.done:
+			return
+		case <-ticker.C:
+			f.checkUpstreamHealth()
+		}
+	}
+}
+
+// Close stops the health checkers of the forwarder and its conditional
+// forwarding routes and closes pooled upstream connections. Queries still
+// in flight are answered.
+func (f *Forwarder) Close() error {
+	f.closeOnce.Do(func() { close(f.done) })
+
+	f.mu.Lock()
+	routes := f.routes
+	for _, transport := range f.transports {
+		transport.Close()
 	}
+	f.mu.Unlock()
+
+	closeConditionalRoutes(routes)
+	return nil
 }
 
 // checkUpstreamHealth checks the health of all upstream servers
 func (f *Forwarder) checkUpstreamHealth() {
+	upstreams := f.GetUpstreams()
+
+	// Probe without holding the lock so queries are not blocked
+	results := make(map[string]bool, len(upstreams))
+	for _, upstream := range upstreams {
+		results[upstream] = f.isUpstreamHealthy(upstream)
+	}
+
 	f.mu.Lock()
 	defer f.mu.Unlock()
 
-	for _, upstream := range f.upstreams {
-		healthy := f.isUpstreamHealthy(upstream)
-		f.healthMap[upstream] = healthy
+	for upstream, healthy := range results {
+		if _, ok := f.healthMap[upstream]; ok {
+			f.healthMap[upstream] = healthy
+		}
 	}
 }
 
-// isUpstreamHealthy checks if a specific upstream server is healthy
+// isUpstreamHealthy checks if a specific upstream server is healthy using
+// the same transport as regular queries
 func (f *Forwarder) isUpstreamHealthy(upstream string) bool {
-	// Create a simple health check query (A record for ".")
+	// Create a simple health check query (NS record for ".")
 	query := &DNSQuery{
 		ID: uint16(rand.Intn(65536)),
 		Question: DNSQuestion{
@@ -219,21 +437,12 @@ func (f *Forwarder) isUpstreamHealthy(upstream string) bool {
 		return false
 	}
 
-	// Set a shorter timeout for health checks
-	conn, err := net.DialTimeout("udp", upstream, 2*time.Second)
-	if err != nil {
-		return false
-	}
-	defer conn.Close()
-
-	conn.SetDeadline(time.Now().Add(2 * time.Second))
-
-	_, err = conn.Write(queryData)
-	if err != nil {
-		return false
-	}
+	// Use a shorter timeout for health checks
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
 
-	buffer := make([]byte, 512)
-	_, err = conn.Read(buffer)
+	start := time.Now()
+	_, err = f.transportFor(upstream).Exchange(ctx, queryData)
+	f.recordProbe(upstream, time.Since(start), err)
 	return err == nil
 }
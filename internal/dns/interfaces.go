@@ -4,6 +4,8 @@ import (
 	"context"
 	"net"
 	"time"
+
+	"pihole-analyzer/internal/types"
 )
 
 // DNSQuery represents a DNS query
@@ -11,7 +13,11 @@ type DNSQuery struct {
 	ID       uint16
 	Question DNSQuestion
 	Client   net.Addr
-	Protocol string // "udp" or "tcp"
+	Protocol string // "udp", "tcp", "dot" or "doh"
+	EDNS     *EDNS  // OPT pseudo-record, nil if the client did not send one
+
+	// CD bit: the client does its own DNSSEC validation
+	CheckingDisabled bool
 }
 
 // DNSQuestion represents the question section of a DNS query
@@ -23,14 +29,20 @@ type DNSQuestion struct {
 
 // DNSResponse represents a DNS response
 type DNSResponse struct {
-	ID           uint16
-	Question     DNSQuestion
-	Answers      []DNSRecord
-	Authorities  []DNSRecord
-	Additional   []DNSRecord
-	ResponseCode uint8
-	Cached       bool
-	ResponseTime time.Duration
+	ID            uint16
+	Question      DNSQuestion
+	Answers       []DNSRecord
+	Authorities   []DNSRecord
+	Additional    []DNSRecord
+	ResponseCode  uint8
+	Authoritative bool  // AA bit: answered from local data
+	Truncated     bool  // TC bit: the answer did not fit the transport
+	AuthenticData bool  // AD bit: the answer passed DNSSEC validation
+	EDNS          *EDNS // OPT pseudo-record, kept out of Additional
+	Cached        bool
+	ResponseTime  time.Duration
+	Upstream      string // Upstream that answered, empty unless forwarded
+	BlockedCNAME  string // Blocked CNAME target the answer was withheld for
 }
 
 // DNSRecord represents a DNS resource record
@@ -42,6 +54,20 @@ type DNSRecord struct {
 	Data  []byte
 }
 
+// EDNS represents the EDNS0 parameters carried in an OPT pseudo-record
+type EDNS struct {
+	UDPSize  uint16 // Largest UDP payload the sender accepts
+	Version  uint8
+	DNSSECOK bool // DO bit
+	Options  []EDNSOption
+}
+
+// EDNSOption represents a single EDNS option
+type EDNSOption struct {
+	Code uint16
+	Data []byte
+}
+
 // CacheEntry represents a cached DNS response
 type CacheEntry struct {
 	Response   *DNSResponse
@@ -70,6 +96,17 @@ type DNSCache interface {
 	// Get retrieves a cached response
 	Get(question DNSQuestion) (*CacheEntry, bool)
 
+	// GetStale retrieves an entry that may have expired but is still
+	// within the serve-stale window
+	GetStale(question DNSQuestion) (*CacheEntry, bool)
+
+	// Prefetch reports whether a popular entry close to expiry should be
+	// refreshed, claiming the refresh for the caller
+	Prefetch(question DNSQuestion) bool
+
+	// CancelPrefetch releases a claimed refresh that stored nothing
+	CancelPrefetch(question DNSQuestion)
+
 	// Set stores a response in cache
 	Set(question DNSQuestion, response *DNSResponse, ttl time.Duration)
 
@@ -96,6 +133,125 @@ type DNSForwarder interface {
 
 	// SetUpstreams sets the upstream DNS servers
 	SetUpstreams(upstreams []string)
+
+	// GetUpstreamStats returns latency and error statistics per upstream
+	GetUpstreamStats() []UpstreamStats
+}
+
+// DNSBlocklist defines the interface for domain blocklists (gravity)
+type DNSBlocklist interface {
+	// Load compiles the configured list sources, replacing current entries
+	Load() error
+
+	// Reconfigure replaces the list sources and compiles them
+	Reconfigure(config BlocklistConfig) error
+
+	// IsBlocked reports whether a domain is blocked
+	IsBlocked(name string) bool
+
+	// Match returns the list that blocks a domain, or nil
+	Match(name string) *BlocklistMatch
+
+	// Sinkhole returns the default answer for blocked queries
+	Sinkhole() SinkholeConfig
+
+	// ListSinkhole returns the answer for domains of a list, including
+	// group lists, or the default
+	ListSinkhole(source string) SinkholeConfig
+
+	// Size returns the number of compiled entries
+	Size() int
+
+	// GetStats returns blocklist statistics
+	GetStats() *BlocklistStats
+}
+
+// DNSRuleEngine defines the interface for group-scoped domain allow/deny rules
+type DNSRuleEngine interface {
+	// Load compiles a rule configuration and replaces the active rules
+	Load(config RulesConfig) error
+
+	// Match returns the rule or group list deciding a domain for a
+	// client, or nil
+	Match(name string, client net.Addr) *RuleMatch
+
+	// GroupsFor returns the enabled groups a client belongs to
+	GroupsFor(client net.Addr) []string
+
+	// Sinkhole returns the answer for a client's blocked queries set by
+	// group, else by the first of the client's groups that sets one
+	Sinkhole(client net.Addr, group string) (SinkholeConfig, bool)
+
+	// Schedules returns the state of every schedule
+	Schedules() []ScheduleState
+
+	// OverrideSchedule forces a schedule active or inactive for a duration
+	OverrideSchedule(name string, active bool, duration time.Duration) (*ScheduleState, error)
+
+	// ClearScheduleOverride removes a schedule's override
+	ClearScheduleOverride(name string) bool
+
+	// GetStats returns rule engine statistics
+	GetStats() *RuleStats
+}
+
+// DNSRewriter defines the interface for group-scoped domain rewrites
+type DNSRewriter interface {
+	// Load compiles a rewrite configuration and replaces the active rewrites
+	Load(config RewritesConfig) error
+
+	// Match returns the rewrites of a name for the first of groups that
+	// rewrites it, or nil
+	Match(name string, groups []string) *RewriteMatch
+
+	// GetStats returns rewrite statistics
+	GetStats() *RewriteStats
+}
+
+// DNSLocalRecords defines the interface for locally served records and
+// authoritative local zones
+type DNSLocalRecords interface {
+	// Load reads the configured records and hosts files, keeping records
+	// added at runtime
+	Load() error
+
+	// Reconfigure replaces the configured zones, records and hosts files
+	// and loads them, keeping records added at runtime
+	Reconfigure(config LocalRecordsConfig) error
+
+	// Lookup answers a question from local data, reporting false if the
+	// name is not local
+	Lookup(question DNSQuestion) (*DNSResponse, bool)
+
+	// Add adds a record at runtime
+	Add(record LocalRecord) error
+
+	// Remove deletes matching records, returning how many were removed
+	Remove(record LocalRecord) int
+
+	// RemoveSource deletes matching records added by the record's source
+	RemoveSource(record LocalRecord) int
+
+	// HasStatic reports whether config or hosts records exist for a name
+	HasStatic(name string) bool
+
+	// Records returns all local records
+	Records() []LocalRecord
+
+	// GetStats returns local record statistics
+	GetStats() *LocalRecordStats
+}
+
+// DNSQueryLog defines the interface for the bounded log of handled queries
+type DNSQueryLog interface {
+	// Add appends a record, dropping the oldest once the log is full
+	Add(record types.PiholeRecord)
+
+	// Records returns the logged records, oldest first
+	Records() []types.PiholeRecord
+
+	// GetStats returns query log statistics
+	GetStats() *QueryLogStats
 }
 
 // DNSParser defines the interface for parsing DNS messages
@@ -119,23 +275,116 @@ type ServerStats struct {
 	QueriesReceived  int64
 	QueriesAnswered  int64
 	QueriesForwarded int64
+	QueriesBlocked   int64
+	CNAMEBlocked     int64 // Blocked for a CNAME target, also in QueriesBlocked
+	QueriesAllowed   int64
+	LocalAnswers     int64
+	RewrittenAnswers int64
 	CacheHits        int64
 	CacheMisses      int64
 	Errors           int64
 	AverageLatency   time.Duration
 	UDPQueries       int64
 	TCPQueries       int64
+	DoTQueries       int64
+	DoHQueries       int64
+	TruncatedReplies int64
+
+	// DNSSEC validation outcomes of forwarded answers
+	DNSSECSecure   int64
+	DNSSECInsecure int64
+	DNSSECBogus    int64
+
+	// Rate limit events, by limit and by action taken
+	RateLimitedQueries   int64 // Over a client's query rate
+	RateLimitedResponses int64 // Over the rate of identical responses (RRL)
+	RateLimitDropped     int64
+	RateLimitRefused     int64
+	RateLimitTruncated   int64
 }
 
 // CacheStats contains DNS cache statistics
 type CacheStats struct {
-	Size        int
-	MaxSize     int
-	HitRate     float64
-	Hits        int64
-	Misses      int64
-	Evictions   int64
-	LastCleanup time.Time
+	Size           int
+	MaxSize        int
+	MemoryUsage    int64 // Approximate bytes held, from encoded response sizes
+	MaxMemory      int64
+	EvictionPolicy string
+	HitRate        float64
+	Hits           int64
+	Misses         int64
+	Evictions      int64
+	LastCleanup    time.Time
+
+	// Eviction reasons
+	CapacityEvictions int64 // Evicted to stay within MaxSize
+	MemoryEvictions   int64 // Evicted to stay within the memory budget
+	Expirations       int64 // Removed after their TTL (and stale window) ran out
+
+	// Resilience
+	StaleHits  int64 // Expired answers served because upstreams failed
+	Prefetches int64 // Popular entries refreshed before expiry
+
+	// Negative (NXDOMAIN/NODATA) entries, also included in Hits and Misses
+	NegativeHits   int64
+	NegativeMisses int64 // Misses later filled with a negative answer
+}
+
+// UpstreamStats contains forwarding statistics for one upstream server
+type UpstreamStats struct {
+	Upstream       string
+	Zone           string // Conditional forwarding domains, empty for the default upstreams
+	Healthy        bool
+	Queries        int64
+	Failures       int64
+	AverageLatency time.Duration // Exponentially weighted moving average
+	FailureRate    float64       // Exponentially weighted moving average, 0..1
+	LastUsed       time.Time
+	LastError      string
+}
+
+// BlocklistStats contains DNS blocklist statistics
+type BlocklistStats struct {
+	Domains      int
+	Sources      int
+	InvalidLines int
+	LastLoaded   time.Time
+}
+
+// RuleStats contains domain rule engine statistics
+type RuleStats struct {
+	Groups     int
+	Clients    int
+	AllowRules int
+	DenyRules  int
+	Lists      int
+	Schedules  int
+	LastLoaded time.Time
+}
+
+// RewriteStats contains rewrite statistics
+type RewriteStats struct {
+	Rules      int   // Enabled rewrite rules
+	SafeSearch int   // Names rewritten by safe search
+	Rewritten  int64 // Queries matched by a rewrite
+	LastLoaded time.Time
+}
+
+// LocalRecordStats contains local record statistics
+type LocalRecordStats struct {
+	Records    int
+	Zones      int
+	HostsFiles int
+	Answers    int64
+	LastLoaded time.Time
+}
+
+// QueryLogStats contains query log statistics
+type QueryLogStats struct {
+	Size    int
+	MaxSize int
+	Logged  int64 // Records added since start
+	Dropped int64 // Records overwritten once the log was full
 }
 
 // DNSServerFactory creates DNS server components
@@ -149,6 +398,18 @@ type DNSServerFactory interface {
 	// CreateForwarder creates a DNS forwarder instance
 	CreateForwarder(config *ForwarderConfig) (DNSForwarder, error)
 
+	// CreateBlocklist creates a DNS blocklist instance
+	CreateBlocklist(config *BlocklistConfig) (DNSBlocklist, error)
+
+	// CreateRuleEngine creates a DNS rule engine loaded with the given rules
+	CreateRuleEngine(config *RulesConfig) (DNSRuleEngine, error)
+
+	// CreateLocalRecords creates a local record store loaded from config
+	CreateLocalRecords(config *LocalRecordsConfig) (DNSLocalRecords, error)
+
+	// CreateQueryLog creates a query log holding up to config.Size records
+	CreateQueryLog(config *QueryLogConfig) (DNSQueryLog, error)
+
 	// CreateParser creates a DNS parser instance
 	CreateParser() DNSParser
 }
@@ -0,0 +1,199 @@
+package dns
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+
+	"pihole-analyzer/internal/dhcp"
+	"pihole-analyzer/internal/logger"
+	"pihole-analyzer/internal/types"
+)
+
+// LeaseRecords publishes an A and a matching PTR record for every DHCP
+// lease that carries a hostname, named <hostname>.<domain>. It follows the
+// lease manager's events, so records appear when a lease is committed and
+// disappear when it is released or expires.
+type LeaseRecords struct {
+	local  DNSLocalRecords
+	domain string
+	logger *logger.Logger
+
+	mu     sync.Mutex
+	byMAC  map[string]leaseHost // Published host per client MAC
+	byName map[string]string    // Client MAC per published name
+}
+
+// leaseHost is the name and address published for one lease
+type leaseHost struct {
+	name string
+	ip   string
+}
+
+// NewLeaseRecords creates a lease record publisher adding to local under
+// domain, usually DHCPOptionsConfig.DomainName
+func NewLeaseRecords(local DNSLocalRecords, domain string, logger *logger.Logger) *LeaseRecords {
+	return &LeaseRecords{
+		local:  local,
+		domain: normalizeDomain(domain),
+		logger: logger,
+		byMAC:  make(map[string]leaseHost),
+		byName: make(map[string]string),
+	}
+}
+
+// Attach subscribes to the lease manager's events and publishes its current
+// active leases. Call the returned function to stop following it.
+func (r *LeaseRecords) Attach(ctx context.Context, manager dhcp.DHCPLeaseManager) (func(), error) {
+	if r.domain == "" {
+		return nil, ErrNoLeaseDomain
+	}
+
+	// Subscribe first so no commit between the snapshot and the
+	// subscription is missed; handling a lease twice is harmless
+	unsubscribe := manager.Subscribe(r.HandleLeaseEvent)
+
+	leases, err := manager.GetAllLeases(ctx)
+	if err != nil {
+		unsubscribe()
+		return nil, fmt.Errorf("failed to load DHCP leases: %w", err)
+	}
+	for _, lease := range leases {
+		if lease.State == types.LeaseStateActive {
+			r.publish(lease)
+		}
+	}
+
+	return unsubscribe, nil
+}
+
+// HandleLeaseEvent adds or removes the records of the event's lease
+func (r *LeaseRecords) HandleLeaseEvent(event dhcp.LeaseEvent) {
+	switch event.Type {
+	case dhcp.LeaseEventCommitted:
+		r.publish(event.Lease)
+	case dhcp.LeaseEventReleased, dhcp.LeaseEventExpired:
+		r.mu.Lock()
+		defer r.mu.Unlock()
+
+		// A client may already hold a newer lease on another address
+		if host, ok := r.byMAC[event.Lease.MAC]; ok && host.ip == event.Lease.IP {
+			r.unpublishLocked(event.Lease.MAC)
+		}
+	}
+}
+
+// publish replaces the records of a lease's client. A name claimed by
+// another client moves to the newest lease; names of config or hosts
+// records are never published, so a client cannot take them over.
+func (r *LeaseRecords) publish(lease types.DHCPLease) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	label := leaseHostname(lease.Hostname)
+	ip := net.ParseIP(lease.IP)
+	if label == "" || ip == nil {
+		r.unpublishLocked(lease.MAC)
+		return
+	}
+
+	host := leaseHost{name: label + "." + r.domain, ip: ip.String()}
+	if r.local.HasStatic(host.name) {
+		r.unpublishLocked(lease.MAC)
+		r.logger.WarnFields("DHCP hostname conflicts with a configured record", map[string]any{
+			"name": host.name,
+			"ip":   host.ip,
+			"mac":  lease.MAC,
+		})
+		return
+	}
+	if current, ok := r.byMAC[lease.MAC]; ok && current == host {
+		return
+	}
+
+	r.unpublishLocked(lease.MAC)
+	if owner, ok := r.byName[host.name]; ok {
+		r.unpublishLocked(owner)
+	}
+
+	for _, record := range leaseRecords(host) {
+		if err := r.local.Add(record); err != nil {
+			r.logger.WarnFields("Failed to add DHCP lease record", map[string]any{
+				"name":  record.Name,
+				"type":  RecordTypeName(record.Type),
+				"value": record.Value,
+				"error": err.Error(),
+			})
+		}
+	}
+	r.byMAC[lease.MAC] = host
+	r.byName[host.name] = lease.MAC
+
+	r.logger.DebugFields("Published DHCP lease records", map[string]any{
+		"name": host.name,
+		"ip":   host.ip,
+		"mac":  lease.MAC,
+	})
+}
+
+// unpublishLocked removes the records of a client; the caller must hold mu
+func (r *LeaseRecords) unpublishLocked(mac string) {
+	host, ok := r.byMAC[mac]
+	if !ok {
+		return
+	}
+
+	// Identical config or hosts records are not the lease's to remove
+	for _, record := range leaseRecords(host) {
+		r.local.RemoveSource(record)
+	}
+	delete(r.byMAC, mac)
+	delete(r.byName, host.name)
+
+	r.logger.DebugFields("Removed DHCP lease records", map[string]any{
+		"name": host.name,
+		"ip":   host.ip,
+		"mac":  mac,
+	})
+}
+
+// leaseRecords returns the address and reverse records of a host
+func leaseRecords(host leaseHost) []LocalRecord {
+	rtype := TypeA
+	if net.ParseIP(host.ip).To4() == nil {
+		rtype = TypeAAAA
+	}
+
+	return []LocalRecord{
+		{Name: host.name, Type: rtype, Value: host.ip, Source: LocalSourceDHCP},
+		{Name: host.ip, Type: TypePTR, Value: host.name, Source: LocalSourceDHCP},
+	}
+}
+
+// leaseHostname turns a client supplied hostname into a single DNS label.
+// Only the first label of a qualified name is used; characters that are not
+// allowed in hostnames are replaced or dropped.
+func leaseHostname(hostname string) string {
+	hostname = strings.ToLower(strings.TrimSpace(hostname))
+	if i := strings.IndexByte(hostname, '.'); i >= 0 {
+		hostname = hostname[:i]
+	}
+
+	var label strings.Builder
+	for _, c := range hostname {
+		switch {
+		case c >= 'a' && c <= 'z', c >= '0' && c <= '9', c == '-':
+			label.WriteRune(c)
+		case c == '_' || c == ' ':
+			label.WriteByte('-')
+		}
+	}
+
+	name := strings.Trim(label.String(), "-")
+	if len(name) > 63 {
+		name = strings.TrimRight(name[:63], "-")
+	}
+	return name
+}
@@ -0,0 +1,181 @@
+package dns
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+
+	"pihole-analyzer/internal/dhcp"
+	"pihole-analyzer/internal/logger"
+	"pihole-analyzer/internal/types"
+)
+
+func TestLeaseRecords(t *testing.T) {
+	testLogger := logger.New(&logger.Config{
+		Level:     logger.LogLevel("ERROR"),
+		Component: "dns-test",
+	})
+	ctx := context.Background()
+
+	config := dhcp.DefaultDHCPConfig()
+	config.Options.DomainName = "Home.Lan."
+	storage, err := dhcp.NewStorage(&types.DHCPStorageConfig{Type: "memory"}, testLogger.GetSlogger())
+	if err != nil {
+		t.Fatalf("NewStorage failed: %v", err)
+	}
+	if err := storage.Initialize(ctx); err != nil {
+		t.Fatalf("Initialize failed: %v", err)
+	}
+	manager, err := dhcp.NewLeaseManager(config, storage, testLogger.GetSlogger())
+	if err != nil {
+		t.Fatalf("NewLeaseManager failed: %v", err)
+	}
+
+	commit := func(mac, hostname string) string {
+		t.Helper()
+		ip, err := manager.AllocateIP(ctx, mac, "", "")
+		if err != nil {
+			t.Fatalf("AllocateIP failed: %v", err)
+		}
+		if _, err := manager.CommitLease(ctx, ip, mac, hostname); err != nil {
+			t.Fatalf("CommitLease failed: %v", err)
+		}
+		return ip
+	}
+
+	local := NewLocalRecords(DefaultConfig().Local)
+	reverse := func(ip string) string {
+		return reverseName(net.ParseIP(ip))
+	}
+	lookup := func(name string, qtype uint16) []DNSRecord {
+		response, ok := local.Lookup(DNSQuestion{Name: name, Type: qtype, Class: ClassIN})
+		if !ok {
+			return nil
+		}
+		return response.Answers
+	}
+
+	// Leases committed before attaching are published on attach
+	nasIP := commit("00:11:22:33:44:01", "NAS")
+	records := NewLeaseRecords(local, config.Options.DomainName, testLogger)
+	detach, err := records.Attach(ctx, manager)
+	if err != nil {
+		t.Fatalf("Attach failed: %v", err)
+	}
+	defer detach()
+
+	if answers := lookup("nas.home.lan", TypeA); len(answers) != 1 || !net.IP(answers[0].Data).Equal(net.ParseIP(nasIP)) {
+		t.Errorf("Expected A record for nas.home.lan, got %+v", answers)
+	}
+	if answers := lookup(reverse(nasIP), TypePTR); len(answers) != 1 {
+		t.Errorf("Expected PTR record for %s, got %+v", nasIP, answers)
+	}
+
+	// Records follow commits and renames
+	laptopIP := commit("00:11:22:33:44:02", "laptop")
+	if len(lookup("laptop.home.lan", TypeA)) != 1 {
+		t.Error("Expected A record after commit")
+	}
+	commit("00:11:22:33:44:02", "work-laptop")
+	if len(lookup("laptop.home.lan", TypeA)) != 0 || len(lookup("work-laptop.home.lan", TypeA)) != 1 {
+		t.Error("Expected rename to replace the A record")
+	}
+	if answers := lookup(reverse(laptopIP), TypePTR); len(answers) != 1 {
+		t.Errorf("Expected one PTR record after rename, got %d", len(answers))
+	}
+
+	// A name claimed by another client moves to the newest lease
+	phoneIP := commit("00:11:22:33:44:03", "nas")
+	if answers := lookup("nas.home.lan", TypeA); len(answers) != 1 || !net.IP(answers[0].Data).Equal(net.ParseIP(phoneIP)) {
+		t.Errorf("Expected nas.home.lan to move to the new lease, got %+v", answers)
+	}
+	if len(lookup(reverse(nasIP), TypePTR)) != 0 {
+		t.Error("Expected the previous owner's PTR record to be removed")
+	}
+	for _, record := range local.Records() {
+		if record.Source != LocalSourceDHCP {
+			t.Errorf("Unexpected record source %q", record.Source)
+		}
+	}
+
+	if err := manager.ReleaseIP(ctx, laptopIP, "00:11:22:33:44:02"); err != nil {
+		t.Fatalf("ReleaseIP failed: %v", err)
+	}
+	if len(lookup("work-laptop.home.lan", TypeA)) != 0 || len(lookup(reverse(laptopIP), TypePTR)) != 0 {
+		t.Error("Expected records to be removed on release")
+	}
+
+	// Expiry of a stale address leaves the client's current records alone
+	records.HandleLeaseEvent(dhcp.LeaseEvent{
+		Type:  dhcp.LeaseEventExpired,
+		Lease: types.DHCPLease{MAC: "00:11:22:33:44:03", IP: "192.168.1.199"},
+	})
+	if len(lookup("nas.home.lan", TypeA)) != 1 {
+		t.Error("Expected expiry of another address to keep the records")
+	}
+	records.HandleLeaseEvent(dhcp.LeaseEvent{
+		Type:  dhcp.LeaseEventExpired,
+		Lease: types.DHCPLease{MAC: "00:11:22:33:44:03", IP: phoneIP},
+	})
+	if len(lookup("nas.home.lan", TypeA)) != 0 || len(lookup(reverse(phoneIP), TypePTR)) != 0 {
+		t.Error("Expected records to be removed on expiry")
+	}
+
+	if _, err := NewLeaseRecords(local, "", testLogger).Attach(ctx, manager); !errors.Is(err, ErrNoLeaseDomain) {
+		t.Errorf("Expected ErrNoLeaseDomain, got %v", err)
+	}
+}
+
+func TestLeaseRecords_ConfiguredName(t *testing.T) {
+	testLogger := logger.New(&logger.Config{
+		Level:     logger.LogLevel("ERROR"),
+		Component: "dns-test",
+	})
+
+	local := NewLocalRecords(LocalRecordsConfig{
+		Enabled: true,
+		Zones:   []string{"home.lan"},
+		Records: []LocalRecordConfig{{Name: "nas.home.lan", Type: "A", Value: "192.168.1.10"}},
+	})
+	if err := local.Load(); err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	records := NewLeaseRecords(local, "home.lan", testLogger)
+
+	// A client naming itself after a configured host publishes nothing
+	lease := types.DHCPLease{MAC: "00:11:22:33:44:05", IP: "192.168.1.50", Hostname: "nas"}
+	records.HandleLeaseEvent(dhcp.LeaseEvent{Type: dhcp.LeaseEventCommitted, Lease: lease})
+
+	response, _ := local.Lookup(DNSQuestion{Name: "nas.home.lan", Type: TypeA, Class: ClassIN})
+	if len(response.Answers) != 1 || !net.IP(response.Answers[0].Data).Equal(net.ParseIP("192.168.1.10")) {
+		t.Errorf("Expected only the configured address, got %+v", response.Answers)
+	}
+	if response, ok := local.Lookup(DNSQuestion{Name: reverseName(net.ParseIP(lease.IP)), Type: TypePTR, Class: ClassIN}); ok && len(response.Answers) != 0 {
+		t.Errorf("Expected no PTR record for the conflicting lease, got %+v", response.Answers)
+	}
+
+	// Its release leaves the configured record alone
+	records.HandleLeaseEvent(dhcp.LeaseEvent{Type: dhcp.LeaseEventReleased, Lease: lease})
+	if response, _ := local.Lookup(DNSQuestion{Name: "nas.home.lan", Type: TypeA, Class: ClassIN}); len(response.Answers) != 1 {
+		t.Error("Expected the configured record to survive the release")
+	}
+}
+
+func TestLeaseHostname(t *testing.T) {
+	tests := map[string]string{
+		"Laptop":             "laptop",
+		"johns-iphone.local": "johns-iphone",
+		"Living Room TV":     "living-room-tv",
+		"_printer_":          "printer",
+		"café":               "caf",
+		"---":                "",
+		"":                   "",
+	}
+
+	for hostname, want := range tests {
+		if got := leaseHostname(hostname); got != want {
+			t.Errorf("leaseHostname(%q) = %q, want %q", hostname, got, want)
+		}
+	}
+}
@@ -0,0 +1,615 @@
+package dns
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"net"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Local record sources
+const (
+	LocalSourceConfig = "config"
+	LocalSourceHosts  = "hosts"
+	LocalSourceAPI    = "api"
+	LocalSourceDHCP   = "dhcp"
+)
+
+// maxLocalCNAMEChain bounds how many local CNAMEs are followed for one answer
+const maxLocalCNAMEChain = 8
+
+// recordTypeNames maps the record types served locally to their names
+var recordTypeNames = map[uint16]string{
+	TypeA:     "A",
+	TypeAAAA:  "AAAA",
+	TypeCNAME: "CNAME",
+	TypePTR:   "PTR",
+	TypeTXT:   "TXT",
+	TypeSRV:   "SRV",
+}
+
+// LocalRecord is a record served from local data. Value is the textual
+// form: an address for A/AAAA, a name for CNAME/PTR, text for TXT and
+// "priority weight port target" for SRV.
+type LocalRecord struct {
+	Name   string
+	Type   uint16
+	Value  string
+	TTL    time.Duration // Zero uses the configured default
+	Source string        // "config", "hosts", "api" or a subsystem name
+
+	data []byte // Encoded rdata
+}
+
+// RecordTypeName returns the name of a locally supported record type
+func RecordTypeName(rtype uint16) string {
+	if name, ok := recordTypeNames[rtype]; ok {
+		return name
+	}
+	return strconv.Itoa(int(rtype))
+}
+
+// ParseRecordType returns the type code for a locally supported type name
+func ParseRecordType(name string) (uint16, bool) {
+	name = strings.ToUpper(strings.TrimSpace(name))
+	for rtype, typeName := range recordTypeNames {
+		if typeName == name {
+			return rtype, true
+		}
+	}
+	return 0, false
+}
+
+// LocalRecords implements the DNSLocalRecords interface
+type LocalRecords struct {
+	mu      sync.RWMutex
+	config  LocalRecordsConfig
+	records map[string][]LocalRecord // By normalized name
+	zones   []string
+	stats   LocalRecordStats
+	serial  uint32
+
+	// Counted under the read lock
+	answered atomic.Int64
+}
+
+// NewLocalRecords creates a local record store; call Load to read the
+// configured records and hosts files
+func NewLocalRecords(config LocalRecordsConfig) DNSLocalRecords {
+	zones := localZones(config)
+
+	return &LocalRecords{
+		config:  config,
+		records: make(map[string][]LocalRecord),
+		zones:   zones,
+		stats:   LocalRecordStats{Zones: len(zones)},
+	}
+}
+
+// Load replaces the records from config and hosts files. Records added at
+// runtime are kept. On error the previous records stay in place.
+func (l *LocalRecords) Load() error {
+	l.mu.RLock()
+	config := l.config
+	l.mu.RUnlock()
+
+	return l.load(config)
+}
+
+// Reconfigure replaces the configured zones, records and hosts files and
+// loads them, keeping records added at runtime. On error the previous
+// configuration and records stay in place.
+func (l *LocalRecords) Reconfigure(config LocalRecordsConfig) error {
+	return l.load(config)
+}
+
+// load reads the records of a configuration and makes it current
+func (l *LocalRecords) load(config LocalRecordsConfig) error {
+	var loaded []LocalRecord
+
+	for _, rc := range config.Records {
+		rtype, ok := ParseRecordType(rc.Type)
+		if !ok {
+			return fmt.Errorf("%w: unsupported type %q for %s", ErrInvalidLocalRecord, rc.Type, rc.Name)
+		}
+		record, err := newLocalRecord(LocalRecord{
+			Name:   rc.Name,
+			Type:   rtype,
+			Value:  rc.Value,
+			TTL:    rc.TTL,
+			Source: LocalSourceConfig,
+		})
+		if err != nil {
+			return err
+		}
+		loaded = append(loaded, record)
+	}
+
+	for _, path := range config.HostsFiles {
+		records, err := loadHostsFile(path)
+		if err != nil {
+			return fmt.Errorf("failed to load hosts file %s: %w", path, err)
+		}
+		loaded = append(loaded, records...)
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	for name, records := range l.records {
+		kept := records[:0]
+		for _, record := range records {
+			if !isStaticSource(record.Source) {
+				kept = append(kept, record)
+			}
+		}
+		l.setLocked(name, kept)
+	}
+	for _, record := range loaded {
+		l.addLocked(record)
+	}
+
+	l.config = config
+	l.zones = localZones(config)
+	l.stats.Zones = len(l.zones)
+	l.stats.HostsFiles = len(config.HostsFiles)
+	l.stats.LastLoaded = time.Now()
+	l.serial = uint32(l.stats.LastLoaded.Unix())
+	return nil
+}
+
+// Add validates and adds a record. Adding an identical record twice is a
+// no-op.
+func (l *LocalRecords) Add(record LocalRecord) error {
+	if record.Source == "" {
+		record.Source = LocalSourceAPI
+	}
+	record, err := newLocalRecord(record)
+	if err != nil {
+		return err
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.addLocked(record)
+	l.serial++
+	return nil
+}
+
+// Remove deletes records matching the name and type, and the value unless
+// it is empty. It returns the number of records removed.
+func (l *LocalRecords) Remove(record LocalRecord) int {
+	return l.remove(record, false)
+}
+
+// RemoveSource is like Remove but only deletes records added by the
+// record's source, leaving identical records from other sources
+func (l *LocalRecords) RemoveSource(record LocalRecord) int {
+	return l.remove(record, true)
+}
+
+// remove deletes matching records, of the record's source only if
+// sameSource is set
+func (l *LocalRecords) remove(record LocalRecord, sameSource bool) int {
+	name := localRecordName(record)
+
+	var data []byte
+	if record.Value != "" {
+		encoded, err := encodeLocalRData(record.Type, record.Value)
+		if err != nil {
+			return 0
+		}
+		data = encoded
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	records := l.records[name]
+	kept := records[:0]
+	for _, existing := range records {
+		if existing.Type == record.Type && (data == nil || bytes.Equal(existing.data, data)) &&
+			(!sameSource || existing.Source == record.Source) {
+			continue
+		}
+		kept = append(kept, existing)
+	}
+
+	removed := len(records) - len(kept)
+	l.setLocked(name, kept)
+	if removed > 0 {
+		l.serial++
+	}
+	return removed
+}
+
+// HasStatic reports whether config or hosts records exist for a name
+func (l *LocalRecords) HasStatic(name string) bool {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	for _, record := range l.records[normalizeDomain(name)] {
+		if isStaticSource(record.Source) {
+			return true
+		}
+	}
+	return false
+}
+
+// Records returns all local records sorted by name and type
+func (l *LocalRecords) Records() []LocalRecord {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	var all []LocalRecord
+	for _, records := range l.records {
+		all = append(all, records...)
+	}
+
+	sort.Slice(all, func(i, j int) bool {
+		if all[i].Name != all[j].Name {
+			return all[i].Name < all[j].Name
+		}
+		return all[i].Type < all[j].Type
+	})
+	return all
+}
+
+// Lookup answers a question from local data. It reports false if the name
+// is neither a local record nor inside an owned zone, so the query should
+// be resolved normally.
+func (l *LocalRecords) Lookup(question DNSQuestion) (*DNSResponse, bool) {
+	name := normalizeDomain(question.Name)
+
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	zone := l.zoneFor(name)
+	_, exists := l.records[name]
+	if !exists && zone == "" {
+		return nil, false
+	}
+
+	response := &DNSResponse{
+		Question:      question,
+		ResponseCode:  RCodeNoError,
+		Authoritative: true,
+	}
+
+	switch {
+	case question.Type == TypeSOA && name == zone:
+		response.Answers = append(response.Answers, l.soaRecordLocked(zone))
+	case exists:
+		response.Answers = l.answerLocked(name, question.Type)
+	case name != zone && !l.hasDescendantLocked(name):
+		response.ResponseCode = RCodeNXDomain
+	}
+
+	// Negative answers carry the zone SOA so they can be cached (RFC 2308)
+	if len(response.Answers) == 0 && zone != "" {
+		response.Authorities = append(response.Authorities, l.soaRecordLocked(zone))
+	}
+
+	l.answered.Add(1)
+	return response, true
+}
+
+// GetStats returns local record statistics
+func (l *LocalRecords) GetStats() *LocalRecordStats {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	stats := l.stats
+	stats.Answers = l.answered.Load()
+	for _, records := range l.records {
+		stats.Records += len(records)
+	}
+	return &stats
+}
+
+// answerLocked returns the records of a type at a name, following local
+// CNAMEs for other types
+func (l *LocalRecords) answerLocked(name string, qtype uint16) []DNSRecord {
+	var answers []DNSRecord
+
+	for hops := 0; hops <= maxLocalCNAMEChain; hops++ {
+		var cname *LocalRecord
+		found := false
+		for i, record := range l.records[name] {
+			switch {
+			case record.Type == qtype:
+				answers = append(answers, l.dnsRecord(name, record))
+				found = true
+			case record.Type == TypeCNAME:
+				cname = &l.records[name][i]
+			}
+		}
+
+		if found || cname == nil {
+			break
+		}
+
+		answers = append(answers, l.dnsRecord(name, *cname))
+		name = normalizeDomain(cname.Value)
+	}
+
+	return answers
+}
+
+// dnsRecord converts a local record to a resource record
+func (l *LocalRecords) dnsRecord(name string, record LocalRecord) DNSRecord {
+	ttl := record.TTL
+	if ttl == 0 {
+		ttl = l.config.TTL
+	}
+	return DNSRecord{
+		Name:  name,
+		Type:  record.Type,
+		Class: ClassIN,
+		TTL:   uint32(ttl / time.Second),
+		Data:  record.data,
+	}
+}
+
+// soaRecordLocked synthesizes the SOA record of an owned zone
+func (l *LocalRecords) soaRecordLocked(zone string) DNSRecord {
+	ttl := uint32(l.config.TTL / time.Second)
+	soa := &SOAData{
+		MName:   zone,
+		RName:   "hostmaster." + zone,
+		Serial:  l.serial,
+		Refresh: 3600,
+		Retry:   600,
+		Expire:  86400,
+		Minimum: ttl,
+	}
+	data, _ := soa.Encode()
+
+	return DNSRecord{Name: zone, Type: TypeSOA, Class: ClassIN, TTL: ttl, Data: data}
+}
+
+// zoneFor returns the most specific owned zone containing a name
+func (l *LocalRecords) zoneFor(name string) string {
+	for _, zone := range l.zones {
+		if name == zone || strings.HasSuffix(name, "."+zone) {
+			return zone
+		}
+	}
+	return ""
+}
+
+// hasDescendantLocked reports whether a name is an empty non-terminal,
+// which exists (NODATA) even though it has no records itself
+func (l *LocalRecords) hasDescendantLocked(name string) bool {
+	suffix := "." + name
+	for other := range l.records {
+		if strings.HasSuffix(other, suffix) {
+			return true
+		}
+	}
+	return false
+}
+
+// addLocked adds a record unless an identical one exists. A config or
+// hosts record takes over an identical runtime one, so removing the runtime
+// record later keeps it.
+func (l *LocalRecords) addLocked(record LocalRecord) {
+	for i, existing := range l.records[record.Name] {
+		if existing.Type == record.Type && bytes.Equal(existing.data, record.data) {
+			if isStaticSource(record.Source) && !isStaticSource(existing.Source) {
+				l.records[record.Name][i] = record
+			}
+			return
+		}
+	}
+	l.records[record.Name] = append(l.records[record.Name], record)
+}
+
+// isStaticSource reports whether records of a source are loaded from the
+// configuration or hosts files
+func isStaticSource(source string) bool {
+	return source == LocalSourceConfig || source == LocalSourceHosts
+}
+
+// setLocked replaces the records of a name, dropping empty names
+func (l *LocalRecords) setLocked(name string, records []LocalRecord) {
+	if len(records) == 0 {
+		delete(l.records, name)
+		return
+	}
+	l.records[name] = records
+}
+
+// localZones returns the normalized zones of a configuration, longest
+// first so the most specific one owns a name
+func localZones(config LocalRecordsConfig) []string {
+	zones := make([]string, 0, len(config.Zones))
+	for _, zone := range config.Zones {
+		zones = append(zones, normalizeDomain(zone))
+	}
+
+	sort.Slice(zones, func(i, j int) bool { return len(zones[i]) > len(zones[j]) })
+	return zones
+}
+
+// newLocalRecord validates and normalizes a record, encoding its data
+func newLocalRecord(record LocalRecord) (LocalRecord, error) {
+	record.Name = localRecordName(record)
+	if !isValidDomain(record.Name) {
+		return record, fmt.Errorf("%w: invalid name %q", ErrInvalidLocalRecord, record.Name)
+	}
+	if record.TTL < 0 {
+		return record, fmt.Errorf("%w: negative TTL for %s", ErrInvalidLocalRecord, record.Name)
+	}
+
+	data, err := encodeLocalRData(record.Type, record.Value)
+	if err != nil {
+		return record, fmt.Errorf("%w: %s %s %q: %v", ErrInvalidLocalRecord,
+			record.Name, RecordTypeName(record.Type), record.Value, err)
+	}
+	record.data = data
+	return record, nil
+}
+
+// localRecordName normalizes a record name; PTR records may be given by
+// address instead of their in-addr.arpa/ip6.arpa name
+func localRecordName(record LocalRecord) string {
+	if record.Type == TypePTR {
+		if ip := net.ParseIP(strings.TrimSpace(record.Name)); ip != nil {
+			return reverseName(ip)
+		}
+	}
+	return normalizeDomain(record.Name)
+}
+
+// encodeLocalRData encodes the textual value of a record as rdata
+func encodeLocalRData(rtype uint16, value string) ([]byte, error) {
+	value = strings.TrimSpace(value)
+
+	switch rtype {
+	case TypeA:
+		ip := net.ParseIP(value).To4()
+		if ip == nil {
+			return nil, fmt.Errorf("not an IPv4 address")
+		}
+		return []byte(ip), nil
+
+	case TypeAAAA:
+		ip := net.ParseIP(value)
+		if ip == nil || ip.To4() != nil {
+			return nil, fmt.Errorf("not an IPv6 address")
+		}
+		return []byte(ip.To16()), nil
+
+	case TypeCNAME, TypePTR:
+		return encodeLocalName(value)
+
+	case TypeTXT:
+		// Character strings are limited to 255 bytes each
+		txt := &TXTData{}
+		for len(value) > 255 {
+			txt.Strings = append(txt.Strings, value[:255])
+			value = value[255:]
+		}
+		txt.Strings = append(txt.Strings, value)
+		return txt.Encode()
+
+	case TypeSRV:
+		fields := strings.Fields(value)
+		if len(fields) != 4 {
+			return nil, fmt.Errorf("expected \"priority weight port target\"")
+		}
+		var numbers [3]uint16
+		for i, field := range fields[:3] {
+			n, err := strconv.ParseUint(field, 10, 16)
+			if err != nil {
+				return nil, fmt.Errorf("invalid SRV number %q", field)
+			}
+			numbers[i] = uint16(n)
+		}
+		target := normalizeDomain(fields[3])
+		if !isValidDomain(target) {
+			return nil, fmt.Errorf("invalid name %q", fields[3])
+		}
+		srv := &SRVData{Priority: numbers[0], Weight: numbers[1], Port: numbers[2], Target: target}
+		return srv.Encode()
+
+	default:
+		return nil, fmt.Errorf("unsupported type")
+	}
+}
+
+// encodeLocalName encodes a domain name without compression
+func encodeLocalName(value string) ([]byte, error) {
+	name := normalizeDomain(value)
+	if !isValidDomain(name) {
+		return nil, fmt.Errorf("invalid name %q", value)
+	}
+
+	var buf bytes.Buffer
+	if err := (&Parser{}).writeName(&buf, name); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// reverseName returns the in-addr.arpa or ip6.arpa name of an address
+func reverseName(ip net.IP) string {
+	if ip4 := ip.To4(); ip4 != nil {
+		return fmt.Sprintf("%d.%d.%d.%d.in-addr.arpa", ip4[3], ip4[2], ip4[1], ip4[0])
+	}
+
+	ip16 := ip.To16()
+	labels := make([]string, 0, 2*net.IPv6len+1)
+	for i := net.IPv6len - 1; i >= 0; i-- {
+		labels = append(labels, strconv.FormatUint(uint64(ip16[i]&0x0F), 16), strconv.FormatUint(uint64(ip16[i]>>4), 16))
+	}
+	return strings.Join(append(labels, "ip6.arpa"), ".")
+}
+
+// loadHostsFile reads "address name [aliases...]" lines into A/AAAA records
+// for every name and a PTR record for the first one
+func loadHostsFile(path string) ([]LocalRecord, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var records []LocalRecord
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if i := strings.IndexByte(line, '#'); i >= 0 {
+			line = line[:i]
+		}
+
+		fields := strings.Fields(line)
+		if len(fields) < 2 {
+			continue
+		}
+
+		ip := net.ParseIP(fields[0])
+		if ip == nil || ip.IsUnspecified() {
+			continue
+		}
+		rtype := TypeAAAA
+		if ip.To4() != nil {
+			rtype = TypeA
+		}
+
+		canonical := ""
+		for _, host := range fields[1:] {
+			name := normalizeDomain(host)
+			if isHostsLocalName(name) || !isValidDomain(name) {
+				continue
+			}
+			if canonical == "" {
+				canonical = name
+			}
+
+			record, err := newLocalRecord(LocalRecord{Name: name, Type: rtype, Value: fields[0], Source: LocalSourceHosts})
+			if err != nil {
+				continue
+			}
+			records = append(records, record)
+		}
+
+		if canonical != "" {
+			record, err := newLocalRecord(LocalRecord{Name: fields[0], Type: TypePTR, Value: canonical, Source: LocalSourceHosts})
+			if err == nil {
+				records = append(records, record)
+			}
+		}
+	}
+
+	return records, scanner.Err()
+}
@@ -0,0 +1,250 @@
+package dns
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"pihole-analyzer/internal/logger"
+)
+
+func testLocalRecords(t *testing.T) DNSLocalRecords {
+	t.Helper()
+
+	hosts := writeTestList(t, "hosts", `# local hosts
+127.0.0.1   localhost
+192.168.1.20  printer.lan printer
+fd00::20      printer.lan
+`)
+
+	records := NewLocalRecords(LocalRecordsConfig{
+		Enabled: true,
+		Zones:   []string{"lan"},
+		Records: []LocalRecordConfig{
+			{Name: "nas.lan", Type: "A", Value: "192.168.1.10"},
+			{Name: "files.lan", Type: "CNAME", Value: "nas.lan"},
+			{Name: "192.168.1.10", Type: "PTR", Value: "nas.lan"},
+			{Name: "nas.lan", Type: "TXT", Value: "v=local", TTL: 10 * time.Second},
+			{Name: "_smb._tcp.nas.lan", Type: "SRV", Value: "0 5 445 nas.lan"},
+		},
+		HostsFiles: []string{hosts},
+		TTL:        time.Minute,
+	})
+	if err := records.Load(); err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	return records
+}
+
+func TestLocalRecords_Lookup(t *testing.T) {
+	records := testLocalRecords(t)
+
+	tests := []struct {
+		name    string
+		qtype   uint16
+		rcode   uint8
+		answers int
+		local   bool
+	}{
+		{"nas.lan", TypeA, RCodeNoError, 1, true},
+		{"NAS.lan.", TypeA, RCodeNoError, 1, true},
+		{"files.lan", TypeA, RCodeNoError, 2, true}, // CNAME and target
+		{"files.lan", TypeCNAME, RCodeNoError, 1, true},
+		{"10.1.168.192.in-addr.arpa", TypePTR, RCodeNoError, 1, true},
+		{"nas.lan", TypeTXT, RCodeNoError, 1, true},
+		{"_smb._tcp.nas.lan", TypeSRV, RCodeNoError, 1, true},
+		{"printer.lan", TypeA, RCodeNoError, 1, true},
+		{"printer.lan", TypeAAAA, RCodeNoError, 1, true},
+		{"printer", TypeA, RCodeNoError, 1, true},
+		{"20.1.168.192.in-addr.arpa", TypePTR, RCodeNoError, 1, true},
+		{"nas.lan", TypeAAAA, RCodeNoError, 0, true},     // NODATA
+		{"_tcp.nas.lan", TypeSRV, RCodeNoError, 0, true}, // Empty non-terminal
+		{"missing.lan", TypeA, RCodeNXDomain, 0, true},
+		{"lan", TypeSOA, RCodeNoError, 1, true},
+		{"lan", TypeA, RCodeNoError, 0, true},
+		{"localhost", TypeA, 0, 0, false},
+		{"example.com", TypeA, 0, 0, false},
+	}
+
+	for _, tt := range tests {
+		response, ok := records.Lookup(DNSQuestion{Name: tt.name, Type: tt.qtype, Class: ClassIN})
+		if ok != tt.local {
+			t.Errorf("Lookup(%s, %d) local = %v, want %v", tt.name, tt.qtype, ok, tt.local)
+			continue
+		}
+		if !ok {
+			continue
+		}
+		if response.ResponseCode != tt.rcode || len(response.Answers) != tt.answers {
+			t.Errorf("Lookup(%s, %d) = rcode %d with %d answers, want rcode %d with %d",
+				tt.name, tt.qtype, response.ResponseCode, len(response.Answers), tt.rcode, tt.answers)
+		}
+		if !response.Authoritative {
+			t.Errorf("Lookup(%s, %d) expected an authoritative answer", tt.name, tt.qtype)
+		}
+		if tt.answers == 0 {
+			// Negative answers carry the zone SOA for negative caching
+			if _, ok := negativeTTL(response); !ok {
+				t.Errorf("Lookup(%s, %d) expected an SOA in the authority section", tt.name, tt.qtype)
+			}
+		}
+	}
+
+	response, _ := records.Lookup(DNSQuestion{Name: "nas.lan", Type: TypeA, Class: ClassIN})
+	if !net.IP(response.Answers[0].Data).Equal(net.IPv4(192, 168, 1, 10)) || response.Answers[0].TTL != 60 {
+		t.Errorf("Unexpected A answer %+v", response.Answers[0])
+	}
+	response, _ = records.Lookup(DNSQuestion{Name: "nas.lan", Type: TypeTXT, Class: ClassIN})
+	if response.Answers[0].TTL != 10 {
+		t.Errorf("Expected per-record TTL 10, got %d", response.Answers[0].TTL)
+	}
+
+	if stats := records.GetStats(); stats.Records != 10 || stats.Zones != 1 || stats.HostsFiles != 1 {
+		t.Errorf("Unexpected stats %+v", stats)
+	}
+}
+
+func TestLocalRecords_AddRemove(t *testing.T) {
+	records := testLocalRecords(t)
+	question := DNSQuestion{Name: "tv.lan", Type: TypeA, Class: ClassIN}
+
+	if err := records.Add(LocalRecord{Name: "tv.lan", Type: TypeA, Value: "192.168.1.30"}); err != nil {
+		t.Fatalf("Add failed: %v", err)
+	}
+	if response, _ := records.Lookup(question); len(response.Answers) != 1 {
+		t.Fatal("Expected the added record to be served")
+	}
+
+	// Runtime records survive a reload of config and hosts files
+	if err := records.Load(); err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	if response, _ := records.Lookup(question); len(response.Answers) != 1 {
+		t.Error("Expected the added record to survive a reload")
+	}
+
+	if removed := records.Remove(LocalRecord{Name: "tv.lan", Type: TypeA, Value: "192.168.1.31"}); removed != 0 {
+		t.Errorf("Expected no record removed for another value, got %d", removed)
+	}
+	if removed := records.Remove(LocalRecord{Name: "TV.lan", Type: TypeA}); removed != 1 {
+		t.Errorf("Expected 1 record removed, got %d", removed)
+	}
+	if response, _ := records.Lookup(question); response.ResponseCode != RCodeNXDomain {
+		t.Errorf("Expected NXDOMAIN after removal, got %d", response.ResponseCode)
+	}
+
+	invalid := []LocalRecord{
+		{Name: "bad.lan", Type: TypeA, Value: "fd00::1"},
+		{Name: "bad.lan", Type: TypeAAAA, Value: "192.168.1.1"},
+		{Name: "bad..lan", Type: TypeA, Value: "192.168.1.1"},
+		{Name: "bad.lan", Type: TypeSRV, Value: "0 5 nas.lan"},
+		{Name: "bad.lan", Type: TypeMX, Value: "10 mail.lan"},
+	}
+	for _, record := range invalid {
+		if err := records.Add(record); err == nil {
+			t.Errorf("Expected error adding %+v", record)
+		}
+	}
+}
+
+func TestLocalRecords_RemoveSource(t *testing.T) {
+	records := testLocalRecords(t)
+	question := DNSQuestion{Name: "nas.lan", Type: TypeA, Class: ClassIN}
+	lease := LocalRecord{Name: "nas.lan", Type: TypeA, Value: "192.168.1.10", Source: LocalSourceDHCP}
+
+	// An identical configured record is not the subsystem's to remove
+	if err := records.Add(lease); err != nil {
+		t.Fatalf("Add failed: %v", err)
+	}
+	if removed := records.RemoveSource(lease); removed != 0 {
+		t.Errorf("Expected the configured record to be kept, removed %d", removed)
+	}
+	if response, _ := records.Lookup(question); len(response.Answers) != 1 {
+		t.Error("Expected the configured record to be served")
+	}
+
+	// A hosts record loaded after an identical runtime one takes it over
+	printer := LocalRecord{Name: "printer.lan", Type: TypeA, Value: "192.168.1.20", Source: LocalSourceDHCP}
+	records.Remove(LocalRecord{Name: "printer.lan", Type: TypeA})
+	if err := records.Add(printer); err != nil {
+		t.Fatalf("Add failed: %v", err)
+	}
+	if err := records.Load(); err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	if removed := records.RemoveSource(printer); removed != 0 {
+		t.Errorf("Expected the hosts record to be kept, removed %d", removed)
+	}
+
+	// Records of the source itself are removed
+	guest := LocalRecord{Name: "guest.lan", Type: TypeA, Value: "192.168.1.40", Source: LocalSourceDHCP}
+	if err := records.Add(guest); err != nil {
+		t.Fatalf("Add failed: %v", err)
+	}
+	if removed := records.RemoveSource(guest); removed != 1 {
+		t.Errorf("Expected 1 record removed, got %d", removed)
+	}
+}
+
+func TestServer_LocalRecordsBeforeForwarding(t *testing.T) {
+	config := DefaultConfig()
+	config.LogQueries = false
+	config.Forwarder.HealthCheck = false
+	config.Local.Zones = []string{"lan"}
+	config.Local.Records = []LocalRecordConfig{{Name: "nas.lan", Type: "A", Value: "192.168.1.10"}}
+
+	if err := config.Validate(); err != nil {
+		t.Fatalf("Validate failed: %v", err)
+	}
+
+	testLogger := logger.New(&logger.Config{
+		Level:     logger.LogLevel("ERROR"),
+		Component: "dns-test",
+	})
+
+	upstream := &fakeTransport{}
+	server := NewServer(config, testLogger).(*Server)
+	server.forwarder = newFakeForwarder("round_robin", map[string]*fakeTransport{"upstream:53": upstream})
+	if err := server.ReloadLocalRecords(); err != nil {
+		t.Fatalf("ReloadLocalRecords failed: %v", err)
+	}
+
+	for _, name := range []string{"nas.lan", "unknown.lan"} {
+		response, err := server.HandleQuery(context.Background(), &DNSQuery{
+			ID:       9,
+			Question: DNSQuestion{Name: name, Type: TypeA, Class: ClassIN},
+			Client:   &net.UDPAddr{IP: net.ParseIP("192.168.1.50"), Port: 5000},
+			Protocol: "udp",
+		})
+		if err != nil {
+			t.Fatalf("HandleQuery failed: %v", err)
+		}
+
+		data, err := server.parser.SerializeResponse(response)
+		if err != nil {
+			t.Fatalf("Failed to serialize response: %v", err)
+		}
+		if flags := uint16(data[2])<<8 | uint16(data[3]); flags&FlagAA == 0 {
+			t.Errorf("Expected AA bit for %s", name)
+		}
+	}
+
+	if upstream.calls.Load() != 0 {
+		t.Errorf("Expected local names not to be forwarded, got %d upstream queries", upstream.calls.Load())
+	}
+	if stats := server.GetStats(); stats.LocalAnswers != 2 {
+		t.Errorf("Expected 2 local answers, got %d", stats.LocalAnswers)
+	}
+
+	// Forwarded answers are not authoritative
+	response, _ := server.HandleQuery(context.Background(), testForwardQuery())
+	if response.Authoritative {
+		t.Error("Expected forwarded answer without AA")
+	}
+
+	config.Local.Records = append(config.Local.Records, LocalRecordConfig{Name: "x.lan", Type: "A", Value: "nope"})
+	if err := config.Validate(); err == nil {
+		t.Error("Expected validation error for invalid local record")
+	}
+}
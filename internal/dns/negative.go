@@ -0,0 +1,102 @@
+package dns
+
+import (
+	"bytes"
+	"encoding/binary"
+	"time"
+)
+
+// SOAData is the decoded data of an SOA record
+type SOAData struct {
+	MName   string // Primary name server
+	RName   string // Responsible mailbox
+	Serial  uint32
+	Refresh uint32
+	Retry   uint32
+	Expire  uint32
+	Minimum uint32 // Negative caching TTL (RFC 2308)
+}
+
+// ParseSOA decodes the data of an SOA record as returned by the parser
+func ParseSOA(record DNSRecord) (*SOAData, error) {
+	if record.Type != TypeSOA {
+		return nil, ErrInvalidRecord
+	}
+	return (&Parser{}).parseSOAAt(record.Data, 0, len(record.Data))
+}
+
+// Encode implements RData
+func (s *SOAData) Encode() ([]byte, error) {
+	var buf bytes.Buffer
+	p := &Parser{}
+
+	if err := p.writeName(&buf, s.MName); err != nil {
+		return nil, err
+	}
+	if err := p.writeName(&buf, s.RName); err != nil {
+		return nil, err
+	}
+	for _, value := range []uint32{s.Serial, s.Refresh, s.Retry, s.Expire, s.Minimum} {
+		binary.Write(&buf, binary.BigEndian, value)
+	}
+	return buf.Bytes(), nil
+}
+
+// parseSOAAt decodes SOA data occupying msg[offset:end]; names may be
+// compressed against the whole message
+func (p *Parser) parseSOAAt(msg []byte, offset, end int) (*SOAData, error) {
+	soa := &SOAData{}
+
+	var err error
+	if soa.MName, offset, err = p.parseName(msg[:end], offset); err != nil {
+		return nil, err
+	}
+	if soa.RName, offset, err = p.parseName(msg[:end], offset); err != nil {
+		return nil, err
+	}
+	if offset+20 != end {
+		return nil, ErrInvalidRecord
+	}
+
+	soa.Serial = binary.BigEndian.Uint32(msg[offset : offset+4])
+	soa.Refresh = binary.BigEndian.Uint32(msg[offset+4 : offset+8])
+	soa.Retry = binary.BigEndian.Uint32(msg[offset+8 : offset+12])
+	soa.Expire = binary.BigEndian.Uint32(msg[offset+12 : offset+16])
+	soa.Minimum = binary.BigEndian.Uint32(msg[offset+16 : offset+20])
+	return soa, nil
+}
+
+// isNegativeResponse reports whether a response is NXDOMAIN or NODATA
+// (NOERROR without answers)
+func isNegativeResponse(response *DNSResponse) bool {
+	switch response.ResponseCode {
+	case RCodeNXDomain:
+		return true
+	case RCodeNoError:
+		return len(response.Answers) == 0
+	default:
+		return false
+	}
+}
+
+// negativeTTL returns how long a negative response may be cached: the
+// smaller of the SOA record's TTL and its MINIMUM field (RFC 2308 section
+// 5). Responses without an SOA in the authority section are not cached.
+func negativeTTL(response *DNSResponse) (time.Duration, bool) {
+	for _, record := range response.Authorities {
+		if record.Type != TypeSOA {
+			continue
+		}
+		soa, err := ParseSOA(record)
+		if err != nil {
+			return 0, false
+		}
+
+		ttl := record.TTL
+		if soa.Minimum < ttl {
+			ttl = soa.Minimum
+		}
+		return time.Duration(ttl) * time.Second, true
+	}
+	return 0, false
+}
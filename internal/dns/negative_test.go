@@ -0,0 +1,180 @@
+package dns
+
+import (
+	"encoding/binary"
+	"testing"
+	"time"
+
+	"pihole-analyzer/internal/logger"
+)
+
+// negativeTestMessage builds an NXDOMAIN response for missing.example.com
+// whose SOA owner and rdata names are compressed against the question
+func negativeTestMessage() []byte {
+	msg := []byte{
+		0x12, 0x34, // ID
+		0x81, 0x83, // QR, RD, RA, NXDOMAIN
+		0, 1, 0, 0, 0, 1, 0, 0, // QD=1 AN=0 NS=1 AR=0
+	}
+
+	// Question: missing.example.com A IN; "example.com" starts at offset 20
+	msg = append(msg, 7)
+	msg = append(msg, "missing"...)
+	msg = append(msg, 7)
+	msg = append(msg, "example"...)
+	msg = append(msg, 3)
+	msg = append(msg, "com"...)
+	msg = append(msg, 0, 0, 1, 0, 1)
+
+	// Authority: example.com SOA, TTL 3600
+	rdata := []byte{3, 'n', 's', '1', 0xC0, 20}
+	rdata = append(rdata, 10)
+	rdata = append(rdata, "hostmaster"...)
+	rdata = append(rdata, 0xC0, 20)
+	for _, value := range []uint32{2024010101, 7200, 900, 1209600, 300} {
+		rdata = binary.BigEndian.AppendUint32(rdata, value)
+	}
+
+	msg = append(msg, 0xC0, 20, 0, 6, 0, 1)
+	msg = binary.BigEndian.AppendUint32(msg, 3600)
+	msg = binary.BigEndian.AppendUint16(msg, uint16(len(rdata)))
+	return append(msg, rdata...)
+}
+
+func TestParser_ParseSOA(t *testing.T) {
+	parser := NewParser()
+
+	response, err := parser.ParseResponse(negativeTestMessage())
+	if err != nil {
+		t.Fatalf("Failed to parse response: %v", err)
+	}
+	if response.ResponseCode != RCodeNXDomain || len(response.Authorities) != 1 {
+		t.Fatalf("Expected NXDOMAIN with 1 authority record, got rcode %d and %d records",
+			response.ResponseCode, len(response.Authorities))
+	}
+
+	check := func(record DNSRecord) {
+		t.Helper()
+		soa, err := ParseSOA(record)
+		if err != nil {
+			t.Fatalf("Failed to decode SOA: %v", err)
+		}
+		if record.Name != "example.com" || soa.MName != "ns1.example.com" || soa.RName != "hostmaster.example.com" {
+			t.Errorf("Unexpected SOA names: owner %s, %+v", record.Name, soa)
+		}
+		if soa.Serial != 2024010101 || soa.Minimum != 300 {
+			t.Errorf("Unexpected SOA values: %+v", soa)
+		}
+	}
+	check(response.Authorities[0])
+
+	// Compressed names are expanded, so the record survives re-serialization
+	data, err := parser.SerializeResponse(response)
+	if err != nil {
+		t.Fatalf("Failed to serialize response: %v", err)
+	}
+	reparsed, err := parser.ParseResponse(data)
+	if err != nil {
+		t.Fatalf("Failed to re-parse response: %v", err)
+	}
+	check(reparsed.Authorities[0])
+
+	if _, err := ParseSOA(DNSRecord{Type: TypeA, Data: []byte{1, 2, 3, 4}}); err == nil {
+		t.Error("Expected error decoding a non-SOA record")
+	}
+}
+
+func TestNegativeTTL(t *testing.T) {
+	response, err := NewParser().ParseResponse(negativeTestMessage())
+	if err != nil {
+		t.Fatalf("Failed to parse response: %v", err)
+	}
+
+	if !isNegativeResponse(response) {
+		t.Error("Expected NXDOMAIN to be negative")
+	}
+	if ttl, ok := negativeTTL(response); !ok || ttl != 300*time.Second {
+		t.Errorf("Expected SOA minimum 300s, got %v (%v)", ttl, ok)
+	}
+
+	// The SOA record's own TTL wins when it is lower
+	response.Authorities[0].TTL = 60
+	if ttl, _ := negativeTTL(response); ttl != 60*time.Second {
+		t.Errorf("Expected SOA TTL 60s, got %v", ttl)
+	}
+
+	response.Authorities = nil
+	if _, ok := negativeTTL(response); ok {
+		t.Error("Expected no negative TTL without an SOA")
+	}
+
+	if isNegativeResponse(&DNSResponse{ResponseCode: RCodeServFail}) {
+		t.Error("Expected SERVFAIL not to be a negative answer")
+	}
+	nodata := &DNSResponse{ResponseCode: RCodeNoError}
+	if !isNegativeResponse(nodata) {
+		t.Error("Expected NODATA to be negative")
+	}
+}
+
+func TestCache_NegativeEntries(t *testing.T) {
+	cache := NewCache(CacheConfig{Enabled: true, MaxSize: 10, NegativeMaxTTL: time.Minute})
+
+	question := DNSQuestion{Name: "missing.example.com", Type: TypeA, Class: ClassIN}
+	cache.Get(question)
+	cache.Set(question, &DNSResponse{Question: question, ResponseCode: RCodeNXDomain}, time.Hour)
+
+	entry, found := cache.Get(question)
+	if !found {
+		t.Fatal("Expected negative entry to be cached")
+	}
+	if time.Until(entry.ExpiresAt) > time.Minute {
+		t.Errorf("Expected negative TTL ceiling of 1m, expires in %v", time.Until(entry.ExpiresAt))
+	}
+
+	positive, response := cacheTestEntry("example.com")
+	cache.Get(positive)
+	cache.Set(positive, response, time.Hour)
+	cache.Get(positive)
+
+	stats := cache.GetStats()
+	if stats.NegativeHits != 1 || stats.NegativeMisses != 1 {
+		t.Errorf("Expected 1 negative hit and miss, got %d and %d", stats.NegativeHits, stats.NegativeMisses)
+	}
+	if stats.Hits != 2 || stats.Misses != 2 {
+		t.Errorf("Expected 2 hits and 2 misses overall, got %d and %d", stats.Hits, stats.Misses)
+	}
+}
+
+func TestServer_NegativeCaching(t *testing.T) {
+	config := DefaultConfig()
+	config.LogQueries = false
+	config.Forwarder.HealthCheck = false
+
+	testLogger := logger.New(&logger.Config{
+		Level:     logger.LogLevel("ERROR"),
+		Component: "dns-test",
+	})
+	server := NewServer(config, testLogger).(*Server)
+
+	response, err := NewParser().ParseResponse(negativeTestMessage())
+	if err != nil {
+		t.Fatalf("Failed to parse response: %v", err)
+	}
+
+	server.cacheResponse(response.Question, response)
+	entry, found := server.cache.Get(response.Question)
+	if !found {
+		t.Fatal("Expected NXDOMAIN with SOA to be cached")
+	}
+	if remaining := time.Until(entry.ExpiresAt); remaining > 300*time.Second || remaining < 290*time.Second {
+		t.Errorf("Expected SOA-derived TTL of 300s, expires in %v", remaining)
+	}
+
+	// Without an SOA there is nothing to bound the negative TTL
+	nodata := DNSQuestion{Name: "nodata.example.com", Type: TypeAAAA, Class: ClassIN}
+	server.cacheResponse(nodata, &DNSResponse{Question: nodata, ResponseCode: RCodeNoError})
+	if _, found := server.cache.Get(nodata); found {
+		t.Error("Expected NODATA without SOA not to be cached")
+	}
+}
@@ -7,6 +7,15 @@ import (
 	"strings"
 )
 
+const (
+	// maxNameLength is the longest encoded name, in bytes (RFC 1035)
+	maxNameLength = 255
+
+	// maxCompressionOffset is the largest offset a compression pointer
+	// can hold
+	maxCompressionOffset = 0x3FFF
+)
+
 // Parser implements the DNSParser interface
 type Parser struct{}
 
@@ -25,6 +34,9 @@ func (p *Parser) ParseQuery(data []byte) (*DNSQuery, error) {
 	id := binary.BigEndian.Uint16(data[0:2])
 	flags := binary.BigEndian.Uint16(data[2:4])
 	qdcount := binary.BigEndian.Uint16(data[4:6])
+	ancount := binary.BigEndian.Uint16(data[6:8])
+	nscount := binary.BigEndian.Uint16(data[8:10])
+	arcount := binary.BigEndian.Uint16(data[10:12])
 
 	// Verify this is a query
 	if flags&FlagQR != 0 {
@@ -37,15 +49,37 @@ func (p *Parser) ParseQuery(data []byte) (*DNSQuery, error) {
 	}
 
 	// Parse question section
-	question, err := p.parseQuestion(data[12:])
+	question, offset, err := p.parseQuestionAt(data, 12)
 	if err != nil {
 		return nil, fmt.Errorf("failed to parse question: %w", err)
 	}
 
-	return &DNSQuery{
-		ID:       id,
-		Question: *question,
-	}, nil
+	query := &DNSQuery{
+		ID:               id,
+		Question:         *question,
+		CheckingDisabled: flags&FlagCD != 0,
+	}
+
+	// Only the OPT pseudo-record is of interest in the remaining sections
+	for i := 0; i < int(ancount)+int(nscount)+int(arcount); i++ {
+		record, newOffset, err := p.parseRecordAt(data, offset)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse query record: %w", err)
+		}
+		offset = newOffset
+
+		if record.Type != TypeOPT || i < int(ancount)+int(nscount) {
+			continue
+		}
+		if query.EDNS != nil {
+			return nil, ErrInvalidQuery // At most one OPT record (RFC 6891)
+		}
+		if query.EDNS, _, err = parseOPTRecord(record); err != nil {
+			return nil, fmt.Errorf("failed to parse OPT record: %w", err)
+		}
+	}
+
+	return query, nil
 }
 
 // SerializeResponse serializes a DNS response to raw bytes
@@ -57,41 +91,59 @@ func (p *Parser) SerializeResponse(response *DNSResponse) ([]byte, error) {
 
 	// Set flags (QR=1 for response, RA=1 for recursion available)
 	flags := uint16(FlagQR | FlagRA)
-	if response.ResponseCode == RCodeNoError && len(response.Answers) > 0 {
-		flags |= FlagAA // Set authoritative answer for successful responses
+	if response.Authoritative {
+		flags |= FlagAA
+	}
+	if response.Truncated {
+		flags |= FlagTC
+	}
+	if response.AuthenticData {
+		flags |= FlagAD
 	}
+	flags |= uint16(response.ResponseCode & 0x0F)
 	binary.Write(&buf, binary.BigEndian, flags)
 
 	// Write counts
+	additional := len(response.Additional)
+	if response.EDNS != nil {
+		additional++
+	}
 	binary.Write(&buf, binary.BigEndian, uint16(1))                         // QDCOUNT
 	binary.Write(&buf, binary.BigEndian, uint16(len(response.Answers)))     // ANCOUNT
 	binary.Write(&buf, binary.BigEndian, uint16(len(response.Authorities))) // NSCOUNT
-	binary.Write(&buf, binary.BigEndian, uint16(len(response.Additional)))  // ARCOUNT
+	binary.Write(&buf, binary.BigEndian, uint16(additional))                // ARCOUNT
 
 	// Write question section
-	if err := p.writeQuestion(&buf, response.Question); err != nil {
+	names := make(compressionMap)
+	if err := p.writeQuestion(&buf, response.Question, names); err != nil {
 		return nil, fmt.Errorf("failed to write question: %w", err)
 	}
 
 	// Write answer sections
 	for _, record := range response.Answers {
-		if err := p.writeRecord(&buf, record); err != nil {
+		if err := p.writeRecord(&buf, record, names); err != nil {
 			return nil, fmt.Errorf("failed to write answer record: %w", err)
 		}
 	}
 
 	for _, record := range response.Authorities {
-		if err := p.writeRecord(&buf, record); err != nil {
+		if err := p.writeRecord(&buf, record, names); err != nil {
 			return nil, fmt.Errorf("failed to write authority record: %w", err)
 		}
 	}
 
 	for _, record := range response.Additional {
-		if err := p.writeRecord(&buf, record); err != nil {
+		if err := p.writeRecord(&buf, record, names); err != nil {
 			return nil, fmt.Errorf("failed to write additional record: %w", err)
 		}
 	}
 
+	if response.EDNS != nil {
+		if err := p.writeRecord(&buf, optRecord(response.EDNS, response.ResponseCode), names); err != nil {
+			return nil, fmt.Errorf("failed to write OPT record: %w", err)
+		}
+	}
+
 	return buf.Bytes(), nil
 }
 
@@ -114,9 +166,17 @@ func (p *Parser) ParseResponse(data []byte) (*DNSResponse, error) {
 		return nil, ErrInvalidQuery
 	}
 
+	// Responses echo at most the single question that was asked
+	if qdcount > 1 {
+		return nil, ErrInvalidDNSMessage
+	}
+
 	response := &DNSResponse{
-		ID:           id,
-		ResponseCode: uint8(flags & 0x0F),
+		ID:            id,
+		ResponseCode:  uint8(flags & 0x0F),
+		Authoritative: flags&FlagAA != 0,
+		Truncated:     flags&FlagTC != 0,
+		AuthenticData: flags&FlagAD != 0,
 	}
 
 	offset := 12
@@ -135,6 +195,9 @@ func (p *Parser) ParseResponse(data []byte) (*DNSResponse, error) {
 	for i := 0; i < int(ancount); i++ {
 		record, newOffset, err := p.parseRecordAt(data, offset)
 		if err != nil {
+			if response.Truncated {
+				return response, nil // Keep what arrived of a truncated message
+			}
 			return nil, fmt.Errorf("failed to parse answer record: %w", err)
 		}
 		response.Answers = append(response.Answers, *record)
@@ -145,6 +208,9 @@ func (p *Parser) ParseResponse(data []byte) (*DNSResponse, error) {
 	for i := 0; i < int(nscount); i++ {
 		record, newOffset, err := p.parseRecordAt(data, offset)
 		if err != nil {
+			if response.Truncated {
+				return response, nil // Keep what arrived of a truncated message
+			}
 			return nil, fmt.Errorf("failed to parse authority record: %w", err)
 		}
 		response.Authorities = append(response.Authorities, *record)
@@ -155,10 +221,23 @@ func (p *Parser) ParseResponse(data []byte) (*DNSResponse, error) {
 	for i := 0; i < int(arcount); i++ {
 		record, newOffset, err := p.parseRecordAt(data, offset)
 		if err != nil {
+			if response.Truncated {
+				return response, nil // Keep what arrived of a truncated message
+			}
 			return nil, fmt.Errorf("failed to parse additional record: %w", err)
 		}
-		response.Additional = append(response.Additional, *record)
 		offset = newOffset
+
+		if record.Type == TypeOPT && response.EDNS == nil {
+			edns, extendedRCode, err := parseOPTRecord(record)
+			if err != nil {
+				return nil, fmt.Errorf("failed to parse OPT record: %w", err)
+			}
+			response.EDNS = edns
+			response.ResponseCode |= extendedRCode << 4
+			continue
+		}
+		response.Additional = append(response.Additional, *record)
 	}
 
 	return response, nil
@@ -168,26 +247,37 @@ func (p *Parser) ParseResponse(data []byte) (*DNSResponse, error) {
 func (p *Parser) SerializeQuery(query *DNSQuery) ([]byte, error) {
 	var buf bytes.Buffer
 
+	arcount := uint16(0)
+	if query.EDNS != nil {
+		arcount = 1
+	}
+
+	flags := FlagRD // Recursion desired
+	if query.CheckingDisabled {
+		flags |= FlagCD
+	}
+
 	// Write header
 	binary.Write(&buf, binary.BigEndian, query.ID)
-	binary.Write(&buf, binary.BigEndian, uint16(FlagRD)) // Recursion desired
-	binary.Write(&buf, binary.BigEndian, uint16(1))      // QDCOUNT
-	binary.Write(&buf, binary.BigEndian, uint16(0))      // ANCOUNT
-	binary.Write(&buf, binary.BigEndian, uint16(0))      // NSCOUNT
-	binary.Write(&buf, binary.BigEndian, uint16(0))      // ARCOUNT
+	binary.Write(&buf, binary.BigEndian, flags)
+	binary.Write(&buf, binary.BigEndian, uint16(1)) // QDCOUNT
+	binary.Write(&buf, binary.BigEndian, uint16(0)) // ANCOUNT
+	binary.Write(&buf, binary.BigEndian, uint16(0)) // NSCOUNT
+	binary.Write(&buf, binary.BigEndian, arcount)   // ARCOUNT
 
 	// Write question
-	if err := p.writeQuestion(&buf, query.Question); err != nil {
+	names := make(compressionMap)
+	if err := p.writeQuestion(&buf, query.Question, names); err != nil {
 		return nil, fmt.Errorf("failed to write question: %w", err)
 	}
 
-	return buf.Bytes(), nil
-}
+	if query.EDNS != nil {
+		if err := p.writeRecord(&buf, optRecord(query.EDNS, 0), names); err != nil {
+			return nil, fmt.Errorf("failed to write OPT record: %w", err)
+		}
+	}
 
-// parseQuestion parses a DNS question from data starting at offset 0
-func (p *Parser) parseQuestion(data []byte) (*DNSQuestion, error) {
-	question, _, err := p.parseQuestionAt(data, 0)
-	return question, err
+	return buf.Bytes(), nil
 }
 
 // parseQuestionAt parses a DNS question from data starting at given offset
@@ -233,8 +323,10 @@ func (p *Parser) parseRecordAt(data []byte, offset int) (*DNSRecord, int, error)
 		return nil, 0, ErrShortMessage
 	}
 
-	rdata := make([]byte, rdlength)
-	copy(rdata, data[newOffset:newOffset+int(rdlength)])
+	rdata, err := p.expandRData(data, rtype, newOffset, newOffset+int(rdlength))
+	if err != nil {
+		return nil, 0, err
+	}
 
 	return &DNSRecord{
 		Name:  name,
@@ -245,12 +337,55 @@ func (p *Parser) parseRecordAt(data []byte, offset int) (*DNSRecord, int, error)
 	}, newOffset + int(rdlength), nil
 }
 
+// expandRData returns a copy of the record data in data[offset:end]. Names
+// in types that may be compressed (RFC 3597 section 4) are expanded, so the
+// record stands alone for caching, typed decoding and DNSSEC canonical form.
+func (p *Parser) expandRData(data []byte, rtype uint16, offset, end int) ([]byte, error) {
+	var prefix int // Fixed fields before the name
+	switch rtype {
+	case TypeSOA:
+		soa, err := p.parseSOAAt(data, offset, end)
+		if err != nil {
+			return nil, err
+		}
+		return soa.Encode()
+	case TypeNS, TypeCNAME, TypePTR:
+	case TypeMX:
+		prefix = 2
+	case TypeSRV:
+		prefix = 6 // Compression is not allowed, but is decoded if sent
+	default:
+		rdata := make([]byte, end-offset)
+		copy(rdata, data[offset:end])
+		return rdata, nil
+	}
+
+	if offset+prefix > end {
+		return nil, ErrInvalidRecord
+	}
+	name, nameEnd, err := p.parseName(data[:end], offset+prefix)
+	if err != nil {
+		return nil, err
+	}
+	if nameEnd != end {
+		return nil, ErrInvalidRecord
+	}
+
+	var buf bytes.Buffer
+	buf.Write(data[offset : offset+prefix])
+	if err := p.writeName(&buf, name); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
 // parseName parses a DNS name with compression support
 func (p *Parser) parseName(data []byte, offset int) (string, int, error) {
 	var labels []string
 	originalOffset := offset
 	jumped := false
 	jumps := 0
+	wireLen := 1 // Terminating root label
 
 	for {
 		if offset >= len(data) {
@@ -300,15 +435,17 @@ func (p *Parser) parseName(data []byte, offset int) (string, int, error) {
 			return "", 0, ErrShortMessage
 		}
 
+		wireLen += 1 + length
+		if wireLen > maxNameLength {
+			return "", 0, ErrNameTooLong
+		}
+
 		label := string(data[offset+1 : offset+1+length])
 		labels = append(labels, label)
 		offset += 1 + length
 	}
 
 	name := strings.Join(labels, ".")
-	if len(name) > 255 {
-		return "", 0, ErrNameTooLong
-	}
 
 	if jumped {
 		return name, originalOffset, nil
@@ -316,9 +453,13 @@ func (p *Parser) parseName(data []byte, offset int) (string, int, error) {
 	return name, offset, nil
 }
 
+// compressionMap holds the message offsets of names already written, by
+// name suffix, so later names can point to them (RFC 1035 section 4.1.4)
+type compressionMap map[string]int
+
 // writeQuestion writes a DNS question to the buffer
-func (p *Parser) writeQuestion(buf *bytes.Buffer, question DNSQuestion) error {
-	if err := p.writeName(buf, question.Name); err != nil {
+func (p *Parser) writeQuestion(buf *bytes.Buffer, question DNSQuestion, names compressionMap) error {
+	if err := p.writeCompressedName(buf, question.Name, names); err != nil {
 		return err
 	}
 	binary.Write(buf, binary.BigEndian, question.Type)
@@ -326,38 +467,136 @@ func (p *Parser) writeQuestion(buf *bytes.Buffer, question DNSQuestion) error {
 	return nil
 }
 
-// writeRecord writes a DNS record to the buffer
-func (p *Parser) writeRecord(buf *bytes.Buffer, record DNSRecord) error {
-	if err := p.writeName(buf, record.Name); err != nil {
+// writeRecord writes a DNS record to the buffer. Names in the data of the
+// RFC 1035 types that allow it are compressed as well.
+func (p *Parser) writeRecord(buf *bytes.Buffer, record DNSRecord, names compressionMap) error {
+	if err := p.writeCompressedName(buf, record.Name, names); err != nil {
 		return err
 	}
 	binary.Write(buf, binary.BigEndian, record.Type)
 	binary.Write(buf, binary.BigEndian, record.Class)
 	binary.Write(buf, binary.BigEndian, record.TTL)
-	binary.Write(buf, binary.BigEndian, uint16(len(record.Data)))
-	buf.Write(record.Data)
+
+	// RDLENGTH is filled in once the data is written
+	lengthAt := buf.Len()
+	binary.Write(buf, binary.BigEndian, uint16(0))
+
+	if !p.writeCompressedRData(buf, record, names) {
+		buf.Write(record.Data)
+	}
+
+	length := buf.Len() - lengthAt - 2
+	if length > 0xFFFF {
+		return ErrInvalidRecord
+	}
+	binary.BigEndian.PutUint16(buf.Bytes()[lengthAt:], uint16(length))
 	return nil
 }
 
-// writeName writes a DNS name to the buffer
-func (p *Parser) writeName(buf *bytes.Buffer, name string) error {
-	if name == "" || name == "." {
-		buf.WriteByte(0)
-		return nil
+// writeCompressedRData writes record data with its names compressed,
+// reporting false without writing if the type does not allow compression
+// or the data does not decode
+func (p *Parser) writeCompressedRData(buf *bytes.Buffer, record DNSRecord, names compressionMap) bool {
+	var prefix []byte
+	var targets []string
+	var suffix []byte
+
+	switch record.Type {
+	case TypeNS, TypeCNAME, TypePTR:
+		target, err := ParseTarget(record)
+		if err != nil {
+			return false
+		}
+		targets = []string{target.Target}
+	case TypeMX:
+		mx, err := ParseMX(record)
+		if err != nil {
+			return false
+		}
+		prefix = record.Data[:2]
+		targets = []string{mx.Exchange}
+	case TypeSOA:
+		soa, err := ParseSOA(record)
+		if err != nil {
+			return false
+		}
+		targets = []string{soa.MName, soa.RName}
+		suffix = record.Data[len(record.Data)-20:]
+	default:
+		return false
 	}
 
-	labels := strings.Split(name, ".")
-	if labels[len(labels)-1] == "" {
-		labels = labels[:len(labels)-1]
+	// Names that cannot be written leave the buffer as it was
+	start := buf.Len()
+	buf.Write(prefix)
+	for _, target := range targets {
+		if err := p.writeCompressedName(buf, target, names); err != nil {
+			buf.Truncate(start)
+			return false
+		}
 	}
+	buf.Write(suffix)
+	return true
+}
 
-	for _, label := range labels {
-		if len(label) > 63 {
-			return ErrInvalidLabel
+// writeCompressedName writes a DNS name to the buffer, pointing to the
+// longest suffix already in the message and recording the new ones
+func (p *Parser) writeCompressedName(buf *bytes.Buffer, name string, names compressionMap) error {
+	labels, err := wireLabels(name)
+	if err != nil {
+		return err
+	}
+
+	for i, label := range labels {
+		suffix := strings.Join(labels[i:], ".")
+		if offset, ok := names[suffix]; ok {
+			binary.Write(buf, binary.BigEndian, uint16(0xC000|offset))
+			return nil
+		}
+		if buf.Len() <= maxCompressionOffset {
+			names[suffix] = buf.Len()
 		}
+
 		buf.WriteByte(byte(len(label)))
 		buf.WriteString(label)
 	}
 	buf.WriteByte(0)
 	return nil
 }
+
+// writeName writes a DNS name to the buffer without compression
+func (p *Parser) writeName(buf *bytes.Buffer, name string) error {
+	labels, err := wireLabels(name)
+	if err != nil {
+		return err
+	}
+
+	for _, label := range labels {
+		buf.WriteByte(byte(len(label)))
+		buf.WriteString(label)
+	}
+	buf.WriteByte(0)
+	return nil
+}
+
+// wireLabels splits a name into its labels, checking that it can be
+// encoded. The root is written as "" or ".".
+func wireLabels(name string) ([]string, error) {
+	name = strings.TrimSuffix(name, ".")
+	if name == "" {
+		return nil, nil
+	}
+
+	labels := strings.Split(name, ".")
+	wireLen := 1
+	for _, label := range labels {
+		if len(label) == 0 || len(label) > 63 {
+			return nil, ErrInvalidLabel
+		}
+		wireLen += 1 + len(label)
+	}
+	if wireLen > maxNameLength {
+		return nil, ErrNameTooLong
+	}
+	return labels, nil
+}
@@ -1,7 +1,11 @@
 package dns
 
 import (
+	"bytes"
 	"encoding/binary"
+	"errors"
+	"net"
+	"reflect"
 	"testing"
 )
 
@@ -132,6 +136,143 @@ func TestParser_SerializeResponse(t *testing.T) {
 	}
 }
 
+func TestParser_TruncatedResponse(t *testing.T) {
+	parser := NewParser()
+
+	data, err := parser.SerializeResponse(&DNSResponse{
+		ID:           0x4321,
+		Question:     DNSQuestion{Name: "example.com", Type: TypeA, Class: ClassIN},
+		ResponseCode: RCodeServFail,
+		Truncated:    true,
+	})
+	if err != nil {
+		t.Fatalf("Failed to serialize response: %v", err)
+	}
+
+	parsed, err := parser.ParseResponse(data)
+	if err != nil {
+		t.Fatalf("Failed to parse response: %v", err)
+	}
+	if !parsed.Truncated {
+		t.Error("Expected TC bit to round-trip")
+	}
+	if parsed.ResponseCode != RCodeServFail {
+		t.Errorf("Expected rcode %d, got %d", RCodeServFail, parsed.ResponseCode)
+	}
+
+	// A truncated message may end mid-record; what was read is kept
+	full, _ := parser.SerializeResponse(&DNSResponse{
+		ID:       0x4321,
+		Question: DNSQuestion{Name: "example.com", Type: TypeA, Class: ClassIN},
+		Answers: []DNSRecord{
+			{Name: "example.com", Type: TypeA, Class: ClassIN, TTL: 60, Data: []byte{192, 0, 2, 1}},
+			{Name: "example.com", Type: TypeA, Class: ClassIN, TTL: 60, Data: []byte{192, 0, 2, 2}},
+		},
+		Truncated: true,
+	})
+	partial, err := parser.ParseResponse(full[:len(full)-3])
+	if err != nil {
+		t.Fatalf("Expected partial parse of truncated message, got %v", err)
+	}
+	if len(partial.Answers) != 1 {
+		t.Errorf("Expected 1 complete answer, got %d", len(partial.Answers))
+	}
+}
+
+func TestParser_EDNSQueryRoundTrip(t *testing.T) {
+	parser := NewParser()
+
+	subnet := []byte{0, 1, 24, 0, 192, 0, 2} // 192.0.2.0/24
+	query := &DNSQuery{
+		ID:       0x2222,
+		Question: DNSQuestion{Name: "example.com", Type: TypeA, Class: ClassIN},
+		EDNS: &EDNS{
+			UDPSize:  1232,
+			DNSSECOK: true,
+			Options:  []EDNSOption{{Code: EDNSOptionClientSubnet, Data: subnet}},
+		},
+	}
+
+	data, err := parser.SerializeQuery(query)
+	if err != nil {
+		t.Fatalf("Failed to serialize query: %v", err)
+	}
+
+	parsed, err := parser.ParseQuery(data)
+	if err != nil {
+		t.Fatalf("Failed to parse query: %v", err)
+	}
+	if parsed.EDNS == nil {
+		t.Fatal("Expected OPT record to be parsed")
+	}
+	if parsed.EDNS.UDPSize != 1232 || !parsed.EDNS.DNSSECOK || parsed.EDNS.Version != 0 {
+		t.Errorf("Unexpected EDNS parameters: %+v", parsed.EDNS)
+	}
+
+	option, ok := parsed.EDNS.Option(EDNSOptionClientSubnet)
+	if !ok {
+		t.Fatal("Expected Client Subnet option")
+	}
+	ecs, err := parseClientSubnet(option.Data)
+	if err != nil {
+		t.Fatalf("Failed to decode Client Subnet: %v", err)
+	}
+	if ecs.SourcePrefix != 24 || !ecs.Address.Equal(net.IPv4(192, 0, 2, 0)) {
+		t.Errorf("Unexpected Client Subnet %+v", ecs)
+	}
+
+	// Queries without OPT stay plain
+	data, _ = parser.SerializeQuery(&DNSQuery{ID: 1, Question: query.Question})
+	if parsed, _ = parser.ParseQuery(data); parsed.EDNS != nil {
+		t.Errorf("Expected no EDNS, got %+v", parsed.EDNS)
+	}
+}
+
+func TestParser_EDNSResponseRoundTrip(t *testing.T) {
+	parser := NewParser()
+
+	response := &DNSResponse{
+		ID:       0x3333,
+		Question: DNSQuestion{Name: "example.com", Type: TypeA, Class: ClassIN},
+		Answers: []DNSRecord{
+			{Name: "example.com", Type: TypeA, Class: ClassIN, TTL: 300, Data: []byte{192, 0, 2, 1}},
+		},
+		ResponseCode: RCodeNoError,
+		EDNS:         &EDNS{UDPSize: 4096, DNSSECOK: true},
+	}
+
+	data, err := parser.SerializeResponse(response)
+	if err != nil {
+		t.Fatalf("Failed to serialize response: %v", err)
+	}
+
+	parsed, err := parser.ParseResponse(data)
+	if err != nil {
+		t.Fatalf("Failed to parse response: %v", err)
+	}
+	if parsed.EDNS == nil || parsed.EDNS.UDPSize != 4096 || !parsed.EDNS.DNSSECOK {
+		t.Errorf("Expected EDNS to round-trip, got %+v", parsed.EDNS)
+	}
+	if len(parsed.Additional) != 0 {
+		t.Errorf("Expected OPT to be kept out of Additional, got %d records", len(parsed.Additional))
+	}
+
+	// Extended response codes are split between the header and OPT
+	response.Answers = nil
+	response.ResponseCode = RCodeBadVers
+	data, _ = parser.SerializeResponse(response)
+	if data[3]&0x0F != 0 {
+		t.Errorf("Expected header rcode 0 for BADVERS, got %d", data[3]&0x0F)
+	}
+	parsed, err = parser.ParseResponse(data)
+	if err != nil {
+		t.Fatalf("Failed to parse response: %v", err)
+	}
+	if parsed.ResponseCode != RCodeBadVers {
+		t.Errorf("Expected extended rcode %d, got %d", RCodeBadVers, parsed.ResponseCode)
+	}
+}
+
 func TestParser_ParseEmptyQuery(t *testing.T) {
 	parser := NewParser()
 
@@ -230,3 +371,170 @@ func createTestQuery() []byte {
 
 	return data
 }
+
+func TestParser_NameCompression(t *testing.T) {
+	parser := NewParser()
+
+	soa, _ := (&SOAData{MName: "ns1.example.com", RName: "hostmaster.example.com", Serial: 1, Minimum: 300}).Encode()
+	cname, _ := (&TargetData{Target: "cdn.example.com"}).Encode()
+	mx, _ := (&MXData{Preference: 10, Exchange: "mail.example.com"}).Encode()
+	response := &DNSResponse{
+		ID:       0x1234,
+		Question: DNSQuestion{Name: "www.example.com", Type: TypeA, Class: ClassIN},
+		Answers: []DNSRecord{
+			{Name: "www.example.com", Type: TypeCNAME, Class: ClassIN, TTL: 300, Data: cname},
+			{Name: "cdn.example.com", Type: TypeA, Class: ClassIN, TTL: 300, Data: []byte{192, 0, 2, 1}},
+			{Name: "cdn.example.com", Type: TypeA, Class: ClassIN, TTL: 300, Data: []byte{192, 0, 2, 2}},
+		},
+		Authorities: []DNSRecord{
+			{Name: "example.com", Type: TypeSOA, Class: ClassIN, TTL: 300, Data: soa},
+		},
+		Additional: []DNSRecord{
+			{Name: "example.com", Type: TypeMX, Class: ClassIN, TTL: 300, Data: mx},
+		},
+	}
+
+	data, err := parser.SerializeResponse(response)
+	if err != nil {
+		t.Fatalf("Failed to serialize response: %v", err)
+	}
+
+	// Each name after the question shrinks to at most a new label and a
+	// pointer; uncompressed this message is well over 250 bytes
+	if len(data) > 200 {
+		t.Errorf("Expected a compressed message, got %d bytes", len(data))
+	}
+	if bytes.Count(data, []byte("example")) != 1 {
+		t.Errorf("Expected \"example\" to be written once, found %d", bytes.Count(data, []byte("example")))
+	}
+
+	parsed, err := parser.ParseResponse(data)
+	if err != nil {
+		t.Fatalf("Failed to parse response: %v", err)
+	}
+	if !reflect.DeepEqual(parsed.Answers, response.Answers) ||
+		!reflect.DeepEqual(parsed.Authorities, response.Authorities) ||
+		!reflect.DeepEqual(parsed.Additional, response.Additional) {
+		t.Errorf("Records changed in round trip:\n got %+v\nwant %+v", parsed, response)
+	}
+
+	// Names that cannot be encoded are rejected rather than written
+	response.Answers[0].Name = "www..example.com"
+	if _, err := parser.SerializeResponse(response); !errors.Is(err, ErrInvalidLabel) {
+		t.Errorf("Expected ErrInvalidLabel, got %v", err)
+	}
+}
+
+func TestParser_CompressedRData(t *testing.T) {
+	// A response whose record data points back into the question
+	data := []byte{
+		0x12, 0x34, 0x81, 0x80, 0, 1, 0, 2, 0, 0, 0, 0,
+		// Question: example.com MX IN
+		7, 'e', 'x', 'a', 'm', 'p', 'l', 'e', 3, 'c', 'o', 'm', 0, 0, 15, 0, 1,
+		// example.com MX 10 mail.example.com
+		0xC0, 12, 0, 15, 0, 1, 0, 0, 0x0E, 0x10, 0, 9, 0, 10, 4, 'm', 'a', 'i', 'l', 0xC0, 12,
+		// _sip._udp.example.com SRV 1 2 5060 mail.example.com, compressed
+		// although RFC 2782 forbids it
+		4, '_', 's', 'i', 'p', 4, '_', 'u', 'd', 'p', 0xC0, 12, 0, 33, 0, 1, 0, 0, 0x0E, 0x10, 0, 8,
+		0, 1, 0, 2, 0x13, 0xC4, 0xC0, 43,
+	}
+
+	response, err := NewParser().ParseResponse(data)
+	if err != nil {
+		t.Fatalf("Failed to parse response: %v", err)
+	}
+	if len(response.Answers) != 2 {
+		t.Fatalf("Expected 2 answers, got %d", len(response.Answers))
+	}
+
+	mx, err := ParseMX(response.Answers[0])
+	if err != nil || mx.Preference != 10 || mx.Exchange != "mail.example.com" {
+		t.Errorf("Unexpected MX %+v, %v", mx, err)
+	}
+	srv, err := ParseSRV(response.Answers[1])
+	if err != nil || response.Answers[1].Name != "_sip._udp.example.com" || srv.Port != 5060 || srv.Target != "mail.example.com" {
+		t.Errorf("Unexpected SRV %+v, %v", srv, err)
+	}
+}
+
+func FuzzParseQuery(f *testing.F) {
+	parser := NewParser()
+
+	f.Add(createTestQuery())
+	if data, err := parser.SerializeQuery(&DNSQuery{
+		ID:       1,
+		Question: DNSQuestion{Name: "www.example.com", Type: TypeHTTPS, Class: ClassIN},
+		EDNS:     &EDNS{UDPSize: 1232, DNSSECOK: true, Options: []EDNSOption{{Code: 10, Data: []byte{1, 2, 3, 4, 5, 6, 7, 8}}}},
+	}); err == nil {
+		f.Add(data)
+	}
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		query, err := parser.ParseQuery(data)
+		if err != nil {
+			return
+		}
+
+		// Whatever parses must serialize to a query that parses the same
+		serialized, err := parser.SerializeQuery(query)
+		if err != nil {
+			return
+		}
+		again, err := parser.ParseQuery(serialized)
+		if err != nil {
+			t.Fatalf("Failed to parse serialized query: %v", err)
+		}
+		if again.Question != query.Question {
+			t.Errorf("Question changed in round trip: %+v, want %+v", again.Question, query.Question)
+		}
+	})
+}
+
+func FuzzParseResponse(f *testing.F) {
+	parser := NewParser()
+
+	soa, _ := (&SOAData{MName: "ns1.example.com", RName: "hostmaster.example.com", Serial: 1}).Encode()
+	https, _ := (&SVCBData{Priority: 1, Params: []SVCParam{{Key: SVCParamALPN, Value: []byte("\x02h2")}}}).Encode()
+	for _, response := range []*DNSResponse{
+		{
+			ID:       1,
+			Question: DNSQuestion{Name: "www.example.com", Type: TypeHTTPS, Class: ClassIN},
+			Answers:  []DNSRecord{{Name: "www.example.com", Type: TypeHTTPS, Class: ClassIN, TTL: 60, Data: https}},
+			EDNS:     &EDNS{UDPSize: 1232},
+		},
+		{
+			ID:           2,
+			Question:     DNSQuestion{Name: "missing.example.com", Type: TypeA, Class: ClassIN},
+			ResponseCode: RCodeNXDomain,
+			Authorities:  []DNSRecord{{Name: "example.com", Type: TypeSOA, Class: ClassIN, TTL: 60, Data: soa}},
+		},
+	} {
+		if data, err := parser.SerializeResponse(response); err == nil {
+			f.Add(data)
+		}
+	}
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		response, err := parser.ParseResponse(data)
+		if err != nil {
+			return
+		}
+
+		records := append(append(append([]DNSRecord{}, response.Answers...), response.Authorities...), response.Additional...)
+		for _, record := range records {
+			DecodeRData(record) // Must not panic on any data
+		}
+
+		serialized, err := parser.SerializeResponse(response)
+		if err != nil {
+			return
+		}
+		again, err := parser.ParseResponse(serialized)
+		if err != nil {
+			t.Fatalf("Failed to parse serialized response: %v", err)
+		}
+		if !reflect.DeepEqual(again.Answers, response.Answers) || !reflect.DeepEqual(again.Authorities, response.Authorities) {
+			t.Errorf("Records changed in round trip:\n got %+v\nwant %+v", again, response)
+		}
+	})
+}
@@ -0,0 +1,195 @@
+package dns
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"pihole-analyzer/internal/types"
+)
+
+// Query log statuses, numbered like Pi-hole FTL so analyzers treat records
+// from the embedded server and from the Pi-hole API alike
+const (
+	QueryStatusUnknown       = 0  // Not answered, e.g. unsupported EDNS version
+	QueryStatusGravity       = 1  // Blocked by a blocklist
+	QueryStatusForwarded     = 2  // Answered by an upstream
+	QueryStatusCached        = 3  // Answered from the cache, local records or a rewrite
+	QueryStatusRegex         = 4  // Blocked by a regex deny rule
+	QueryStatusDenylist      = 5  // Blocked by an exact or wildcard deny rule
+	QueryStatusGravityCNAME  = 9  // A CNAME target is on a blocklist
+	QueryStatusRegexCNAME    = 10 // A CNAME target matched a regex deny rule
+	QueryStatusDenylistCNAME = 11 // A CNAME target matched an exact or wildcard deny rule
+	QueryStatusStale         = 17 // Answered from an expired cache entry
+)
+
+// queryLogTimeFormat is the DateTime layout of Pi-hole query records
+const queryLogTimeFormat = "2006-01-02 15:04:05"
+
+// queryTypeNames maps query types to the names Pi-hole reports
+var queryTypeNames = map[uint16]string{
+	TypeA:      "A",
+	TypeNS:     "NS",
+	TypeCNAME:  "CNAME",
+	TypeSOA:    "SOA",
+	TypePTR:    "PTR",
+	TypeMX:     "MX",
+	TypeTXT:    "TXT",
+	TypeAAAA:   "AAAA",
+	TypeSRV:    "SRV",
+	35:         "NAPTR",
+	TypeDS:     "DS",
+	TypeRRSIG:  "RRSIG",
+	TypeDNSKEY: "DNSKEY",
+	64:         "SVCB",
+	65:         "HTTPS",
+	255:        "ANY",
+}
+
+// QueryTypeName returns the name of a query type, or "TYPEnnn" (RFC 3597)
+// for types without one
+func QueryTypeName(qtype uint16) string {
+	if name, ok := queryTypeNames[qtype]; ok {
+		return name
+	}
+	return fmt.Sprintf("TYPE%d", qtype)
+}
+
+// parseQueryTypeName returns the type of a name given by QueryTypeName
+func parseQueryTypeName(name string) (uint16, bool) {
+	for qtype, typeName := range queryTypeNames {
+		if typeName == name {
+			return qtype, true
+		}
+	}
+	if rest, ok := strings.CutPrefix(name, "TYPE"); ok {
+		if qtype, err := strconv.ParseUint(rest, 10, 16); err == nil {
+			return uint16(qtype), true
+		}
+	}
+	return 0, false
+}
+
+// isBlockedStatus reports whether a query log status is a blocked query
+func isBlockedStatus(status int) bool {
+	switch status {
+	case QueryStatusGravity, QueryStatusRegex, QueryStatusDenylist,
+		QueryStatusGravityCNAME, QueryStatusRegexCNAME, QueryStatusDenylistCNAME:
+		return true
+	}
+	return false
+}
+
+// QueryLog implements the DNSQueryLog interface as a ring buffer holding
+// the most recent queries
+type QueryLog struct {
+	mu      sync.RWMutex
+	records []types.PiholeRecord
+	next    int // Slot the next record is written to
+	full    bool
+	logged  int64
+	dropped int64
+}
+
+// NewQueryLog creates a query log holding up to size records
+func NewQueryLog(size int) *QueryLog {
+	return &QueryLog{
+		records: make([]types.PiholeRecord, size),
+	}
+}
+
+// Add appends a record, numbering it and overwriting the oldest record
+// once the log is full
+func (l *QueryLog) Add(record types.PiholeRecord) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if len(l.records) == 0 {
+		return
+	}
+
+	l.logged++
+	if l.full {
+		l.dropped++
+	}
+
+	record.ID = int(l.logged)
+	l.records[l.next] = record
+	l.next++
+	if l.next == len(l.records) {
+		l.next = 0
+		l.full = true
+	}
+}
+
+// Records returns the logged records, oldest first
+func (l *QueryLog) Records() []types.PiholeRecord {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	if !l.full {
+		return append([]types.PiholeRecord(nil), l.records[:l.next]...)
+	}
+
+	records := make([]types.PiholeRecord, 0, len(l.records))
+	records = append(records, l.records[l.next:]...)
+	return append(records, l.records[:l.next]...)
+}
+
+// GetStats returns query log statistics
+func (l *QueryLog) GetStats() *QueryLogStats {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	size := l.next
+	if l.full {
+		size = len(l.records)
+	}
+
+	return &QueryLogStats{
+		Size:    size,
+		MaxSize: len(l.records),
+		Logged:  l.logged,
+		Dropped: l.dropped,
+	}
+}
+
+// cnameStatus returns the status of a query blocked for a CNAME target
+// that was blocked with status
+func cnameStatus(status int) int {
+	switch status {
+	case QueryStatusRegex:
+		return QueryStatusRegexCNAME
+	case QueryStatusDenylist:
+		return QueryStatusDenylistCNAME
+	}
+	return QueryStatusGravityCNAME
+}
+
+// newQueryRecord describes a handled query as a Pi-hole query record
+func newQueryRecord(query *DNSQuery, response *DNSResponse, status int, at time.Time) types.PiholeRecord {
+	record := types.PiholeRecord{
+		DateTime:  at.Format(queryLogTimeFormat),
+		Timestamp: strconv.FormatInt(at.Unix(), 10),
+		Domain:    normalizeDomain(query.Question.Name),
+		QueryType: QueryTypeName(query.Question.Type),
+		Status:    status,
+	}
+
+	if ip := clientIP(query.Client); ip != nil {
+		record.Client = ip.String()
+	}
+	if response != nil {
+		record.ReplyTime = float64(response.ResponseTime) / float64(time.Millisecond)
+	}
+	if response != nil && status == QueryStatusForwarded {
+		record.Upstream = response.Upstream
+	}
+	if response != nil {
+		record.CNAME = response.BlockedCNAME
+	}
+
+	return record
+}
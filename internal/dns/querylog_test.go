@@ -0,0 +1,130 @@
+package dns
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"pihole-analyzer/internal/logger"
+	"pihole-analyzer/internal/types"
+)
+
+func TestQueryLog_Bounded(t *testing.T) {
+	log := NewQueryLog(3)
+
+	if records := log.Records(); len(records) != 0 {
+		t.Fatalf("Expected empty log, got %d records", len(records))
+	}
+
+	for _, domain := range []string{"a.example", "b.example", "c.example", "d.example", "e.example"} {
+		log.Add(types.PiholeRecord{Domain: domain})
+	}
+
+	records := log.Records()
+	if len(records) != 3 {
+		t.Fatalf("Expected 3 records, got %d", len(records))
+	}
+	for i, want := range []string{"c.example", "d.example", "e.example"} {
+		if records[i].Domain != want || records[i].ID != i+3 {
+			t.Errorf("Record %d = %s (ID %d), want %s (ID %d)", i, records[i].Domain, records[i].ID, want, i+3)
+		}
+	}
+
+	stats := log.GetStats()
+	if stats.Size != 3 || stats.MaxSize != 3 || stats.Logged != 5 || stats.Dropped != 2 {
+		t.Errorf("Unexpected stats: %+v", stats)
+	}
+}
+
+func TestQueryTypeName(t *testing.T) {
+	for _, qtype := range []uint16{TypeA, TypeAAAA, 65, 255, 999} {
+		name := QueryTypeName(qtype)
+		if parsed, ok := parseQueryTypeName(name); !ok || parsed != qtype {
+			t.Errorf("parseQueryTypeName(%s) = %d, %v; want %d", name, parsed, ok, qtype)
+		}
+	}
+	if name := QueryTypeName(999); name != "TYPE999" {
+		t.Errorf("Expected TYPE999, got %s", name)
+	}
+}
+
+func TestServer_QueryLog(t *testing.T) {
+	config := DefaultConfig()
+	config.LogQueries = false
+	config.Forwarder.HealthCheck = false
+	config.QueryLog.Size = 10
+	config.Local.Records = []LocalRecordConfig{{Name: "nas.lan", Type: "A", Value: "192.168.1.10"}}
+	config.Rules = RulesConfig{
+		Enabled: true,
+		Domains: []DomainRuleConfig{
+			{Domain: "ads.example", Kind: RuleKindExact, Action: RuleActionDeny, Enabled: true},
+			{Domain: "^track", Kind: RuleKindRegex, Action: RuleActionDeny, Enabled: true},
+		},
+	}
+	if err := config.Validate(); err != nil {
+		t.Fatalf("Validate failed: %v", err)
+	}
+
+	testLogger := logger.New(&logger.Config{
+		Level:     logger.LogLevel("ERROR"),
+		Component: "dns-test",
+	})
+	server := NewServer(config, testLogger).(*Server)
+	server.forwarder = newFakeForwarder("round_robin", map[string]*fakeTransport{"upstream:53": {}})
+	if err := server.ReloadLocalRecords(); err != nil {
+		t.Fatalf("ReloadLocalRecords failed: %v", err)
+	}
+	if err := server.ReloadRules(config.Rules); err != nil {
+		t.Fatalf("ReloadRules failed: %v", err)
+	}
+
+	queries := []struct {
+		name   string
+		qtype  uint16
+		status int
+	}{
+		{"example.com", TypeA, QueryStatusForwarded},
+		{"example.com", TypeA, QueryStatusCached},
+		{"nas.lan", TypeA, QueryStatusCached},
+		{"ads.example", TypeAAAA, QueryStatusDenylist},
+		{"tracker.example", TypeA, QueryStatusRegex},
+	}
+
+	for _, q := range queries {
+		if _, err := server.HandleQuery(context.Background(), &DNSQuery{
+			ID:       3,
+			Question: DNSQuestion{Name: q.name, Type: q.qtype, Class: ClassIN},
+			Client:   &net.UDPAddr{IP: net.ParseIP("192.168.1.50"), Port: 5000},
+			Protocol: "udp",
+		}); err != nil {
+			t.Fatalf("HandleQuery(%s) failed: %v", q.name, err)
+		}
+	}
+
+	records := server.QueryLog().Records()
+	if len(records) != len(queries) {
+		t.Fatalf("Expected %d logged queries, got %d", len(queries), len(records))
+	}
+	for i, q := range queries {
+		record := records[i]
+		if record.Domain != normalizeDomain(q.name) || record.Status != q.status ||
+			record.QueryType != QueryTypeName(q.qtype) || record.Client != "192.168.1.50" {
+			t.Errorf("Record %d = %+v, want %s/%s with status %d", i, record, q.name, QueryTypeName(q.qtype), q.status)
+		}
+		if record.DateTime == "" || record.Timestamp == "" {
+			t.Errorf("Record %d has no time", i)
+		}
+	}
+
+	if records[0].Upstream != "upstream:53" {
+		t.Errorf("Expected forwarded query to name its upstream, got %q", records[0].Upstream)
+	}
+	if records[1].Upstream != "" {
+		t.Errorf("Expected no upstream for a cached answer, got %q", records[1].Upstream)
+	}
+
+	config.QueryLog.Enabled = false
+	if server := NewServer(config, testLogger).(*Server); server.QueryLog() != nil {
+		t.Error("Expected no query log when disabled")
+	}
+}
@@ -0,0 +1,248 @@
+package dns
+
+import (
+	"fmt"
+	"math"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// RateLimiter enforces per-client token bucket query limits and response
+// rate limiting (RRL) of identical responses. Query buckets are keyed by
+// client address, so one misbehaving device cannot exhaust its neighbours'
+// budget; RRL buckets by a wider prefix, so a spoofed network shares one.
+type RateLimiter struct {
+	config RateLimitConfig
+	exempt []*net.IPNet
+	now    func() time.Time
+
+	mu        sync.Mutex
+	queries   *bucketSet
+	responses *bucketSet
+}
+
+// bucketSet holds the buckets of one limit in least recently used order,
+// so the set stays bounded without scanning it
+type bucketSet struct {
+	buckets map[string]*tokenBucket
+	head    *tokenBucket // Most recently used
+	tail    *tokenBucket // Least recently used
+}
+
+// tokenBucket holds the tokens left for one key
+type tokenBucket struct {
+	key    string
+	tokens float64
+	last   time.Time
+
+	prev *tokenBucket
+	next *tokenBucket
+}
+
+// NewRateLimiter creates a rate limiter from a validated config
+func NewRateLimiter(config RateLimitConfig) *RateLimiter {
+	limiter := &RateLimiter{
+		config:    config,
+		now:       time.Now,
+		queries:   newBucketSet(),
+		responses: newBucketSet(),
+	}
+
+	for _, exempt := range config.Exempt {
+		if network, err := parseNetwork(exempt); err == nil {
+			limiter.exempt = append(limiter.exempt, network)
+		}
+	}
+
+	return limiter
+}
+
+// AllowQuery takes a token from the client's query bucket, reporting
+// false once the client exceeded its rate
+func (l *RateLimiter) AllowQuery(client net.Addr) bool {
+	if l.config.QueriesPerSecond <= 0 {
+		return true
+	}
+
+	prefix, ok := l.clientPrefix(client, l.config.QueryIPv4PrefixLength, l.config.QueryIPv6PrefixLength)
+	if !ok {
+		return true
+	}
+
+	burst := math.Max(float64(l.config.Burst), 1)
+	return l.take(l.queries, prefix, l.config.QueriesPerSecond, burst)
+}
+
+// AllowResponse takes a token from the bucket of identical responses to
+// the client's prefix, reporting false once they exceeded their rate
+func (l *RateLimiter) AllowResponse(client net.Addr, response *DNSResponse) bool {
+	if l.config.ResponsesPerSecond <= 0 {
+		return true
+	}
+
+	prefix, ok := l.clientPrefix(client, l.config.IPv4PrefixLength, l.config.IPv6PrefixLength)
+	if !ok {
+		return true
+	}
+
+	// A second's worth of identical responses may arrive at once
+	burst := math.Max(math.Ceil(l.config.ResponsesPerSecond), 1)
+	return l.take(l.responses, prefix+"|"+responseKey(response), l.config.ResponsesPerSecond, burst)
+}
+
+// take refills a bucket for the time elapsed and removes a token
+func (l *RateLimiter) take(set *bucketSet, key string, rate, burst float64) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	bucket, exists := set.buckets[key]
+	if exists {
+		set.unlink(bucket)
+	} else {
+		// Make room by forgetting the bucket idle for longest
+		if len(set.buckets) >= l.config.MaxTracked && set.tail != nil {
+			evicted := set.tail
+			set.unlink(evicted)
+			delete(set.buckets, evicted.key)
+		}
+		bucket = &tokenBucket{key: key, tokens: burst, last: now}
+		set.buckets[key] = bucket
+	}
+	set.pushFront(bucket)
+
+	bucket.tokens = math.Min(burst, bucket.tokens+now.Sub(bucket.last).Seconds()*rate)
+	bucket.last = now
+
+	if bucket.tokens < 1 {
+		return false
+	}
+	bucket.tokens--
+	return true
+}
+
+// newBucketSet creates an empty bucket set
+func newBucketSet() *bucketSet {
+	return &bucketSet{buckets: make(map[string]*tokenBucket)}
+}
+
+// pushFront marks a bucket as the most recently used
+func (s *bucketSet) pushFront(bucket *tokenBucket) {
+	bucket.prev = nil
+	bucket.next = s.head
+	if s.head != nil {
+		s.head.prev = bucket
+	}
+	s.head = bucket
+	if s.tail == nil {
+		s.tail = bucket
+	}
+}
+
+// unlink removes a bucket from the usage order
+func (s *bucketSet) unlink(bucket *tokenBucket) {
+	if bucket.prev != nil {
+		bucket.prev.next = bucket.next
+	} else {
+		s.head = bucket.next
+	}
+	if bucket.next != nil {
+		bucket.next.prev = bucket.prev
+	} else {
+		s.tail = bucket.prev
+	}
+	bucket.prev = nil
+	bucket.next = nil
+}
+
+// clientPrefix returns the prefix of the given lengths a client is limited
+// by, reporting false for exempt or unknown clients
+func (l *RateLimiter) clientPrefix(client net.Addr, ipv4Length, ipv6Length int) (string, bool) {
+	ip := clientIP(client)
+	if ip == nil {
+		return "", false
+	}
+
+	for _, network := range l.exempt {
+		if network.Contains(ip) {
+			return "", false
+		}
+	}
+
+	if ip4 := ip.To4(); ip4 != nil {
+		mask := net.CIDRMask(ipv4Length, 8*net.IPv4len)
+		return ip4.Mask(mask).String() + "/" + strconv.Itoa(ipv4Length), true
+	}
+	mask := net.CIDRMask(ipv6Length, 8*net.IPv6len)
+	return ip.Mask(mask).String() + "/" + strconv.Itoa(ipv6Length), true
+}
+
+// responseKey identifies identical responses. Answers are keyed by name
+// and type; negative answers by the zone that denies them, so random
+// subdomains of one zone share a budget; errors share a single key.
+func responseKey(response *DNSResponse) string {
+	question := response.Question
+	switch {
+	case response.ResponseCode == RCodeNoError && len(response.Answers) > 0:
+		return fmt.Sprintf("answer|%s|%d", normalizeDomain(question.Name), question.Type)
+	case isNegativeResponse(response):
+		zone := normalizeDomain(question.Name)
+		for _, record := range response.Authorities {
+			if record.Type == TypeSOA {
+				zone = normalizeDomain(record.Name)
+				break
+			}
+		}
+		return fmt.Sprintf("negative|%s|%d", zone, response.ResponseCode)
+	}
+	return fmt.Sprintf("error|%d", response.ResponseCode)
+}
+
+// validate checks rate limit settings
+func (c RateLimitConfig) validate() error {
+	if c.QueriesPerSecond < 0 || c.ResponsesPerSecond < 0 || c.Burst < 0 {
+		return fmt.Errorf("%w: negative rate or burst", ErrInvalidRateLimit)
+	}
+	for _, action := range []string{c.Action, c.ResponseAction} {
+		switch action {
+		case RateLimitActionDrop, RateLimitActionRefuse, RateLimitActionTruncate:
+		default:
+			return fmt.Errorf("%w: unknown action %q", ErrInvalidRateLimit, action)
+		}
+	}
+	if c.IPv4PrefixLength < 1 || c.IPv4PrefixLength > 32 || c.IPv6PrefixLength < 1 || c.IPv6PrefixLength > 128 {
+		return fmt.Errorf("%w: invalid prefix length", ErrInvalidRateLimit)
+	}
+	if c.QueryIPv4PrefixLength < 1 || c.QueryIPv4PrefixLength > 32 || c.QueryIPv6PrefixLength < 1 || c.QueryIPv6PrefixLength > 128 {
+		return fmt.Errorf("%w: invalid query prefix length", ErrInvalidRateLimit)
+	}
+	if c.MaxTracked < 1 {
+		return fmt.Errorf("%w: max tracked must be positive", ErrInvalidRateLimit)
+	}
+	for _, exempt := range c.Exempt {
+		if _, err := parseNetwork(exempt); err != nil {
+			return fmt.Errorf("%w: invalid exempt network %q", ErrInvalidRateLimit, exempt)
+		}
+	}
+	return nil
+}
+
+// parseNetwork parses a CIDR, or a single address as a host network
+func parseNetwork(value string) (*net.IPNet, error) {
+	if strings.Contains(value, "/") {
+		_, network, err := net.ParseCIDR(value)
+		return network, err
+	}
+
+	ip := net.ParseIP(value)
+	if ip == nil {
+		return nil, fmt.Errorf("invalid address %q", value)
+	}
+	if ip4 := ip.To4(); ip4 != nil {
+		return &net.IPNet{IP: ip4, Mask: net.CIDRMask(32, 32)}, nil
+	}
+	return &net.IPNet{IP: ip, Mask: net.CIDRMask(128, 128)}, nil
+}
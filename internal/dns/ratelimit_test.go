@@ -0,0 +1,280 @@
+package dns
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"pihole-analyzer/internal/logger"
+)
+
+func testRateLimitConfig() RateLimitConfig {
+	config := DefaultConfig().RateLimit
+	config.Enabled = true
+	config.QueriesPerSecond = 2
+	config.Burst = 3
+	config.ResponsesPerSecond = 2
+	return config
+}
+
+func TestRateLimiter_AllowQuery(t *testing.T) {
+	limiter := NewRateLimiter(testRateLimitConfig())
+	now := time.Unix(1700000000, 0)
+	limiter.now = func() time.Time { return now }
+
+	client := &net.UDPAddr{IP: net.ParseIP("192.0.2.10"), Port: 5000}
+	neighbour := &net.UDPAddr{IP: net.ParseIP("192.0.2.99"), Port: 5000}
+	other := &net.UDPAddr{IP: net.ParseIP("198.51.100.1"), Port: 5000}
+
+	// Each address has its own burst, even within one /24
+	for i := 0; i < 3; i++ {
+		if !limiter.AllowQuery(client) {
+			t.Fatalf("Query %d within burst was limited", i)
+		}
+	}
+	if limiter.AllowQuery(client) {
+		t.Error("Expected query over burst to be limited")
+	}
+	for i := 0; i < 3; i++ {
+		if !limiter.AllowQuery(neighbour) {
+			t.Fatalf("Neighbour query %d was limited by another address's budget", i)
+		}
+	}
+	if !limiter.AllowQuery(other) {
+		t.Error("Expected another prefix to have its own bucket")
+	}
+
+	// Two tokens per second refill
+	now = now.Add(time.Second)
+	if !limiter.AllowQuery(client) || !limiter.AllowQuery(client) || limiter.AllowQuery(client) {
+		t.Error("Expected two queries to be allowed after one second")
+	}
+
+	// Shorter query prefixes aggregate clients again
+	config := testRateLimitConfig()
+	config.QueryIPv4PrefixLength = 24
+	shared := NewRateLimiter(config)
+	shared.now = limiter.now
+	for _, addr := range []net.Addr{client, neighbour, client} {
+		shared.AllowQuery(addr)
+	}
+	if shared.AllowQuery(neighbour) {
+		t.Error("Expected a /24 query prefix to share the burst")
+	}
+
+	// Exempt clients are never limited
+	loopback := &net.UDPAddr{IP: net.IPv6loopback, Port: 5000}
+	for i := 0; i < 10; i++ {
+		if !limiter.AllowQuery(loopback) {
+			t.Fatal("Expected loopback to be exempt")
+		}
+	}
+}
+
+func TestRateLimiter_AllowResponse(t *testing.T) {
+	limiter := NewRateLimiter(testRateLimitConfig())
+	limiter.now = func() time.Time { return time.Unix(1700000000, 0) }
+
+	response := &DNSResponse{
+		Question: DNSQuestion{Name: "example.com", Type: TypeA, Class: ClassIN},
+		Answers:  []DNSRecord{{Name: "example.com", Type: TypeA, Class: ClassIN, Data: []byte{192, 0, 2, 1}}},
+	}
+	client := &net.UDPAddr{IP: net.ParseIP("192.0.2.10"), Port: 5000}
+	neighbour := &net.UDPAddr{IP: net.ParseIP("192.0.2.99"), Port: 5000}
+
+	// Identical responses to one /24 share a bucket, so spoofing addresses
+	// within the victim's network does not multiply the rate
+	if !limiter.AllowResponse(client, response) || !limiter.AllowResponse(neighbour, response) {
+		t.Fatal("Expected responses within the rate to be allowed")
+	}
+	if limiter.AllowResponse(neighbour, response) {
+		t.Error("Expected the /24 to share the response rate")
+	}
+}
+
+func TestRateLimiter_MaxTracked(t *testing.T) {
+	config := testRateLimitConfig()
+	config.MaxTracked = 2
+	limiter := NewRateLimiter(config)
+	limiter.now = func() time.Time { return time.Unix(1700000000, 0) }
+
+	idle := &net.UDPAddr{IP: net.ParseIP("10.0.1.1")}
+	busy := &net.UDPAddr{IP: net.ParseIP("10.0.2.1")}
+	flood := &net.UDPAddr{IP: net.ParseIP("10.0.3.1")}
+
+	limiter.AllowQuery(idle)
+	for i := 0; i < 3; i++ {
+		limiter.AllowQuery(busy)
+	}
+
+	// A new client evicts the least recently used bucket
+	limiter.AllowQuery(flood)
+	if len(limiter.queries.buckets) != 2 {
+		t.Errorf("Expected 2 tracked buckets, got %d", len(limiter.queries.buckets))
+	}
+	if _, ok := limiter.queries.buckets["10.0.1.1/32"]; ok {
+		t.Error("Expected the idle bucket to be evicted")
+	}
+	if limiter.AllowQuery(busy) {
+		t.Error("Expected the recently used bucket to stay limited")
+	}
+
+	// Clients tracked past the limit are still limited
+	limiter.AllowQuery(flood)
+	limiter.AllowQuery(flood)
+	if limiter.AllowQuery(flood) {
+		t.Error("Expected a newly tracked client to be limited over its burst")
+	}
+}
+
+func TestResponseKey(t *testing.T) {
+	soa := DNSRecord{Name: "example.com", Type: TypeSOA, Class: ClassIN}
+	nxdomain := func(name string) *DNSResponse {
+		return &DNSResponse{
+			Question:     DNSQuestion{Name: name, Type: TypeA, Class: ClassIN},
+			ResponseCode: RCodeNXDomain,
+			Authorities:  []DNSRecord{soa},
+		}
+	}
+
+	// Random subdomains of one zone are the same response
+	if responseKey(nxdomain("a1.example.com")) != responseKey(nxdomain("b2.example.com")) {
+		t.Error("Expected NXDOMAIN answers from one zone to share a key")
+	}
+
+	answer := func(name string) *DNSResponse {
+		return &DNSResponse{
+			Question: DNSQuestion{Name: name, Type: TypeA, Class: ClassIN},
+			Answers:  []DNSRecord{{Name: name, Type: TypeA, Class: ClassIN, Data: []byte{192, 0, 2, 1}}},
+		}
+	}
+	if responseKey(answer("a.example.com")) == responseKey(answer("b.example.com")) {
+		t.Error("Expected answers for different names to have different keys")
+	}
+	if responseKey(answer("A.example.com.")) != responseKey(answer("a.example.com")) {
+		t.Error("Expected names to be compared case-insensitively")
+	}
+}
+
+func TestServer_RateLimit(t *testing.T) {
+	testLogger := logger.New(&logger.Config{
+		Level:     logger.LogLevel("ERROR"),
+		Component: "dns-test",
+	})
+
+	newServer := func(action string, queries, responses float64) *Server {
+		config := DefaultConfig()
+		config.LogQueries = false
+		config.Forwarder.HealthCheck = false
+		config.RateLimit = testRateLimitConfig()
+		config.RateLimit.Action = action
+		config.RateLimit.ResponseAction = action
+		config.RateLimit.QueriesPerSecond = queries
+		config.RateLimit.ResponsesPerSecond = responses
+		config.RateLimit.Burst = 1
+		if err := config.Validate(); err != nil {
+			t.Fatalf("Validate failed: %v", err)
+		}
+
+		server := NewServer(config, testLogger).(*Server)
+		server.forwarder = newFakeForwarder("round_robin", map[string]*fakeTransport{"upstream:53": {}})
+		server.limiter.now = func() time.Time { return time.Unix(1700000000, 0) }
+		return server
+	}
+
+	query := func(server *Server, protocol string) (*DNSResponse, error) {
+		return server.HandleQuery(context.Background(), &DNSQuery{
+			ID:       5,
+			Question: DNSQuestion{Name: "example.com", Type: TypeA, Class: ClassIN},
+			Client:   &net.UDPAddr{IP: net.ParseIP("192.0.2.10"), Port: 5000},
+			Protocol: protocol,
+		})
+	}
+
+	tests := []struct {
+		action    string
+		protocol  string
+		queries   float64
+		responses float64
+		check     func(*DNSResponse, error) bool
+	}{
+		{RateLimitActionDrop, "udp", 1, 0, func(r *DNSResponse, err error) bool {
+			return r == nil && errors.Is(err, ErrRateLimited)
+		}},
+		{RateLimitActionRefuse, "udp", 1, 0, func(r *DNSResponse, err error) bool {
+			return err == nil && r.ResponseCode == RCodeRefused && len(r.Answers) == 0
+		}},
+		{RateLimitActionTruncate, "udp", 1, 0, func(r *DNSResponse, err error) bool {
+			return err == nil && r.Truncated && r.ResponseCode == RCodeNoError && len(r.Answers) == 0
+		}},
+		{RateLimitActionTruncate, "tcp", 1, 0, func(r *DNSResponse, err error) bool {
+			return err == nil && r.ResponseCode == RCodeRefused
+		}},
+		{RateLimitActionTruncate, "udp", 0, 1, func(r *DNSResponse, err error) bool {
+			return err == nil && r.Truncated
+		}},
+	}
+
+	for _, tt := range tests {
+		server := newServer(tt.action, tt.queries, tt.responses)
+
+		var response *DNSResponse
+		var err error
+		for i := 0; i < 5; i++ {
+			response, err = query(server, tt.protocol)
+		}
+		if !tt.check(response, err) {
+			t.Errorf("%s over %s (queries %v, responses %v): unexpected response %+v, %v",
+				tt.action, tt.protocol, tt.queries, tt.responses, response, err)
+		}
+	}
+
+	// Responses over TCP are never limited by RRL
+	server := newServer(RateLimitActionRefuse, 0, 1)
+	for i := 0; i < 5; i++ {
+		if response, err := query(server, "tcp"); err != nil || response.ResponseCode != RCodeNoError {
+			t.Fatalf("Expected TCP answer, got %+v, %v", response, err)
+		}
+	}
+
+	server = newServer(RateLimitActionRefuse, 1, 1)
+	for i := 0; i < 4; i++ {
+		query(server, "udp")
+	}
+	stats := server.GetStats()
+	if stats.RateLimitedQueries != 3 || stats.RateLimitRefused != 3 || stats.RateLimitedResponses != 0 {
+		t.Errorf("Unexpected rate limit stats: %d queries, %d responses, %d refused",
+			stats.RateLimitedQueries, stats.RateLimitedResponses, stats.RateLimitRefused)
+	}
+}
+
+func TestConfig_RateLimitValidation(t *testing.T) {
+	invalid := []func(*RateLimitConfig){
+		func(c *RateLimitConfig) { c.QueriesPerSecond = -1 },
+		func(c *RateLimitConfig) { c.Action = "block" },
+		func(c *RateLimitConfig) { c.ResponseAction = "" },
+		func(c *RateLimitConfig) { c.IPv4PrefixLength = 33 },
+		func(c *RateLimitConfig) { c.IPv6PrefixLength = 0 },
+		func(c *RateLimitConfig) { c.QueryIPv4PrefixLength = 0 },
+		func(c *RateLimitConfig) { c.QueryIPv6PrefixLength = 129 },
+		func(c *RateLimitConfig) { c.MaxTracked = 0 },
+		func(c *RateLimitConfig) { c.Exempt = []string{"not-an-ip"} },
+	}
+
+	for i, mutate := range invalid {
+		config := DefaultConfig()
+		config.RateLimit = testRateLimitConfig()
+		mutate(&config.RateLimit)
+		if err := config.Validate(); !errors.Is(err, ErrInvalidRateLimit) {
+			t.Errorf("Case %d: expected ErrInvalidRateLimit, got %v", i, err)
+		}
+	}
+
+	config := DefaultConfig()
+	config.RateLimit = testRateLimitConfig()
+	if err := config.Validate(); err != nil {
+		t.Errorf("Expected valid rate limit config, got %v", err)
+	}
+}
@@ -0,0 +1,430 @@
+package dns
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"sort"
+)
+
+// SVCB and HTTPS service parameter keys (RFC 9460 section 14.3.2)
+const (
+	SVCParamMandatory     uint16 = 0
+	SVCParamALPN          uint16 = 1
+	SVCParamNoDefaultALPN uint16 = 2
+	SVCParamPort          uint16 = 3
+	SVCParamIPv4Hint      uint16 = 4
+	SVCParamECH           uint16 = 5
+	SVCParamIPv6Hint      uint16 = 6
+)
+
+// CAAFlagCritical marks a CAA property the issuer must understand
+const CAAFlagCritical uint8 = 1 << 7
+
+// RData is the typed data of a resource record
+type RData interface {
+	// Encode returns the uncompressed wire form of the data
+	Encode() ([]byte, error)
+}
+
+// AddressData is the decoded data of an A or AAAA record
+type AddressData struct {
+	IP net.IP
+}
+
+// TargetData is the decoded data of a CNAME, NS or PTR record: a single
+// domain name
+type TargetData struct {
+	Target string
+}
+
+// MXData is the decoded data of an MX record
+type MXData struct {
+	Preference uint16
+	Exchange   string
+}
+
+// TXTData is the decoded data of a TXT record; each string is at most 255
+// bytes long
+type TXTData struct {
+	Strings []string
+}
+
+// SRVData is the decoded data of an SRV record (RFC 2782)
+type SRVData struct {
+	Priority uint16
+	Weight   uint16
+	Port     uint16
+	Target   string
+}
+
+// CAAData is the decoded data of a CAA record (RFC 8659)
+type CAAData struct {
+	Flags uint8
+	Tag   string // Property, such as "issue" or "iodef"
+	Value string
+}
+
+// SVCBData is the decoded data of an SVCB or HTTPS record (RFC 9460).
+// Priority 0 is AliasMode; any other priority is ServiceMode.
+type SVCBData struct {
+	Priority uint16
+	Target   string
+	Params   []SVCParam // Ordered by key
+}
+
+// SVCParam is a single SVCB service parameter in wire form
+type SVCParam struct {
+	Key   uint16
+	Value []byte
+}
+
+// DecodeRData decodes the data of a record of a supported type. The result
+// is one of *AddressData, *TargetData, *MXData, *TXTData, *SRVData,
+// *SOAData, *CAAData or *SVCBData.
+func DecodeRData(record DNSRecord) (RData, error) {
+	switch record.Type {
+	case TypeA, TypeAAAA:
+		return ParseAddress(record)
+	case TypeCNAME, TypeNS, TypePTR:
+		return ParseTarget(record)
+	case TypeMX:
+		return ParseMX(record)
+	case TypeTXT:
+		return ParseTXT(record)
+	case TypeSRV:
+		return ParseSRV(record)
+	case TypeSOA:
+		return ParseSOA(record)
+	case TypeCAA:
+		return ParseCAA(record)
+	case TypeSVCB, TypeHTTPS:
+		return ParseSVCB(record)
+	default:
+		return nil, fmt.Errorf("%w: %s", ErrUnsupportedRRType, RecordTypeName(record.Type))
+	}
+}
+
+// NewRecord builds an IN class record from typed data, checking that the
+// data fits the record type
+func NewRecord(name string, rtype uint16, ttl uint32, data RData) (DNSRecord, error) {
+	rdata, err := data.Encode()
+	if err != nil {
+		return DNSRecord{}, err
+	}
+
+	record := DNSRecord{Name: name, Type: rtype, Class: ClassIN, TTL: ttl, Data: rdata}
+	if _, err := DecodeRData(record); err != nil {
+		return DNSRecord{}, err
+	}
+	return record, nil
+}
+
+// ParseAddress decodes the data of an A or AAAA record
+func ParseAddress(record DNSRecord) (*AddressData, error) {
+	switch {
+	case record.Type == TypeA && len(record.Data) == net.IPv4len:
+	case record.Type == TypeAAAA && len(record.Data) == net.IPv6len:
+	default:
+		return nil, ErrInvalidRecord
+	}
+
+	ip := make(net.IP, len(record.Data))
+	copy(ip, record.Data)
+	return &AddressData{IP: ip}, nil
+}
+
+// ParseTarget decodes the data of a CNAME, NS or PTR record
+func ParseTarget(record DNSRecord) (*TargetData, error) {
+	if record.Type != TypeCNAME && record.Type != TypeNS && record.Type != TypePTR {
+		return nil, ErrInvalidRecord
+	}
+
+	target, err := parseRDataName(record.Data, 0)
+	if err != nil {
+		return nil, err
+	}
+	return &TargetData{Target: target}, nil
+}
+
+// ParseMX decodes the data of an MX record
+func ParseMX(record DNSRecord) (*MXData, error) {
+	if record.Type != TypeMX || len(record.Data) < 3 {
+		return nil, ErrInvalidRecord
+	}
+
+	exchange, err := parseRDataName(record.Data, 2)
+	if err != nil {
+		return nil, err
+	}
+	return &MXData{
+		Preference: binary.BigEndian.Uint16(record.Data[0:2]),
+		Exchange:   exchange,
+	}, nil
+}
+
+// ParseTXT decodes the data of a TXT record
+func ParseTXT(record DNSRecord) (*TXTData, error) {
+	if record.Type != TypeTXT || len(record.Data) == 0 {
+		return nil, ErrInvalidRecord
+	}
+
+	strs, err := parseCharacterStrings(record.Data)
+	if err != nil {
+		return nil, err
+	}
+	return &TXTData{Strings: strs}, nil
+}
+
+// ParseSRV decodes the data of an SRV record
+func ParseSRV(record DNSRecord) (*SRVData, error) {
+	if record.Type != TypeSRV || len(record.Data) < 7 {
+		return nil, ErrInvalidRecord
+	}
+
+	target, err := parseRDataName(record.Data, 6)
+	if err != nil {
+		return nil, err
+	}
+	return &SRVData{
+		Priority: binary.BigEndian.Uint16(record.Data[0:2]),
+		Weight:   binary.BigEndian.Uint16(record.Data[2:4]),
+		Port:     binary.BigEndian.Uint16(record.Data[4:6]),
+		Target:   target,
+	}, nil
+}
+
+// ParseCAA decodes the data of a CAA record
+func ParseCAA(record DNSRecord) (*CAAData, error) {
+	data := record.Data
+	if record.Type != TypeCAA || len(data) < 2 {
+		return nil, ErrInvalidRecord
+	}
+
+	tagLen := int(data[1])
+	if tagLen == 0 || 2+tagLen > len(data) {
+		return nil, ErrInvalidRecord
+	}
+	return &CAAData{
+		Flags: data[0],
+		Tag:   string(data[2 : 2+tagLen]),
+		Value: string(data[2+tagLen:]),
+	}, nil
+}
+
+// ParseSVCB decodes the data of an SVCB or HTTPS record
+func ParseSVCB(record DNSRecord) (*SVCBData, error) {
+	data := record.Data
+	if (record.Type != TypeSVCB && record.Type != TypeHTTPS) || len(data) < 3 {
+		return nil, ErrInvalidRecord
+	}
+
+	// The target name is never compressed (RFC 9460 section 2.2)
+	target, offset, err := (&Parser{}).parseName(data, 2)
+	if err != nil {
+		return nil, err
+	}
+
+	svcb := &SVCBData{
+		Priority: binary.BigEndian.Uint16(data[0:2]),
+		Target:   target,
+	}
+	for offset < len(data) {
+		if offset+4 > len(data) {
+			return nil, ErrInvalidRecord
+		}
+		key := binary.BigEndian.Uint16(data[offset : offset+2])
+		length := int(binary.BigEndian.Uint16(data[offset+2 : offset+4]))
+		offset += 4
+		if offset+length > len(data) {
+			return nil, ErrInvalidRecord
+		}
+
+		// Keys must appear in strictly increasing order
+		if n := len(svcb.Params); n > 0 && key <= svcb.Params[n-1].Key {
+			return nil, ErrInvalidRecord
+		}
+
+		value := make([]byte, length)
+		copy(value, data[offset:offset+length])
+		svcb.Params = append(svcb.Params, SVCParam{Key: key, Value: value})
+		offset += length
+	}
+
+	return svcb, nil
+}
+
+// Encode implements RData
+func (a *AddressData) Encode() ([]byte, error) {
+	if ip4 := a.IP.To4(); ip4 != nil {
+		return []byte(ip4), nil
+	}
+	if len(a.IP) != net.IPv6len {
+		return nil, ErrInvalidRecord
+	}
+	return []byte(a.IP), nil
+}
+
+// Encode implements RData
+func (t *TargetData) Encode() ([]byte, error) {
+	var buf bytes.Buffer
+	if err := (&Parser{}).writeName(&buf, t.Target); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// Encode implements RData
+func (m *MXData) Encode() ([]byte, error) {
+	buf := bytes.NewBuffer(binary.BigEndian.AppendUint16(nil, m.Preference))
+	if err := (&Parser{}).writeName(buf, m.Exchange); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// Encode implements RData. A record without strings holds a single empty
+// string, as the data may not be empty.
+func (t *TXTData) Encode() ([]byte, error) {
+	strs := t.Strings
+	if len(strs) == 0 {
+		strs = []string{""}
+	}
+
+	var data []byte
+	for _, s := range strs {
+		if len(s) > 255 {
+			return nil, fmt.Errorf("%w: TXT string longer than 255 bytes", ErrInvalidRecord)
+		}
+		data = append(data, byte(len(s)))
+		data = append(data, s...)
+	}
+	return data, nil
+}
+
+// Encode implements RData
+func (s *SRVData) Encode() ([]byte, error) {
+	data := binary.BigEndian.AppendUint16(nil, s.Priority)
+	data = binary.BigEndian.AppendUint16(data, s.Weight)
+	data = binary.BigEndian.AppendUint16(data, s.Port)
+
+	buf := bytes.NewBuffer(data)
+	if err := (&Parser{}).writeName(buf, s.Target); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// Encode implements RData
+func (c *CAAData) Encode() ([]byte, error) {
+	if len(c.Tag) == 0 || len(c.Tag) > 255 {
+		return nil, fmt.Errorf("%w: invalid CAA tag", ErrInvalidRecord)
+	}
+
+	data := []byte{c.Flags, byte(len(c.Tag))}
+	data = append(data, c.Tag...)
+	return append(data, c.Value...), nil
+}
+
+// Encode implements RData. Parameters are written in key order; a key may
+// appear only once.
+func (s *SVCBData) Encode() ([]byte, error) {
+	buf := bytes.NewBuffer(binary.BigEndian.AppendUint16(nil, s.Priority))
+	if err := (&Parser{}).writeName(buf, s.Target); err != nil {
+		return nil, err
+	}
+
+	params := make([]SVCParam, len(s.Params))
+	copy(params, s.Params)
+	sort.Slice(params, func(i, j int) bool { return params[i].Key < params[j].Key })
+
+	for i, param := range params {
+		if i > 0 && param.Key == params[i-1].Key {
+			return nil, fmt.Errorf("%w: duplicate SVCB parameter %d", ErrInvalidRecord, param.Key)
+		}
+		if len(param.Value) > 0xFFFF {
+			return nil, fmt.Errorf("%w: SVCB parameter %d too long", ErrInvalidRecord, param.Key)
+		}
+		binary.Write(buf, binary.BigEndian, param.Key)
+		binary.Write(buf, binary.BigEndian, uint16(len(param.Value)))
+		buf.Write(param.Value)
+	}
+	return buf.Bytes(), nil
+}
+
+// Param returns the wire value of a service parameter
+func (s *SVCBData) Param(key uint16) ([]byte, bool) {
+	for _, param := range s.Params {
+		if param.Key == key {
+			return param.Value, true
+		}
+	}
+	return nil, false
+}
+
+// ALPN returns the protocol identifiers of the alpn parameter
+func (s *SVCBData) ALPN() []string {
+	value, ok := s.Param(SVCParamALPN)
+	if !ok {
+		return nil
+	}
+	protocols, err := parseCharacterStrings(value)
+	if err != nil {
+		return nil
+	}
+	return protocols
+}
+
+// Port returns the port parameter, if present
+func (s *SVCBData) Port() (uint16, bool) {
+	value, ok := s.Param(SVCParamPort)
+	if !ok || len(value) != 2 {
+		return 0, false
+	}
+	return binary.BigEndian.Uint16(value), true
+}
+
+// IPHints returns the addresses of the ipv4hint and ipv6hint parameters
+func (s *SVCBData) IPHints() []net.IP {
+	var hints []net.IP
+	for _, hint := range []struct {
+		key  uint16
+		size int
+	}{{SVCParamIPv4Hint, net.IPv4len}, {SVCParamIPv6Hint, net.IPv6len}} {
+		value, ok := s.Param(hint.key)
+		if !ok || len(value)%hint.size != 0 {
+			continue
+		}
+		for i := 0; i < len(value); i += hint.size {
+			hints = append(hints, net.IP(value[i:i+hint.size]))
+		}
+	}
+	return hints
+}
+
+// parseRDataName decodes the name that ends a record's data at offset
+func parseRDataName(data []byte, offset int) (string, error) {
+	name, end, err := (&Parser{}).parseName(data, offset)
+	if err != nil {
+		return "", err
+	}
+	if end != len(data) {
+		return "", ErrInvalidRecord
+	}
+	return name, nil
+}
+
+// parseCharacterStrings decodes a sequence of length prefixed strings
+func parseCharacterStrings(data []byte) ([]string, error) {
+	var strs []string
+	for len(data) > 0 {
+		length := int(data[0])
+		if 1+length > len(data) {
+			return nil, ErrInvalidRecord
+		}
+		strs = append(strs, string(data[1:1+length]))
+		data = data[1+length:]
+	}
+	return strs, nil
+}
@@ -0,0 +1,123 @@
+package dns
+
+import (
+	"errors"
+	"net"
+	"reflect"
+	"testing"
+)
+
+func TestDecodeRData(t *testing.T) {
+	tests := []struct {
+		rtype uint16
+		data  RData
+	}{
+		{TypeA, &AddressData{IP: net.ParseIP("192.168.1.10").To4()}},
+		{TypeAAAA, &AddressData{IP: net.ParseIP("2001:db8::1")}},
+		{TypeCNAME, &TargetData{Target: "edge.example.net"}},
+		{TypeNS, &TargetData{Target: "ns1.example.com"}},
+		{TypePTR, &TargetData{Target: "nas.lan"}},
+		{TypeMX, &MXData{Preference: 10, Exchange: "mail.example.com"}},
+		{TypeTXT, &TXTData{Strings: []string{"v=spf1 -all", ""}}},
+		{TypeSRV, &SRVData{Priority: 10, Weight: 5, Port: 5060, Target: "sip.example.com"}},
+		{TypeSOA, &SOAData{MName: "ns1.example.com", RName: "hostmaster.example.com", Serial: 2024010101, Refresh: 3600, Retry: 600, Expire: 86400, Minimum: 300}},
+		{TypeCAA, &CAAData{Flags: CAAFlagCritical, Tag: "issue", Value: "letsencrypt.org"}},
+		{TypeHTTPS, &SVCBData{Priority: 1, Target: "", Params: []SVCParam{
+			{Key: SVCParamALPN, Value: []byte("\x02h2\x02h3")},
+			{Key: SVCParamPort, Value: []byte{0x01, 0xBB}},
+		}}},
+		{TypeSVCB, &SVCBData{Priority: 0, Target: "svc.example.com"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(RecordTypeName(tt.rtype), func(t *testing.T) {
+			record, err := NewRecord("example.com", tt.rtype, 300, tt.data)
+			if err != nil {
+				t.Fatalf("NewRecord failed: %v", err)
+			}
+
+			decoded, err := DecodeRData(record)
+			if err != nil {
+				t.Fatalf("DecodeRData failed: %v", err)
+			}
+			if !reflect.DeepEqual(decoded, tt.data) {
+				t.Errorf("Decoded %+v, want %+v", decoded, tt.data)
+			}
+		})
+	}
+}
+
+func TestDecodeRData_Invalid(t *testing.T) {
+	tests := map[string]DNSRecord{
+		"short A":          {Type: TypeA, Data: []byte{127, 0, 1}},
+		"IPv4 in AAAA":     {Type: TypeAAAA, Data: []byte{127, 0, 0, 1}},
+		"CNAME trailer":    {Type: TypeCNAME, Data: []byte{1, 'a', 0, 0xFF}},
+		"MX without name":  {Type: TypeMX, Data: []byte{0, 10}},
+		"empty TXT":        {Type: TypeTXT},
+		"overlong TXT":     {Type: TypeTXT, Data: []byte{5, 'a', 'b'}},
+		"SRV without name": {Type: TypeSRV, Data: []byte{0, 1, 0, 1, 0, 80}},
+		"SOA short":        {Type: TypeSOA, Data: []byte{0, 0, 0, 0, 0, 1}},
+		"CAA without tag":  {Type: TypeCAA, Data: []byte{0, 0}},
+		"SVCB key order":   {Type: TypeHTTPS, Data: []byte{0, 1, 0, 0, 3, 0, 0, 0, 1, 0, 0}},
+		"SVCB param short": {Type: TypeHTTPS, Data: []byte{0, 1, 0, 0, 1, 0, 4, 'h'}},
+	}
+
+	for name, record := range tests {
+		if _, err := DecodeRData(record); !errors.Is(err, ErrInvalidRecord) {
+			t.Errorf("%s: expected ErrInvalidRecord, got %v", name, err)
+		}
+	}
+
+	if _, err := DecodeRData(DNSRecord{Type: TypeDNSKEY}); !errors.Is(err, ErrUnsupportedRRType) {
+		t.Errorf("Expected ErrUnsupportedRRType, got %v", err)
+	}
+	if _, err := NewRecord("example.com", TypeAAAA, 300, &AddressData{IP: net.ParseIP("10.0.0.1")}); err == nil {
+		t.Error("Expected an IPv4 address to be rejected for AAAA")
+	}
+	if _, err := NewRecord("example.com", TypeTXT, 300, &TXTData{Strings: []string{string(make([]byte, 256))}}); err == nil {
+		t.Error("Expected a TXT string over 255 bytes to be rejected")
+	}
+}
+
+func TestSVCBData_Params(t *testing.T) {
+	svcb := &SVCBData{
+		Priority: 1,
+		Params: []SVCParam{
+			{Key: SVCParamIPv6Hint, Value: net.ParseIP("2001:db8::1")},
+			{Key: SVCParamPort, Value: []byte{0x20, 0xFB}},
+			{Key: SVCParamALPN, Value: []byte("\x02h2\x08http/1.1")},
+			{Key: SVCParamIPv4Hint, Value: []byte{192, 0, 2, 1, 192, 0, 2, 2}},
+		},
+	}
+
+	// Parameters are encoded in key order
+	record, err := NewRecord("example.com", TypeHTTPS, 300, svcb)
+	if err != nil {
+		t.Fatalf("NewRecord failed: %v", err)
+	}
+	decoded, err := ParseSVCB(record)
+	if err != nil {
+		t.Fatalf("ParseSVCB failed: %v", err)
+	}
+	for i, param := range decoded.Params {
+		if i > 0 && param.Key <= decoded.Params[i-1].Key {
+			t.Errorf("Parameters out of order: %+v", decoded.Params)
+		}
+	}
+
+	if alpn := decoded.ALPN(); !reflect.DeepEqual(alpn, []string{"h2", "http/1.1"}) {
+		t.Errorf("ALPN() = %v", alpn)
+	}
+	if port, ok := decoded.Port(); !ok || port != 8443 {
+		t.Errorf("Port() = %d, %v", port, ok)
+	}
+	hints := decoded.IPHints()
+	if len(hints) != 3 || !hints[0].Equal(net.ParseIP("192.0.2.1")) || !hints[2].Equal(net.ParseIP("2001:db8::1")) {
+		t.Errorf("IPHints() = %v", hints)
+	}
+
+	svcb.Params = append(svcb.Params, SVCParam{Key: SVCParamPort, Value: []byte{0, 80}})
+	if _, err := svcb.Encode(); !errors.Is(err, ErrInvalidRecord) {
+		t.Errorf("Expected duplicate parameters to be rejected, got %v", err)
+	}
+}
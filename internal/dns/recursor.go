@@ -0,0 +1,675 @@
+package dns
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"net"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// recursorUDPSize is the EDNS payload size offered to authoritative
+	// servers, small enough to avoid IP fragmentation
+	recursorUDPSize = 1232
+
+	// recursorMaxCNAMEs bounds the CNAME chain followed for one question
+	recursorMaxCNAMEs = 8
+
+	// recursorMaxDepth bounds nested lookups of nameserver addresses
+	recursorMaxDepth = 4
+
+	// recursorMaxMinimise is the number of labels QNAME minimisation adds
+	// one at a time before the full name is sent (RFC 9156)
+	recursorMaxMinimise = 10
+
+	// recursorMaxCacheTTL caps how long delegations and addresses are kept
+	recursorMaxCacheTTL = 24 * time.Hour
+)
+
+// Recursor implements the DNSForwarder interface by resolving queries
+// iteratively. It starts at the root servers and follows referrals down to
+// the authoritative servers, caching the delegations and nameserver
+// addresses it learns on the way.
+type Recursor struct {
+	mu     sync.RWMutex
+	config RecursionConfig
+	roots  []string
+	parser DNSParser
+	cache  *delegationCache
+
+	// Port of the nameservers learned from referrals
+	port string
+
+	// Conditional forwarding routes, which bypass recursion
+	conditional *Forwarder
+
+	// dnstap output for authoritative messages, nil unless enabled
+	tap atomic.Pointer[Dnstap]
+}
+
+// nameserver is a server of a zone and the addresses to query it on.
+// Addresses are looked up on first use when a referral carried no glue.
+type nameserver struct {
+	name      string
+	addresses []string
+}
+
+// resolution is the state shared by the nested lookups answering one
+// client query
+type resolution struct {
+	queries int
+	depth   int
+	dnssec  bool
+}
+
+// NewRecursor creates a recursive resolver. Conditional forwarding rules
+// of the forwarder configuration keep sending their zones to the
+// configured upstreams.
+func NewRecursor(config RecursionConfig, forwarding ForwarderConfig) DNSForwarder {
+	conditional := forwarding
+	conditional.Enabled = false
+	conditional.Upstreams = nil
+	conditional.HealthCheck = false
+
+	return &Recursor{
+		config:      config,
+		roots:       rootAddresses(config.RootHints),
+		parser:      NewParser(),
+		cache:       newDelegationCache(config.CacheSize),
+		port:        "53",
+		conditional: NewForwarder(conditional).(*Forwarder),
+	}
+}
+
+// validate checks the recursion configuration
+func (c RecursionConfig) validate() error {
+	if len(c.RootHints) == 0 {
+		return fmt.Errorf("%w: no root hints", ErrInvalidRecursion)
+	}
+	for _, hint := range c.RootHints {
+		if _, err := rootHintAddress(hint); err != nil {
+			return err
+		}
+	}
+	if c.Timeout <= 0 {
+		return fmt.Errorf("%w: timeout must be positive", ErrInvalidRecursion)
+	}
+	if c.MaxQueries < 1 {
+		return fmt.Errorf("%w: max queries must be positive", ErrInvalidRecursion)
+	}
+	if c.CacheSize < 1 {
+		return fmt.Errorf("%w: cache size must be positive", ErrInvalidRecursion)
+	}
+	return nil
+}
+
+// rootHintAddress returns a root hint as "ip:port"
+func rootHintAddress(hint string) (string, error) {
+	host, port := hint, "53"
+	if h, p, err := net.SplitHostPort(hint); err == nil {
+		host, port = h, p
+	}
+	if net.ParseIP(host) == nil {
+		return "", fmt.Errorf("%w: root hint %q is not an IP address", ErrInvalidRecursion, hint)
+	}
+	return net.JoinHostPort(host, port), nil
+}
+
+// rootAddresses converts root hints to addresses, skipping invalid ones
+func rootAddresses(hints []string) []string {
+	addresses := make([]string, 0, len(hints))
+	for _, hint := range hints {
+		if address, err := rootHintAddress(hint); err == nil {
+			addresses = append(addresses, address)
+		}
+	}
+	return addresses
+}
+
+// Forward resolves a query iteratively. Queries below a conditional
+// forwarding zone go to that zone's upstreams.
+func (r *Recursor) Forward(ctx context.Context, query *DNSQuery) (*DNSResponse, error) {
+	if route := r.conditional.conditionalFor(query.Question.Name); route != nil {
+		return route.forwarder.Forward(ctx, query)
+	}
+
+	state := &resolution{dnssec: query.EDNS != nil && query.EDNS.DNSSECOK}
+	response, err := r.resolve(ctx, query.Question, state)
+	if err != nil {
+		return nil, err
+	}
+
+	response.ID = query.ID
+	response.Question = query.Question
+	return response, nil
+}
+
+// GetUpstreams returns the root server addresses
+func (r *Recursor) GetUpstreams() []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	roots := make([]string, len(r.roots))
+	copy(roots, r.roots)
+	return roots
+}
+
+// SetUpstreams replaces the root hints
+func (r *Recursor) SetUpstreams(upstreams []string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.roots = rootAddresses(upstreams)
+}
+
+// GetUpstreamStats returns the statistics of the conditional forwarding
+// upstreams; authoritative servers are not tracked
+func (r *Recursor) GetUpstreamStats() []UpstreamStats {
+	return r.conditional.GetUpstreamStats()
+}
+
+// SetDnstap logs the queries sent to authoritative servers and their
+// responses, and those of conditional forwarding routes, to tap
+func (r *Recursor) SetDnstap(tap *Dnstap) {
+	r.tap.Store(tap)
+	r.conditional.SetDnstap(tap)
+}
+
+// SetConditional replaces the conditional forwarding rules
+func (r *Recursor) SetConditional(rules []ConditionalForwardConfig) {
+	r.conditional.SetConditional(rules)
+}
+
+// Close stops the health checkers of the conditional forwarding routes
+func (r *Recursor) Close() error {
+	return r.conditional.Close()
+}
+
+// resolve answers a question, following CNAMEs across zones. The answer
+// holds the chain from the question name to the records of its type.
+func (r *Recursor) resolve(ctx context.Context, question DNSQuestion, state *resolution) (*DNSResponse, error) {
+	var chain []DNSRecord
+	current := question
+	for i := 0; ; i++ {
+		response, err := r.iterate(ctx, current, state)
+		if err != nil {
+			return nil, err
+		}
+
+		records, target := followCNAMEs(response.Answers, current)
+		chain = append(chain, records...)
+		if response.ResponseCode != RCodeNoError || target == "" {
+			response.Answers = chain
+			return response, nil
+		}
+
+		if i == recursorMaxCNAMEs {
+			return nil, fmt.Errorf("%w: CNAME chain of %s is too long", ErrRecursionFailed, question.Name)
+		}
+		current.Name = target
+	}
+}
+
+// iterate asks the servers of the closest known zone and follows
+// referrals until a server answers the question itself
+func (r *Recursor) iterate(ctx context.Context, question DNSQuestion, state *resolution) (*DNSResponse, error) {
+	name := canonicalName(question.Name)
+	zone, servers := r.closestZone(name, question.Type == TypeDS)
+
+	// known is the deepest name found not to be a zone cut; a minimised
+	// query asks for one label more
+	known := zone
+	minimise := r.config.QNAMEMinimisation
+	steps := 0
+	for {
+		asked := question
+		asked.Name = name
+		if minimise && steps < recursorMaxMinimise {
+			if next, ok := minimisedName(name, known); ok {
+				asked = DNSQuestion{Name: next, Type: TypeA, Class: question.Class}
+				steps++
+			}
+		}
+		minimised := asked.Name != name
+
+		response, server, err := r.query(ctx, servers, zone, asked, state)
+		if err != nil {
+			if minimised && ctx.Err() == nil {
+				// Some servers mishandle minimised queries; ask in full
+				minimise = false
+				continue
+			}
+			return nil, err
+		}
+
+		if cut, nameservers, ok := r.referral(response, zone, asked.Name); ok {
+			if question.Type == TypeDS && cut == name {
+				// The parent answers for DS; referring to the child means
+				// it has none
+				return &DNSResponse{ResponseCode: RCodeNoError, Upstream: server}, nil
+			}
+			zone, servers, known = cut, nameservers, cut
+			continue
+		}
+
+		if minimised {
+			switch response.ResponseCode {
+			case RCodeNoError:
+				known = asked.Name
+				continue
+			case RCodeNXDomain:
+				// Nothing exists below a name that does not exist (RFC 8020)
+			default:
+				minimise = false
+				continue
+			}
+		}
+		return authoritativeAnswer(response, zone, server), nil
+	}
+}
+
+// minimisedName returns name cut to one label below known, reporting
+// false if that is the full name
+func minimisedName(name, known string) (string, bool) {
+	labels := nameLabels(name)
+	keep := len(nameLabels(known)) + 1
+	if keep >= len(labels) {
+		return "", false
+	}
+	return strings.Join(labels[len(labels)-keep:], "."), true
+}
+
+// closestZone returns the deepest cached zone containing name and its
+// nameservers, starting at the root servers. DS records live in the
+// parent zone, so their search starts above the name.
+func (r *Recursor) closestZone(name string, ds bool) (string, []nameserver) {
+	zone := name
+	if ds {
+		zone = parentName(name)
+	}
+	for zone != "" {
+		if names, ok := r.cache.delegation(zone); ok {
+			return zone, r.nameservers(names)
+		}
+		zone = parentName(zone)
+	}
+
+	roots := r.GetUpstreams()
+	servers := make([]nameserver, len(roots))
+	for i, root := range roots {
+		servers[i] = nameserver{name: root, addresses: []string{root}}
+	}
+	return "", servers
+}
+
+// nameservers returns the named servers with their cached addresses
+func (r *Recursor) nameservers(names []string) []nameserver {
+	servers := make([]nameserver, len(names))
+	for i, name := range names {
+		servers[i] = nameserver{name: name}
+		if ips, ok := r.cache.addresses(name); ok {
+			servers[i].addresses = r.withPort(ips)
+		}
+	}
+	return servers
+}
+
+// withPort joins addresses with the nameserver port
+func (r *Recursor) withPort(ips []string) []string {
+	addresses := make([]string, len(ips))
+	for i, ip := range ips {
+		addresses[i] = net.JoinHostPort(ip, r.port)
+	}
+	return addresses
+}
+
+// query sends a question to the servers of a zone in random order until
+// one answers. SERVFAIL, REFUSED and NOTIMP answers count as failures.
+func (r *Recursor) query(ctx context.Context, servers []nameserver, zone string, question DNSQuestion, state *resolution) (*DNSResponse, string, error) {
+	lastErr := fmt.Errorf("%w: no nameservers for %s", ErrRecursionFailed, displayName(zone))
+	for _, i := range rand.Perm(len(servers)) {
+		server := servers[i]
+		addresses := server.addresses
+		if len(addresses) == 0 {
+			var err error
+			if addresses, err = r.lookupNameserver(ctx, server.name, state); err != nil {
+				lastErr = err
+				continue
+			}
+		}
+
+		for _, address := range addresses {
+			if err := ctx.Err(); err != nil {
+				return nil, "", err
+			}
+			if state.queries >= r.config.MaxQueries {
+				return nil, "", fmt.Errorf("%w: query limit reached", ErrRecursionFailed)
+			}
+			state.queries++
+
+			response, err := r.exchange(ctx, address, question, state.dnssec)
+			if err != nil {
+				lastErr = err
+				continue
+			}
+			switch response.ResponseCode {
+			case RCodeServFail, RCodeRefused, RCodeNotImp:
+				lastErr = fmt.Errorf("%w: %s answered %s with rcode %d", ErrRecursionFailed, address, question.Name, response.ResponseCode)
+				continue
+			}
+			return response, address, nil
+		}
+	}
+	return nil, "", lastErr
+}
+
+// lookupNameserver resolves the addresses of a nameserver that came
+// without glue
+func (r *Recursor) lookupNameserver(ctx context.Context, name string, state *resolution) ([]string, error) {
+	if ips, ok := r.cache.addresses(name); ok {
+		return r.withPort(ips), nil
+	}
+	if state.depth >= recursorMaxDepth {
+		return nil, fmt.Errorf("%w: nameserver lookups for %s nested too deep", ErrRecursionFailed, name)
+	}
+	state.depth++
+	defer func() { state.depth-- }()
+
+	for _, qtype := range []uint16{TypeA, TypeAAAA} {
+		response, err := r.resolve(ctx, DNSQuestion{Name: name, Type: qtype, Class: ClassIN}, state)
+		if err != nil {
+			return nil, err
+		}
+
+		var ips []string
+		ttl := uint32(0)
+		for _, record := range response.Answers {
+			if record.Type != qtype {
+				continue
+			}
+			if address, err := ParseAddress(record); err == nil {
+				ips = append(ips, address.IP.String())
+				if ttl == 0 || record.TTL < ttl {
+					ttl = record.TTL
+				}
+			}
+		}
+		if len(ips) > 0 {
+			r.cache.setAddresses(name, ips, ttl)
+			return r.withPort(ips), nil
+		}
+	}
+	return nil, fmt.Errorf("%w: no address for nameserver %s", ErrRecursionFailed, name)
+}
+
+// referral reports whether a response delegates qname to a zone below the
+// one asked. The delegation and the glue for nameservers within the asked
+// zone are cached; glue outside it is ignored.
+func (r *Recursor) referral(response *DNSResponse, zone, qname string) (string, []nameserver, bool) {
+	if response.ResponseCode != RCodeNoError || len(response.Answers) > 0 {
+		return "", nil, false
+	}
+
+	var cut string
+	var names []string
+	var ttl uint32
+	for _, record := range response.Authorities {
+		owner := canonicalName(record.Name)
+		if record.Type != TypeNS || owner == zone || !isSubdomain(owner, zone) || !isSubdomain(qname, owner) {
+			continue
+		}
+		if cut == "" {
+			cut, ttl = owner, record.TTL
+		} else if owner != cut {
+			continue
+		}
+		target, err := ParseTarget(record)
+		if err != nil {
+			continue
+		}
+		names = append(names, canonicalName(target.Target))
+		ttl = min(ttl, record.TTL)
+	}
+	if len(names) == 0 {
+		return "", nil, false
+	}
+
+	glue := make(map[string][]string)
+	glueTTL := make(map[string]uint32)
+	for _, record := range response.Additional {
+		owner := canonicalName(record.Name)
+		if record.Type != TypeA && record.Type != TypeAAAA || !isSubdomain(owner, zone) || !containsName(names, owner) {
+			continue
+		}
+		if address, err := ParseAddress(record); err == nil {
+			glue[owner] = append(glue[owner], address.IP.String())
+			if ttl, ok := glueTTL[owner]; !ok || record.TTL < ttl {
+				glueTTL[owner] = record.TTL
+			}
+		}
+	}
+	for name, ips := range glue {
+		r.cache.setAddresses(name, ips, glueTTL[name])
+	}
+	r.cache.setDelegation(cut, names, ttl)
+
+	return cut, r.nameservers(names), true
+}
+
+// containsName reports whether names holds name
+func containsName(names []string, name string) bool {
+	for _, n := range names {
+		if n == name {
+			return true
+		}
+	}
+	return false
+}
+
+// authoritativeAnswer keeps the records of a response that lie within the
+// zone its server was asked about. Authority records are kept only for
+// negative answers, where they carry the SOA for negative caching.
+func authoritativeAnswer(response *DNSResponse, zone, server string) *DNSResponse {
+	answer := &DNSResponse{
+		ResponseCode: response.ResponseCode,
+		Answers:      inBailiwick(response.Answers, zone),
+		Upstream:     server,
+	}
+	if answer.ResponseCode == RCodeNXDomain || len(answer.Answers) == 0 {
+		answer.Authorities = inBailiwick(response.Authorities, zone)
+	}
+	return answer
+}
+
+// inBailiwick returns the records whose owner lies within zone
+func inBailiwick(records []DNSRecord, zone string) []DNSRecord {
+	var kept []DNSRecord
+	for _, record := range records {
+		if isSubdomain(record.Name, zone) {
+			kept = append(kept, record)
+		}
+	}
+	return kept
+}
+
+// followCNAMEs returns the records answering question, walking any CNAME
+// chain in answers. If the chain leaves the answers before reaching
+// records of the question type, the name it ends at is returned as the
+// target to resolve next.
+func followCNAMEs(answers []DNSRecord, question DNSQuestion) ([]DNSRecord, string) {
+	var records []DNSRecord
+	name := canonicalName(question.Name)
+	for hops := 0; hops <= recursorMaxCNAMEs; hops++ {
+		matched := false
+		next := ""
+		for _, record := range answers {
+			if canonicalName(record.Name) != name {
+				continue
+			}
+			matched = true
+			records = append(records, record)
+			if record.Type == TypeCNAME && question.Type != TypeCNAME {
+				if target, err := ParseTarget(record); err == nil {
+					next = canonicalName(target.Target)
+				}
+			}
+		}
+
+		switch {
+		case !matched && hops > 0:
+			return records, name
+		case !matched, next == "":
+			return records, ""
+		}
+		name = next
+	}
+	return records, ""
+}
+
+// exchange sends a question without recursion to an authoritative server
+// and parses its answer. Truncated UDP answers are retried over TCP.
+func (r *Recursor) exchange(ctx context.Context, address string, question DNSQuestion, dnssec bool) (*DNSResponse, error) {
+	query := &DNSQuery{
+		ID:       uint16(rand.Intn(65536)),
+		Question: question,
+		EDNS:     &EDNS{UDPSize: recursorUDPSize, DNSSECOK: dnssec},
+	}
+	data, err := r.parser.SerializeQuery(query)
+	if err != nil {
+		return nil, fmt.Errorf("failed to serialize query: %w", err)
+	}
+	data[2] &^= byte(FlagRD >> 8)
+
+	ctx, cancel := context.WithTimeout(ctx, r.config.Timeout)
+	defer cancel()
+
+	transport := &udpTransport{address: address, timeout: r.config.Timeout}
+	response, err := r.exchangeOn(ctx, transport, address, query, data)
+	if err == nil && response.Truncated {
+		response, err = r.exchangeOn(ctx, transport.StreamFallback(), address, query, data)
+	}
+	return response, err
+}
+
+// exchangeOn sends a query over a transport and checks that the response
+// answers it
+func (r *Recursor) exchangeOn(ctx context.Context, transport upstreamTransport, address string, query *DNSQuery, data []byte) (*DNSResponse, error) {
+	tap := r.tap.Load()
+	addr, protocol := dnstapUpstream(transport)
+	sent := time.Now()
+	if tap != nil {
+		tap.ResolverQuery(addr, protocol, data, sent)
+	}
+
+	responseData, err := transport.Exchange(ctx, data)
+	if err != nil {
+		return nil, err
+	}
+
+	if tap != nil {
+		tap.ResolverResponse(addr, protocol, responseData, sent, time.Now())
+	}
+
+	response, err := r.parser.ParseResponse(responseData)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse response from %s: %w", address, err)
+	}
+	if response.ID != query.ID || response.Question.Type != query.Question.Type ||
+		canonicalName(response.Question.Name) != canonicalName(query.Question.Name) {
+		return nil, fmt.Errorf("%w: response from %s does not match the query", ErrInvalidDNSMessage, address)
+	}
+	return response, nil
+}
+
+// delegationCache holds the zone cuts and nameserver addresses learned
+// while resolving, each until its TTL expires
+type delegationCache struct {
+	mu    sync.Mutex
+	size  int
+	zones map[string]cachedNames
+	addrs map[string]cachedNames
+}
+
+// cachedNames is a cached list of nameserver names or addresses
+type cachedNames struct {
+	values  []string
+	expires time.Time
+}
+
+// newDelegationCache creates a cache holding up to size entries
+func newDelegationCache(size int) *delegationCache {
+	return &delegationCache{
+		size:  size,
+		zones: make(map[string]cachedNames),
+		addrs: make(map[string]cachedNames),
+	}
+}
+
+// delegation returns the nameserver names of a zone
+func (c *delegationCache) delegation(zone string) ([]string, bool) {
+	return c.lookup(c.zones, zone)
+}
+
+// setDelegation caches the nameserver names of a zone
+func (c *delegationCache) setDelegation(zone string, names []string, ttl uint32) {
+	c.store(c.zones, zone, names, ttl)
+}
+
+// addresses returns the IP addresses of a nameserver
+func (c *delegationCache) addresses(name string) ([]string, bool) {
+	return c.lookup(c.addrs, name)
+}
+
+// setAddresses caches the IP addresses of a nameserver
+func (c *delegationCache) setAddresses(name string, ips []string, ttl uint32) {
+	c.store(c.addrs, name, ips, ttl)
+}
+
+// lookup returns an unexpired entry
+func (c *delegationCache) lookup(entries map[string]cachedNames, key string) ([]string, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry, ok := entries[key]
+	if !ok || time.Now().After(entry.expires) {
+		return nil, false
+	}
+	return entry.values, true
+}
+
+// store caches an entry for ttl seconds. When the cache is full, expired
+// entries are dropped first, then arbitrary ones.
+func (c *delegationCache) store(entries map[string]cachedNames, key string, values []string, ttl uint32) {
+	if ttl == 0 {
+		return
+	}
+	lifetime := min(time.Duration(ttl)*time.Second, recursorMaxCacheTTL)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if _, ok := entries[key]; !ok && len(c.zones)+len(c.addrs) >= c.size {
+		now := time.Now()
+		for _, m := range []map[string]cachedNames{c.zones, c.addrs} {
+			for k, entry := range m {
+				if now.After(entry.expires) {
+					delete(m, k)
+				}
+			}
+		}
+		for _, m := range []map[string]cachedNames{entries, c.zones, c.addrs} {
+			for k := range m {
+				if len(c.zones)+len(c.addrs) < c.size {
+					break
+				}
+				delete(m, k)
+			}
+		}
+	}
+
+	entries[key] = cachedNames{values: values, expires: time.Now().Add(lifetime)}
+}
@@ -0,0 +1,407 @@
+package dns
+
+import (
+	"context"
+	"errors"
+	"net"
+	"reflect"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+// authServer is an in-process stand-in authoritative server for one zone.
+// Delegations are NS records below the zone; glue is looked up among the
+// server's own records, whatever zone it belongs to.
+type authServer struct {
+	zone    string
+	records []DNSRecord
+	extra   map[string][]DNSRecord // Records slipped into answers for a name
+
+	mu    sync.Mutex
+	asked []string
+}
+
+func newAuthServer(t *testing.T, zone string, records ...DNSRecord) *authServer {
+	t.Helper()
+
+	soa := testRR(t, zone, TypeSOA, &SOAData{MName: "ns." + zone, RName: "hostmaster." + zone, Serial: 1, Refresh: 3600, Retry: 600, Expire: 86400, Minimum: 300})
+	return &authServer{
+		zone:    zone,
+		records: append([]DNSRecord{soa}, records...),
+		extra:   make(map[string][]DNSRecord),
+	}
+}
+
+// testRR builds a record, failing the test on invalid data
+func testRR(t *testing.T, name string, rtype uint16, data RData) DNSRecord {
+	t.Helper()
+
+	record, err := NewRecord(name, rtype, 300, data)
+	if err != nil {
+		t.Fatalf("NewRecord(%s) failed: %v", name, err)
+	}
+	return record
+}
+
+func testAddress(ip string) *AddressData {
+	parsed := net.ParseIP(ip)
+	if v4 := parsed.To4(); v4 != nil {
+		parsed = v4
+	}
+	return &AddressData{IP: parsed}
+}
+
+// find returns the server's records of a type at an owner name
+func (s *authServer) find(name string, rtype uint16) []DNSRecord {
+	var found []DNSRecord
+	for _, record := range s.records {
+		if canonicalName(record.Name) == name && record.Type == rtype {
+			found = append(found, record)
+		}
+	}
+	return found
+}
+
+// answer refers, answers or denies a question like an authoritative server
+func (s *authServer) answer(question DNSQuestion) *DNSResponse {
+	name := canonicalName(question.Name)
+	s.mu.Lock()
+	s.asked = append(s.asked, displayName(name))
+	s.mu.Unlock()
+
+	response := &DNSResponse{Question: question}
+	if !isSubdomain(name, s.zone) {
+		response.ResponseCode = RCodeRefused
+		return response
+	}
+
+	for cut := name; cut != s.zone; cut = parentName(cut) {
+		if ns := s.find(cut, TypeNS); len(ns) > 0 {
+			response.Authorities = ns
+			for _, record := range ns {
+				target, _ := ParseTarget(record)
+				response.Additional = append(response.Additional, s.find(canonicalName(target.Target), TypeA)...)
+			}
+			return response
+		}
+	}
+
+	response.Authoritative = true
+	exists := false
+	for _, record := range s.records {
+		owner := canonicalName(record.Name)
+		if owner == name && (record.Type == question.Type || record.Type == TypeCNAME) {
+			response.Answers = append(response.Answers, record)
+		}
+		if isSubdomain(owner, name) {
+			exists = true
+		}
+	}
+	if len(response.Answers) > 0 {
+		response.Answers = append(response.Answers, s.extra[name]...)
+		return response
+	}
+	if !exists {
+		response.ResponseCode = RCodeNXDomain
+	}
+	response.Authorities = s.find(s.zone, TypeSOA)
+	return response
+}
+
+// questions returns the names the server was asked about
+func (s *authServer) questions() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.asked...)
+}
+
+// startAuthServers serves each server on its loopback address. All share
+// one port, as referrals carry addresses only.
+func startAuthServers(t *testing.T, servers map[string]*authServer) string {
+	t.Helper()
+
+	for attempt := 0; attempt < 10; attempt++ {
+		var conns []net.PacketConn
+		port := "0"
+		for ip := range servers {
+			conn, err := net.ListenPacket("udp", net.JoinHostPort(ip, port))
+			if err != nil {
+				break
+			}
+			conns = append(conns, conn)
+			_, port, _ = net.SplitHostPort(conn.LocalAddr().String())
+		}
+		if len(conns) < len(servers) {
+			for _, conn := range conns {
+				conn.Close()
+			}
+			continue
+		}
+
+		parser := NewParser()
+		for _, conn := range conns {
+			t.Cleanup(func() { conn.Close() })
+			ip, _, _ := net.SplitHostPort(conn.LocalAddr().String())
+			server := servers[ip]
+			go func(conn net.PacketConn) {
+				buffer := make([]byte, maxUDPMessageSize)
+				for {
+					n, addr, err := conn.ReadFrom(buffer)
+					if err != nil {
+						return
+					}
+					query, err := parser.ParseQuery(buffer[:n])
+					if err != nil {
+						continue
+					}
+					response := server.answer(query.Question)
+					response.ID = query.ID
+					if data, err := parser.SerializeResponse(response); err == nil {
+						conn.WriteTo(data, addr)
+					}
+				}
+			}(conn)
+		}
+		return port
+	}
+	t.Skip("No port free on all loopback test addresses")
+	return ""
+}
+
+// newTestHierarchy serves a root, "com", "example.com", "net" and
+// "other.net" on 127.0.0.2 to 127.0.0.6
+func newTestHierarchy(t *testing.T) (map[string]*authServer, string) {
+	root := newAuthServer(t, "",
+		testRR(t, "com", TypeNS, &TargetData{Target: "ns.com"}),
+		testRR(t, "ns.com", TypeA, testAddress("127.0.0.3")),
+		testRR(t, "net", TypeNS, &TargetData{Target: "ns.net"}),
+		testRR(t, "ns.net", TypeA, testAddress("127.0.0.5")),
+	)
+	com := newAuthServer(t, "com",
+		testRR(t, "example.com", TypeNS, &TargetData{Target: "ns1.example.com"}),
+		testRR(t, "example.com", TypeNS, &TargetData{Target: "ns.other.net"}),
+		testRR(t, "ns1.example.com", TypeA, testAddress("127.0.0.4")),
+		// Glue outside "com" that must not be believed
+		testRR(t, "ns.other.net", TypeA, testAddress("127.0.0.9")),
+	)
+	example := newAuthServer(t, "example.com",
+		testRR(t, "example.com", TypeNS, &TargetData{Target: "ns1.example.com"}),
+		testRR(t, "ns1.example.com", TypeA, testAddress("127.0.0.4")),
+		testRR(t, "www.example.com", TypeA, testAddress("192.0.2.1")),
+		testRR(t, "mail.example.com", TypeA, testAddress("192.0.2.25")),
+		testRR(t, "alias.example.com", TypeCNAME, &TargetData{Target: "cdn.other.net"}),
+	)
+	example.extra["alias.example.com"] = []DNSRecord{
+		testRR(t, "cdn.other.net", TypeA, testAddress("127.0.0.9")),
+	}
+	network := newAuthServer(t, "net",
+		testRR(t, "other.net", TypeNS, &TargetData{Target: "ns.other.net"}),
+		testRR(t, "ns.other.net", TypeA, testAddress("127.0.0.6")),
+	)
+	other := newAuthServer(t, "other.net",
+		testRR(t, "ns.other.net", TypeA, testAddress("127.0.0.6")),
+		testRR(t, "cdn.other.net", TypeA, testAddress("198.51.100.7")),
+	)
+
+	servers := map[string]*authServer{
+		"127.0.0.2": root,
+		"127.0.0.3": com,
+		"127.0.0.4": example,
+		"127.0.0.5": network,
+		"127.0.0.6": other,
+	}
+	return servers, startAuthServers(t, servers)
+}
+
+func newTestRecursor(port string, minimise bool) *Recursor {
+	config := DefaultConfig().Recursion
+	config.Enabled = true
+	config.RootHints = []string{net.JoinHostPort("127.0.0.2", port)}
+	config.QNAMEMinimisation = minimise
+	config.Timeout = time.Second
+
+	recursor := NewRecursor(config, DefaultConfig().Forwarder).(*Recursor)
+	recursor.port = port
+	return recursor
+}
+
+// answerAddresses returns the addresses in a response's A records
+func answerAddresses(response *DNSResponse) []string {
+	var addresses []string
+	for _, record := range response.Answers {
+		if record.Type != TypeA {
+			continue
+		}
+		if address, err := ParseAddress(record); err == nil {
+			addresses = append(addresses, address.IP.String())
+		}
+	}
+	return addresses
+}
+
+func resolveTest(t *testing.T, recursor *Recursor, name string, qtype uint16) *DNSResponse {
+	t.Helper()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	response, err := recursor.Forward(ctx, &DNSQuery{
+		ID:       99,
+		Question: DNSQuestion{Name: name, Type: qtype, Class: ClassIN},
+	})
+	if err != nil {
+		t.Fatalf("Forward(%s) failed: %v", name, err)
+	}
+	if response.ID != 99 || response.Question.Name != name {
+		t.Errorf("Expected the response to carry the query ID and question, got %d %q", response.ID, response.Question.Name)
+	}
+	return response
+}
+
+func TestRecursor_Resolve(t *testing.T) {
+	servers, port := newTestHierarchy(t)
+	recursor := newTestRecursor(port, true)
+
+	response := resolveTest(t, recursor, "www.example.com", TypeA)
+	if response.ResponseCode != RCodeNoError || !reflect.DeepEqual(answerAddresses(response), []string{"192.0.2.1"}) {
+		t.Fatalf("Unexpected answer %+v", response)
+	}
+
+	// QNAME minimisation: each server only learns the next label. The
+	// root may also be asked for "net" if ns.other.net is tried first.
+	rootAsked := servers["127.0.0.2"].questions()
+	for _, name := range rootAsked {
+		if name != "com" && name != "net" {
+			t.Errorf("Root was asked %v", rootAsked)
+		}
+	}
+	if asked := servers["127.0.0.3"].questions(); !reflect.DeepEqual(asked, []string{"example.com"}) {
+		t.Errorf("com was asked %v", asked)
+	}
+
+	// The cached delegation sends further names straight to example.com
+	response = resolveTest(t, recursor, "mail.example.com", TypeA)
+	if !reflect.DeepEqual(answerAddresses(response), []string{"192.0.2.25"}) {
+		t.Errorf("Unexpected answer %+v", response)
+	}
+	if asked := servers["127.0.0.2"].questions(); countNames(asked, "com") != 1 {
+		t.Errorf("Expected the delegation to be cached, root was asked %v", asked)
+	}
+
+	// Glue for a nameserver outside the referring zone is ignored
+	if ips, ok := recursor.cache.addresses("ns.other.net"); ok && !reflect.DeepEqual(ips, []string{"127.0.0.6"}) {
+		t.Errorf("Expected out-of-bailiwick glue to be ignored, cached %v", ips)
+	}
+}
+
+// countNames counts the occurrences of name in names
+func countNames(names []string, name string) int {
+	count := 0
+	for _, n := range names {
+		if n == name {
+			count++
+		}
+	}
+	return count
+}
+
+func TestRecursor_CNAME(t *testing.T) {
+	_, port := newTestHierarchy(t)
+	recursor := newTestRecursor(port, true)
+
+	// The target lies in another zone; the address slipped in by the
+	// example.com server is dropped and looked up at other.net
+	response := resolveTest(t, recursor, "alias.example.com", TypeA)
+	if len(response.Answers) != 2 || response.Answers[0].Type != TypeCNAME {
+		t.Fatalf("Expected the CNAME and its target's address, got %+v", response.Answers)
+	}
+	if addresses := answerAddresses(response); !reflect.DeepEqual(addresses, []string{"198.51.100.7"}) {
+		t.Errorf("Expected the target's authoritative address, got %v", addresses)
+	}
+	if response.Upstream != net.JoinHostPort("127.0.0.6", port) {
+		t.Errorf("Expected other.net's server to answer last, got %q", response.Upstream)
+	}
+}
+
+func TestRecursor_NXDomain(t *testing.T) {
+	servers, port := newTestHierarchy(t)
+	recursor := newTestRecursor(port, true)
+
+	response := resolveTest(t, recursor, "a.b.missing.example.com", TypeA)
+	if response.ResponseCode != RCodeNXDomain {
+		t.Fatalf("Expected NXDOMAIN, got rcode %d", response.ResponseCode)
+	}
+	if len(response.Authorities) != 1 || response.Authorities[0].Type != TypeSOA {
+		t.Errorf("Expected the zone's SOA for negative caching, got %+v", response.Authorities)
+	}
+
+	// Nothing exists below a missing name, so the full name is never sent
+	if asked := servers["127.0.0.4"].questions(); !reflect.DeepEqual(asked, []string{"missing.example.com"}) {
+		t.Errorf("example.com was asked %v", asked)
+	}
+
+	// A name without records of the type is NODATA
+	response = resolveTest(t, recursor, "www.example.com", TypeAAAA)
+	if response.ResponseCode != RCodeNoError || len(response.Answers) != 0 || len(response.Authorities) != 1 {
+		t.Errorf("Expected NODATA with the SOA, got %+v", response)
+	}
+}
+
+func TestRecursor_WithoutMinimisation(t *testing.T) {
+	servers, port := newTestHierarchy(t)
+	recursor := newTestRecursor(port, false)
+
+	resolveTest(t, recursor, "www.example.com", TypeA)
+	if asked := servers["127.0.0.2"].questions(); len(asked) == 0 || asked[0] != "www.example.com" {
+		t.Errorf("Expected root to be asked the full name, got %v", asked)
+	}
+}
+
+func TestRecursor_Limits(t *testing.T) {
+	_, port := newTestHierarchy(t)
+
+	recursor := newTestRecursor(port, true)
+	recursor.config.MaxQueries = 2
+	_, err := recursor.Forward(context.Background(), &DNSQuery{
+		Question: DNSQuestion{Name: "www.example.com", Type: TypeA, Class: ClassIN},
+	})
+	if !errors.Is(err, ErrRecursionFailed) {
+		t.Errorf("Expected the query limit to stop resolution, got %v", err)
+	}
+
+	// Root servers that do not answer fail the query
+	recursor = newTestRecursor(port, true)
+	recursor.SetUpstreams([]string{"127.0.0.1:" + strconv.Itoa(freeTestPort(t))})
+	recursor.config.Timeout = 100 * time.Millisecond
+	if _, err := recursor.Forward(context.Background(), &DNSQuery{
+		Question: DNSQuestion{Name: "www.example.com", Type: TypeA, Class: ClassIN},
+	}); err == nil {
+		t.Error("Expected resolution without reachable roots to fail")
+	}
+}
+
+func TestRecursionConfig_Validate(t *testing.T) {
+	valid := DefaultConfig().Recursion
+	if err := valid.validate(); err != nil {
+		t.Fatalf("Expected the default configuration to be valid, got %v", err)
+	}
+
+	for name, mutate := range map[string]func(*RecursionConfig){
+		"no hints":   func(c *RecursionConfig) { c.RootHints = nil },
+		"hostname":   func(c *RecursionConfig) { c.RootHints = []string{"a.root-servers.net"} },
+		"timeout":    func(c *RecursionConfig) { c.Timeout = 0 },
+		"queries":    func(c *RecursionConfig) { c.MaxQueries = 0 },
+		"cache size": func(c *RecursionConfig) { c.CacheSize = 0 },
+	} {
+		config := valid
+		mutate(&config)
+		if err := config.validate(); !errors.Is(err, ErrInvalidRecursion) {
+			t.Errorf("%s: expected ErrInvalidRecursion, got %v", name, err)
+		}
+	}
+
+	if address, err := rootHintAddress("[2001:db8::53]:5353"); err != nil || address != "[2001:db8::53]:5353" {
+		t.Errorf("rootHintAddress() = %q, %v", address, err)
+	}
+}
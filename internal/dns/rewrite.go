@@ -0,0 +1,437 @@
+package dns
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// safeSearchServices lists the safe search services in the order their
+// rewrites are added
+var safeSearchServices = []string{
+	SafeSearchGoogle,
+	SafeSearchBing,
+	SafeSearchDuckDuckGo,
+	SafeSearchYouTube,
+}
+
+// safeSearchEndpoints are the restricted endpoints each service's names
+// are rewritten to
+var safeSearchEndpoints = map[string]string{
+	SafeSearchGoogle:     "forcesafesearch.google.com",
+	SafeSearchBing:       "strict.bing.com",
+	SafeSearchDuckDuckGo: "safe.duckduckgo.com",
+	SafeSearchYouTube:    "restrict.youtube.com",
+}
+
+// youtubeModerateEndpoint is the YouTube endpoint for moderate restrictions
+const youtubeModerateEndpoint = "restrictmoderate.youtube.com"
+
+// safeSearchDomains are the names rewritten for each service; Google's
+// are derived from googleSearchTLDs
+var safeSearchDomains = map[string][]string{
+	SafeSearchBing: {"bing.com", "www.bing.com"},
+	SafeSearchDuckDuckGo: {
+		"duckduckgo.com",
+		"www.duckduckgo.com",
+		"start.duckduckgo.com",
+		"html.duckduckgo.com",
+	},
+	SafeSearchYouTube: {
+		"www.youtube.com",
+		"m.youtube.com",
+		"youtubei.googleapis.com",
+		"youtube.googleapis.com",
+		"www.youtube-nocookie.com",
+	},
+}
+
+// googleSearchTLDs are the top-level domains Google search is served under
+var googleSearchTLDs = []string{
+	"com", "ad", "ae", "com.af", "com.ag", "al", "am", "co.ao", "com.ar",
+	"as", "at", "com.au", "az", "ba", "com.bd", "be", "bf", "bg", "com.bh",
+	"bi", "bj", "com.bn", "com.bo", "com.br", "bs", "bt", "co.bw", "by",
+	"com.bz", "ca", "cat", "cd", "cf", "cg", "ch", "ci", "co.ck", "cl",
+	"cm", "cn", "com.co", "co.cr", "com.cu", "cv", "com.cy", "cz", "de",
+	"dj", "dk", "dm", "com.do", "dz", "com.ec", "ee", "com.eg", "es",
+	"com.et", "fi", "com.fj", "fm", "fr", "ga", "ge", "gg", "com.gh",
+	"com.gi", "gl", "gm", "gr", "com.gt", "gy", "com.hk", "hn", "hr", "ht",
+	"hu", "co.id", "ie", "co.il", "im", "co.in", "iq", "is", "it", "je",
+	"com.jm", "jo", "co.jp", "co.ke", "com.kh", "ki", "kg", "co.kr",
+	"com.kw", "kz", "la", "com.lb", "li", "lk", "co.ls", "lt", "lu", "lv",
+	"com.ly", "co.ma", "md", "me", "mg", "mk", "ml", "com.mm", "mn",
+	"com.mt", "mu", "mv", "mw", "com.mx", "com.my", "co.mz", "com.na",
+	"com.ng", "com.ni", "ne", "nl", "no", "com.np", "nr", "nu", "co.nz",
+	"com.om", "com.pa", "com.pe", "com.pg", "com.ph", "com.pk", "pl", "pn",
+	"com.pr", "ps", "pt", "com.py", "com.qa", "ro", "rs", "ru", "rw",
+	"com.sa", "com.sb", "sc", "se", "com.sg", "sh", "si", "sk", "com.sl",
+	"sn", "so", "sm", "sr", "st", "com.sv", "td", "tg", "co.th", "com.tj",
+	"tl", "tm", "tn", "to", "com.tr", "tt", "com.tw", "co.tz", "com.ua",
+	"co.ug", "co.uk", "com.uy", "co.uz", "com.vc", "co.ve", "co.vi",
+	"com.vn", "vu", "ws", "co.za", "co.zm", "co.zw",
+}
+
+// Rewrite is a compiled rewrite rule
+type Rewrite struct {
+	Domain     string // Normalized name, without "*." for wildcards
+	Wildcard   bool   // Also rewrites the subdomains of Domain
+	Target     string // CNAME target, empty for an address
+	Address    net.IP // 4 bytes for IPv4, nil for a CNAME target
+	Groups     []string
+	Comment    string
+	SafeSearch bool // Added by safe search rather than configured
+
+	data []byte // Encoded CNAME target
+}
+
+// RewriteMatch holds the rewrites of a name for a client
+type RewriteMatch struct {
+	Group    string     // Group whose rewrites apply
+	Rewrites []*Rewrite // A CNAME target, or addresses
+
+	ttl uint32
+}
+
+// Target returns the CNAME target of the match, empty if the name is
+// rewritten to addresses
+func (m *RewriteMatch) Target() string {
+	return m.Rewrites[0].Target
+}
+
+// answer returns the records answering a question from the match. A name
+// rewritten to a CNAME target is answered with the CNAME record alone;
+// the caller resolves the target.
+func (m *RewriteMatch) answer(question DNSQuestion) []DNSRecord {
+	var answers []DNSRecord
+	for _, rewrite := range m.Rewrites {
+		rtype := TypeAAAA
+		data := []byte(rewrite.Address)
+		switch {
+		case rewrite.Target != "":
+			rtype, data = TypeCNAME, rewrite.data
+		case len(rewrite.Address) == net.IPv4len:
+			rtype = TypeA
+		}
+
+		if rtype == question.Type || rtype == TypeCNAME {
+			answers = append(answers, DNSRecord{
+				Name:  question.Name,
+				Type:  rtype,
+				Class: ClassIN,
+				TTL:   m.ttl,
+				Data:  data,
+			})
+		}
+	}
+	return answers
+}
+
+// RewriteEngine implements the DNSRewriter interface. Like domain rules,
+// the compiled rewrites are swapped atomically on Load.
+type RewriteEngine struct {
+	rewrites  atomic.Pointer[rewriteSet]
+	rewritten atomic.Int64
+
+	mu    sync.RWMutex
+	stats RewriteStats
+}
+
+// rewriteSet is an immutable, compiled set of rewrites by group
+type rewriteSet struct {
+	ttl    uint32
+	groups map[string]*rewriteIndex
+}
+
+// rewriteIndex indexes the rewrites of one group by name
+type rewriteIndex struct {
+	exact    map[string][]*Rewrite
+	wildcard map[string][]*Rewrite
+}
+
+// NewRewriteEngine creates a new, empty rewrite engine
+func NewRewriteEngine() DNSRewriter {
+	engine := &RewriteEngine{}
+	engine.rewrites.Store(&rewriteSet{groups: make(map[string]*rewriteIndex)})
+	return engine
+}
+
+// Load compiles a rewrite configuration and replaces the active rewrites.
+// On error the previous rewrites stay in effect.
+func (e *RewriteEngine) Load(config RewritesConfig) error {
+	set, stats, err := compileRewrites(config)
+	if err != nil {
+		return err
+	}
+
+	e.rewrites.Store(set)
+
+	e.mu.Lock()
+	e.stats = *stats
+	e.stats.LastLoaded = time.Now()
+	e.mu.Unlock()
+
+	return nil
+}
+
+// Match returns the rewrites of a name for the first of groups that
+// rewrites it, or nil
+func (e *RewriteEngine) Match(name string, groups []string) *RewriteMatch {
+	name = normalizeDomain(name)
+	if name == "" {
+		return nil
+	}
+
+	set := e.rewrites.Load()
+	for _, group := range groups {
+		index, ok := set.groups[group]
+		if !ok {
+			continue
+		}
+		if rewrites := index.match(name); rewrites != nil {
+			e.rewritten.Add(1)
+			return &RewriteMatch{Group: group, Rewrites: rewrites, ttl: set.ttl}
+		}
+	}
+	return nil
+}
+
+// GetStats returns rewrite statistics
+func (e *RewriteEngine) GetStats() *RewriteStats {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+
+	stats := e.stats
+	stats.Rewritten = e.rewritten.Load()
+	return &stats
+}
+
+// validate checks the rewrites and that the groups they name are declared
+// by the rules
+func (c RewritesConfig) validate(rules RulesConfig) error {
+	if c.TTL < 0 {
+		return fmt.Errorf("%w: negative TTL", ErrInvalidRewrite)
+	}
+	if _, _, err := compileRewrites(c); err != nil {
+		return err
+	}
+
+	declared := map[string]bool{DefaultGroupName: true}
+	for _, group := range rules.Groups {
+		declared[group.Name] = true
+	}
+	var groups []string
+	if c.SafeSearch.Enabled {
+		groups = append(groups, c.SafeSearch.Groups...)
+	}
+	for _, rule := range c.Rules {
+		if rule.Enabled {
+			groups = append(groups, rule.Groups...)
+		}
+	}
+	for _, group := range groups {
+		if !declared[group] {
+			return fmt.Errorf("%w: %s", ErrUnknownGroup, group)
+		}
+	}
+	return nil
+}
+
+// compileRewrites validates and compiles a rewrite configuration. Safe
+// search rewrites are added after the rules and skip names a rule
+// already rewrites for the group.
+func compileRewrites(config RewritesConfig) (*rewriteSet, *RewriteStats, error) {
+	set := &rewriteSet{
+		ttl:    uint32(config.TTL / time.Second),
+		groups: make(map[string]*rewriteIndex),
+	}
+	stats := &RewriteStats{}
+
+	for _, cfg := range config.Rules {
+		if !cfg.Enabled {
+			continue
+		}
+
+		rewrite, err := compileRewrite(cfg)
+		if err != nil {
+			return nil, nil, err
+		}
+		for _, group := range rewrite.Groups {
+			if err := set.index(group).add(rewrite); err != nil {
+				return nil, nil, err
+			}
+		}
+		stats.Rules++
+	}
+
+	if config.SafeSearch.Enabled {
+		rewrites, err := safeSearchRewrites(config.SafeSearch)
+		if err != nil {
+			return nil, nil, err
+		}
+		for _, rewrite := range rewrites {
+			for _, group := range rewrite.Groups {
+				index := set.index(group)
+				if len(index.exact[rewrite.Domain]) == 0 {
+					index.add(rewrite)
+				}
+			}
+		}
+		stats.SafeSearch = len(rewrites)
+	}
+
+	return set, stats, nil
+}
+
+// compileRewrite validates a single rewrite rule configuration
+func compileRewrite(cfg RewriteRuleConfig) (*Rewrite, error) {
+	domain, wildcard := strings.CutPrefix(strings.TrimSpace(cfg.Domain), "*.")
+	rewrite := &Rewrite{
+		Domain:   normalizeDomain(domain),
+		Wildcard: wildcard,
+		Groups:   cfg.Groups,
+		Comment:  cfg.Comment,
+	}
+
+	if len(rewrite.Groups) == 0 {
+		rewrite.Groups = []string{DefaultGroupName}
+	}
+	if !isValidDomain(rewrite.Domain) {
+		return nil, fmt.Errorf("%w: invalid domain %q", ErrInvalidRewrite, cfg.Domain)
+	}
+
+	target := strings.TrimSpace(cfg.Target)
+	if ip := net.ParseIP(target); ip != nil {
+		if ip4 := ip.To4(); ip4 != nil {
+			ip = ip4
+		}
+		rewrite.Address = ip
+		return rewrite, nil
+	}
+
+	rewrite.Target = normalizeDomain(target)
+	if !isValidDomain(rewrite.Target) {
+		return nil, fmt.Errorf("%w: invalid target %q for %s", ErrInvalidRewrite, cfg.Target, cfg.Domain)
+	}
+	if rewrite.Target == rewrite.Domain {
+		return nil, fmt.Errorf("%w: %s rewrites to itself", ErrInvalidRewrite, cfg.Domain)
+	}
+	return rewrite, rewrite.encodeTarget()
+}
+
+// safeSearchRewrites returns the rewrites enforcing safe search for the
+// configured services and groups
+func safeSearchRewrites(config SafeSearchConfig) ([]*Rewrite, error) {
+	groups := config.Groups
+	if len(groups) == 0 {
+		groups = []string{DefaultGroupName}
+	}
+	services := config.Services
+	if len(services) == 0 {
+		services = safeSearchServices
+	}
+
+	var rewrites []*Rewrite
+	for _, service := range services {
+		target, ok := safeSearchEndpoints[service]
+		if !ok {
+			return nil, fmt.Errorf("%w: unknown safe search service %q", ErrInvalidRewrite, service)
+		}
+
+		domains := safeSearchDomains[service]
+		switch service {
+		case SafeSearchGoogle:
+			domains = nil
+			for _, tld := range googleSearchTLDs {
+				domains = append(domains, "google."+tld, "www.google."+tld)
+			}
+		case SafeSearchYouTube:
+			switch config.YouTube {
+			case "", YouTubeStrict:
+			case YouTubeModerate:
+				target = youtubeModerateEndpoint
+			default:
+				return nil, fmt.Errorf("%w: unknown YouTube mode %q", ErrInvalidRewrite, config.YouTube)
+			}
+		}
+
+		for _, domain := range domains {
+			rewrite := &Rewrite{
+				Domain:     domain,
+				Target:     target,
+				Groups:     groups,
+				Comment:    "safe search: " + service,
+				SafeSearch: true,
+			}
+			if err := rewrite.encodeTarget(); err != nil {
+				return nil, err
+			}
+			rewrites = append(rewrites, rewrite)
+		}
+	}
+	return rewrites, nil
+}
+
+// encodeTarget encodes the CNAME target of a rewrite
+func (r *Rewrite) encodeTarget() error {
+	data, err := (&TargetData{Target: r.Target}).Encode()
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidRewrite, err)
+	}
+	r.data = data
+	return nil
+}
+
+// index returns the rewrite index of a group, creating it if needed
+func (s *rewriteSet) index(group string) *rewriteIndex {
+	index, ok := s.groups[group]
+	if !ok {
+		index = &rewriteIndex{
+			exact:    make(map[string][]*Rewrite),
+			wildcard: make(map[string][]*Rewrite),
+		}
+		s.groups[group] = index
+	}
+	return index
+}
+
+// add indexes a rewrite. A name rewritten to a CNAME target can have no
+// other rewrites, as a CNAME cannot coexist with other records.
+func (idx *rewriteIndex) add(rewrite *Rewrite) error {
+	names := idx.exact
+	if rewrite.Wildcard {
+		names = idx.wildcard
+	}
+
+	existing := names[rewrite.Domain]
+	if len(existing) > 0 && (rewrite.Target != "" || existing[0].Target != "") {
+		return fmt.Errorf("%w: %s has a CNAME target and other rewrites", ErrInvalidRewrite, rewrite.Domain)
+	}
+	names[rewrite.Domain] = append(existing, rewrite)
+	return nil
+}
+
+// match returns the rewrites of a normalized name: exact ones first, then
+// those of the closest wildcard
+func (idx *rewriteIndex) match(name string) []*Rewrite {
+	if rewrites, ok := idx.exact[name]; ok {
+		return rewrites
+	}
+
+	if len(idx.wildcard) > 0 {
+		for suffix := name; suffix != ""; {
+			if rewrites, ok := idx.wildcard[suffix]; ok {
+				return rewrites
+			}
+			dot := strings.IndexByte(suffix, '.')
+			if dot < 0 {
+				break
+			}
+			suffix = suffix[dot+1:]
+		}
+	}
+
+	return nil
+}
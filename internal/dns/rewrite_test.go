@@ -0,0 +1,267 @@
+package dns
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"pihole-analyzer/internal/logger"
+)
+
+func testRewritesConfig() RewritesConfig {
+	return RewritesConfig{
+		Enabled: true,
+		Rules: []RewriteRuleConfig{
+			{Domain: "nas.home.example", Target: "192.168.1.10", Enabled: true},
+			{Domain: "nas.home.example", Target: "fd00::10", Enabled: true},
+			{Domain: "*.cdn.example", Target: "edge.example.net", Enabled: true},
+			{Domain: "games.example", Target: "0.0.0.0", Groups: []string{"kids"}, Enabled: true},
+			{Domain: "www.bing.com", Target: "bing.kids.example", Groups: []string{"kids"}, Enabled: true},
+			{Domain: "off.example", Target: "10.0.0.1", Enabled: false},
+		},
+		SafeSearch: SafeSearchConfig{
+			Enabled: true,
+			Groups:  []string{"kids"},
+			YouTube: YouTubeModerate,
+		},
+		TTL: 5 * time.Minute,
+	}
+}
+
+func TestRewriteEngine_Match(t *testing.T) {
+	engine := NewRewriteEngine()
+	if err := engine.Load(testRewritesConfig()); err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+
+	adult := []string{DefaultGroupName}
+	kid := []string{DefaultGroupName, "kids"}
+
+	tests := []struct {
+		name   string
+		groups []string
+		group  string // Empty for no match
+		target string
+	}{
+		{"nas.home.example", adult, DefaultGroupName, ""},
+		{"NAS.home.example.", kid, DefaultGroupName, ""},
+		{"cdn.example", adult, DefaultGroupName, "edge.example.net"},
+		{"img.eu.cdn.example", adult, DefaultGroupName, "edge.example.net"},
+		{"games.example", adult, "", ""},
+		{"games.example", kid, "kids", ""},
+		{"www.google.co.uk", kid, "kids", "forcesafesearch.google.com"},
+		{"google.com", kid, "kids", "forcesafesearch.google.com"},
+		{"www.google.com", adult, "", ""},
+		{"www.youtube.com", kid, "kids", "restrictmoderate.youtube.com"},
+		{"duckduckgo.com", kid, "kids", "safe.duckduckgo.com"},
+		{"www.bing.com", kid, "kids", "bing.kids.example"}, // The rule wins over safe search
+		{"off.example", adult, "", ""},
+		{"example.com", kid, "", ""},
+	}
+
+	for _, tt := range tests {
+		match := engine.Match(tt.name, tt.groups)
+		if tt.group == "" {
+			if match != nil {
+				t.Errorf("Match(%s, %v) = %+v, want nil", tt.name, tt.groups, match)
+			}
+			continue
+		}
+		if match == nil {
+			t.Errorf("Match(%s, %v) = nil, want group %s", tt.name, tt.groups, tt.group)
+			continue
+		}
+		if match.Group != tt.group || match.Target() != tt.target {
+			t.Errorf("Match(%s, %v) = group %s target %q, want %s %q",
+				tt.name, tt.groups, match.Group, match.Target(), tt.group, tt.target)
+		}
+	}
+
+	stats := engine.GetStats()
+	if stats.Rules != 5 || stats.SafeSearch == 0 || stats.Rewritten != 10 {
+		t.Errorf("Unexpected stats: %+v", stats)
+	}
+}
+
+func TestRewriteMatch_Answer(t *testing.T) {
+	engine := NewRewriteEngine()
+	if err := engine.Load(testRewritesConfig()); err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+
+	addresses := engine.Match("nas.home.example", []string{DefaultGroupName})
+	tests := []struct {
+		qtype   uint16
+		address string // Empty for NODATA
+	}{
+		{TypeA, "192.168.1.10"},
+		{TypeAAAA, "fd00::10"},
+		{TypeMX, ""},
+	}
+	for _, tt := range tests {
+		answers := addresses.answer(DNSQuestion{Name: "nas.home.example", Type: tt.qtype, Class: ClassIN})
+		if tt.address == "" {
+			if len(answers) != 0 {
+				t.Errorf("Type %d: expected no answers, got %+v", tt.qtype, answers)
+			}
+			continue
+		}
+		if len(answers) != 1 || answers[0].Type != tt.qtype || answers[0].TTL != 300 {
+			t.Errorf("Type %d: unexpected answers %+v", tt.qtype, answers)
+			continue
+		}
+		if !net.IP(answers[0].Data).Equal(net.ParseIP(tt.address)) {
+			t.Errorf("Type %d: got %v, want %s", tt.qtype, net.IP(answers[0].Data), tt.address)
+		}
+	}
+
+	cname := engine.Match("img.cdn.example", []string{DefaultGroupName})
+	answers := cname.answer(DNSQuestion{Name: "img.cdn.example", Type: TypeAAAA, Class: ClassIN})
+	if len(answers) != 1 || answers[0].Type != TypeCNAME || answers[0].Name != "img.cdn.example" {
+		t.Fatalf("Expected a CNAME answer, got %+v", answers)
+	}
+	if target, err := ParseTarget(answers[0]); err != nil || target.Target != "edge.example.net" {
+		t.Errorf("Expected target edge.example.net, got %+v (%v)", target, err)
+	}
+}
+
+func TestRewritesConfig_Validate(t *testing.T) {
+	rules := testRulesConfig()
+	if err := testRewritesConfig().validate(rules); err != nil {
+		t.Fatalf("validate failed: %v", err)
+	}
+
+	tests := map[string]struct {
+		modify func(c *RewritesConfig)
+		err    error
+	}{
+		"invalid domain": {func(c *RewritesConfig) {
+			c.Rules = append(c.Rules, RewriteRuleConfig{Domain: "bad domain!", Target: "10.0.0.1", Enabled: true})
+		}, ErrInvalidRewrite},
+		"invalid target": {func(c *RewritesConfig) {
+			c.Rules = append(c.Rules, RewriteRuleConfig{Domain: "a.example", Target: "not a name", Enabled: true})
+		}, ErrInvalidRewrite},
+		"rewrite to itself": {func(c *RewritesConfig) {
+			c.Rules = append(c.Rules, RewriteRuleConfig{Domain: "*.a.example", Target: "a.example", Enabled: true})
+		}, ErrInvalidRewrite},
+		"CNAME and address": {func(c *RewritesConfig) {
+			c.Rules = append(c.Rules, RewriteRuleConfig{Domain: "nas.home.example", Target: "nas.lan", Enabled: true})
+		}, ErrInvalidRewrite},
+		"unknown group": {func(c *RewritesConfig) {
+			c.Rules = append(c.Rules, RewriteRuleConfig{Domain: "a.example", Target: "10.0.0.1", Groups: []string{"guests"}, Enabled: true})
+		}, ErrUnknownGroup},
+		"unknown safe search group": {func(c *RewritesConfig) {
+			c.SafeSearch.Groups = []string{"guests"}
+		}, ErrUnknownGroup},
+		"unknown service": {func(c *RewritesConfig) {
+			c.SafeSearch.Services = []string{"altavista"}
+		}, ErrInvalidRewrite},
+		"unknown YouTube mode": {func(c *RewritesConfig) {
+			c.SafeSearch.YouTube = "relaxed"
+		}, ErrInvalidRewrite},
+		"negative TTL": {func(c *RewritesConfig) {
+			c.TTL = -time.Second
+		}, ErrInvalidRewrite},
+	}
+
+	for name, tt := range tests {
+		config := testRewritesConfig()
+		tt.modify(&config)
+		if err := config.validate(rules); !errors.Is(err, tt.err) {
+			t.Errorf("%s: expected %v, got %v", name, tt.err, err)
+		}
+	}
+
+	// Disabled rules and safe search are not checked for groups
+	config := testRewritesConfig()
+	config.Rules = append(config.Rules, RewriteRuleConfig{Domain: "a.example", Target: "10.0.0.1", Groups: []string{"guests"}})
+	config.SafeSearch = SafeSearchConfig{Groups: []string{"guests"}}
+	if err := config.validate(rules); err != nil {
+		t.Errorf("Expected disabled entries to be ignored, got %v", err)
+	}
+}
+
+func TestServer_Rewrites(t *testing.T) {
+	config := DefaultConfig()
+	config.LogQueries = false
+	config.Forwarder.HealthCheck = false
+	config.Rules = testRulesConfig()
+	config.Rewrites = testRewritesConfig()
+	if err := config.Validate(); err != nil {
+		t.Fatalf("Validate failed: %v", err)
+	}
+
+	testLogger := logger.New(&logger.Config{
+		Level:     logger.LogLevel("ERROR"),
+		Component: "dns-test",
+	})
+
+	upstream := &fakeTransport{}
+	server := NewServer(config, testLogger).(*Server)
+	server.forwarder = newFakeForwarder("round_robin", map[string]*fakeTransport{"upstream:53": upstream})
+	if err := server.ReloadRules(config.Rules); err != nil {
+		t.Fatalf("ReloadRules failed: %v", err)
+	}
+	if err := server.rewrites.Load(config.Rewrites); err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+
+	adult := udpClient("192.168.1.10")
+	kid := udpClient("192.168.1.50")
+
+	tests := []struct {
+		name    string
+		client  net.Addr
+		types   []uint16 // Answer record types in order
+		status  int
+		forward bool // Whether the upstream is asked
+	}{
+		{"www.google.com", kid, []uint16{TypeCNAME, TypeA}, QueryStatusForwarded, true},
+		{"www.google.de", kid, []uint16{TypeCNAME, TypeA}, QueryStatusCached, false}, // Same target, from the cache
+		{"www.google.com", adult, []uint16{TypeA}, QueryStatusForwarded, true},
+		{"nas.home.example", adult, []uint16{TypeA}, QueryStatusCached, false},
+		{"games.example", kid, []uint16{TypeA}, QueryStatusDenylist, false}, // Blocking comes first
+	}
+
+	for i, tt := range tests {
+		calls := upstream.calls.Load()
+		response, err := server.HandleQuery(context.Background(), &DNSQuery{
+			ID:       uint16(i),
+			Question: DNSQuestion{Name: tt.name, Type: TypeA, Class: ClassIN},
+			Client:   tt.client,
+			Protocol: "udp",
+		})
+		if err != nil {
+			t.Fatalf("HandleQuery(%s) failed: %v", tt.name, err)
+		}
+
+		if response.ID != uint16(i) || response.ResponseCode != RCodeNoError || len(response.Answers) != len(tt.types) {
+			t.Errorf("%d: unexpected response %+v", i, response)
+			continue
+		}
+		for j, rtype := range tt.types {
+			if response.Answers[j].Type != rtype {
+				t.Errorf("%d: answer %d has type %d, want %d", i, j, response.Answers[j].Type, rtype)
+			}
+		}
+		if response.Answers[0].Name != tt.name {
+			t.Errorf("%d: expected the first answer for %s, got %s", i, tt.name, response.Answers[0].Name)
+		}
+		if asked := upstream.calls.Load() > calls; asked != tt.forward {
+			t.Errorf("%d: upstream asked = %v, want %v", i, asked, tt.forward)
+		}
+	}
+
+	records := server.QueryLog().Records()
+	for i, tt := range tests {
+		if records[i].Status != tt.status {
+			t.Errorf("%d: logged status %d, want %d", i, records[i].Status, tt.status)
+		}
+	}
+
+	if stats := server.GetStats(); stats.RewrittenAnswers != 3 {
+		t.Errorf("Expected 3 rewritten answers, got %d", stats.RewrittenAnswers)
+	}
+}
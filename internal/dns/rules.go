@@ -0,0 +1,609 @@
+package dns
+
+import (
+	"fmt"
+	"net"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Domain rule kinds
+const (
+	RuleKindExact    = "exact"
+	RuleKindRegex    = "regex"
+	RuleKindWildcard = "wildcard"
+)
+
+// Domain rule actions
+const (
+	RuleActionDeny  = "deny"
+	RuleActionAllow = "allow"
+)
+
+// DomainRule is a compiled domain rule
+type DomainRule struct {
+	Domain   string
+	Kind     string
+	Action   string
+	Groups   []string
+	Comment  string
+	Schedule string
+
+	regex    *regexp.Regexp
+	schedule *Schedule
+}
+
+// RuleMatch describes the rule or group list that decided a query
+type RuleMatch struct {
+	Rule     *DomainRule // Nil for a group list
+	List     string      // Source of the group list that blocks the domain
+	Group    string
+	Schedule string // Schedule the rule or list applies during, if any
+}
+
+// Allowed reports whether the match allows the domain
+func (m *RuleMatch) Allowed() bool {
+	return m != nil && m.Rule != nil && m.Rule.Action == RuleActionAllow
+}
+
+// Denied reports whether the match denies the domain
+func (m *RuleMatch) Denied() bool {
+	return m != nil && (m.Rule == nil || m.Rule.Action == RuleActionDeny)
+}
+
+// RuleEngine implements the DNSRuleEngine interface.
+//
+// The compiled rule set is swapped atomically on Load, so lookups never
+// block on a reload and always see a consistent set of rules. Schedule
+// overrides are kept across reloads while their schedule exists.
+type RuleEngine struct {
+	rules    atomic.Pointer[ruleSet]
+	resolver MACResolver
+	now      func() time.Time
+
+	mu        sync.RWMutex
+	stats     RuleStats
+	overrides map[string]ScheduleOverride
+}
+
+// ruleSet is an immutable, compiled set of groups, clients, rules and
+// schedules
+type ruleSet struct {
+	clients   *clientTable
+	groups    map[string]*groupRules
+	schedules []*Schedule
+}
+
+// groupRules holds the compiled rules and lists assigned to one group and
+// the answer for its clients' blocked queries
+type groupRules struct {
+	allow    ruleIndex
+	deny     ruleIndex
+	lists    []*groupList
+	sinkhole SinkholeConfig
+}
+
+// ruleIndex indexes rules of one action by kind. A domain may have several
+// rules on different schedules.
+type ruleIndex struct {
+	exact    map[string][]*DomainRule
+	wildcard map[string][]*DomainRule
+	regex    []*DomainRule
+}
+
+// groupList is a compiled list file that blocks domains for some groups
+type groupList struct {
+	source   string
+	schedule *Schedule
+	exact    map[string]uint16
+	wildcard map[string]uint16
+}
+
+// NewRuleEngine creates a new, empty domain rule engine
+func NewRuleEngine(resolver MACResolver) DNSRuleEngine {
+	engine := &RuleEngine{
+		resolver:  resolver,
+		now:       time.Now,
+		overrides: make(map[string]ScheduleOverride),
+	}
+	engine.rules.Store(&ruleSet{
+		clients: &clientTable{
+			byMAC: make(map[string]*clientMatcher),
+			byIP:  make(map[string]*clientMatcher),
+		},
+		groups: make(map[string]*groupRules),
+	})
+	return engine
+}
+
+// Load compiles a rule configuration and replaces the active rule set.
+// On error the previous rule set stays in effect.
+func (e *RuleEngine) Load(config RulesConfig) error {
+	set, stats, err := compileRules(config)
+	if err != nil {
+		return err
+	}
+
+	e.rules.Store(set)
+
+	e.mu.Lock()
+	e.stats = *stats
+	e.stats.LastLoaded = time.Now()
+	for name := range e.overrides {
+		if set.schedule(name) == nil {
+			delete(e.overrides, name)
+		}
+	}
+	e.mu.Unlock()
+
+	return nil
+}
+
+// Match returns the rule or group list that applies to a domain for a
+// client, or nil. Allow rules in any of the client's groups take precedence
+// over deny rules, and deny rules over group lists. Rules and lists on a
+// schedule only apply while it is active.
+func (e *RuleEngine) Match(name string, client net.Addr) *RuleMatch {
+	name = normalizeDomain(name)
+	if name == "" {
+		return nil
+	}
+
+	set := e.rules.Load()
+	groups := e.GroupsFor(client)
+
+	now := e.now()
+	active := func(schedule *Schedule) bool {
+		return schedule == nil || e.scheduleActive(schedule, now)
+	}
+
+	for _, group := range groups {
+		rules, ok := set.groups[group]
+		if !ok {
+			continue
+		}
+		if rule := rules.allow.match(name, active); rule != nil {
+			return &RuleMatch{Rule: rule, Group: group, Schedule: rule.Schedule}
+		}
+	}
+
+	for _, group := range groups {
+		rules, ok := set.groups[group]
+		if !ok {
+			continue
+		}
+		if rule := rules.deny.match(name, active); rule != nil {
+			return &RuleMatch{Rule: rule, Group: group, Schedule: rule.Schedule}
+		}
+	}
+
+	for _, group := range groups {
+		rules, ok := set.groups[group]
+		if !ok {
+			continue
+		}
+		for _, list := range rules.lists {
+			if _, ok := lookupBlocklistEntry(name, list.exact, list.wildcard); ok && active(list.schedule) {
+				match := &RuleMatch{List: list.source, Group: group}
+				if list.schedule != nil {
+					match.Schedule = list.schedule.Name
+				}
+				return match
+			}
+		}
+	}
+
+	return nil
+}
+
+// GroupsFor returns the enabled groups a client belongs to
+func (e *RuleEngine) GroupsFor(client net.Addr) []string {
+	set := e.rules.Load()
+
+	var groups []string
+	for _, group := range set.clients.groupsFor(clientIP(client), e.resolver) {
+		if _, ok := set.groups[group]; ok {
+			groups = append(groups, group)
+		}
+	}
+	return groups
+}
+
+// Sinkhole returns the answer for a client's blocked queries set by
+// group, else by the first of the client's groups that sets one
+func (e *RuleEngine) Sinkhole(client net.Addr, group string) (SinkholeConfig, bool) {
+	set := e.rules.Load()
+	if rules, ok := set.groups[group]; ok && rules.sinkhole.Mode != "" {
+		return rules.sinkhole, true
+	}
+
+	for _, name := range e.GroupsFor(client) {
+		if rules, ok := set.groups[name]; ok && rules.sinkhole.Mode != "" {
+			return rules.sinkhole, true
+		}
+	}
+	return SinkholeConfig{}, false
+}
+
+// Schedules returns the state of every schedule, in configuration order
+func (e *RuleEngine) Schedules() []ScheduleState {
+	set := e.rules.Load()
+	now := e.now()
+
+	states := make([]ScheduleState, 0, len(set.schedules))
+	for _, schedule := range set.schedules {
+		states = append(states, e.scheduleState(schedule, now))
+	}
+	return states
+}
+
+// OverrideSchedule forces a schedule active or inactive for a duration,
+// replacing any earlier override
+func (e *RuleEngine) OverrideSchedule(name string, active bool, duration time.Duration) (*ScheduleState, error) {
+	schedule := e.rules.Load().schedule(name)
+	if schedule == nil {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownSchedule, name)
+	}
+	if duration <= 0 || duration > maxScheduleOverride {
+		return nil, fmt.Errorf("%w: override duration %s out of range", ErrInvalidSchedule, duration)
+	}
+
+	now := e.now()
+
+	e.mu.Lock()
+	for other, override := range e.overrides {
+		if !now.Before(override.Until) {
+			delete(e.overrides, other)
+		}
+	}
+	e.overrides[name] = ScheduleOverride{Active: active, Until: now.Add(duration)}
+	e.mu.Unlock()
+
+	state := e.scheduleState(schedule, now)
+	return &state, nil
+}
+
+// ClearScheduleOverride removes a schedule's override and reports whether
+// an unexpired one was set
+func (e *RuleEngine) ClearScheduleOverride(name string) bool {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	override, ok := e.overrides[name]
+	delete(e.overrides, name)
+	return ok && e.now().Before(override.Until)
+}
+
+// GetStats returns rule engine statistics
+func (e *RuleEngine) GetStats() *RuleStats {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+
+	stats := e.stats
+	return &stats
+}
+
+// scheduleActive reports whether a schedule is in effect at now, by its
+// override while one is set
+func (e *RuleEngine) scheduleActive(schedule *Schedule, now time.Time) bool {
+	if override, ok := e.override(schedule.Name, now); ok {
+		return override.Active
+	}
+	return schedule.Active(now)
+}
+
+// override returns a schedule's override if it has not expired at now
+func (e *RuleEngine) override(name string, now time.Time) (ScheduleOverride, bool) {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+
+	override, ok := e.overrides[name]
+	if !ok || !now.Before(override.Until) {
+		return ScheduleOverride{}, false
+	}
+	return override, true
+}
+
+// scheduleState describes a schedule at now
+func (e *RuleEngine) scheduleState(schedule *Schedule, now time.Time) ScheduleState {
+	state := ScheduleState{
+		Schedule:  schedule,
+		Scheduled: schedule.Active(now),
+		Next:      schedule.NextChange(now),
+		Groups:    schedule.groups,
+		Rules:     schedule.rules,
+		Lists:     schedule.lists,
+	}
+
+	state.Active = state.Scheduled
+	if override, ok := e.override(schedule.Name, now); ok {
+		state.Active = override.Active
+		state.Override = &override
+	}
+	return state
+}
+
+// schedule returns the compiled schedule with a name, or nil
+func (set *ruleSet) schedule(name string) *Schedule {
+	for _, schedule := range set.schedules {
+		if schedule.Name == name {
+			return schedule
+		}
+	}
+	return nil
+}
+
+// compileRules validates and compiles a rule configuration
+func compileRules(config RulesConfig) (*ruleSet, *RuleStats, error) {
+	set := &ruleSet{groups: make(map[string]*groupRules)}
+	stats := &RuleStats{}
+
+	for _, cfg := range config.Schedules {
+		schedule, err := compileSchedule(cfg)
+		if err != nil {
+			return nil, nil, err
+		}
+		if set.schedule(schedule.Name) != nil {
+			return nil, nil, fmt.Errorf("%w: duplicate schedule %s", ErrInvalidSchedule, schedule.Name)
+		}
+		set.schedules = append(set.schedules, schedule)
+	}
+	stats.Schedules = len(set.schedules)
+
+	// The default group always exists unless explicitly disabled
+	declared := map[string]bool{DefaultGroupName: true}
+	for _, group := range config.Groups {
+		if group.Name == "" {
+			return nil, nil, fmt.Errorf("%w: group without name", ErrInvalidRule)
+		}
+		if err := group.Sinkhole.validate(); err != nil {
+			return nil, nil, fmt.Errorf("%w (group %s)", err, group.Name)
+		}
+		declared[group.Name] = group.Enabled
+	}
+	for name, enabled := range declared {
+		if enabled {
+			set.groups[name] = newGroupRules()
+		}
+	}
+	for _, group := range config.Groups {
+		if rules, ok := set.groups[group.Name]; ok {
+			rules.sinkhole = group.Sinkhole
+		}
+	}
+	stats.Groups = len(set.groups)
+
+	for _, client := range config.Clients {
+		for _, group := range client.Groups {
+			if _, ok := declared[group]; !ok {
+				return nil, nil, fmt.Errorf("%w: %s", ErrUnknownGroup, group)
+			}
+		}
+	}
+
+	clients, err := newClientTable(config.Clients)
+	if err != nil {
+		return nil, nil, err
+	}
+	set.clients = clients
+	stats.Clients = len(config.Clients)
+
+	for _, cfg := range config.Domains {
+		if !cfg.Enabled {
+			continue
+		}
+
+		rule, err := compileDomainRule(cfg)
+		if err != nil {
+			return nil, nil, err
+		}
+		if rule.Schedule != "" {
+			if rule.schedule = set.schedule(rule.Schedule); rule.schedule == nil {
+				return nil, nil, fmt.Errorf("%w: %s", ErrUnknownSchedule, rule.Schedule)
+			}
+			rule.schedule.use(rule.Groups)
+			rule.schedule.rules++
+		}
+
+		for _, group := range rule.Groups {
+			enabled, ok := declared[group]
+			if !ok {
+				return nil, nil, fmt.Errorf("%w: %s", ErrUnknownGroup, group)
+			}
+			if !enabled {
+				continue
+			}
+
+			rules := set.groups[group]
+			if rule.Action == RuleActionAllow {
+				rules.allow.add(rule)
+			} else {
+				rules.deny.add(rule)
+			}
+		}
+
+		if rule.Action == RuleActionAllow {
+			stats.AllowRules++
+		} else {
+			stats.DenyRules++
+		}
+	}
+
+	for _, cfg := range config.Lists {
+		if !cfg.Enabled {
+			continue
+		}
+
+		list, groups, err := compileGroupList(cfg, set)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		for _, group := range groups {
+			enabled, ok := declared[group]
+			if !ok {
+				return nil, nil, fmt.Errorf("%w: %s", ErrUnknownGroup, group)
+			}
+			if enabled {
+				set.groups[group].lists = append(set.groups[group].lists, list)
+			}
+		}
+		stats.Lists++
+	}
+
+	return set, stats, nil
+}
+
+// compileGroupList reads the list file of a group list configuration and
+// returns the list and its groups
+func compileGroupList(cfg GroupListConfig, set *ruleSet) (*groupList, []string, error) {
+	list := &groupList{
+		source:   cfg.Source,
+		exact:    make(map[string]uint16),
+		wildcard: make(map[string]uint16),
+	}
+
+	groups := cfg.Groups
+	if len(groups) == 0 {
+		groups = []string{DefaultGroupName}
+	}
+
+	if cfg.Schedule != "" {
+		if list.schedule = set.schedule(cfg.Schedule); list.schedule == nil {
+			return nil, nil, fmt.Errorf("%w: %s", ErrUnknownSchedule, cfg.Schedule)
+		}
+		list.schedule.use(groups)
+		list.schedule.lists++
+	}
+
+	if cfg.Source == "" {
+		return nil, nil, fmt.Errorf("%w: group list without source", ErrInvalidRule)
+	}
+	if _, err := loadBlocklistFile(cfg.Source, 0, list.exact, list.wildcard); err != nil {
+		return nil, nil, fmt.Errorf("failed to load group list %s: %w", cfg.Source, err)
+	}
+
+	return list, groups, nil
+}
+
+// use records groups that have rules or lists on the schedule
+func (s *Schedule) use(groups []string) {
+	for _, group := range groups {
+		if !slices.Contains(s.groups, group) {
+			s.groups = append(s.groups, group)
+		}
+	}
+}
+
+// compileDomainRule validates a single domain rule configuration
+func compileDomainRule(cfg DomainRuleConfig) (*DomainRule, error) {
+	rule := &DomainRule{
+		Kind:     cfg.Kind,
+		Action:   cfg.Action,
+		Groups:   cfg.Groups,
+		Comment:  cfg.Comment,
+		Schedule: cfg.Schedule,
+	}
+
+	if rule.Kind == "" {
+		rule.Kind = RuleKindExact
+	}
+	if len(rule.Groups) == 0 {
+		rule.Groups = []string{DefaultGroupName}
+	}
+	if rule.Action != RuleActionDeny && rule.Action != RuleActionAllow {
+		return nil, fmt.Errorf("%w: unknown action %q", ErrInvalidRule, cfg.Action)
+	}
+
+	switch rule.Kind {
+	case RuleKindExact:
+		rule.Domain = normalizeDomain(cfg.Domain)
+	case RuleKindWildcard:
+		rule.Domain = normalizeDomain(strings.TrimPrefix(cfg.Domain, "*."))
+	case RuleKindRegex:
+		re, err := regexp.Compile(cfg.Domain)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidRule, err)
+		}
+		rule.Domain = cfg.Domain
+		rule.regex = re
+		return rule, nil
+	default:
+		return nil, fmt.Errorf("%w: unknown kind %q", ErrInvalidRule, cfg.Kind)
+	}
+
+	if !isValidDomain(rule.Domain) {
+		return nil, fmt.Errorf("%w: invalid domain %q", ErrInvalidRule, cfg.Domain)
+	}
+
+	return rule, nil
+}
+
+// newGroupRules creates an empty set of group rules
+func newGroupRules() *groupRules {
+	return &groupRules{
+		allow: ruleIndex{
+			exact:    make(map[string][]*DomainRule),
+			wildcard: make(map[string][]*DomainRule),
+		},
+		deny: ruleIndex{
+			exact:    make(map[string][]*DomainRule),
+			wildcard: make(map[string][]*DomainRule),
+		},
+	}
+}
+
+// add indexes a rule by its kind
+func (idx *ruleIndex) add(rule *DomainRule) {
+	switch rule.Kind {
+	case RuleKindExact:
+		idx.exact[rule.Domain] = append(idx.exact[rule.Domain], rule)
+	case RuleKindWildcard:
+		idx.wildcard[rule.Domain] = append(idx.wildcard[rule.Domain], rule)
+	case RuleKindRegex:
+		idx.regex = append(idx.regex, rule)
+	}
+}
+
+// match returns the first rule matching a normalized domain whose
+// schedule is active
+func (idx *ruleIndex) match(name string, active func(*Schedule) bool) *DomainRule {
+	if rule := firstActive(idx.exact[name], active); rule != nil {
+		return rule
+	}
+
+	if len(idx.wildcard) > 0 {
+		for suffix := name; suffix != ""; {
+			if rule := firstActive(idx.wildcard[suffix], active); rule != nil {
+				return rule
+			}
+			dot := strings.IndexByte(suffix, '.')
+			if dot < 0 {
+				break
+			}
+			suffix = suffix[dot+1:]
+		}
+	}
+
+	for _, rule := range idx.regex {
+		if active(rule.schedule) && rule.regex.MatchString(name) {
+			return rule
+		}
+	}
+
+	return nil
+}
+
+// firstActive returns the first rule whose schedule is active
+func firstActive(rules []*DomainRule, active func(*Schedule) bool) *DomainRule {
+	for _, rule := range rules {
+		if active(rule.schedule) {
+			return rule
+		}
+	}
+	return nil
+}
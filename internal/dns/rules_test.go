@@ -0,0 +1,235 @@
+package dns
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+
+	"pihole-analyzer/internal/logger"
+)
+
+// staticMACResolver resolves MAC addresses from a fixed table
+type staticMACResolver map[string]string
+
+func (r staticMACResolver) ResolveMAC(ip net.IP) (string, bool) {
+	mac, ok := r[ip.String()]
+	return mac, ok
+}
+
+func udpClient(ip string) net.Addr {
+	return &net.UDPAddr{IP: net.ParseIP(ip), Port: 53000}
+}
+
+func testRulesConfig() RulesConfig {
+	return RulesConfig{
+		Enabled: true,
+		Groups: []GroupConfig{
+			{Name: DefaultGroupName, Enabled: true},
+			{Name: "kids", Enabled: true},
+			{Name: "iot", Enabled: true},
+			{Name: "disabled", Enabled: false},
+		},
+		Clients: []ClientConfig{
+			{Network: "192.168.1.0/24", Groups: []string{DefaultGroupName}},
+			{Network: "192.168.1.128/25", Groups: []string{"iot"}},
+			{IP: "192.168.1.50", Groups: []string{DefaultGroupName, "kids"}},
+			{MAC: "AA:BB:CC:DD:EE:FF", Groups: []string{"kids"}},
+			{IP: "192.168.1.60", Groups: []string{"disabled"}},
+		},
+		Domains: []DomainRuleConfig{
+			{Domain: "ads.example.com", Kind: RuleKindExact, Action: RuleActionDeny, Enabled: true},
+			{Domain: "*.games.example", Kind: RuleKindWildcard, Action: RuleActionDeny, Groups: []string{"kids"}, Enabled: true},
+			{Domain: `^tracker[0-9]+\.`, Kind: RuleKindRegex, Action: RuleActionDeny, Groups: []string{"iot"}, Enabled: true},
+			{Domain: "ok.games.example", Kind: RuleKindExact, Action: RuleActionAllow, Groups: []string{"kids"}, Enabled: true},
+			{Domain: "ads.example.com", Kind: RuleKindExact, Action: RuleActionAllow, Groups: []string{"kids"}, Enabled: true},
+			{Domain: "inactive.example", Kind: RuleKindExact, Action: RuleActionDeny, Enabled: false},
+			{Domain: "hidden.example", Kind: RuleKindExact, Action: RuleActionDeny, Groups: []string{"disabled"}, Enabled: true},
+		},
+	}
+}
+
+func TestRuleEngine_GroupsFor(t *testing.T) {
+	engine := NewRuleEngine(staticMACResolver{"192.168.1.77": "aa:bb:cc:dd:ee:ff"})
+	if err := engine.Load(testRulesConfig()); err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+
+	tests := []struct {
+		client string
+		groups []string
+	}{
+		{"192.168.1.10", []string{DefaultGroupName}},
+		{"192.168.1.200", []string{"iot"}},                   // longest prefix wins
+		{"192.168.1.50", []string{DefaultGroupName, "kids"}}, // exact IP beats network
+		{"192.168.1.77", []string{"kids"}},                   // MAC beats everything
+		{"10.0.0.1", []string{DefaultGroupName}},             // unknown client
+		{"192.168.1.60", nil},                                // only in disabled group
+	}
+
+	for _, tt := range tests {
+		groups := engine.GroupsFor(udpClient(tt.client))
+		if len(groups) != len(tt.groups) {
+			t.Errorf("GroupsFor(%s) = %v, want %v", tt.client, groups, tt.groups)
+			continue
+		}
+		for i := range groups {
+			if groups[i] != tt.groups[i] {
+				t.Errorf("GroupsFor(%s) = %v, want %v", tt.client, groups, tt.groups)
+				break
+			}
+		}
+	}
+}
+
+func TestRuleEngine_Match(t *testing.T) {
+	engine := NewRuleEngine(staticMACResolver{"192.168.1.77": "aa:bb:cc:dd:ee:ff"})
+	if err := engine.Load(testRulesConfig()); err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+
+	tests := []struct {
+		name    string
+		domain  string
+		client  string
+		allowed bool
+		denied  bool
+	}{
+		{"exact deny in default group", "ads.example.com", "192.168.1.10", false, true},
+		{"allow overrides deny across groups", "ads.example.com", "192.168.1.50", true, false},
+		{"exact deny not assigned to group", "ads.example.com", "192.168.1.77", true, false},
+		{"wildcard covers apex", "games.example", "192.168.1.77", false, true},
+		{"wildcard covers subdomain", "play.games.example", "192.168.1.77", false, true},
+		{"allow overrides wildcard", "ok.games.example", "192.168.1.77", true, false},
+		{"wildcard scoped to group", "play.games.example", "192.168.1.10", false, false},
+		{"regex deny", "tracker42.vendor.example", "192.168.1.200", false, true},
+		{"regex no match", "tracker.vendor.example", "192.168.1.200", false, false},
+		{"disabled rule ignored", "inactive.example", "192.168.1.10", false, false},
+		{"disabled group ignored", "hidden.example", "192.168.1.60", false, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			match := engine.Match(tt.domain, udpClient(tt.client))
+			if match.Allowed() != tt.allowed {
+				t.Errorf("Allowed() = %v, want %v", match.Allowed(), tt.allowed)
+			}
+			if match.Denied() != tt.denied {
+				t.Errorf("Denied() = %v, want %v", match.Denied(), tt.denied)
+			}
+		})
+	}
+}
+
+func TestRuleEngine_LoadInvalid(t *testing.T) {
+	tests := []struct {
+		name    string
+		config  RulesConfig
+		wantErr error
+	}{
+		{
+			name: "bad regex",
+			config: RulesConfig{Domains: []DomainRuleConfig{
+				{Domain: "(", Kind: RuleKindRegex, Action: RuleActionDeny, Enabled: true},
+			}},
+			wantErr: ErrInvalidRule,
+		},
+		{
+			name: "unknown action",
+			config: RulesConfig{Domains: []DomainRuleConfig{
+				{Domain: "example.com", Action: "block", Enabled: true},
+			}},
+			wantErr: ErrInvalidRule,
+		},
+		{
+			name: "unknown group in rule",
+			config: RulesConfig{Domains: []DomainRuleConfig{
+				{Domain: "example.com", Action: RuleActionDeny, Groups: []string{"nope"}, Enabled: true},
+			}},
+			wantErr: ErrUnknownGroup,
+		},
+		{
+			name: "unknown group in client",
+			config: RulesConfig{Clients: []ClientConfig{
+				{IP: "10.0.0.1", Groups: []string{"nope"}},
+			}},
+			wantErr: ErrUnknownGroup,
+		},
+		{
+			name: "invalid client",
+			config: RulesConfig{Clients: []ClientConfig{
+				{Network: "10.0.0.0/99", Groups: []string{DefaultGroupName}},
+			}},
+			wantErr: ErrInvalidClient,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			engine := NewRuleEngine(nil)
+			if err := engine.Load(tt.config); !errors.Is(err, tt.wantErr) {
+				t.Errorf("Load() error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestServer_ReloadRules(t *testing.T) {
+	config := DefaultConfig()
+	config.LogQueries = false
+	config.Forwarder.Enabled = false
+	config.Forwarder.HealthCheck = false
+	config.Cache.Enabled = false
+
+	testLogger := logger.New(&logger.Config{
+		Level:     logger.LogLevel("ERROR"),
+		Component: "dns-test",
+	})
+
+	server := NewServer(config, testLogger).(*Server)
+
+	query := &DNSQuery{
+		ID:       7,
+		Question: DNSQuestion{Name: "ads.example.com", Type: TypeA, Class: ClassIN},
+		Client:   udpClient("192.168.1.10"),
+		Protocol: "udp",
+	}
+
+	// No rules loaded: query is forwarded (and fails, forwarding is disabled)
+	response, err := server.HandleQuery(context.Background(), query)
+	if err != nil {
+		t.Fatalf("HandleQuery failed: %v", err)
+	}
+	if response.ResponseCode != RCodeServFail {
+		t.Errorf("Expected SERVFAIL before reload, got %d", response.ResponseCode)
+	}
+
+	if err := server.ReloadRules(testRulesConfig()); err != nil {
+		t.Fatalf("ReloadRules failed: %v", err)
+	}
+
+	response, err = server.HandleQuery(context.Background(), query)
+	if err != nil {
+		t.Fatalf("HandleQuery failed: %v", err)
+	}
+	if response.ResponseCode != RCodeNoError || len(response.Answers) != 1 {
+		t.Errorf("Expected blocked answer after reload, got rcode %d with %d answers",
+			response.ResponseCode, len(response.Answers))
+	}
+
+	// An invalid reload keeps the current rules
+	invalid := testRulesConfig()
+	invalid.Domains = append(invalid.Domains, DomainRuleConfig{
+		Domain: "(", Kind: RuleKindRegex, Action: RuleActionDeny, Enabled: true,
+	})
+	if err := server.ReloadRules(invalid); err == nil {
+		t.Error("Expected error reloading invalid rules")
+	}
+
+	if stats := server.GetStats(); stats.QueriesBlocked != 1 {
+		t.Errorf("Expected 1 blocked query, got %d", stats.QueriesBlocked)
+	}
+	if match := server.rules.Match("ads.example.com", query.Client); !match.Denied() {
+		t.Error("Expected previous rules to remain active after failed reload")
+	}
+}
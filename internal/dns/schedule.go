@@ -0,0 +1,217 @@
+package dns
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// maxScheduleOverride bounds how long a temporary override lasts
+const maxScheduleOverride = 7 * 24 * time.Hour
+
+// minutesPerDay is the number of minutes in a day without DST changes
+const minutesPerDay = 24 * 60
+
+// scheduleDays maps the day names accepted in schedule ranges
+var scheduleDays = map[string]time.Weekday{
+	"sun": time.Sunday, "sunday": time.Sunday,
+	"mon": time.Monday, "monday": time.Monday,
+	"tue": time.Tuesday, "tuesday": time.Tuesday,
+	"wed": time.Wednesday, "wednesday": time.Wednesday,
+	"thu": time.Thursday, "thursday": time.Thursday,
+	"fri": time.Friday, "friday": time.Friday,
+	"sat": time.Saturday, "saturday": time.Saturday,
+}
+
+// Schedule is a compiled weekly schedule. Times are evaluated as wall
+// clock times in the schedule's timezone.
+type Schedule struct {
+	Name     string
+	Timezone string
+	Ranges   []ScheduleRangeConfig
+	Comment  string
+
+	location *time.Location
+	ranges   []scheduleRange
+
+	// Rules, lists and groups that refer to the schedule
+	rules  int
+	lists  int
+	groups []string
+}
+
+// scheduleRange is a compiled range, in minutes after midnight
+type scheduleRange struct {
+	days  [7]bool
+	start int
+	end   int
+}
+
+// ScheduleOverride forces the state of a schedule until it expires
+type ScheduleOverride struct {
+	Active bool
+	Until  time.Time
+}
+
+// ScheduleState describes a schedule and its state at some time
+type ScheduleState struct {
+	Schedule  *Schedule
+	Active    bool              // State in effect, including an override
+	Scheduled bool              // State by the schedule's ranges alone
+	Next      time.Time         // Next change of the scheduled state, zero if it never changes
+	Override  *ScheduleOverride // Unexpired override, if any
+	Groups    []string          // Groups with rules or lists on the schedule
+	Rules     int
+	Lists     int
+}
+
+// compileSchedule validates and compiles a schedule configuration
+func compileSchedule(cfg ScheduleConfig) (*Schedule, error) {
+	if cfg.Name == "" {
+		return nil, fmt.Errorf("%w: schedule without name", ErrInvalidSchedule)
+	}
+
+	schedule := &Schedule{
+		Name:     cfg.Name,
+		Timezone: cfg.Timezone,
+		Ranges:   cfg.Ranges,
+		Comment:  cfg.Comment,
+		location: time.Local,
+	}
+
+	if cfg.Timezone != "" {
+		location, err := time.LoadLocation(cfg.Timezone)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %s: unknown timezone %q", ErrInvalidSchedule, cfg.Name, cfg.Timezone)
+		}
+		schedule.location = location
+	}
+
+	if len(cfg.Ranges) == 0 {
+		return nil, fmt.Errorf("%w: %s: no time ranges", ErrInvalidSchedule, cfg.Name)
+	}
+	for _, rc := range cfg.Ranges {
+		r, err := compileScheduleRange(rc)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %s: %v", ErrInvalidSchedule, cfg.Name, err)
+		}
+		schedule.ranges = append(schedule.ranges, r)
+	}
+
+	return schedule, nil
+}
+
+// compileScheduleRange parses the days and times of a range
+func compileScheduleRange(cfg ScheduleRangeConfig) (scheduleRange, error) {
+	var r scheduleRange
+
+	if len(cfg.Days) == 0 {
+		for day := range r.days {
+			r.days[day] = true
+		}
+	}
+	for _, name := range cfg.Days {
+		day, ok := scheduleDays[strings.ToLower(strings.TrimSpace(name))]
+		if !ok {
+			return r, fmt.Errorf("unknown day %q", name)
+		}
+		r.days[day] = true
+	}
+
+	var ok bool
+	if r.start, ok = parseClock(cfg.Start); !ok || r.start == minutesPerDay {
+		return r, fmt.Errorf("invalid start time %q", cfg.Start)
+	}
+	if r.end, ok = parseClock(cfg.End); !ok {
+		return r, fmt.Errorf("invalid end time %q", cfg.End)
+	}
+
+	return r, nil
+}
+
+// parseClock parses an "HH:MM" time of day into minutes after midnight.
+// "24:00" is accepted for the end of the day.
+func parseClock(s string) (int, bool) {
+	hours, minutes, ok := strings.Cut(strings.TrimSpace(s), ":")
+	if !ok || len(hours) == 0 || len(hours) > 2 || len(minutes) != 2 {
+		return 0, false
+	}
+
+	h, err := strconv.Atoi(hours)
+	if err != nil || h < 0 || h > 24 {
+		return 0, false
+	}
+	m, err := strconv.Atoi(minutes)
+	if err != nil || m < 0 || m > 59 || (h == 24 && m != 0) {
+		return 0, false
+	}
+
+	return h*60 + m, true
+}
+
+// Location returns the timezone the schedule is evaluated in
+func (s *Schedule) Location() *time.Location {
+	return s.location
+}
+
+// Active reports whether one of the schedule's ranges covers t
+func (s *Schedule) Active(t time.Time) bool {
+	local := t.In(s.location)
+	day := local.Weekday()
+	minute := local.Hour()*60 + local.Minute()
+
+	for _, r := range s.ranges {
+		if r.active(day, minute) {
+			return true
+		}
+	}
+	return false
+}
+
+// active reports whether the range covers a minute of a day. A range that
+// ends before it starts covers the start of the next day too.
+func (r scheduleRange) active(day time.Weekday, minute int) bool {
+	switch {
+	case r.start == r.end:
+		return r.days[day]
+	case r.start < r.end:
+		return r.days[day] && minute >= r.start && minute < r.end
+	default:
+		previous := (day + 6) % 7
+		return (r.days[day] && minute >= r.start) || (r.days[previous] && minute < r.end)
+	}
+}
+
+// NextChange returns the first time after t at which the schedule's
+// state changes, or the zero time if it never does
+func (s *Schedule) NextChange(t time.Time) time.Time {
+	local := t.In(s.location)
+	midnight := time.Date(local.Year(), local.Month(), local.Day(), 0, 0, 0, 0, s.location)
+
+	// Every change happens at the start or end of a range, or at midnight
+	// for whole days, so these times in the coming week are the only
+	// candidates
+	var candidates []time.Time
+	for day := 0; day <= 8; day++ {
+		for _, r := range s.ranges {
+			for _, minute := range []int{0, r.start, r.end % minutesPerDay} {
+				boundary := time.Date(midnight.Year(), midnight.Month(), midnight.Day()+day,
+					minute/60, minute%60, 0, 0, s.location)
+				if boundary.After(t) {
+					candidates = append(candidates, boundary)
+				}
+			}
+		}
+	}
+	sort.Slice(candidates, func(i, j int) bool { return candidates[i].Before(candidates[j]) })
+
+	active := s.Active(t)
+	for _, candidate := range candidates {
+		if s.Active(candidate) != active {
+			return candidate
+		}
+	}
+	return time.Time{}
+}
@@ -0,0 +1,394 @@
+package dns
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"pihole-analyzer/internal/logger"
+)
+
+func loadBerlin(t *testing.T) *time.Location {
+	t.Helper()
+
+	berlin, err := time.LoadLocation("Europe/Berlin")
+	if err != nil {
+		t.Skipf("Timezone data not available: %v", err)
+	}
+	return berlin
+}
+
+func testScheduleRulesConfig(t *testing.T) RulesConfig {
+	t.Helper()
+
+	social := writeTestList(t, "social.txt", "social.example\n||video.example^\n")
+
+	config := testRulesConfig()
+	config.Schedules = []ScheduleConfig{
+		{
+			Name:     "school-nights",
+			Timezone: "Europe/Berlin",
+			Ranges:   []ScheduleRangeConfig{{Days: []string{"sun", "mon", "tue", "wed", "thu"}, Start: "21:00", End: "07:00"}},
+		},
+		{
+			Name:     "weekend",
+			Timezone: "Europe/Berlin",
+			Ranges:   []ScheduleRangeConfig{{Days: []string{"Saturday", "Sunday"}, Start: "00:00", End: "00:00"}},
+		},
+	}
+	config.Domains = append(config.Domains,
+		DomainRuleConfig{Domain: "chat.example", Action: RuleActionDeny, Groups: []string{"kids"}, Enabled: true, Schedule: "school-nights"},
+		DomainRuleConfig{Domain: "chat.example", Action: RuleActionDeny, Groups: []string{"kids"}, Enabled: true, Schedule: "weekend"},
+		DomainRuleConfig{Domain: "*.games.example", Kind: RuleKindWildcard, Action: RuleActionAllow, Groups: []string{"kids"}, Enabled: true, Schedule: "weekend"},
+	)
+	config.Lists = []GroupListConfig{
+		{Source: social, Groups: []string{"kids"}, Enabled: true, Schedule: "school-nights"},
+	}
+	return config
+}
+
+func TestSchedule_Active(t *testing.T) {
+	berlin := loadBerlin(t)
+
+	schedule, err := compileSchedule(ScheduleConfig{
+		Name:     "school-nights",
+		Timezone: "Europe/Berlin",
+		Ranges: []ScheduleRangeConfig{
+			{Days: []string{"sun", "mon", "tue", "wed", "thu"}, Start: "21:00", End: "07:00"},
+			{Days: []string{"wed"}, Start: "14:00", End: "16:30"},
+		},
+	})
+	if err != nil {
+		t.Fatalf("compileSchedule failed: %v", err)
+	}
+
+	tests := []struct {
+		time   time.Time
+		active bool
+	}{
+		{time.Date(2026, 10, 12, 6, 59, 0, 0, berlin), true},    // Monday morning, from Sunday night
+		{time.Date(2026, 10, 12, 7, 0, 0, 0, berlin), false},    // End is exclusive
+		{time.Date(2026, 10, 12, 21, 0, 0, 0, berlin), true},    // Start is inclusive
+		{time.Date(2026, 10, 12, 19, 30, 0, 0, time.UTC), true}, // 21:30 in Berlin
+		{time.Date(2026, 10, 14, 15, 0, 0, 0, berlin), true},    // Wednesday afternoon
+		{time.Date(2026, 10, 14, 16, 30, 0, 0, berlin), false},
+		{time.Date(2026, 10, 16, 6, 0, 0, 0, berlin), true}, // Friday morning, from Thursday night
+		{time.Date(2026, 10, 16, 22, 0, 0, 0, berlin), false},
+		{time.Date(2026, 10, 17, 6, 0, 0, 0, berlin), false},
+		{time.Date(2026, 10, 18, 23, 0, 0, 0, berlin), true},
+	}
+
+	for _, tt := range tests {
+		if active := schedule.Active(tt.time); active != tt.active {
+			t.Errorf("Active(%s) = %v, want %v", tt.time.In(berlin).Format("Mon 15:04"), active, tt.active)
+		}
+	}
+}
+
+func TestSchedule_NextChange(t *testing.T) {
+	berlin := loadBerlin(t)
+
+	nights, err := compileSchedule(ScheduleConfig{
+		Name:     "school-nights",
+		Timezone: "Europe/Berlin",
+		Ranges:   []ScheduleRangeConfig{{Days: []string{"sun", "mon", "tue", "wed", "thu"}, Start: "21:00", End: "07:00"}},
+	})
+	if err != nil {
+		t.Fatalf("compileSchedule failed: %v", err)
+	}
+	weekend, err := compileSchedule(ScheduleConfig{
+		Name:     "weekend",
+		Timezone: "Europe/Berlin",
+		Ranges:   []ScheduleRangeConfig{{Days: []string{"sat", "sun"}, Start: "00:00", End: "00:00"}},
+	})
+	if err != nil {
+		t.Fatalf("compileSchedule failed: %v", err)
+	}
+	always, err := compileSchedule(ScheduleConfig{
+		Name:   "always",
+		Ranges: []ScheduleRangeConfig{{Start: "00:00", End: "24:00"}},
+	})
+	if err != nil {
+		t.Fatalf("compileSchedule failed: %v", err)
+	}
+
+	tests := []struct {
+		schedule *Schedule
+		time     time.Time
+		next     time.Time
+	}{
+		{nights, time.Date(2026, 10, 12, 12, 0, 0, 0, berlin), time.Date(2026, 10, 12, 21, 0, 0, 0, berlin)},
+		{nights, time.Date(2026, 10, 12, 21, 0, 0, 0, berlin), time.Date(2026, 10, 13, 7, 0, 0, 0, berlin)},
+		{nights, time.Date(2026, 10, 16, 12, 0, 0, 0, berlin), time.Date(2026, 10, 18, 21, 0, 0, 0, berlin)},
+		{weekend, time.Date(2026, 10, 16, 12, 0, 0, 0, berlin), time.Date(2026, 10, 17, 0, 0, 0, 0, berlin)},
+		{weekend, time.Date(2026, 10, 17, 12, 0, 0, 0, berlin), time.Date(2026, 10, 19, 0, 0, 0, 0, berlin)},
+		{always, time.Date(2026, 10, 17, 12, 0, 0, 0, berlin), time.Time{}},
+	}
+
+	for _, tt := range tests {
+		if next := tt.schedule.NextChange(tt.time); !next.Equal(tt.next) {
+			t.Errorf("%s: NextChange(%s) = %s, want %s", tt.schedule.Name, tt.time, next, tt.next)
+		}
+	}
+}
+
+func TestCompileSchedule_Invalid(t *testing.T) {
+	valid := []ScheduleRangeConfig{{Start: "21:00", End: "07:00"}}
+
+	invalid := []ScheduleConfig{
+		{Ranges: valid},
+		{Name: "no-ranges"},
+		{Name: "bad-timezone", Timezone: "Mars/Olympus", Ranges: valid},
+		{Name: "bad-day", Ranges: []ScheduleRangeConfig{{Days: []string{"funday"}, Start: "21:00", End: "07:00"}}},
+		{Name: "bad-start", Ranges: []ScheduleRangeConfig{{Start: "9pm", End: "07:00"}}},
+		{Name: "bad-end", Ranges: []ScheduleRangeConfig{{Start: "21:00", End: "07:60"}}},
+		{Name: "start-at-midnight", Ranges: []ScheduleRangeConfig{{Start: "24:00", End: "07:00"}}},
+		{Name: "past-midnight", Ranges: []ScheduleRangeConfig{{Start: "21:00", End: "24:30"}}},
+	}
+
+	for _, config := range invalid {
+		if _, err := compileSchedule(config); !errors.Is(err, ErrInvalidSchedule) {
+			t.Errorf("compileSchedule(%q) = %v, want ErrInvalidSchedule", config.Name, err)
+		}
+	}
+}
+
+func TestRuleEngine_Schedules(t *testing.T) {
+	berlin := loadBerlin(t)
+
+	engine := NewRuleEngine(nil).(*RuleEngine)
+	config := testScheduleRulesConfig(t)
+	if err := engine.Load(config); err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+
+	now := time.Date(2026, 10, 12, 22, 0, 0, 0, berlin) // Monday night
+	engine.now = func() time.Time { return now }
+
+	kid := udpClient("192.168.1.50")
+	adult := udpClient("192.168.1.10")
+
+	type result struct {
+		domain   string
+		client   string
+		allowed  bool
+		denied   bool
+		schedule string
+	}
+	check := func(when string, results []result) {
+		t.Helper()
+		for _, tt := range results {
+			client := kid
+			if tt.client == "adult" {
+				client = adult
+			}
+			match := engine.Match(tt.domain, client)
+			if match.Allowed() != tt.allowed || match.Denied() != tt.denied {
+				t.Errorf("%s: Match(%s, %s) = %+v, want allowed %v denied %v",
+					when, tt.domain, tt.client, match, tt.allowed, tt.denied)
+				continue
+			}
+			if match != nil && match.Schedule != tt.schedule {
+				t.Errorf("%s: Match(%s) schedule %q, want %q", when, tt.domain, match.Schedule, tt.schedule)
+			}
+		}
+	}
+
+	check("school night", []result{
+		{"chat.example", "kid", false, true, "school-nights"},
+		{"social.example", "kid", false, true, "school-nights"},
+		{"www.video.example", "kid", false, true, "school-nights"},
+		{"play.games.example", "kid", false, true, ""},
+		{"social.example", "adult", false, false, ""},
+	})
+
+	if match := engine.Match("social.example", kid); match.Rule != nil || match.List != config.Lists[0].Source || match.Group != "kids" {
+		t.Errorf("Expected the group list to match, got %+v", match)
+	}
+
+	now = time.Date(2026, 10, 12, 12, 0, 0, 0, berlin) // Monday noon
+	check("school day", []result{
+		{"chat.example", "kid", false, false, ""},
+		{"social.example", "kid", false, false, ""},
+		{"play.games.example", "kid", false, true, ""},
+	})
+
+	now = time.Date(2026, 10, 17, 12, 0, 0, 0, berlin) // Saturday noon
+	check("weekend", []result{
+		{"chat.example", "kid", false, true, "weekend"},
+		{"social.example", "kid", false, false, ""},
+		{"play.games.example", "kid", true, false, "weekend"},
+	})
+
+	states := engine.Schedules()
+	if len(states) != 2 {
+		t.Fatalf("Expected 2 schedules, got %d", len(states))
+	}
+	nights := states[0]
+	if nights.Schedule.Name != "school-nights" || nights.Active || nights.Scheduled || nights.Override != nil {
+		t.Errorf("Unexpected school-nights state: %+v", nights)
+	}
+	if want := time.Date(2026, 10, 18, 21, 0, 0, 0, berlin); !nights.Next.Equal(want) {
+		t.Errorf("Expected the next change at %s, got %s", want, nights.Next)
+	}
+	if len(nights.Groups) != 1 || nights.Groups[0] != "kids" || nights.Rules != 1 || nights.Lists != 1 {
+		t.Errorf("Unexpected school-nights usage: %+v", nights)
+	}
+	if weekend := states[1]; !weekend.Active || weekend.Rules != 2 || weekend.Lists != 0 {
+		t.Errorf("Unexpected weekend state: %+v", weekend)
+	}
+
+	stats := engine.GetStats()
+	if stats.Schedules != 2 || stats.Lists != 1 {
+		t.Errorf("Unexpected stats: %+v", stats)
+	}
+}
+
+func TestRuleEngine_ScheduleOverrides(t *testing.T) {
+	berlin := loadBerlin(t)
+
+	engine := NewRuleEngine(nil).(*RuleEngine)
+	config := testScheduleRulesConfig(t)
+	if err := engine.Load(config); err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+
+	now := time.Date(2026, 10, 12, 22, 0, 0, 0, berlin)
+	engine.now = func() time.Time { return now }
+	kid := udpClient("192.168.1.50")
+
+	// Unblock for 30 minutes
+	state, err := engine.OverrideSchedule("school-nights", false, 30*time.Minute)
+	if err != nil {
+		t.Fatalf("OverrideSchedule failed: %v", err)
+	}
+	if state.Active || !state.Scheduled || state.Override == nil || !state.Override.Until.Equal(now.Add(30*time.Minute)) {
+		t.Errorf("Unexpected state: %+v", state)
+	}
+	if match := engine.Match("social.example", kid); match != nil {
+		t.Errorf("Expected the override to unblock, got %+v", match)
+	}
+
+	// Overrides survive reloads while their schedule exists
+	if err := engine.Load(config); err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	if match := engine.Match("chat.example", kid); match != nil {
+		t.Errorf("Expected the override to survive a reload, got %+v", match)
+	}
+
+	now = now.Add(31 * time.Minute)
+	if match := engine.Match("social.example", kid); !match.Denied() {
+		t.Errorf("Expected the expired override to block again, got %+v", match)
+	}
+	if engine.ClearScheduleOverride("school-nights") {
+		t.Error("Expected no unexpired override to be cleared")
+	}
+
+	// Block outside the schedule, then resume it
+	now = time.Date(2026, 10, 13, 12, 0, 0, 0, berlin)
+	if _, err := engine.OverrideSchedule("school-nights", true, time.Hour); err != nil {
+		t.Fatalf("OverrideSchedule failed: %v", err)
+	}
+	if match := engine.Match("social.example", kid); !match.Denied() {
+		t.Errorf("Expected the override to block, got %+v", match)
+	}
+	if !engine.ClearScheduleOverride("school-nights") {
+		t.Error("Expected the override to be cleared")
+	}
+	if match := engine.Match("social.example", kid); match != nil {
+		t.Errorf("Expected the schedule to resume, got %+v", match)
+	}
+
+	if _, err := engine.OverrideSchedule("bedtime", false, time.Hour); !errors.Is(err, ErrUnknownSchedule) {
+		t.Errorf("Expected ErrUnknownSchedule, got %v", err)
+	}
+	for _, duration := range []time.Duration{0, -time.Minute, 8 * 24 * time.Hour} {
+		if _, err := engine.OverrideSchedule("weekend", false, duration); !errors.Is(err, ErrInvalidSchedule) {
+			t.Errorf("OverrideSchedule(%s) = %v, want ErrInvalidSchedule", duration, err)
+		}
+	}
+
+	// Reloading without the schedule drops its override
+	if _, err := engine.OverrideSchedule("weekend", true, time.Hour); err != nil {
+		t.Fatalf("OverrideSchedule failed: %v", err)
+	}
+	if err := engine.Load(testRulesConfig()); err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	if len(engine.overrides) != 0 {
+		t.Errorf("Expected overrides to be dropped, got %+v", engine.overrides)
+	}
+}
+
+func TestRuleEngine_LoadScheduleErrors(t *testing.T) {
+	tests := map[string]struct {
+		modify func(c *RulesConfig)
+		err    error
+	}{
+		"rule on unknown schedule": {func(c *RulesConfig) {
+			c.Domains[0].Schedule = "bedtime"
+		}, ErrUnknownSchedule},
+		"list on unknown schedule": {func(c *RulesConfig) {
+			c.Lists[0].Schedule = "bedtime"
+		}, ErrUnknownSchedule},
+		"list in unknown group": {func(c *RulesConfig) {
+			c.Lists[0].Groups = []string{"guests"}
+		}, ErrUnknownGroup},
+		"duplicate schedule": {func(c *RulesConfig) {
+			c.Schedules = append(c.Schedules, c.Schedules[0])
+		}, ErrInvalidSchedule},
+		"invalid schedule": {func(c *RulesConfig) {
+			c.Schedules[1].Ranges[0].Start = "noon"
+		}, ErrInvalidSchedule},
+	}
+
+	for name, tt := range tests {
+		config := testScheduleRulesConfig(t)
+		tt.modify(&config)
+		if err := NewRuleEngine(nil).Load(config); !errors.Is(err, tt.err) {
+			t.Errorf("%s: expected %v, got %v", name, tt.err, err)
+		}
+	}
+
+	config := testScheduleRulesConfig(t)
+	config.Lists[0].Source = "/nonexistent/social.txt"
+	if err := NewRuleEngine(nil).Load(config); err == nil {
+		t.Error("Expected a missing group list to fail")
+	}
+}
+
+func TestServer_ScheduledBlockReason(t *testing.T) {
+	berlin := loadBerlin(t)
+
+	config := DefaultConfig()
+	config.LogQueries = false
+	config.Forwarder.HealthCheck = false
+	config.Rules = testScheduleRulesConfig(t)
+
+	testLogger := logger.New(&logger.Config{
+		Level:     logger.LogLevel("ERROR"),
+		Component: "dns-test",
+	})
+
+	server := NewServer(config, testLogger).(*Server)
+	server.rules.(*RuleEngine).now = func() time.Time {
+		return time.Date(2026, 10, 14, 23, 30, 0, 0, berlin)
+	}
+	if err := server.ReloadRules(config.Rules); err != nil {
+		t.Fatalf("ReloadRules failed: %v", err)
+	}
+
+	reason := server.BlockReason("social.example", udpClient("192.168.1.50"))
+	if reason == nil {
+		t.Fatal("Expected social.example to be blocked on a school night")
+	}
+	if reason.List != config.Rules.Lists[0].Source || reason.Rule != nil || reason.Group != "kids" ||
+		reason.Schedule != "school-nights" || reason.Status != QueryStatusGravity || reason.Sinkhole.Mode != SinkholeNull {
+		t.Errorf("Unexpected block reason: %+v", reason)
+	}
+
+	if reason := server.BlockReason("social.example", udpClient("192.168.1.10")); reason != nil {
+		t.Errorf("Expected the group list not to apply to other groups, got %+v", reason)
+	}
+}
@@ -2,8 +2,13 @@ package dns
 
 import (
 	"context"
+	"crypto/tls"
+	"errors"
 	"fmt"
+	"io"
+	"math/rand"
 	"net"
+	"reflect"
 	"sync"
 	"sync/atomic"
 	"time"
@@ -17,12 +22,22 @@ type Server struct {
 	logger    *logger.Logger
 	cache     DNSCache
 	forwarder DNSForwarder
+	blocklist DNSBlocklist
+	rules     DNSRuleEngine
+	local     DNSLocalRecords
+	rewrites  DNSRewriter
+	validator *Validator   // nil unless DNSSEC validation is enabled
+	queryLog  DNSQueryLog  // nil unless the query log is enabled
+	limiter   *RateLimiter // nil unless rate limiting is enabled
+	tap       *Dnstap      // nil unless dnstap logging is enabled
 	parser    DNSParser
 
 	// Server state
 	running     atomic.Bool
 	udpConn     *net.UDPConn
 	tcpListener *net.TCPListener
+	dotListener *net.TCPListener
+	tlsConfig   *tls.Config
 
 	// Statistics
 	stats   ServerStats
@@ -31,21 +46,70 @@ type Server struct {
 	// Shutdown
 	shutdownCh chan struct{}
 	wg         sync.WaitGroup
+	inflight   sync.WaitGroup // UDP queries and stream connections being served
+	streamMu   sync.Mutex
+	streams    map[net.Conn]struct{}
+
+	// Guards adding prefetches to wg once Stop waits for it
+	prefetchMu sync.Mutex
+	stopping   bool
+
+	// Serializes runtime reloads
+	reloadMu sync.Mutex
 }
 
 // NewServer creates a new DNS server
 func NewServer(config *Config, logger *logger.Logger) DNSServer {
-	return &Server{
+	var forwarder DNSForwarder
+	if config.Recursion.Enabled {
+		forwarder = NewRecursor(config.Recursion, config.Forwarder)
+	} else {
+		forwarder = NewForwarder(config.Forwarder)
+	}
+
+	s := &Server{
 		config:     config,
 		logger:     logger,
 		cache:      NewCache(config.Cache),
-		forwarder:  NewForwarder(config.Forwarder),
+		forwarder:  forwarder,
+		blocklist:  NewBlocklist(config.Blocklist),
+		rules:      NewRuleEngine(NewARPTableResolver(arpRefreshInterval)),
+		local:      NewLocalRecords(config.Local),
+		rewrites:   NewRewriteEngine(),
 		parser:     NewParser(),
 		shutdownCh: make(chan struct{}),
+		streams:    make(map[net.Conn]struct{}),
 		stats: ServerStats{
 			StartTime: time.Now(),
 		},
 	}
+
+	if config.QueryLog.Enabled {
+		s.queryLog = NewQueryLog(config.QueryLog.Size)
+	}
+
+	if config.RateLimit.Enabled {
+		s.limiter = NewRateLimiter(config.RateLimit)
+	}
+
+	if config.Dnstap.Enabled {
+		s.tap = NewDnstap(config.Dnstap, logger.Component("dnstap"))
+		if tapped, ok := s.forwarder.(interface{ SetDnstap(*Dnstap) }); ok {
+			tapped.SetDnstap(s.tap)
+		}
+	}
+
+	if config.DNSSEC.Enabled {
+		validator, err := NewValidator(config.DNSSEC.TrustAnchors, s.dnssecLookup)
+		if err != nil {
+			logger.ErrorFields("DNSSEC validation disabled", map[string]any{
+				"error": err.Error(),
+			})
+		}
+		s.validator = validator
+	}
+
+	return s
 }
 
 // Start starts the DNS server
@@ -59,7 +123,9 @@ func (s *Server) Start(ctx context.Context) error {
 		"port":          s.config.Port,
 		"udp_enabled":   s.config.UDPEnabled,
 		"tcp_enabled":   s.config.TCPEnabled,
+		"tls_enabled":   s.config.TLS.Enabled,
 		"cache_enabled": s.config.Cache.Enabled,
+		"recursion":     s.config.Recursion.Enabled,
 	})
 
 	// Validate configuration
@@ -67,6 +133,59 @@ func (s *Server) Start(ctx context.Context) error {
 		return fmt.Errorf("invalid configuration: %w", err)
 	}
 
+	// Compile blocklists before accepting queries
+	if s.config.Blocklist.Enabled {
+		if err := s.blocklist.Load(); err != nil {
+			return fmt.Errorf("failed to load blocklists: %w", err)
+		}
+		stats := s.blocklist.GetStats()
+		s.logger.InfoFields("Blocklists loaded", map[string]any{
+			"sources":       stats.Sources,
+			"domains":       stats.Domains,
+			"invalid_lines": stats.InvalidLines,
+		})
+	}
+
+	// Compile domain rules and client groups
+	if s.config.Rules.Enabled {
+		if err := s.rules.Load(s.config.Rules); err != nil {
+			return fmt.Errorf("failed to load domain rules: %w", err)
+		}
+	}
+
+	// Compile rewrites and safe search
+	if s.config.Rewrites.Enabled {
+		if err := s.rewrites.Load(s.config.Rewrites); err != nil {
+			return fmt.Errorf("failed to load rewrites: %w", err)
+		}
+		stats := s.rewrites.GetStats()
+		s.logger.InfoFields("Rewrites loaded", map[string]any{
+			"rules":       stats.Rules,
+			"safe_search": stats.SafeSearch,
+		})
+	}
+
+	// Load local records and hosts files
+	if s.config.Local.Enabled {
+		if err := s.local.Load(); err != nil {
+			return fmt.Errorf("failed to load local records: %w", err)
+		}
+		stats := s.local.GetStats()
+		s.logger.InfoFields("Local records loaded", map[string]any{
+			"records": stats.Records,
+			"zones":   stats.Zones,
+		})
+	}
+
+	// Deliver dnstap frames queued from here on
+	if s.tap != nil {
+		s.tap.Start()
+		s.logger.InfoFields("dnstap output enabled", map[string]any{
+			"network": s.config.Dnstap.Network,
+			"address": s.config.Dnstap.Address,
+		})
+	}
+
 	// Start UDP server if enabled
 	if s.config.UDPEnabled {
 		if err := s.startUDPServer(); err != nil {
@@ -84,6 +203,16 @@ func (s *Server) Start(ctx context.Context) error {
 		s.logger.Success("TCP DNS server started on %s:%d", s.config.Host, s.config.Port)
 	}
 
+	// Start DNS-over-TLS server if enabled
+	if s.config.TLS.Enabled {
+		if err := s.startDoTServer(); err != nil {
+			s.stopUDPServer()
+			s.stopTCPServer()
+			return fmt.Errorf("failed to start DNS-over-TLS server: %w", err)
+		}
+		s.logger.Success("DNS-over-TLS server started on %s:%d", s.config.Host, s.config.TLS.Port)
+	}
+
 	s.running.Store(true)
 
 	// Start cache cleanup routine
@@ -100,7 +229,8 @@ func (s *Server) Start(ctx context.Context) error {
 	return nil
 }
 
-// Stop stops the DNS server gracefully
+// Stop stops the DNS server gracefully. New queries are no longer
+// accepted, while queries in flight are answered until ctx is done.
 func (s *Server) Stop(ctx context.Context) error {
 	if !s.running.Load() {
 		return ErrServerNotStarted
@@ -111,17 +241,75 @@ func (s *Server) Stop(ctx context.Context) error {
 	s.running.Store(false)
 	close(s.shutdownCh)
 
-	// Stop servers
-	s.stopUDPServer()
+	// Queries still in flight must not start prefetches while wg is
+	// waited on below
+	s.prefetchMu.Lock()
+	s.stopping = true
+	s.prefetchMu.Unlock()
+
+	// Stop accepting connections; the UDP socket stays open until
+	// in-flight queries have been answered
 	s.stopTCPServer()
+	s.stopDoTServer()
 
-	// Wait for all goroutines to finish
+	// Wait for the accept and read loops to finish
 	s.wg.Wait()
 
+	// Wake idle stream connections so they close, and let in-flight
+	// queries finish
+	s.streamMu.Lock()
+	for conn := range s.streams {
+		conn.SetReadDeadline(time.Now())
+	}
+	s.streamMu.Unlock()
+
+	drained := make(chan struct{})
+	go func() {
+		s.inflight.Wait()
+		close(drained)
+	}()
+
+	select {
+	case <-drained:
+	case <-ctx.Done():
+		s.stopUDPServer()
+		s.closeForwarder()
+		s.closeDnstap()
+		s.logger.WarnFields("DNS server stopped before in-flight queries finished", map[string]any{
+			"error": ctx.Err().Error(),
+		})
+		return fmt.Errorf("failed to drain in-flight queries: %w", ctx.Err())
+	}
+
+	s.stopUDPServer()
+	s.closeForwarder()
+	s.closeDnstap()
+
 	s.logger.Success("✅ DNS server stopped gracefully")
 	return nil
 }
 
+// closeForwarder stops the forwarder's health checks
+func (s *Server) closeForwarder() {
+	if closer, ok := s.forwarder.(io.Closer); ok {
+		closer.Close()
+	}
+}
+
+// closeDnstap ends the dnstap stream once no more messages are logged
+func (s *Server) closeDnstap() {
+	if s.tap == nil {
+		return
+	}
+	s.tap.Close()
+
+	stats := s.tap.GetStats()
+	s.logger.InfoFields("dnstap output closed", map[string]any{
+		"frames":  stats.Frames,
+		"dropped": stats.Dropped,
+	})
+}
+
 // GetStats returns server statistics
 func (s *Server) GetStats() *ServerStats {
 	s.statsMu.RLock()
@@ -131,16 +319,71 @@ func (s *Server) GetStats() *ServerStats {
 	return &stats
 }
 
+// GetUpstreamStats returns latency and error statistics per upstream,
+// including conditional forwarding upstreams
+func (s *Server) GetUpstreamStats() []UpstreamStats {
+	return s.forwarder.GetUpstreamStats()
+}
+
 // HandleQuery processes a DNS query
 func (s *Server) HandleQuery(ctx context.Context, query *DNSQuery) (*DNSResponse, error) {
+	received := time.Now()
+
+	if s.limiter != nil && !s.limiter.AllowQuery(query.Client) {
+		s.updateStats(func(stats *ServerStats) {
+			stats.RateLimitedQueries++
+		})
+		return s.rateLimitedResponse(query, s.config.RateLimit.Action)
+	}
+
+	response, status, err := s.resolveQuery(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+
+	// Spoofed sources can only be reflected over UDP
+	if s.limiter != nil && query.Protocol == "udp" && !s.limiter.AllowResponse(query.Client, response) {
+		s.updateStats(func(stats *ServerStats) {
+			stats.RateLimitedResponses++
+		})
+		return s.rateLimitedResponse(query, s.config.RateLimit.ResponseAction)
+	}
+
+	if s.queryLog != nil {
+		s.queryLog.Add(newQueryRecord(query, response, status, received))
+	}
+
+	// Responses may be shared with the cache, so EDNS is set on a copy
+	reply := *response
+	reply.EDNS = s.responseEDNS(query)
+
+	// Signatures, denial records and the AD bit are only for clients that
+	// asked for DNSSEC (RFC 4035 section 3.2.1)
+	if query.EDNS == nil || !query.EDNS.DNSSECOK {
+		reply.AuthenticData = false
+		reply.Answers = withoutDNSSEC(reply.Answers, query.Question.Type)
+		reply.Authorities = withoutDNSSEC(reply.Authorities, query.Question.Type)
+		reply.Additional = withoutDNSSEC(reply.Additional, query.Question.Type)
+	}
+	return &reply, nil
+}
+
+// resolveQuery answers a query from local data, the cache or upstreams,
+// reporting how it was answered as a query log status
+func (s *Server) resolveQuery(ctx context.Context, query *DNSQuery) (*DNSResponse, int, error) {
 	start := time.Now()
 
 	// Update statistics
 	s.updateStats(func(stats *ServerStats) {
 		stats.QueriesReceived++
-		if query.Protocol == "udp" {
+		switch query.Protocol {
+		case "udp":
 			stats.UDPQueries++
-		} else {
+		case "dot":
+			stats.DoTQueries++
+		case "doh":
+			stats.DoHQueries++
+		default:
 			stats.TCPQueries++
 		}
 	})
@@ -155,6 +398,73 @@ func (s *Server) HandleQuery(ctx context.Context, query *DNSQuery) (*DNSResponse
 		})
 	}
 
+	// Clients speaking a newer EDNS version are told which one we support
+	if s.responseEDNS(query) != nil && query.EDNS.Version > ednsVersion {
+		s.updateStats(func(stats *ServerStats) {
+			stats.QueriesAnswered++
+		})
+		return &DNSResponse{
+			ID:           query.ID,
+			Question:     query.Question,
+			ResponseCode: RCodeBadVers,
+			ResponseTime: time.Since(start),
+		}, QueryStatusUnknown, nil
+	}
+
+	// Local names are answered authoritatively, ahead of blocking
+	if s.config.Local.Enabled {
+		if response, ok := s.local.Lookup(query.Question); ok {
+			response.ID = query.ID
+			response.ResponseTime = time.Since(start)
+
+			s.updateStats(func(stats *ServerStats) {
+				stats.LocalAnswers++
+				stats.QueriesAnswered++
+			})
+
+			if s.config.LogQueries {
+				s.logger.InfoFields("Local answer", map[string]any{
+					"domain":        query.Question.Name,
+					"response_code": response.ResponseCode,
+					"answers":       len(response.Answers),
+				})
+			}
+
+			return response, QueryStatusCached, nil
+		}
+	}
+
+	// Answer blocked domains before touching the cache or upstreams
+	if reason, blocked := s.isBlocked(query); blocked {
+		response := s.blockedResponse(query, reason)
+		response.ResponseTime = time.Since(start)
+
+		s.updateStats(func(stats *ServerStats) {
+			stats.QueriesBlocked++
+			stats.QueriesAnswered++
+		})
+
+		if s.config.LogQueries {
+			s.logger.InfoFields("Query blocked", map[string]any{
+				"domain":   query.Question.Name,
+				"client":   query.Client.String(),
+				"sinkhole": reason.Sinkhole.Mode,
+			})
+		}
+
+		return response, reason.Status, nil
+	}
+
+	// Rewrites differ by client group, so they are answered ahead of the
+	// shared cache
+	if s.config.Rewrites.Enabled {
+		if match := s.rewrites.Match(query.Question.Name, s.clientGroups(query.Client)); match != nil {
+			response, status := s.rewrittenResponse(ctx, query, match)
+			response.ResponseTime = time.Since(start)
+			return response, status, nil
+		}
+	}
+
 	// Check cache first
 	if s.config.Cache.Enabled {
 		if entry, found := s.cache.Get(query.Question); found {
@@ -163,11 +473,24 @@ func (s *Server) HandleQuery(ctx context.Context, query *DNSQuery) (*DNSResponse
 				stats.QueriesAnswered++
 			})
 
-			response := entry.Response
+			// The entry is shared by concurrent hits, so per-query fields
+			// are set on a copy
+			hit := *entry.Response
+			response := &hit
 			response.ID = query.ID // Use the query ID
 			response.Cached = true
 			response.ResponseTime = time.Since(start)
 
+			// Refresh popular entries before they expire
+			if s.cache.Prefetch(query.Question) {
+				s.startPrefetch(*query)
+			}
+
+			if reason := s.uncloak(query, response); reason != nil {
+				blocked, status := s.cnameBlockedResponse(query, reason, start)
+				return blocked, status, nil
+			}
+
 			if s.config.LogQueries {
 				s.logger.InfoFields("Cache hit", map[string]any{
 					"domain":        query.Question.Name,
@@ -175,7 +498,7 @@ func (s *Server) HandleQuery(ctx context.Context, query *DNSQuery) (*DNSResponse
 				})
 			}
 
-			return response, nil
+			return response, QueryStatusCached, nil
 		}
 
 		s.updateStats(func(stats *ServerStats) {
@@ -184,7 +507,21 @@ func (s *Server) HandleQuery(ctx context.Context, query *DNSQuery) (*DNSResponse
 	}
 
 	// Forward to upstream
-	response, err := s.forwarder.Forward(ctx, query)
+	response, status, err := s.forward(ctx, query)
+	if err != nil || response.ResponseCode == RCodeServFail {
+		// An expired answer beats SERVFAIL when upstreams are unavailable
+		if stale, ok := s.staleResponse(query); ok {
+			stale.ResponseTime = time.Since(start)
+			s.updateStats(func(stats *ServerStats) {
+				stats.QueriesAnswered++
+			})
+			if reason := s.uncloak(query, stale); reason != nil {
+				blocked, status := s.cnameBlockedResponse(query, reason, start)
+				return blocked, status, nil
+			}
+			return stale, QueryStatusStale, nil
+		}
+	}
 	if err != nil {
 		s.updateStats(func(stats *ServerStats) {
 			stats.Errors++
@@ -201,20 +538,17 @@ func (s *Server) HandleQuery(ctx context.Context, query *DNSQuery) (*DNSResponse
 			Question:     query.Question,
 			ResponseCode: RCodeServFail,
 			ResponseTime: time.Since(start),
-		}, nil
+		}, QueryStatusForwarded, nil
 	}
 
 	// Update response
 	response.ID = query.ID
 	response.ResponseTime = time.Since(start)
 
-	// Cache the response if caching is enabled
-	if s.config.Cache.Enabled && response.ResponseCode == RCodeNoError {
-		// Calculate TTL from the response records
-		ttl := s.calculateTTL(response)
-		if ttl > 0 {
-			s.cache.Set(query.Question, response, ttl)
-		}
+	// Cache the response if caching is enabled; bogus data passed on to
+	// clients with checking disabled is not kept
+	if status != SecurityBogus {
+		s.cacheResponse(query.Question, response)
 	}
 
 	s.updateStats(func(stats *ServerStats) {
@@ -232,6 +566,13 @@ func (s *Server) HandleQuery(ctx context.Context, query *DNSQuery) (*DNSResponse
 		}
 	})
 
+	// The cache keeps the upstream answer; CNAME targets are checked for
+	// each client as its rules may differ
+	if reason := s.uncloak(query, response); reason != nil {
+		blocked, status := s.cnameBlockedResponse(query, reason, start)
+		return blocked, status, nil
+	}
+
 	if s.config.LogQueries {
 		s.logger.InfoFields("Query forwarded", map[string]any{
 			"domain":        query.Question.Name,
@@ -241,7 +582,7 @@ func (s *Server) HandleQuery(ctx context.Context, query *DNSQuery) (*DNSResponse
 		})
 	}
 
-	return response, nil
+	return response, QueryStatusForwarded, nil
 }
 
 // startUDPServer starts the UDP DNS server
@@ -280,11 +621,38 @@ func (s *Server) startTCPServer() error {
 	return nil
 }
 
+// startDoTServer starts the DNS-over-TLS server
+func (s *Server) startDoTServer() error {
+	cert, err := tls.LoadX509KeyPair(s.config.TLS.CertFile, s.config.TLS.KeyFile)
+	if err != nil {
+		return fmt.Errorf("failed to load TLS certificate: %w", err)
+	}
+
+	s.tlsConfig = &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+	}
+
+	addr := fmt.Sprintf("%s:%d", s.config.Host, s.config.TLS.Port)
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+
+	s.dotListener = listener.(*net.TCPListener)
+
+	s.wg.Add(1)
+	go s.handleDoTConnections()
+
+	return nil
+}
+
 // stopUDPServer stops the UDP server
 func (s *Server) stopUDPServer() {
+	// The field is left set: queries still in flight after a drain
+	// timeout hold the connection and fail their writes instead
 	if s.udpConn != nil {
 		s.udpConn.Close()
-		s.udpConn = nil
 	}
 }
 
@@ -296,16 +664,25 @@ func (s *Server) stopTCPServer() {
 	}
 }
 
+// stopDoTServer stops the DNS-over-TLS server
+func (s *Server) stopDoTServer() {
+	if s.dotListener != nil {
+		s.dotListener.Close()
+		s.dotListener = nil
+	}
+}
+
 // handleUDPQueries handles incoming UDP DNS queries
 func (s *Server) handleUDPQueries() {
 	defer s.wg.Done()
 
+	conn := s.udpConn
 	buffer := make([]byte, s.config.BufferSize)
 
 	for s.running.Load() {
-		s.udpConn.SetReadDeadline(time.Now().Add(1 * time.Second))
+		conn.SetReadDeadline(time.Now().Add(1 * time.Second))
 
-		n, clientAddr, err := s.udpConn.ReadFromUDP(buffer)
+		n, clientAddr, err := conn.ReadFromUDP(buffer)
 		if err != nil {
 			if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
 				continue // Timeout is expected, continue loop
@@ -318,13 +695,21 @@ func (s *Server) handleUDPQueries() {
 			continue
 		}
 
-		// Handle query in goroutine
-		go s.handleUDPQuery(buffer[:n], clientAddr)
+		// Handle query in goroutine on a copy, the buffer is reused
+		data := make([]byte, n)
+		copy(data, buffer[:n])
+		s.inflight.Add(1)
+		go func() {
+			defer s.inflight.Done()
+			s.handleUDPQuery(conn, data, clientAddr)
+		}()
 	}
 }
 
-// handleUDPQuery handles a single UDP DNS query
-func (s *Server) handleUDPQuery(data []byte, clientAddr *net.UDPAddr) {
+// handleUDPQuery handles a single UDP DNS query, replying on the
+// connection it arrived on
+func (s *Server) handleUDPQuery(conn *net.UDPConn, data []byte, clientAddr *net.UDPAddr) {
+	received := time.Now()
 	ctx, cancel := context.WithTimeout(context.Background(), s.config.ReadTimeout)
 	defer cancel()
 
@@ -341,8 +726,15 @@ func (s *Server) handleUDPQuery(data []byte, clientAddr *net.UDPAddr) {
 	query.Client = clientAddr
 	query.Protocol = "udp"
 
+	if s.tap != nil {
+		s.tap.ClientQuery(query, conn.LocalAddr(), data, received)
+	}
+
 	// Process query
 	response, err := s.HandleQuery(ctx, query)
+	if errors.Is(err, ErrRateLimited) {
+		return
+	}
 	if err != nil {
 		s.logger.ErrorFields("Failed to process UDP query", map[string]any{
 			"client": clientAddr.String(),
@@ -361,10 +753,30 @@ func (s *Server) handleUDPQuery(data []byte, clientAddr *net.UDPAddr) {
 		return
 	}
 
+	// Answers that do not fit in a datagram are replaced by an empty,
+	// truncated reply so the client retries over TCP
+	if len(responseData) > s.udpPayloadLimit(query) {
+		responseData, err = s.parser.SerializeResponse(truncatedResponse(response))
+		if err != nil {
+			s.logger.ErrorFields("Failed to serialize truncated UDP response", map[string]any{
+				"client": clientAddr.String(),
+				"error":  err.Error(),
+			})
+			return
+		}
+		s.updateStats(func(stats *ServerStats) {
+			stats.TruncatedReplies++
+		})
+	}
+
+	if s.tap != nil {
+		s.tap.ClientResponse(query, conn.LocalAddr(), responseData, received, time.Now())
+	}
+
 	// Send response
-	s.udpConn.SetWriteDeadline(time.Now().Add(s.config.WriteTimeout))
-	_, err = s.udpConn.WriteToUDP(responseData, clientAddr)
-	if err != nil {
+	conn.SetWriteDeadline(time.Now().Add(s.config.WriteTimeout))
+	_, err = conn.WriteToUDP(responseData, clientAddr)
+	if err != nil && !errors.Is(err, net.ErrClosed) {
 		s.logger.ErrorFields("Failed to send UDP response", map[string]any{
 			"client": clientAddr.String(),
 			"error":  err.Error(),
@@ -393,95 +805,185 @@ func (s *Server) handleTCPConnections() {
 		}
 
 		// Handle connection in goroutine
+		s.inflight.Add(1)
 		go s.handleTCPConnection(conn)
 	}
 }
 
+// handleDoTConnections handles incoming DNS-over-TLS connections
+func (s *Server) handleDoTConnections() {
+	defer s.wg.Done()
+
+	listener := s.dotListener
+
+	for s.running.Load() {
+		listener.SetDeadline(time.Now().Add(1 * time.Second))
+
+		conn, err := listener.Accept()
+		if err != nil {
+			if isTimeout(err) {
+				continue // Timeout is expected, continue loop
+			}
+			if s.running.Load() {
+				s.logger.ErrorFields("DNS-over-TLS accept error", map[string]any{
+					"error": err.Error(),
+				})
+			}
+			continue
+		}
+
+		// The TLS handshake runs on first read, under the read deadline
+		s.inflight.Add(1)
+		go s.serveStreamConn(tls.Server(conn, s.tlsConfig), "dot")
+	}
+}
+
 // handleTCPConnection handles a single TCP connection
 func (s *Server) handleTCPConnection(conn net.Conn) {
+	s.serveStreamConn(conn, "tcp")
+}
+
+// serveStreamConn serves length-prefixed DNS messages (RFC 1035 4.2.2) on a
+// stream connection. Queries are answered in a loop so clients can reuse the
+// connection until it has been idle for IdleTimeout. The caller adds the
+// connection to s.inflight.
+func (s *Server) serveStreamConn(conn net.Conn, protocol string) {
+	defer s.inflight.Done()
 	defer conn.Close()
 
-	conn.SetReadDeadline(time.Now().Add(s.config.ReadTimeout))
+	// Registered before checking running, so Stop either sees the
+	// connection or the loop below never starts
+	s.streamMu.Lock()
+	s.streams[conn] = struct{}{}
+	s.streamMu.Unlock()
+	defer func() {
+		s.streamMu.Lock()
+		delete(s.streams, conn)
+		s.streamMu.Unlock()
+	}()
+
+	// The first query must arrive within the read timeout, later ones
+	// within the idle timeout
+	readTimeout := s.config.ReadTimeout
 
-	// Read message length (TCP DNS uses 2-byte length prefix)
-	lengthBuf := make([]byte, 2)
-	_, err := conn.Read(lengthBuf)
-	if err != nil {
-		s.logger.ErrorFields("Failed to read TCP message length", map[string]any{
-			"client": conn.RemoteAddr().String(),
-			"error":  err.Error(),
-		})
-		return
-	}
+	for {
+		// Checked after setting the deadline, so a deadline set by Stop
+		// to wake the connection is never overwritten unnoticed
+		conn.SetReadDeadline(time.Now().Add(readTimeout))
+		if !s.running.Load() {
+			return
+		}
+		readTimeout = s.config.IdleTimeout
+
+		// Read message length (TCP DNS uses 2-byte length prefix)
+		lengthBuf := make([]byte, 2)
+		if _, err := io.ReadFull(conn, lengthBuf); err != nil {
+			if err != io.EOF && !isTimeout(err) {
+				s.logger.ErrorFields("Failed to read stream message length", map[string]any{
+					"client":   conn.RemoteAddr().String(),
+					"protocol": protocol,
+					"error":    err.Error(),
+				})
+			}
+			return
+		}
 
-	messageLength := int(lengthBuf[0])<<8 | int(lengthBuf[1])
-	if messageLength > s.config.BufferSize {
-		s.logger.ErrorFields("TCP message too large", map[string]any{
-			"client": conn.RemoteAddr().String(),
-			"length": messageLength,
-			"max":    s.config.BufferSize,
-		})
-		return
-	}
+		messageLength := int(lengthBuf[0])<<8 | int(lengthBuf[1])
+		if messageLength > s.config.BufferSize {
+			s.logger.ErrorFields("Stream message too large", map[string]any{
+				"client":   conn.RemoteAddr().String(),
+				"protocol": protocol,
+				"length":   messageLength,
+				"max":      s.config.BufferSize,
+			})
+			return
+		}
 
-	// Read message
-	messageBuf := make([]byte, messageLength)
-	_, err = conn.Read(messageBuf)
-	if err != nil {
-		s.logger.ErrorFields("Failed to read TCP message", map[string]any{
-			"client": conn.RemoteAddr().String(),
-			"error":  err.Error(),
-		})
-		return
+		// Read message
+		messageBuf := make([]byte, messageLength)
+		if _, err := io.ReadFull(conn, messageBuf); err != nil {
+			s.logger.ErrorFields("Failed to read stream message", map[string]any{
+				"client":   conn.RemoteAddr().String(),
+				"protocol": protocol,
+				"error":    err.Error(),
+			})
+			return
+		}
+
+		if !s.handleStreamQuery(conn, messageBuf, protocol) {
+			return
+		}
 	}
+}
 
+// handleStreamQuery answers a single query read from a stream connection and
+// reports whether the connection can be reused
+func (s *Server) handleStreamQuery(conn net.Conn, message []byte, protocol string) bool {
+	received := time.Now()
 	ctx, cancel := context.WithTimeout(context.Background(), s.config.ReadTimeout)
 	defer cancel()
 
 	// Parse query
-	query, err := s.parser.ParseQuery(messageBuf)
+	query, err := s.parser.ParseQuery(message)
 	if err != nil {
-		s.logger.ErrorFields("Failed to parse TCP query", map[string]any{
-			"client": conn.RemoteAddr().String(),
-			"error":  err.Error(),
+		s.logger.ErrorFields("Failed to parse stream query", map[string]any{
+			"client":   conn.RemoteAddr().String(),
+			"protocol": protocol,
+			"error":    err.Error(),
 		})
-		return
+		return false
 	}
 
 	query.Client = conn.RemoteAddr()
-	query.Protocol = "tcp"
+	query.Protocol = protocol
+
+	if s.tap != nil {
+		s.tap.ClientQuery(query, conn.LocalAddr(), message, received)
+	}
 
 	// Process query
 	response, err := s.HandleQuery(ctx, query)
+	if errors.Is(err, ErrRateLimited) {
+		return false
+	}
 	if err != nil {
-		s.logger.ErrorFields("Failed to process TCP query", map[string]any{
-			"client": conn.RemoteAddr().String(),
-			"error":  err.Error(),
+		s.logger.ErrorFields("Failed to process stream query", map[string]any{
+			"client":   conn.RemoteAddr().String(),
+			"protocol": protocol,
+			"error":    err.Error(),
 		})
-		return
+		return false
 	}
 
 	// Serialize response
 	responseData, err := s.parser.SerializeResponse(response)
 	if err != nil {
-		s.logger.ErrorFields("Failed to serialize TCP response", map[string]any{
-			"client": conn.RemoteAddr().String(),
-			"error":  err.Error(),
+		s.logger.ErrorFields("Failed to serialize stream response", map[string]any{
+			"client":   conn.RemoteAddr().String(),
+			"protocol": protocol,
+			"error":    err.Error(),
 		})
-		return
+		return false
+	}
+
+	if s.tap != nil {
+		s.tap.ClientResponse(query, conn.LocalAddr(), responseData, received, time.Now())
 	}
 
 	// Send response with length prefix
 	conn.SetWriteDeadline(time.Now().Add(s.config.WriteTimeout))
 
 	lengthPrefix := []byte{byte(len(responseData) >> 8), byte(len(responseData) & 0xFF)}
-	_, err = conn.Write(append(lengthPrefix, responseData...))
-	if err != nil {
-		s.logger.ErrorFields("Failed to send TCP response", map[string]any{
-			"client": conn.RemoteAddr().String(),
-			"error":  err.Error(),
+	if _, err := conn.Write(append(lengthPrefix, responseData...)); err != nil {
+		s.logger.ErrorFields("Failed to send stream response", map[string]any{
+			"client":   conn.RemoteAddr().String(),
+			"protocol": protocol,
+			"error":    err.Error(),
 		})
+		return false
 	}
+
+	return true
 }
 
 // cacheCleanupRoutine periodically cleans up expired cache entries
@@ -501,6 +1003,596 @@ func (s *Server) cacheCleanupRoutine() {
 	}
 }
 
+// ReloadRules replaces the domain rules and client groups at runtime.
+// On error the current rules stay in effect.
+func (s *Server) ReloadRules(config RulesConfig) error {
+	if err := s.rules.Load(config); err != nil {
+		return fmt.Errorf("failed to reload domain rules: %w", err)
+	}
+
+	stats := s.rules.GetStats()
+	s.logger.InfoFields("Domain rules reloaded", map[string]any{
+		"groups":      stats.Groups,
+		"clients":     stats.Clients,
+		"allow_rules": stats.AllowRules,
+		"deny_rules":  stats.DenyRules,
+		"group_lists": stats.Lists,
+		"schedules":   stats.Schedules,
+	})
+
+	return nil
+}
+
+// ReloadBlocklists recompiles the configured blocklist sources at runtime.
+// On error the current blocklist stays in effect.
+func (s *Server) ReloadBlocklists() error {
+	if err := s.blocklist.Load(); err != nil {
+		return fmt.Errorf("failed to reload blocklists: %w", err)
+	}
+	return nil
+}
+
+// ReloadLocalRecords re-reads local records from config and hosts files,
+// keeping records added at runtime
+func (s *Server) ReloadLocalRecords() error {
+	if err := s.local.Load(); err != nil {
+		return fmt.Errorf("failed to reload local records: %w", err)
+	}
+	return nil
+}
+
+// Reload applies a changed configuration at runtime. Domain rules,
+// blocklist sources and local records are reloaded in place, keeping
+// records added at runtime; each part is only replaced once it loaded. All
+// other settings, including turning features on or off, take effect after
+// a restart, which is logged when they differ.
+func (s *Server) Reload(config *Config) error {
+	if err := config.Validate(); err != nil {
+		return fmt.Errorf("invalid configuration: %w", err)
+	}
+
+	s.reloadMu.Lock()
+	defer s.reloadMu.Unlock()
+
+	if err := s.blocklist.Reconfigure(config.Blocklist); err != nil {
+		return fmt.Errorf("failed to reload blocklists: %w", err)
+	}
+	if err := s.local.Reconfigure(config.Local); err != nil {
+		return fmt.Errorf("failed to reload local records: %w", err)
+	}
+	if err := s.rules.Load(config.Rules); err != nil {
+		return fmt.Errorf("failed to reload domain rules: %w", err)
+	}
+	if config.Rewrites.Enabled {
+		if err := s.rewrites.Load(config.Rewrites); err != nil {
+			return fmt.Errorf("failed to reload rewrites: %w", err)
+		}
+	}
+	if conditional, ok := s.forwarder.(interface {
+		SetConditional([]ConditionalForwardConfig)
+	}); ok {
+		conditional.SetConditional(config.Forwarder.Conditional)
+	}
+
+	blocklist := s.blocklist.GetStats()
+	local := s.local.GetStats()
+	rules := s.rules.GetStats()
+	s.logger.InfoFields("DNS configuration reloaded", map[string]any{
+		"blocklist_domains": blocklist.Domains,
+		"local_records":     local.Records,
+		"allow_rules":       rules.AllowRules,
+		"deny_rules":        rules.DenyRules,
+		"group_lists":       rules.Lists,
+		"schedules":         rules.Schedules,
+		"conditional_rules": len(config.Forwarder.Conditional),
+	})
+
+	if requiresRestart(s.config, config) {
+		s.logger.Warn("Some changed DNS settings take effect after a restart")
+	}
+	return nil
+}
+
+// requiresRestart reports whether two configurations differ in more than
+// what Reload applies at runtime
+func requiresRestart(current, updated *Config) bool {
+	a, b := *current, *updated
+	for _, c := range []*Config{&a, &b} {
+		c.Blocklist.Sources = nil
+		c.Blocklist.Sinkhole = SinkholeConfig{}
+		c.Blocklist.ListSinkholes = nil
+		c.Local = LocalRecordsConfig{Enabled: c.Local.Enabled}
+		c.Rules = RulesConfig{Enabled: c.Rules.Enabled}
+		c.Rewrites = RewritesConfig{Enabled: c.Rewrites.Enabled}
+		c.Forwarder.Conditional = nil
+	}
+	return !reflect.DeepEqual(a, b)
+}
+
+// LocalRecords returns the local record store for runtime changes
+func (s *Server) LocalRecords() DNSLocalRecords {
+	return s.local
+}
+
+// Rules returns the domain rule engine, whose schedules can be overridden
+// at runtime
+func (s *Server) Rules() DNSRuleEngine {
+	return s.rules
+}
+
+// Dnstap returns the server's dnstap output, nil unless enabled
+func (s *Server) Dnstap() *Dnstap {
+	return s.tap
+}
+
+// QueryLog returns the server's query log, nil if it is disabled
+func (s *Server) QueryLog() DNSQueryLog {
+	return s.queryLog
+}
+
+// rateLimitedResponse answers a query over its rate limit as the action
+// says, returning ErrRateLimited if it is dropped. Truncation only helps
+// over UDP, so stream clients are refused instead.
+func (s *Server) rateLimitedResponse(query *DNSQuery, action string) (*DNSResponse, error) {
+	if action == RateLimitActionTruncate && query.Protocol != "udp" {
+		action = RateLimitActionRefuse
+	}
+
+	s.updateStats(func(stats *ServerStats) {
+		switch action {
+		case RateLimitActionDrop:
+			stats.RateLimitDropped++
+		case RateLimitActionTruncate:
+			stats.RateLimitTruncated++
+		default:
+			stats.RateLimitRefused++
+		}
+	})
+
+	// A limited client may be flooding, so this stays out of the info log
+	if s.config.LogQueries {
+		s.logger.DebugFields("Query rate limited", map[string]any{
+			"domain": query.Question.Name,
+			"client": query.Client.String(),
+			"action": action,
+		})
+	}
+
+	response := &DNSResponse{
+		ID:       query.ID,
+		Question: query.Question,
+		EDNS:     s.responseEDNS(query),
+	}
+	switch action {
+	case RateLimitActionDrop:
+		return nil, ErrRateLimited
+	case RateLimitActionTruncate:
+		response.Truncated = true
+	default:
+		response.ResponseCode = RCodeRefused
+	}
+	return response, nil
+}
+
+// isBlocked decides whether a query is blocked and why. Allow rules for
+// the client's groups override both deny rules and the blocklist.
+func (s *Server) isBlocked(query *DNSQuery) (*BlockReason, bool) {
+	reason, allowed := s.blockReason(query.Question.Name, query.Client)
+	if allowed {
+		s.updateStats(func(stats *ServerStats) {
+			stats.QueriesAllowed++
+		})
+	}
+	return reason, reason != nil
+}
+
+// BlockReason reports why a domain is blocked for a client and what it is
+// answered with, or nil if the domain is not blocked
+func (s *Server) BlockReason(name string, client net.Addr) *BlockReason {
+	reason, _ := s.blockReason(name, client)
+	return reason
+}
+
+// blockReason decides whether a domain is blocked for a client, also
+// reporting whether an allow rule let it through. The answer is the one
+// set by the client's group, else by the list, else the default.
+func (s *Server) blockReason(name string, client net.Addr) (*BlockReason, bool) {
+	reason := &BlockReason{Domain: normalizeDomain(name)}
+
+	if s.config.Rules.Enabled {
+		match := s.rules.Match(name, client)
+		if match.Allowed() {
+			return nil, true
+		}
+		if match.Denied() {
+			reason.Rule = match.Rule
+			reason.List = match.List
+			reason.Group = match.Group
+			reason.Schedule = match.Schedule
+			switch {
+			case match.Rule == nil:
+				reason.Status = QueryStatusGravity
+			case match.Rule.Kind == RuleKindRegex:
+				reason.Status = QueryStatusRegex
+			default:
+				reason.Status = QueryStatusDenylist
+			}
+			reason.Sinkhole = s.blocklist.ListSinkhole(match.List)
+		}
+	}
+
+	if reason.Status == 0 {
+		if !s.config.Blocklist.Enabled {
+			return nil, false
+		}
+		match := s.blocklist.Match(name)
+		if match == nil {
+			return nil, false
+		}
+		reason.List = match.Source
+		reason.Status = QueryStatusGravity
+		reason.Sinkhole = match.Sinkhole
+	}
+
+	if s.config.Rules.Enabled {
+		if sinkhole, ok := s.rules.Sinkhole(client, reason.Group); ok {
+			reason.Sinkhole = sinkhole
+		}
+	}
+	if reason.Sinkhole.Mode == "" {
+		reason.Sinkhole.Mode = SinkholeNull
+	}
+	return reason, false
+}
+
+// clientGroups returns the enabled groups of a client; without rules every
+// client is in the default group
+func (s *Server) clientGroups(client net.Addr) []string {
+	if !s.config.Rules.Enabled {
+		return []string{DefaultGroupName}
+	}
+	return s.rules.GroupsFor(client)
+}
+
+// rewrittenResponse answers a rewritten query. The target of a CNAME
+// rewrite is resolved from local records, the cache or upstreams and its
+// answer follows the CNAME record.
+func (s *Server) rewrittenResponse(ctx context.Context, query *DNSQuery, match *RewriteMatch) (*DNSResponse, int) {
+	response := &DNSResponse{
+		ID:           query.ID,
+		Question:     query.Question,
+		ResponseCode: RCodeNoError,
+		Answers:      match.answer(query.Question),
+	}
+	status := QueryStatusCached
+
+	target := match.Target()
+	if target != "" && query.Question.Type != TypeCNAME {
+		var resolved *DNSResponse
+		resolved, status = s.resolveRewriteTarget(ctx, query, target)
+		response.ResponseCode = resolved.ResponseCode
+		response.Answers = append(response.Answers, resolved.Answers...)
+		response.Upstream = resolved.Upstream
+	}
+
+	s.updateStats(func(stats *ServerStats) {
+		stats.RewrittenAnswers++
+		stats.QueriesAnswered++
+	})
+
+	if s.config.LogQueries {
+		s.logger.InfoFields("Query rewritten", map[string]any{
+			"domain":  query.Question.Name,
+			"group":   match.Group,
+			"target":  target,
+			"answers": len(response.Answers),
+		})
+	}
+
+	return response, status
+}
+
+// resolveRewriteTarget resolves a rewrite's CNAME target for a query,
+// reporting how it was answered as a query log status
+func (s *Server) resolveRewriteTarget(ctx context.Context, query *DNSQuery, target string) (*DNSResponse, int) {
+	question := DNSQuestion{Name: target, Type: query.Question.Type, Class: query.Question.Class}
+
+	if s.config.Local.Enabled {
+		if response, ok := s.local.Lookup(question); ok {
+			return response, QueryStatusCached
+		}
+	}
+	if s.config.Cache.Enabled {
+		if entry, ok := s.cache.Get(question); ok {
+			hit := *entry.Response
+			return &hit, QueryStatusCached
+		}
+	}
+
+	upstream := *query
+	upstream.Question = question
+	response, status, err := s.forward(ctx, &upstream)
+	if err != nil {
+		s.logger.WarnFields("Failed to resolve rewrite target", map[string]any{
+			"domain": query.Question.Name,
+			"target": target,
+			"error":  err.Error(),
+		})
+		return &DNSResponse{ResponseCode: RCodeServFail}, QueryStatusForwarded
+	}
+
+	if status != SecurityBogus {
+		s.cacheResponse(question, response)
+	}
+	s.updateStats(func(stats *ServerStats) {
+		stats.QueriesForwarded++
+	})
+	return response, QueryStatusForwarded
+}
+
+// uncloak looks for a blocked domain among the CNAME targets of an
+// answer, so trackers cloaked behind first-party names are blocked like
+// the trackers themselves. Names the client's allow rules let through are
+// not inspected.
+func (s *Server) uncloak(query *DNSQuery, response *DNSResponse) *BlockReason {
+	if !s.config.Blocklist.CNAMEInspection || response.ResponseCode != RCodeNoError {
+		return nil
+	}
+
+	targets := cnameTargets(query.Question.Name, response.Answers)
+	if len(targets) == 0 {
+		return nil
+	}
+	if s.config.Rules.Enabled && s.rules.Match(query.Question.Name, query.Client).Allowed() {
+		return nil
+	}
+
+	for _, target := range targets {
+		if reason, _ := s.blockReason(target, query.Client); reason != nil {
+			return reason
+		}
+	}
+	return nil
+}
+
+// cnameBlockedResponse answers a query whose CNAME chain leads to a
+// blocked domain as if the queried name were blocked, tagged with the
+// blocked target
+func (s *Server) cnameBlockedResponse(query *DNSQuery, reason *BlockReason, start time.Time) (*DNSResponse, int) {
+	response := s.blockedResponse(query, reason)
+	response.BlockedCNAME = reason.Domain
+	response.ResponseTime = time.Since(start)
+
+	s.updateStats(func(stats *ServerStats) {
+		stats.QueriesBlocked++
+		stats.CNAMEBlocked++
+	})
+
+	if s.config.LogQueries {
+		s.logger.InfoFields("CNAME target blocked", map[string]any{
+			"domain":   query.Question.Name,
+			"cname":    reason.Domain,
+			"client":   query.Client.String(),
+			"sinkhole": reason.Sinkhole.Mode,
+		})
+	}
+
+	return response, cnameStatus(reason.Status)
+}
+
+// cnameTargets follows the CNAME chain of an answer from name, returning
+// the targets in order
+func cnameTargets(name string, answers []DNSRecord) []string {
+	var targets []string
+	current := normalizeDomain(name)
+
+	// A chain cannot be longer than the answer; this also stops at loops
+	for range answers {
+		next := ""
+		for _, record := range answers {
+			if record.Type != TypeCNAME || normalizeDomain(record.Name) != current {
+				continue
+			}
+			if target, err := ParseTarget(record); err == nil {
+				next = normalizeDomain(target.Target)
+			}
+			break
+		}
+		if next == "" {
+			break
+		}
+
+		targets = append(targets, next)
+		current = next
+	}
+	return targets
+}
+
+// blockedResponse builds the answer for a blocked query as its sinkhole
+// says
+func (s *Server) blockedResponse(query *DNSQuery, reason *BlockReason) *DNSResponse {
+	return reason.Sinkhole.answer(query, uint32(s.config.Blocklist.BlockedTTL/time.Second))
+}
+
+// cacheResponse stores a successful or negative upstream response in the
+// cache and reports whether it was stored
+func (s *Server) cacheResponse(question DNSQuestion, response *DNSResponse) bool {
+	if !s.config.Cache.Enabled || subnetSpecific(response) {
+		return false
+	}
+
+	var ttl time.Duration
+	switch {
+	case isNegativeResponse(response):
+		// Negative answers are only cached with an SOA to bound them
+		var ok bool
+		if ttl, ok = negativeTTL(response); !ok || !s.config.Cache.NegativeCache {
+			return false
+		}
+	case response.ResponseCode == RCodeNoError:
+		// Calculate TTL from the response records
+		ttl = s.calculateTTL(response)
+	default:
+		return false
+	}
+
+	if ttl <= 0 {
+		return false
+	}
+	s.cache.Set(question, response, ttl)
+	return true
+}
+
+// startPrefetch refreshes a cached entry in the background, unless the
+// server is stopping
+func (s *Server) startPrefetch(query DNSQuery) {
+	s.prefetchMu.Lock()
+	defer s.prefetchMu.Unlock()
+
+	if s.stopping {
+		return
+	}
+	s.wg.Add(1)
+	go s.prefetch(query)
+}
+
+// prefetch refreshes a cached entry from upstream in the background
+func (s *Server) prefetch(query DNSQuery) {
+	defer s.wg.Done()
+
+	timeout := s.config.Forwarder.Timeout * time.Duration(s.config.Forwarder.Retries+1)
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	response, status, err := s.forward(ctx, &query)
+	if err != nil {
+		s.logger.DebugFields("Prefetch failed", map[string]any{
+			"domain": query.Question.Name,
+			"error":  err.Error(),
+		})
+		s.cache.CancelPrefetch(query.Question)
+		return
+	}
+	if status == SecurityBogus || !s.cacheResponse(query.Question, response) {
+		s.cache.CancelPrefetch(query.Question)
+	}
+}
+
+// forward sends a query upstream. With DNSSEC validation enabled the
+// answer is validated: secure answers get the AD bit and bogus ones become
+// SERVFAIL, unless the client disabled checking.
+func (s *Server) forward(ctx context.Context, query *DNSQuery) (*DNSResponse, SecurityStatus, error) {
+	if s.validator == nil {
+		response, err := s.forwarder.Forward(ctx, query)
+		return response, SecurityIndeterminate, err
+	}
+
+	// Signatures are requested whatever the client asked for; checking is
+	// disabled upstream so bogus data reaches us instead of a SERVFAIL
+	upstream := *query
+	upstream.EDNS = &EDNS{}
+	if query.EDNS != nil {
+		edns := *query.EDNS
+		upstream.EDNS = &edns
+	}
+	upstream.EDNS.DNSSECOK = true
+	upstream.CheckingDisabled = true
+
+	response, err := s.forwarder.Forward(ctx, &upstream)
+	if err != nil {
+		return nil, SecurityIndeterminate, err
+	}
+	if response.ResponseCode != RCodeNoError && response.ResponseCode != RCodeNXDomain {
+		return response, SecurityIndeterminate, nil
+	}
+
+	status, verr := s.validator.Validate(ctx, response)
+	s.updateStats(func(stats *ServerStats) {
+		switch status {
+		case SecuritySecure:
+			stats.DNSSECSecure++
+		case SecurityInsecure:
+			stats.DNSSECInsecure++
+		case SecurityBogus:
+			stats.DNSSECBogus++
+		}
+	})
+
+	switch status {
+	case SecuritySecure:
+		response.AuthenticData = true
+	case SecurityBogus:
+		s.logger.WarnFields("DNSSEC validation failed", map[string]any{
+			"domain": query.Question.Name,
+			"type":   query.Question.Type,
+			"error":  verr.Error(),
+		})
+		if !query.CheckingDisabled {
+			return &DNSResponse{
+				ID:           query.ID,
+				Question:     query.Question,
+				ResponseCode: RCodeServFail,
+			}, status, nil
+		}
+	}
+	return response, status, nil
+}
+
+// dnssecLookup fetches records for the validator through the forwarder
+func (s *Server) dnssecLookup(ctx context.Context, name string, qtype uint16) (*DNSResponse, error) {
+	response, err := s.forwarder.Forward(ctx, &DNSQuery{
+		ID:               uint16(rand.Intn(65536)),
+		Question:         DNSQuestion{Name: name, Type: qtype, Class: ClassIN},
+		EDNS:             &EDNS{DNSSECOK: true},
+		CheckingDisabled: true,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if response.ResponseCode != RCodeNoError && response.ResponseCode != RCodeNXDomain {
+		return nil, fmt.Errorf("upstream answered with rcode %d", response.ResponseCode)
+	}
+	return response, nil
+}
+
+// staleResponse returns a copy of an expired cached answer with its TTLs
+// capped to the stale TTL (RFC 8767), if serve-stale has one
+func (s *Server) staleResponse(query *DNSQuery) (*DNSResponse, bool) {
+	if !s.config.Cache.Enabled {
+		return nil, false
+	}
+	entry, ok := s.cache.GetStale(query.Question)
+	if !ok {
+		return nil, false
+	}
+
+	ttl := uint32(s.config.Cache.StaleTTL / time.Second)
+	capTTLs := func(records []DNSRecord) []DNSRecord {
+		capped := make([]DNSRecord, len(records))
+		copy(capped, records)
+		for i := range capped {
+			if capped[i].TTL > ttl {
+				capped[i].TTL = ttl
+			}
+		}
+		return capped
+	}
+
+	stale := *entry.Response
+	stale.ID = query.ID
+	stale.Answers = capTTLs(stale.Answers)
+	stale.Authorities = capTTLs(stale.Authorities)
+	stale.Additional = capTTLs(stale.Additional)
+	stale.Cached = true
+
+	if s.config.LogQueries {
+		s.logger.InfoFields("Serving stale answer", map[string]any{
+			"domain": query.Question.Name,
+		})
+	}
+	return &stale, true
+}
+
 // calculateTTL calculates the TTL for caching from response records
 func (s *Server) calculateTTL(response *DNSResponse) time.Duration {
 	if len(response.Answers) == 0 {
@@ -528,6 +1620,56 @@ func (s *Server) calculateTTL(response *DNSResponse) time.Duration {
 	return ttl
 }
 
+// responseEDNS returns the OPT parameters to answer a query with, or nil if
+// the client did not use EDNS or EDNS is disabled. The DO bit is copied
+// from the query (RFC 3225).
+func (s *Server) responseEDNS(query *DNSQuery) *EDNS {
+	if query.EDNS == nil || !s.config.Forwarder.EDNS0Enabled {
+		return nil
+	}
+	return &EDNS{
+		UDPSize:  ednsPayloadSize(s.config.Forwarder.UDPSize),
+		Version:  ednsVersion,
+		DNSSECOK: query.EDNS.DNSSECOK,
+	}
+}
+
+// udpPayloadLimit returns the largest UDP response the client accepts: the
+// smaller of its advertised EDNS payload size and our own
+func (s *Server) udpPayloadLimit(query *DNSQuery) int {
+	edns := s.responseEDNS(query)
+	if edns == nil {
+		return minUDPPayloadSize
+	}
+
+	limit := int(query.EDNS.UDPSize)
+	if limit < minUDPPayloadSize {
+		limit = minUDPPayloadSize
+	}
+	if limit > int(edns.UDPSize) {
+		limit = int(edns.UDPSize)
+	}
+	return limit
+}
+
+// truncatedResponse returns an empty copy of a response with the TC bit set
+func truncatedResponse(response *DNSResponse) *DNSResponse {
+	return &DNSResponse{
+		ID:           response.ID,
+		Question:     response.Question,
+		ResponseCode: response.ResponseCode,
+		Truncated:    true,
+		EDNS:         response.EDNS,
+		ResponseTime: response.ResponseTime,
+	}
+}
+
+// isTimeout reports whether an error is a network timeout
+func isTimeout(err error) bool {
+	netErr, ok := err.(net.Error)
+	return ok && netErr.Timeout()
+}
+
 // updateStats safely updates server statistics
 func (s *Server) updateStats(updater func(*ServerStats)) {
 	s.statsMu.Lock()
@@ -0,0 +1,275 @@
+package dns
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"pihole-analyzer/internal/logger"
+)
+
+func TestServer_Reload(t *testing.T) {
+	testLogger := logger.New(&logger.Config{
+		Level:     logger.LogLevel("ERROR"),
+		Component: "dns-test",
+	})
+
+	config := DefaultConfig()
+	config.LogQueries = false
+	config.Forwarder.HealthCheck = false
+	config.Blocklist.Sources = []string{writeTestList(t, "a.txt", "a.example\n")}
+	config.Rules.Enabled = true
+	if err := config.Validate(); err != nil {
+		t.Fatalf("Validate failed: %v", err)
+	}
+
+	server := NewServer(config, testLogger).(*Server)
+	if err := server.blocklist.Load(); err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	if err := server.LocalRecords().Add(LocalRecord{Name: "printer.lan", Type: TypeA, Value: "192.168.1.5"}); err != nil {
+		t.Fatalf("Add failed: %v", err)
+	}
+
+	blocked := func(name string) bool {
+		_, blocked := server.isBlocked(&DNSQuery{
+			Question: DNSQuestion{Name: name, Type: TypeA, Class: ClassIN},
+			Client:   &net.UDPAddr{IP: net.ParseIP("192.168.1.50")},
+		})
+		return blocked
+	}
+	local := func(name string) bool {
+		response, ok := server.LocalRecords().Lookup(DNSQuestion{Name: name, Type: TypeA, Class: ClassIN})
+		return ok && len(response.Answers) == 1
+	}
+
+	updated := *config
+	updated.Blocklist.Sources = []string{writeTestList(t, "b.txt", "b.example\n")}
+	updated.Local.Records = []LocalRecordConfig{{Name: "nas.lan", Type: "A", Value: "192.168.1.10"}}
+	updated.Rules.Domains = []DomainRuleConfig{
+		{Domain: "c.example", Kind: RuleKindExact, Action: RuleActionDeny, Enabled: true},
+	}
+	updated.Forwarder.Conditional = []ConditionalForwardConfig{
+		{Domains: []string{"corp.example"}, Upstreams: []string{"10.0.0.53:53"}},
+	}
+	if err := server.Reload(&updated); err != nil {
+		t.Fatalf("Reload failed: %v", err)
+	}
+
+	if blocked("a.example") || !blocked("b.example") || !blocked("c.example") {
+		t.Error("Expected the reloaded blocklist and rules to be in effect")
+	}
+	if !local("nas.lan") || !local("printer.lan") {
+		t.Error("Expected configured records to load and runtime records to be kept")
+	}
+	if server.forwarder.(*Forwarder).conditionalFor("dc.corp.example") == nil {
+		t.Error("Expected the reloaded conditional forwarding rules to be in effect")
+	}
+
+	// A failing reload keeps the current configuration
+	broken := updated
+	broken.Blocklist.Sources = []string{"/nonexistent/list.txt"}
+	if err := server.Reload(&broken); err == nil {
+		t.Fatal("Expected reload with a missing list to fail")
+	}
+	if !blocked("b.example") {
+		t.Error("Expected the previous blocklist to stay in effect")
+	}
+
+	invalid := updated
+	invalid.Cache.MaxSize = -1
+	if err := server.Reload(&invalid); err == nil {
+		t.Error("Expected reload of an invalid configuration to fail")
+	}
+}
+
+func TestRequiresRestart(t *testing.T) {
+	current := DefaultConfig()
+
+	reloadable := *current
+	reloadable.Blocklist.Sources = []string{"list.txt"}
+	reloadable.Local.Zones = []string{"lan"}
+	reloadable.Rules.Groups = []GroupConfig{{Name: "kids", Enabled: true}}
+	reloadable.Forwarder.Conditional = []ConditionalForwardConfig{{Domains: []string{"lan"}, Upstreams: []string{"192.168.1.1:53"}}}
+	if requiresRestart(current, &reloadable) {
+		t.Error("Expected list, record, rule and conditional forwarding changes to apply at runtime")
+	}
+
+	for name, mutate := range map[string]func(*Config){
+		"port":    func(c *Config) { c.Port = 5300 },
+		"cache":   func(c *Config) { c.Cache.MaxSize++ },
+		"enabled": func(c *Config) { c.Rules.Enabled = !c.Rules.Enabled },
+	} {
+		changed := *current
+		mutate(&changed)
+		if !requiresRestart(current, &changed) {
+			t.Errorf("Expected a %s change to require a restart", name)
+		}
+	}
+}
+
+func TestServer_StopDrainsQueries(t *testing.T) {
+	testLogger := logger.New(&logger.Config{
+		Level:     logger.LogLevel("ERROR"),
+		Component: "dns-test",
+	})
+
+	config := DefaultConfig()
+	config.Host = "127.0.0.1"
+	config.Port = freeTestPort(t)
+	config.LogQueries = false
+	config.Cache.Enabled = false
+	config.Forwarder.HealthCheck = false
+	config.IdleTimeout = 30 * time.Second
+
+	server := NewServer(config, testLogger).(*Server)
+	server.forwarder = newFakeForwarder("round_robin", map[string]*fakeTransport{
+		"upstream:53": {delay: 300 * time.Millisecond},
+	})
+
+	go server.Start(context.Background())
+	deadline := time.Now().Add(2 * time.Second)
+	for !server.running.Load() {
+		if time.Now().After(deadline) {
+			t.Fatal("Server did not start")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// An idle stream connection must not hold up shutdown
+	idle, err := net.Dial("tcp", server.tcpListener.Addr().String())
+	if err != nil {
+		t.Fatalf("Failed to connect over TCP: %v", err)
+	}
+	defer idle.Close()
+
+	client, err := net.DialUDP("udp", nil, server.udpConn.LocalAddr().(*net.UDPAddr))
+	if err != nil {
+		t.Fatalf("Failed to connect over UDP: %v", err)
+	}
+	defer client.Close()
+
+	parser := NewParser()
+	data, err := parser.SerializeQuery(&DNSQuery{
+		ID:       7,
+		Question: DNSQuestion{Name: "slow.example", Type: TypeA, Class: ClassIN},
+	})
+	if err != nil {
+		t.Fatalf("Failed to serialize query: %v", err)
+	}
+	if _, err := client.Write(data); err != nil {
+		t.Fatalf("Failed to send query: %v", err)
+	}
+
+	// Stop while the query waits for its upstream
+	time.Sleep(50 * time.Millisecond)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	start := time.Now()
+	if err := server.Stop(ctx); err != nil {
+		t.Fatalf("Stop failed: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 3*time.Second {
+		t.Errorf("Stop took %v, expected idle connections to be closed", elapsed)
+	}
+
+	client.SetReadDeadline(time.Now().Add(time.Second))
+	buf := make([]byte, 512)
+	n, err := client.Read(buf)
+	if err != nil {
+		t.Fatalf("Expected the in-flight query to be answered: %v", err)
+	}
+	response, err := parser.ParseResponse(buf[:n])
+	if err != nil || response.ID != 7 || len(response.Answers) != 1 {
+		t.Errorf("Unexpected response %+v, %v", response, err)
+	}
+}
+
+func TestServer_StopTimesOutWithQueryInFlight(t *testing.T) {
+	testLogger := logger.New(&logger.Config{
+		Level:     logger.LogLevel("ERROR"),
+		Component: "dns-test",
+	})
+
+	config := DefaultConfig()
+	config.Host = "127.0.0.1"
+	config.Port = freeTestPort(t)
+	config.LogQueries = false
+	config.Cache.Enabled = false
+	config.Forwarder.HealthCheck = false
+
+	server := NewServer(config, testLogger).(*Server)
+	server.forwarder = newFakeForwarder("round_robin", map[string]*fakeTransport{
+		"upstream:53": {delay: 300 * time.Millisecond},
+	})
+
+	go server.Start(context.Background())
+	deadline := time.Now().Add(2 * time.Second)
+	for !server.running.Load() {
+		if time.Now().After(deadline) {
+			t.Fatal("Server did not start")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	client, err := net.DialUDP("udp", nil, server.udpConn.LocalAddr().(*net.UDPAddr))
+	if err != nil {
+		t.Fatalf("Failed to connect over UDP: %v", err)
+	}
+	defer client.Close()
+
+	data, err := NewParser().SerializeQuery(&DNSQuery{
+		ID:       8,
+		Question: DNSQuestion{Name: "slow.example", Type: TypeA, Class: ClassIN},
+	})
+	if err != nil {
+		t.Fatalf("Failed to serialize query: %v", err)
+	}
+	if _, err := client.Write(data); err != nil {
+		t.Fatalf("Failed to send query: %v", err)
+	}
+
+	// The drain gives up while the query still waits for its upstream
+	time.Sleep(50 * time.Millisecond)
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	if err := server.Stop(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Expected Stop to report the drain timeout, got %v", err)
+	}
+
+	// The query finishes against the closed socket without panicking
+	done := make(chan struct{})
+	go func() {
+		server.inflight.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("In-flight query did not finish after Stop")
+	}
+}
+
+// freeTestPort returns a port that is currently free for both UDP and TCP
+func freeTestPort(t *testing.T) int {
+	t.Helper()
+	for i := 0; i < 10; i++ {
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatalf("Failed to find a free port: %v", err)
+		}
+		port := listener.Addr().(*net.TCPAddr).Port
+		conn, err := net.ListenPacket("udp", listener.Addr().String())
+		listener.Close()
+		if err == nil {
+			conn.Close()
+			return port
+		}
+	}
+	t.Fatal("Failed to find a port free for UDP and TCP")
+	return 0
+}
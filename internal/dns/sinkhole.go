@@ -0,0 +1,101 @@
+package dns
+
+import (
+	"fmt"
+	"net"
+)
+
+// BlockReason explains why a domain is blocked for a client
+type BlockReason struct {
+	Domain string
+
+	// Blocklist or group list source that lists the domain, empty if a
+	// rule blocked it
+	List string
+
+	// Deny rule that matched, nil if a list blocked the domain
+	Rule *DomainRule
+
+	// Group of the deny rule or group list, empty if the blocklist
+	// blocked the domain
+	Group string
+
+	// Schedule during which the rule or group list applies, if any
+	Schedule string
+
+	// Query log status: gravity, regex or denylist
+	Status int
+
+	// Answer given for the domain
+	Sinkhole SinkholeConfig
+}
+
+// validate checks a sinkhole configuration; an empty mode is valid and
+// defers to the next level
+func (c SinkholeConfig) validate() error {
+	switch c.Mode {
+	case "", SinkholeNull, SinkholeNXDomain, SinkholeNoData, SinkholeRefused:
+	case SinkholeIP:
+		if c.IPv4 == "" && c.IPv6 == "" {
+			return fmt.Errorf("%w: mode %q needs an IPv4 or IPv6 address", ErrInvalidSinkhole, c.Mode)
+		}
+	default:
+		return fmt.Errorf("%w: unknown mode %q", ErrInvalidSinkhole, c.Mode)
+	}
+
+	if c.IPv4 != "" {
+		if ip := net.ParseIP(c.IPv4); ip == nil || ip.To4() == nil {
+			return fmt.Errorf("%w: %q is not an IPv4 address", ErrInvalidSinkhole, c.IPv4)
+		}
+	}
+	if c.IPv6 != "" {
+		if ip := net.ParseIP(c.IPv6); ip == nil || ip.To4() != nil {
+			return fmt.Errorf("%w: %q is not an IPv6 address", ErrInvalidSinkhole, c.IPv6)
+		}
+	}
+	return nil
+}
+
+// answer builds the response to a blocked query. Address modes answer A
+// and AAAA questions and give NODATA for other types.
+func (c SinkholeConfig) answer(query *DNSQuery, ttl uint32) *DNSResponse {
+	response := &DNSResponse{
+		ID:           query.ID,
+		Question:     query.Question,
+		ResponseCode: RCodeNoError,
+	}
+
+	var address net.IP
+	switch c.Mode {
+	case SinkholeNXDomain:
+		response.ResponseCode = RCodeNXDomain
+	case SinkholeRefused:
+		response.ResponseCode = RCodeRefused
+	case SinkholeNoData:
+	case SinkholeIP:
+		switch query.Question.Type {
+		case TypeA:
+			address = net.ParseIP(c.IPv4).To4()
+		case TypeAAAA:
+			address = net.ParseIP(c.IPv6).To16()
+		}
+	default:
+		switch query.Question.Type {
+		case TypeA:
+			address = make(net.IP, net.IPv4len)
+		case TypeAAAA:
+			address = make(net.IP, net.IPv6len)
+		}
+	}
+
+	if address != nil {
+		response.Answers = []DNSRecord{{
+			Name:  query.Question.Name,
+			Type:  query.Question.Type,
+			Class: ClassIN,
+			TTL:   ttl,
+			Data:  address,
+		}}
+	}
+	return response
+}
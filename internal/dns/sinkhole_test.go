@@ -0,0 +1,189 @@
+package dns
+
+import (
+	"errors"
+	"net"
+	"testing"
+
+	"pihole-analyzer/internal/logger"
+)
+
+func TestSinkholeConfig_Answer(t *testing.T) {
+	custom := SinkholeConfig{Mode: SinkholeIP, IPv4: "192.168.1.2", IPv6: "fd00::2"}
+
+	tests := []struct {
+		name     string
+		sinkhole SinkholeConfig
+		qtype    uint16
+		rcode    uint8
+		address  string // Expected answer, empty for none
+	}{
+		{"default A", SinkholeConfig{}, TypeA, RCodeNoError, "0.0.0.0"},
+		{"null A", SinkholeConfig{Mode: SinkholeNull}, TypeA, RCodeNoError, "0.0.0.0"},
+		{"null AAAA", SinkholeConfig{Mode: SinkholeNull}, TypeAAAA, RCodeNoError, "::"},
+		{"null MX", SinkholeConfig{Mode: SinkholeNull}, TypeMX, RCodeNoError, ""},
+		{"nxdomain", SinkholeConfig{Mode: SinkholeNXDomain}, TypeA, RCodeNXDomain, ""},
+		{"nodata", SinkholeConfig{Mode: SinkholeNoData}, TypeA, RCodeNoError, ""},
+		{"refused", SinkholeConfig{Mode: SinkholeRefused}, TypeAAAA, RCodeRefused, ""},
+		{"ip A", custom, TypeA, RCodeNoError, "192.168.1.2"},
+		{"ip AAAA", custom, TypeAAAA, RCodeNoError, "fd00::2"},
+		{"ip without IPv6", SinkholeConfig{Mode: SinkholeIP, IPv4: "192.168.1.2"}, TypeAAAA, RCodeNoError, ""},
+		{"ip TXT", custom, TypeTXT, RCodeNoError, ""},
+	}
+
+	for _, tt := range tests {
+		query := &DNSQuery{
+			ID:       9,
+			Question: DNSQuestion{Name: "ads.example.com", Type: tt.qtype, Class: ClassIN},
+		}
+
+		response := tt.sinkhole.answer(query, 60)
+		if response.ID != query.ID || response.ResponseCode != tt.rcode {
+			t.Errorf("%s: got ID %d rcode %d, want ID %d rcode %d",
+				tt.name, response.ID, response.ResponseCode, query.ID, tt.rcode)
+			continue
+		}
+
+		if tt.address == "" {
+			if len(response.Answers) != 0 {
+				t.Errorf("%s: expected no answers, got %d", tt.name, len(response.Answers))
+			}
+			continue
+		}
+		if len(response.Answers) != 1 {
+			t.Errorf("%s: expected 1 answer, got %d", tt.name, len(response.Answers))
+			continue
+		}
+
+		answer := response.Answers[0]
+		if answer.Type != tt.qtype || answer.TTL != 60 {
+			t.Errorf("%s: got type %d TTL %d", tt.name, answer.Type, answer.TTL)
+		}
+		if want := net.ParseIP(tt.address); !net.IP(answer.Data).Equal(want) {
+			t.Errorf("%s: got address %v, want %v", tt.name, net.IP(answer.Data), want)
+		}
+	}
+}
+
+func TestSinkholeConfig_Validate(t *testing.T) {
+	valid := []SinkholeConfig{
+		{},
+		{Mode: SinkholeNull},
+		{Mode: SinkholeNXDomain},
+		{Mode: SinkholeNoData},
+		{Mode: SinkholeRefused},
+		{Mode: SinkholeIP, IPv4: "10.0.0.1"},
+		{Mode: SinkholeIP, IPv6: "fd00::1"},
+	}
+	for _, sinkhole := range valid {
+		if err := sinkhole.validate(); err != nil {
+			t.Errorf("validate(%+v) failed: %v", sinkhole, err)
+		}
+	}
+
+	invalid := []SinkholeConfig{
+		{Mode: "blackhole"},
+		{Mode: SinkholeIP},
+		{Mode: SinkholeIP, IPv4: "fd00::1"},
+		{Mode: SinkholeIP, IPv6: "10.0.0.1"},
+		{Mode: SinkholeIP, IPv4: "not an address"},
+	}
+	for _, sinkhole := range invalid {
+		if err := sinkhole.validate(); !errors.Is(err, ErrInvalidSinkhole) {
+			t.Errorf("validate(%+v) = %v, want ErrInvalidSinkhole", sinkhole, err)
+		}
+	}
+
+	config := DefaultConfig()
+	config.Blocklist.ListSinkholes = map[string]SinkholeConfig{"ads.txt": {Mode: "drop"}}
+	if err := config.Validate(); !errors.Is(err, ErrInvalidSinkhole) {
+		t.Errorf("Expected an invalid list sinkhole to be rejected, got %v", err)
+	}
+
+	rules := testRulesConfig()
+	rules.Groups[1].Sinkhole = SinkholeConfig{Mode: SinkholeIP}
+	if err := NewRuleEngine(nil).Load(rules); !errors.Is(err, ErrInvalidSinkhole) {
+		t.Errorf("Expected an invalid group sinkhole to be rejected, got %v", err)
+	}
+}
+
+func TestServer_BlockReason(t *testing.T) {
+	ads := writeTestList(t, "ads.txt", "ads.example.com\nshared.example.com\n")
+	trackers := writeTestList(t, "trackers.txt", "tracker.example.com\nshared.example.com\n")
+	telemetry := writeTestList(t, "telemetry.txt", "telemetry.example.com\n")
+
+	config := DefaultConfig()
+	config.LogQueries = false
+	config.Forwarder.HealthCheck = false
+	config.Blocklist.Sources = []string{ads, trackers}
+	config.Blocklist.ListSinkholes = map[string]SinkholeConfig{
+		trackers:  {Mode: SinkholeNXDomain},
+		telemetry: {Mode: SinkholeIP, IPv4: "192.168.1.3"},
+	}
+	config.Rules = testRulesConfig()
+	config.Rules.Lists = []GroupListConfig{{Source: telemetry, Groups: []string{"iot"}, Enabled: true}}
+	config.Rules.Groups[1].Sinkhole = SinkholeConfig{Mode: SinkholeIP, IPv4: "192.168.1.2"}
+
+	testLogger := logger.New(&logger.Config{
+		Level:     logger.LogLevel("ERROR"),
+		Component: "dns-test",
+	})
+
+	server := NewServer(config, testLogger).(*Server)
+	if err := server.blocklist.Load(); err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	if err := server.ReloadRules(config.Rules); err != nil {
+		t.Fatalf("ReloadRules failed: %v", err)
+	}
+
+	adult := udpClient("192.168.1.10")
+	kid := udpClient("192.168.1.50")
+
+	tests := []struct {
+		name   string
+		domain string
+		client net.Addr
+		list   string
+		rule   string
+		status int
+		mode   string
+	}{
+		{"list default", "tracker.example.com.", adult, trackers, "", QueryStatusGravity, SinkholeNXDomain},
+		{"first list wins", "shared.example.com", adult, ads, "", QueryStatusGravity, SinkholeNull},
+		{"deny rule", "tracker7.example.com", udpClient("192.168.1.200"), "", `^tracker[0-9]+\.`, QueryStatusRegex, SinkholeNull},
+		{"group overrides list", "tracker.example.com", kid, trackers, "", QueryStatusGravity, SinkholeIP},
+		{"group deny rule", "play.games.example", kid, "", "games.example", QueryStatusDenylist, SinkholeIP},
+		{"group list", "telemetry.example.com", udpClient("192.168.1.200"), telemetry, "", QueryStatusGravity, SinkholeIP},
+	}
+
+	for _, tt := range tests {
+		reason := server.BlockReason(tt.domain, tt.client)
+		if reason == nil {
+			t.Errorf("%s: expected %s to be blocked", tt.name, tt.domain)
+			continue
+		}
+		if reason.Domain != normalizeDomain(tt.domain) || reason.List != tt.list || reason.Status != tt.status {
+			t.Errorf("%s: got %+v", tt.name, reason)
+		}
+		if rule := reason.Rule; (rule == nil) != (tt.rule == "") || (rule != nil && rule.Domain != tt.rule) {
+			t.Errorf("%s: got rule %+v, want %q", tt.name, rule, tt.rule)
+		}
+		if reason.Sinkhole.Mode != tt.mode {
+			t.Errorf("%s: got sinkhole %q, want %q", tt.name, reason.Sinkhole.Mode, tt.mode)
+		}
+	}
+
+	// A group list is answered like a gravity list with the same source
+	if reason := server.BlockReason("telemetry.example.com", udpClient("192.168.1.200")); reason.Sinkhole.IPv4 != "192.168.1.3" {
+		t.Errorf("Expected the group list's sinkhole address, got %+v", reason.Sinkhole)
+	}
+
+	// Allow rules and unlisted domains are not blocked
+	if reason := server.BlockReason("ads.example.com", kid); reason != nil {
+		t.Errorf("Expected the allow rule to apply, got %+v", reason)
+	}
+	if reason := server.BlockReason("example.com", adult); reason != nil {
+		t.Errorf("Expected example.com not to be blocked, got %+v", reason)
+	}
+}
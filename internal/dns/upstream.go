@@ -0,0 +1,444 @@
+package dns
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Upstream URL schemes
+const (
+	SchemeUDP   = "udp"
+	SchemeTCP   = "tcp"
+	SchemeTLS   = "tls"
+	SchemeHTTPS = "https"
+)
+
+const (
+	// upstreamPoolSize is the number of idle connections kept per upstream
+	upstreamPoolSize = 4
+
+	// upstreamIdleTimeout is how long a pooled connection may stay idle
+	upstreamIdleTimeout = 30 * time.Second
+
+	// maxUDPMessageSize is the largest datagram read from an upstream
+	maxUDPMessageSize = 65535
+)
+
+// upstreamTransport exchanges raw DNS messages with one upstream server
+type upstreamTransport interface {
+	// Exchange sends a query and returns the raw response
+	Exchange(ctx context.Context, query []byte) ([]byte, error)
+
+	// Close releases pooled connections
+	Close() error
+}
+
+// truncationFallback is implemented by transports that can retry a
+// truncated answer over a stream transport
+type truncationFallback interface {
+	// StreamFallback returns the transport to retry truncated answers on
+	StreamFallback() upstreamTransport
+}
+
+// parsedUpstream is an upstream address split into scheme and target
+type parsedUpstream struct {
+	scheme string
+	// host:port for udp, tcp and tls, full URL for https
+	address string
+	// TLS server name for certificate verification
+	serverName string
+}
+
+// parseUpstream parses an upstream specification. Bare "host[:port]"
+// addresses are plain DNS over UDP (with TCP fallback); "udp://", "tcp://",
+// "tls://" and "https://" select the transport explicitly.
+func parseUpstream(upstream string) (*parsedUpstream, error) {
+	scheme := SchemeUDP
+	rest := upstream
+	if idx := strings.Index(upstream, "://"); idx >= 0 {
+		scheme = strings.ToLower(upstream[:idx])
+		rest = upstream[idx+3:]
+	}
+
+	switch scheme {
+	case SchemeUDP, SchemeTCP, SchemeTLS:
+		defaultPort := "53"
+		if scheme == SchemeTLS {
+			defaultPort = "853"
+		}
+		host, port, err := net.SplitHostPort(rest)
+		if err != nil {
+			host, port = strings.Trim(rest, "[]"), defaultPort
+		}
+		if host == "" {
+			return nil, fmt.Errorf("%w: %s", ErrInvalidUpstream, upstream)
+		}
+		return &parsedUpstream{
+			scheme:     scheme,
+			address:    net.JoinHostPort(host, port),
+			serverName: host,
+		}, nil
+	case SchemeHTTPS:
+		u, err := url.Parse(upstream)
+		if err != nil || u.Host == "" {
+			return nil, fmt.Errorf("%w: %s", ErrInvalidUpstream, upstream)
+		}
+		if u.Path == "" {
+			u.Path = "/dns-query"
+		}
+		return &parsedUpstream{
+			scheme:     scheme,
+			address:    u.String(),
+			serverName: u.Hostname(),
+		}, nil
+	default:
+		return nil, fmt.Errorf("%w: unsupported scheme %q", ErrInvalidUpstream, scheme)
+	}
+}
+
+// newUpstreamTransport creates the transport for an upstream specification
+func newUpstreamTransport(upstream string, config ForwarderConfig) (upstreamTransport, error) {
+	parsed, err := parseUpstream(upstream)
+	if err != nil {
+		return nil, err
+	}
+
+	var tlsConfig *tls.Config
+	if parsed.scheme == SchemeTLS || parsed.scheme == SchemeHTTPS {
+		tlsConfig, err = upstreamTLSConfig(parsed.serverName, config.CAFile)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	switch parsed.scheme {
+	case SchemeTLS:
+		return newDoTTransport(parsed.address, tlsConfig, config.Timeout), nil
+	case SchemeHTTPS:
+		return newDoHTransport(parsed.address, tlsConfig, config.Timeout), nil
+	case SchemeTCP:
+		return &tcpTransport{address: parsed.address, timeout: config.Timeout}, nil
+	default:
+		return &udpTransport{address: parsed.address, timeout: config.Timeout}, nil
+	}
+}
+
+// upstreamTLSConfig builds the client TLS configuration for an upstream
+func upstreamTLSConfig(serverName, caFile string) (*tls.Config, error) {
+	config := &tls.Config{
+		ServerName: serverName,
+		MinVersion: tls.VersionTLS12,
+	}
+
+	if caFile != "" {
+		pem, err := os.ReadFile(caFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read CA file: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in CA file %s", caFile)
+		}
+		config.RootCAs = pool
+	}
+
+	return config, nil
+}
+
+// exchangeDeadline returns the deadline for an exchange
+func exchangeDeadline(ctx context.Context, timeout time.Duration) time.Time {
+	if deadline, ok := ctx.Deadline(); ok {
+		return deadline
+	}
+	return time.Now().Add(timeout)
+}
+
+// udpTransport sends queries over plain UDP
+type udpTransport struct {
+	address string
+	timeout time.Duration
+}
+
+// Exchange sends a query over UDP
+func (t *udpTransport) Exchange(ctx context.Context, query []byte) ([]byte, error) {
+	conn, err := net.DialTimeout("udp", t.address, t.timeout)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to upstream %s: %w", t.address, err)
+	}
+	defer conn.Close()
+
+	conn.SetDeadline(exchangeDeadline(ctx, t.timeout))
+
+	if _, err := conn.Write(query); err != nil {
+		return nil, fmt.Errorf("failed to send query to %s: %w", t.address, err)
+	}
+
+	buffer := make([]byte, maxUDPMessageSize)
+	n, err := conn.Read(buffer)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read response from %s: %w", t.address, err)
+	}
+
+	return buffer[:n], nil
+}
+
+// Close is a no-op for UDP
+func (t *udpTransport) Close() error {
+	return nil
+}
+
+// StreamFallback returns a TCP transport to the same server
+func (t *udpTransport) StreamFallback() upstreamTransport {
+	return &tcpTransport{address: t.address, timeout: t.timeout}
+}
+
+// tcpTransport sends queries over plain TCP with 2-byte length framing
+type tcpTransport struct {
+	address string
+	timeout time.Duration
+}
+
+// Exchange sends a query over a new TCP connection
+func (t *tcpTransport) Exchange(ctx context.Context, query []byte) ([]byte, error) {
+	conn, err := net.DialTimeout("tcp", t.address, t.timeout)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to upstream tcp://%s: %w", t.address, err)
+	}
+	defer conn.Close()
+
+	conn.SetDeadline(exchangeDeadline(ctx, t.timeout))
+	return exchangeStreamMessage(conn, query)
+}
+
+// Close is a no-op for TCP
+func (t *tcpTransport) Close() error {
+	return nil
+}
+
+// pooledConn is an idle connection in a DoT pool
+type pooledConn struct {
+	conn     net.Conn
+	lastUsed time.Time
+}
+
+// dotTransport sends queries over DNS-over-TLS, reusing connections
+type dotTransport struct {
+	address   string
+	tlsConfig *tls.Config
+	timeout   time.Duration
+
+	mu   sync.Mutex
+	idle []pooledConn
+}
+
+// newDoTTransport creates a DNS-over-TLS transport
+func newDoTTransport(address string, tlsConfig *tls.Config, timeout time.Duration) *dotTransport {
+	return &dotTransport{
+		address:   address,
+		tlsConfig: tlsConfig,
+		timeout:   timeout,
+	}
+}
+
+// Exchange sends a query over a pooled TLS connection. A failure on a reused
+// connection is retried once on a fresh one, since the server may have
+// closed it while idle.
+func (t *dotTransport) Exchange(ctx context.Context, query []byte) ([]byte, error) {
+	conn, reused, err := t.getConn(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	response, err := t.exchangeOn(ctx, conn, query)
+	if err != nil && reused {
+		conn.Close()
+		if conn, err = t.dial(ctx); err != nil {
+			return nil, err
+		}
+		response, err = t.exchangeOn(ctx, conn, query)
+	}
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	t.putConn(conn)
+	return response, nil
+}
+
+// exchangeOn performs one length-prefixed exchange on a connection
+func (t *dotTransport) exchangeOn(ctx context.Context, conn net.Conn, query []byte) ([]byte, error) {
+	conn.SetDeadline(exchangeDeadline(ctx, t.timeout))
+	return exchangeStreamMessage(conn, query)
+}
+
+// getConn returns an idle pooled connection or dials a new one
+func (t *dotTransport) getConn(ctx context.Context) (net.Conn, bool, error) {
+	t.mu.Lock()
+	for len(t.idle) > 0 {
+		pc := t.idle[len(t.idle)-1]
+		t.idle = t.idle[:len(t.idle)-1]
+		if time.Since(pc.lastUsed) < upstreamIdleTimeout {
+			t.mu.Unlock()
+			return pc.conn, true, nil
+		}
+		pc.conn.Close()
+	}
+	t.mu.Unlock()
+
+	conn, err := t.dial(ctx)
+	return conn, false, err
+}
+
+// putConn returns a healthy connection to the pool
+func (t *dotTransport) putConn(conn net.Conn) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if len(t.idle) >= upstreamPoolSize {
+		conn.Close()
+		return
+	}
+	t.idle = append(t.idle, pooledConn{conn: conn, lastUsed: time.Now()})
+}
+
+// dial opens a new TLS connection to the upstream
+func (t *dotTransport) dial(ctx context.Context) (net.Conn, error) {
+	dialer := &tls.Dialer{
+		NetDialer: &net.Dialer{Timeout: t.timeout, KeepAlive: upstreamIdleTimeout},
+		Config:    t.tlsConfig,
+	}
+
+	dialCtx, cancel := context.WithDeadline(ctx, exchangeDeadline(ctx, t.timeout))
+	defer cancel()
+
+	conn, err := dialer.DialContext(dialCtx, "tcp", t.address)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to upstream tls://%s: %w", t.address, err)
+	}
+	return conn, nil
+}
+
+// Close closes all pooled connections
+func (t *dotTransport) Close() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	for _, pc := range t.idle {
+		pc.conn.Close()
+	}
+	t.idle = nil
+	return nil
+}
+
+// dohTransport sends queries over DNS-over-HTTPS (RFC 8484 POST)
+type dohTransport struct {
+	url    string
+	client *http.Client
+}
+
+// newDoHTransport creates a DNS-over-HTTPS transport with keepalive
+func newDoHTransport(url string, tlsConfig *tls.Config, timeout time.Duration) *dohTransport {
+	transport := &http.Transport{
+		TLSClientConfig:     tlsConfig,
+		ForceAttemptHTTP2:   true,
+		MaxIdleConnsPerHost: upstreamPoolSize,
+		IdleConnTimeout:     upstreamIdleTimeout,
+		TLSHandshakeTimeout: timeout,
+		DialContext: (&net.Dialer{
+			Timeout:   timeout,
+			KeepAlive: upstreamIdleTimeout,
+		}).DialContext,
+	}
+
+	return &dohTransport{
+		url: url,
+		client: &http.Client{
+			Transport: transport,
+			Timeout:   timeout,
+		},
+	}
+}
+
+// Exchange POSTs a query to the DoH endpoint
+func (t *dohTransport) Exchange(ctx context.Context, query []byte) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, t.url, bytes.NewReader(query))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create DoH request for %s: %w", t.url, err)
+	}
+	req.Header.Set("Content-Type", "application/dns-message")
+	req.Header.Set("Accept", "application/dns-message")
+
+	resp, err := t.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query upstream %s: %w", t.url, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("upstream %s returned HTTP %d", t.url, resp.StatusCode)
+	}
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 65535))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read response from %s: %w", t.url, err)
+	}
+	return body, nil
+}
+
+// Close releases idle HTTP connections
+func (t *dohTransport) Close() error {
+	t.client.CloseIdleConnections()
+	return nil
+}
+
+// failedTransport reports a configuration error for every exchange
+type failedTransport struct {
+	err error
+}
+
+// Exchange always fails with the configuration error
+func (t *failedTransport) Exchange(ctx context.Context, query []byte) ([]byte, error) {
+	return nil, t.err
+}
+
+// Close is a no-op
+func (t *failedTransport) Close() error {
+	return nil
+}
+
+// exchangeStreamMessage writes a length-prefixed message and reads the
+// length-prefixed reply (RFC 1035 4.2.2)
+func exchangeStreamMessage(conn net.Conn, message []byte) ([]byte, error) {
+	buf := make([]byte, 2+len(message))
+	binary.BigEndian.PutUint16(buf, uint16(len(message)))
+	copy(buf[2:], message)
+
+	if _, err := conn.Write(buf); err != nil {
+		return nil, fmt.Errorf("failed to send query to %s: %w", conn.RemoteAddr(), err)
+	}
+
+	lengthBuf := make([]byte, 2)
+	if _, err := io.ReadFull(conn, lengthBuf); err != nil {
+		return nil, fmt.Errorf("failed to read response length from %s: %w", conn.RemoteAddr(), err)
+	}
+
+	response := make([]byte, binary.BigEndian.Uint16(lengthBuf))
+	if _, err := io.ReadFull(conn, response); err != nil {
+		return nil, fmt.Errorf("failed to read response from %s: %w", conn.RemoteAddr(), err)
+	}
+
+	return response, nil
+}
@@ -0,0 +1,152 @@
+package dns
+
+import (
+	"sort"
+	"time"
+)
+
+// ewmaAlpha is the smoothing factor for latency and failure-rate averages;
+// higher values react faster to recent samples
+const ewmaAlpha = 0.2
+
+// upstreamState tracks the observed performance of one upstream
+type upstreamState struct {
+	queries     int64
+	failures    int64
+	samples     int64   // Queries and health checks in the averages
+	latency     float64 // EWMA of successful response times, in nanoseconds
+	failureRate float64 // EWMA of failures, 0..1
+	lastUsed    time.Time
+	lastError   string
+}
+
+// record counts the result of one query and folds it into the averages
+func (s *upstreamState) record(latency time.Duration, err error) {
+	s.queries++
+	s.lastUsed = time.Now()
+	if err != nil {
+		s.failures++
+		s.lastError = err.Error()
+	}
+	s.sample(latency, err)
+}
+
+// sample folds one round trip, of a query or a health check, into the
+// averages
+func (s *upstreamState) sample(latency time.Duration, err error) {
+	s.samples++
+
+	failed := 0.0
+	if err != nil {
+		failed = 1.0
+	} else if s.latency == 0 {
+		// First successful sample seeds the average
+		s.latency = float64(latency)
+	} else {
+		s.latency = ewmaAlpha*float64(latency) + (1-ewmaAlpha)*s.latency
+	}
+
+	if s.samples == 1 {
+		s.failureRate = failed
+	} else {
+		s.failureRate = ewmaAlpha*failed + (1-ewmaAlpha)*s.failureRate
+	}
+}
+
+// score estimates the expected cost of querying the upstream: its average
+// latency plus the failure rate weighted by the timeout a failure costs.
+// Upstreams without samples score zero so they are tried first.
+func (s *upstreamState) score(timeout time.Duration) float64 {
+	if s == nil || s.samples == 0 {
+		return 0
+	}
+	return s.latency + s.failureRate*float64(timeout)
+}
+
+// recordResult updates the statistics of an upstream after an exchange
+func (f *Forwarder) recordResult(upstream string, latency time.Duration, err error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.upstreamStateLocked(upstream).record(latency, err)
+}
+
+// recordProbe folds a health check into the averages of an upstream
+// without counting it as a query. Upstreams the fastest strategy stopped
+// querying are still measured, so they can win back queries.
+func (f *Forwarder) recordProbe(upstream string, latency time.Duration, err error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.upstreamStateLocked(upstream).sample(latency, err)
+}
+
+// upstreamStateLocked returns the state of an upstream, creating it; the
+// caller must hold mu
+func (f *Forwarder) upstreamStateLocked(upstream string) *upstreamState {
+	state, ok := f.upstreamStates[upstream]
+	if !ok {
+		state = &upstreamState{}
+		f.upstreamStates[upstream] = state
+	}
+	return state
+}
+
+// rankUpstreams returns the upstreams ordered from best to worst score
+func (f *Forwarder) rankUpstreams(upstreams []string) []string {
+	f.mu.RLock()
+	scores := make(map[string]float64, len(upstreams))
+	for _, upstream := range upstreams {
+		scores[upstream] = f.upstreamStates[upstream].score(f.config.Timeout)
+	}
+	f.mu.RUnlock()
+
+	ranked := make([]string, len(upstreams))
+	copy(ranked, upstreams)
+	sort.SliceStable(ranked, func(i, j int) bool {
+		return scores[ranked[i]] < scores[ranked[j]]
+	})
+	return ranked
+}
+
+// GetUpstreamStats returns latency and error statistics per upstream,
+// followed by those of the conditional forwarding upstreams
+func (f *Forwarder) GetUpstreamStats() []UpstreamStats {
+	stats := f.upstreamStats()
+
+	f.mu.RLock()
+	routes := f.routes
+	f.mu.RUnlock()
+
+	for _, route := range routes {
+		for _, entry := range route.forwarder.upstreamStats() {
+			entry.Zone = route.name
+			stats = append(stats, entry)
+		}
+	}
+	return stats
+}
+
+// upstreamStats returns the statistics of the forwarder's own upstreams
+func (f *Forwarder) upstreamStats() []UpstreamStats {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+
+	stats := make([]UpstreamStats, 0, len(f.upstreams))
+	for _, upstream := range f.upstreams {
+		entry := UpstreamStats{
+			Upstream: upstream,
+			Healthy:  f.healthMap[upstream],
+		}
+		if state, ok := f.upstreamStates[upstream]; ok {
+			entry.Queries = state.queries
+			entry.Failures = state.failures
+			entry.AverageLatency = time.Duration(state.latency)
+			entry.FailureRate = state.failureRate
+			entry.LastUsed = state.lastUsed
+			entry.LastError = state.lastError
+		}
+		stats = append(stats, entry)
+	}
+	return stats
+}
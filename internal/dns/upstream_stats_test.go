@@ -0,0 +1,229 @@
+package dns
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// fakeTransport answers queries after a fixed delay with an A record or the
+// configured rcode, optionally failing
+type fakeTransport struct {
+	delay time.Duration
+	fail  bool
+	rcode uint8
+	calls atomic.Int64
+}
+
+func (t *fakeTransport) Exchange(ctx context.Context, query []byte) ([]byte, error) {
+	t.calls.Add(1)
+
+	select {
+	case <-time.After(t.delay):
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+
+	if t.fail {
+		return nil, errors.New("upstream unreachable")
+	}
+
+	parser := NewParser()
+	parsed, err := parser.ParseQuery(query)
+	if err != nil {
+		return nil, err
+	}
+	response := &DNSResponse{
+		ID:           parsed.ID,
+		Question:     parsed.Question,
+		ResponseCode: t.rcode,
+	}
+	if t.rcode == RCodeNoError {
+		response.Answers = []DNSRecord{
+			{Name: parsed.Question.Name, Type: TypeA, Class: ClassIN, TTL: 300, Data: []byte{192, 0, 2, 1}},
+		}
+	}
+	return parser.SerializeResponse(response)
+}
+
+func (t *fakeTransport) Close() error { return nil }
+
+func newFakeForwarder(strategy string, transports map[string]*fakeTransport) *Forwarder {
+	var upstreams []string
+	for upstream := range transports {
+		upstreams = append(upstreams, upstream)
+	}
+
+	forwarder := NewForwarder(ForwarderConfig{
+		Enabled:           true,
+		Upstreams:         upstreams,
+		Timeout:           time.Second,
+		Retries:           2,
+		LoadBalancing:     strategy,
+		ParallelUpstreams: 2,
+	}).(*Forwarder)
+
+	for upstream, transport := range transports {
+		forwarder.transports[upstream] = transport
+	}
+	return forwarder
+}
+
+func testForwardQuery() *DNSQuery {
+	return &DNSQuery{
+		ID:       1,
+		Question: DNSQuestion{Name: "example.com", Type: TypeA, Class: ClassIN},
+	}
+}
+
+func TestUpstreamState_Record(t *testing.T) {
+	state := &upstreamState{}
+
+	state.record(100*time.Millisecond, nil)
+	if time.Duration(state.latency) != 100*time.Millisecond {
+		t.Errorf("Expected first sample to seed latency, got %v", time.Duration(state.latency))
+	}
+
+	state.record(200*time.Millisecond, nil)
+	if want := 120 * time.Millisecond; time.Duration(state.latency) != want {
+		t.Errorf("Expected EWMA latency %v, got %v", want, time.Duration(state.latency))
+	}
+
+	state.record(0, errors.New("timeout"))
+	if state.failures != 1 || state.queries != 3 {
+		t.Errorf("Expected 1 failure in 3 queries, got %d in %d", state.failures, state.queries)
+	}
+	if state.failureRate <= 0 || state.failureRate >= 1 {
+		t.Errorf("Expected failure rate between 0 and 1, got %f", state.failureRate)
+	}
+	if time.Duration(state.latency) != 120*time.Millisecond {
+		t.Errorf("Failures must not change latency, got %v", time.Duration(state.latency))
+	}
+}
+
+func TestForwarder_FastestStrategy(t *testing.T) {
+	slow := &fakeTransport{delay: 40 * time.Millisecond}
+	fast := &fakeTransport{delay: 5 * time.Millisecond}
+	broken := &fakeTransport{delay: time.Millisecond, fail: true}
+
+	forwarder := newFakeForwarder("fastest", map[string]*fakeTransport{
+		"slow:53":   slow,
+		"fast:53":   fast,
+		"broken:53": broken,
+	})
+
+	// Every upstream is tried once while it has no samples, then the
+	// fastest healthy one is preferred
+	for i := 0; i < 10; i++ {
+		if _, err := forwarder.Forward(context.Background(), testForwardQuery()); err != nil {
+			t.Fatalf("Forward failed: %v", err)
+		}
+	}
+
+	if fast.calls.Load() < 8 {
+		t.Errorf("Expected the fast upstream to serve most queries, got %d", fast.calls.Load())
+	}
+	if broken.calls.Load() > 1 {
+		t.Errorf("Expected the broken upstream to be avoided, got %d calls", broken.calls.Load())
+	}
+
+	stats := forwarder.GetUpstreamStats()
+	if len(stats) != 3 {
+		t.Fatalf("Expected stats for 3 upstreams, got %d", len(stats))
+	}
+	for _, s := range stats {
+		switch s.Upstream {
+		case "broken:53":
+			if s.Failures != s.Queries || s.LastError == "" {
+				t.Errorf("Expected broken upstream to record failures, got %+v", s)
+			}
+		case "fast:53":
+			if s.AverageLatency <= 0 || s.FailureRate != 0 {
+				t.Errorf("Expected fast upstream latency without failures, got %+v", s)
+			}
+		}
+	}
+}
+
+func TestForwarder_FastestRecoversThroughHealthChecks(t *testing.T) {
+	leader := &fakeTransport{delay: 10 * time.Millisecond}
+	lagging := &fakeTransport{}
+
+	forwarder := newFakeForwarder("fastest", map[string]*fakeTransport{
+		"leader:53":  leader,
+		"lagging:53": lagging,
+	})
+	forwarder.recordResult("leader:53", 10*time.Millisecond, nil)
+	forwarder.recordResult("lagging:53", 100*time.Millisecond, nil)
+	if upstream := forwarder.selectUpstream(forwarder.GetUpstreams()); upstream != "leader:53" {
+		t.Fatalf("Expected the leader to be selected, got %s", upstream)
+	}
+
+	// The lagging upstream gets no queries, but health checks measure its
+	// recovery
+	for i := 0; i < 20; i++ {
+		forwarder.checkUpstreamHealth()
+	}
+	if upstream := forwarder.selectUpstream(forwarder.GetUpstreams()); upstream != "lagging:53" {
+		t.Errorf("Expected the recovered upstream to be selected, got %s", upstream)
+	}
+
+	// Health checks are not counted as queries
+	for _, s := range forwarder.GetUpstreamStats() {
+		if s.Queries != 1 {
+			t.Errorf("Expected 1 query for %s, got %d", s.Upstream, s.Queries)
+		}
+	}
+}
+
+func TestForwarder_ParallelStrategy(t *testing.T) {
+	slow := &fakeTransport{delay: 500 * time.Millisecond}
+	fast := &fakeTransport{delay: 5 * time.Millisecond}
+
+	forwarder := newFakeForwarder("parallel", map[string]*fakeTransport{
+		"slow:53": slow,
+		"fast:53": fast,
+	})
+
+	start := time.Now()
+	response, err := forwarder.Forward(context.Background(), testForwardQuery())
+	if err != nil {
+		t.Fatalf("Forward failed: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 250*time.Millisecond {
+		t.Errorf("Expected the fast answer to win the race, took %v", elapsed)
+	}
+	if response.ResponseCode != RCodeNoError {
+		t.Errorf("Expected NOERROR, got %d", response.ResponseCode)
+	}
+	if slow.calls.Load() != 1 || fast.calls.Load() != 1 {
+		t.Errorf("Expected both upstreams to be raced, got slow=%d fast=%d", slow.calls.Load(), fast.calls.Load())
+	}
+
+	// The cancelled loser must not be recorded as a failure
+	for _, s := range forwarder.GetUpstreamStats() {
+		if s.Upstream == "slow:53" && s.Failures != 0 {
+			t.Errorf("Expected cancelled query not to count as failure, got %d", s.Failures)
+		}
+	}
+}
+
+func TestForwarder_ParallelPrefersValidAnswer(t *testing.T) {
+	servfail := &fakeTransport{delay: time.Millisecond, rcode: RCodeServFail}
+	good := &fakeTransport{delay: 20 * time.Millisecond}
+
+	forwarder := newFakeForwarder("parallel", map[string]*fakeTransport{
+		"servfail:53": servfail,
+		"good:53":     good,
+	})
+
+	response, err := forwarder.Forward(context.Background(), testForwardQuery())
+	if err != nil {
+		t.Fatalf("Forward failed: %v", err)
+	}
+	if response.ResponseCode != RCodeNoError {
+		t.Errorf("Expected the valid answer to win over SERVFAIL, got rcode %d", response.ResponseCode)
+	}
+}
@@ -0,0 +1,290 @@
+package dns
+
+import (
+	"context"
+	"encoding/pem"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"pihole-analyzer/internal/logger"
+)
+
+func TestParseUpstream(t *testing.T) {
+	tests := []struct {
+		upstream   string
+		scheme     string
+		address    string
+		serverName string
+		wantErr    bool
+	}{
+		{"8.8.8.8:53", SchemeUDP, "8.8.8.8:53", "8.8.8.8", false},
+		{"8.8.8.8", SchemeUDP, "8.8.8.8:53", "8.8.8.8", false},
+		{"udp://9.9.9.9:5353", SchemeUDP, "9.9.9.9:5353", "9.9.9.9", false},
+		{"tls://1.1.1.1", SchemeTLS, "1.1.1.1:853", "1.1.1.1", false},
+		{"tls://dns.example:8853", SchemeTLS, "dns.example:8853", "dns.example", false},
+		{"tls://[2606:4700::1111]:853", SchemeTLS, "[2606:4700::1111]:853", "2606:4700::1111", false},
+		{"https://dns.example/dns-query", SchemeHTTPS, "https://dns.example/dns-query", "dns.example", false},
+		{"https://dns.example", SchemeHTTPS, "https://dns.example/dns-query", "dns.example", false},
+		{"quic://dns.example", "", "", "", true},
+		{"tls://", "", "", "", true},
+	}
+
+	for _, tt := range tests {
+		parsed, err := parseUpstream(tt.upstream)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("parseUpstream(%q) expected error", tt.upstream)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("parseUpstream(%q) unexpected error: %v", tt.upstream, err)
+			continue
+		}
+		if parsed.scheme != tt.scheme || parsed.address != tt.address || parsed.serverName != tt.serverName {
+			t.Errorf("parseUpstream(%q) = %+v, want %s %s %s", tt.upstream, parsed, tt.scheme, tt.address, tt.serverName)
+		}
+	}
+}
+
+func TestForwarder_DoTUpstream(t *testing.T) {
+	certFile, keyFile := writeTestCertificate(t)
+	list := writeTestList(t, "list.txt", "blocked.example.com\n")
+
+	// A local DoT server stands in for the encrypted upstream
+	config := DefaultConfig()
+	config.Host = "127.0.0.1"
+	config.LogQueries = false
+	config.Forwarder.HealthCheck = false
+	config.Blocklist.Sources = []string{list}
+	config.TLS = TLSConfig{Enabled: true, CertFile: certFile, KeyFile: keyFile}
+
+	testLogger := logger.New(&logger.Config{
+		Level:     logger.LogLevel("ERROR"),
+		Component: "dns-test",
+	})
+
+	upstreamServer := NewServer(config, testLogger).(*Server)
+	if err := upstreamServer.blocklist.Load(); err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	upstreamServer.running.Store(true)
+	if err := upstreamServer.startDoTServer(); err != nil {
+		t.Fatalf("Failed to start DoT server: %v", err)
+	}
+	addr := upstreamServer.dotListener.Addr().String()
+	defer func() {
+		upstreamServer.running.Store(false)
+		upstreamServer.stopDoTServer()
+		upstreamServer.wg.Wait()
+	}()
+
+	forwarder := NewForwarder(ForwarderConfig{
+		Enabled:   true,
+		Upstreams: []string{"tls://" + addr},
+		Timeout:   5 * time.Second,
+		CAFile:    certFile,
+	}).(*Forwarder)
+	defer forwarder.transportFor("tls://" + addr).Close()
+
+	for id := uint16(1); id <= 3; id++ {
+		response, err := forwarder.Forward(context.Background(), &DNSQuery{
+			ID:       id,
+			Question: DNSQuestion{Name: "blocked.example.com", Type: TypeA, Class: ClassIN},
+		})
+		if err != nil {
+			t.Fatalf("Forward over DoT failed: %v", err)
+		}
+		if len(response.Answers) != 1 {
+			t.Errorf("Expected 1 answer, got %d", len(response.Answers))
+		}
+	}
+
+	if !forwarder.isUpstreamHealthy("tls://" + addr) {
+		t.Error("Expected DoT upstream to be healthy")
+	}
+
+	// Pooled connections are reused across queries
+	transport := forwarder.transportFor("tls://" + addr).(*dotTransport)
+	transport.mu.Lock()
+	idle := len(transport.idle)
+	transport.mu.Unlock()
+	if idle != 1 {
+		t.Errorf("Expected 1 pooled connection, got %d", idle)
+	}
+}
+
+func TestForwarder_DoHUpstream(t *testing.T) {
+	parser := NewParser()
+	var requests atomic.Int64
+
+	upstream := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		if r.Method != http.MethodPost || r.Header.Get("Content-Type") != "application/dns-message" {
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+
+		body, _ := io.ReadAll(r.Body)
+		query, err := parser.ParseQuery(body)
+		if err != nil {
+			http.Error(w, "bad query", http.StatusBadRequest)
+			return
+		}
+
+		data, _ := parser.SerializeResponse(&DNSResponse{
+			ID:       query.ID,
+			Question: query.Question,
+			Answers: []DNSRecord{
+				{Name: query.Question.Name, Type: TypeA, Class: ClassIN, TTL: 60, Data: []byte{192, 0, 2, 53}},
+			},
+		})
+		w.Header().Set("Content-Type", "application/dns-message")
+		w.Write(data)
+	}))
+	defer upstream.Close()
+
+	caFile := filepath.Join(t.TempDir(), "ca.pem")
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: upstream.Certificate().Raw})
+	if err := os.WriteFile(caFile, certPEM, 0600); err != nil {
+		t.Fatalf("Failed to write CA file: %v", err)
+	}
+
+	upstreamURL := upstream.URL + "/dns-query"
+	forwarder := NewForwarder(ForwarderConfig{
+		Enabled:   true,
+		Upstreams: []string{upstreamURL},
+		Timeout:   5 * time.Second,
+		CAFile:    caFile,
+	}).(*Forwarder)
+	defer forwarder.transportFor(upstreamURL).Close()
+
+	response, err := forwarder.Forward(context.Background(), &DNSQuery{
+		ID:       99,
+		Question: DNSQuestion{Name: "example.com", Type: TypeA, Class: ClassIN},
+	})
+	if err != nil {
+		t.Fatalf("Forward over DoH failed: %v", err)
+	}
+	if response.ID != 99 || len(response.Answers) != 1 {
+		t.Errorf("Unexpected DoH response: id %d, %d answers", response.ID, len(response.Answers))
+	}
+
+	if !forwarder.isUpstreamHealthy(upstreamURL) {
+		t.Error("Expected DoH upstream to be healthy")
+	}
+	if requests.Load() != 2 {
+		t.Errorf("Expected 2 DoH requests (query and health check), got %d", requests.Load())
+	}
+}
+
+func TestForwarder_TruncatedRetriesOverTCP(t *testing.T) {
+	parser := NewParser()
+	answer := func(query *DNSQuery, truncated bool) []byte {
+		response := &DNSResponse{ID: query.ID, Question: query.Question, Truncated: truncated}
+		if !truncated {
+			response.Answers = []DNSRecord{
+				{Name: query.Question.Name, Type: TypeA, Class: ClassIN, TTL: 60, Data: []byte{192, 0, 2, 7}},
+			}
+		}
+		data, _ := parser.SerializeResponse(response)
+		return data
+	}
+
+	// The UDP side only ever answers with TC set
+	udpConn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen on UDP: %v", err)
+	}
+	defer udpConn.Close()
+	go func() {
+		buffer := make([]byte, 512)
+		for {
+			n, addr, err := udpConn.ReadFrom(buffer)
+			if err != nil {
+				return
+			}
+			if query, err := parser.ParseQuery(buffer[:n]); err == nil {
+				udpConn.WriteTo(answer(query, true), addr)
+			}
+		}
+	}()
+
+	// The TCP side shares the port and carries the full answer
+	tcpListener, err := net.Listen("tcp", udpConn.LocalAddr().String())
+	if err != nil {
+		t.Skipf("TCP port not available: %v", err)
+	}
+	defer tcpListener.Close()
+	go func() {
+		for {
+			conn, err := tcpListener.Accept()
+			if err != nil {
+				return
+			}
+			go func(conn net.Conn) {
+				defer conn.Close()
+				var length [2]byte
+				if _, err := io.ReadFull(conn, length[:]); err != nil {
+					return
+				}
+				msg := make([]byte, int(length[0])<<8|int(length[1]))
+				if _, err := io.ReadFull(conn, msg); err != nil {
+					return
+				}
+				query, err := parser.ParseQuery(msg)
+				if err != nil {
+					return
+				}
+				data := answer(query, false)
+				conn.Write(append([]byte{byte(len(data) >> 8), byte(len(data))}, data...))
+			}(conn)
+		}
+	}()
+
+	forwarder := NewForwarder(ForwarderConfig{
+		Enabled:   true,
+		Upstreams: []string{udpConn.LocalAddr().String()},
+		Timeout:   2 * time.Second,
+	})
+
+	response, err := forwarder.Forward(context.Background(), testForwardQuery())
+	if err != nil {
+		t.Fatalf("Forward failed: %v", err)
+	}
+	if response.Truncated {
+		t.Error("Expected the TCP retry to return a complete answer")
+	}
+	if len(response.Answers) != 1 {
+		t.Errorf("Expected 1 answer over TCP, got %d", len(response.Answers))
+	}
+}
+
+func TestForwarder_InvalidUpstream(t *testing.T) {
+	forwarder := NewForwarder(ForwarderConfig{
+		Enabled:   true,
+		Upstreams: []string{"quic://dns.example"},
+		Timeout:   time.Second,
+	})
+
+	_, err := forwarder.Forward(context.Background(), &DNSQuery{
+		ID:       1,
+		Question: DNSQuestion{Name: "example.com", Type: TypeA, Class: ClassIN},
+	})
+	if err == nil {
+		t.Error("Expected error forwarding to invalid upstream")
+	}
+
+	config := DefaultConfig()
+	config.Forwarder.Upstreams = []string{"quic://dns.example"}
+	if err := config.Validate(); err == nil {
+		t.Error("Expected validation error for invalid upstream")
+	}
+}
@@ -0,0 +1,887 @@
+package dns
+
+import (
+	"context"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// SecurityStatus is the DNSSEC validation outcome of a response (RFC 4035
+// section 4.3)
+type SecurityStatus int
+
+const (
+	SecurityIndeterminate SecurityStatus = iota // Not validated or no trust anchor applies
+	SecuritySecure                              // Signatures chain up to a trust anchor
+	SecurityInsecure                            // Provably unsigned
+	SecurityBogus                               // Signatures missing or invalid where required
+)
+
+// String returns the RFC name of the status
+func (s SecurityStatus) String() string {
+	switch s {
+	case SecuritySecure:
+		return "secure"
+	case SecurityInsecure:
+		return "insecure"
+	case SecurityBogus:
+		return "bogus"
+	default:
+		return "indeterminate"
+	}
+}
+
+// maxNSEC3Iterations is the highest NSEC3 iteration count accepted; proofs
+// with more are treated as insecure (RFC 9276)
+const maxNSEC3Iterations = 150
+
+// maxKeyCacheTTL caps how long validated keys and insecure delegations
+// are remembered
+const maxKeyCacheTTL = time.Hour
+
+// DNSSECLookup fetches the records the validator needs, with the DO bit set
+type DNSSECLookup func(ctx context.Context, name string, qtype uint16) (*DNSResponse, error)
+
+// zoneKeys is the validated key set of a zone, or the zone's insecure status
+type zoneKeys struct {
+	status    SecurityStatus
+	keys      []*DNSKEYData
+	expiresAt time.Time
+}
+
+// Validator checks DNSSEC signatures from configured trust anchors down
+type Validator struct {
+	anchors map[string][]*DSData
+	lookup  DNSSECLookup
+	now     func() time.Time
+
+	mu    sync.Mutex
+	zones map[string]*zoneKeys
+}
+
+// NewValidator creates a validator for trust anchors given as DS records
+func NewValidator(trustAnchors []string, lookup DNSSECLookup) (*Validator, error) {
+	v := &Validator{
+		anchors: make(map[string][]*DSData),
+		lookup:  lookup,
+		now:     time.Now,
+		zones:   make(map[string]*zoneKeys),
+	}
+
+	for _, anchor := range trustAnchors {
+		owner, ds, err := ParseTrustAnchor(anchor)
+		if err != nil {
+			return nil, err
+		}
+		v.anchors[owner] = append(v.anchors[owner], ds)
+	}
+	if len(v.anchors) == 0 {
+		return nil, fmt.Errorf("%w: none configured", ErrInvalidTrustAnchor)
+	}
+	return v, nil
+}
+
+// ParseTrustAnchor parses a DS record in presentation format,
+// "<owner> [IN DS] <key tag> <algorithm> <digest type> <digest>"
+func ParseTrustAnchor(anchor string) (string, *DSData, error) {
+	var fields []string
+	for i, field := range strings.Fields(anchor) {
+		if i > 0 && (strings.EqualFold(field, "IN") || strings.EqualFold(field, "DS")) {
+			continue
+		}
+		fields = append(fields, field)
+	}
+	if len(fields) < 5 {
+		return "", nil, fmt.Errorf("%w: %q", ErrInvalidTrustAnchor, anchor)
+	}
+
+	keyTag, err1 := strconv.ParseUint(fields[1], 10, 16)
+	algorithm, err2 := strconv.ParseUint(fields[2], 10, 8)
+	digestType, err3 := strconv.ParseUint(fields[3], 10, 8)
+	digest, err4 := hex.DecodeString(strings.Join(fields[4:], ""))
+	if err1 != nil || err2 != nil || err3 != nil || err4 != nil || len(digest) == 0 {
+		return "", nil, fmt.Errorf("%w: %q", ErrInvalidTrustAnchor, anchor)
+	}
+
+	owner := canonicalName(fields[0])
+	if owner != "" && !isValidDomain(owner) {
+		return "", nil, fmt.Errorf("%w: invalid owner %q", ErrInvalidTrustAnchor, fields[0])
+	}
+
+	return owner, &DSData{
+		KeyTag:     uint16(keyTag),
+		Algorithm:  uint8(algorithm),
+		DigestType: uint8(digestType),
+		Digest:     digest,
+	}, nil
+}
+
+// validation holds the state of one Validate call
+type validation struct {
+	*Validator
+	ctx     context.Context
+	pending map[string]bool // Zones whose keys are being validated
+}
+
+// Validate checks the answer and denial records of a response. Bogus
+// results come with an error describing the failure.
+func (v *Validator) Validate(ctx context.Context, response *DNSResponse) (SecurityStatus, error) {
+	name := canonicalName(response.Question.Name)
+	if _, ok := v.anchorFor(name); !ok {
+		return SecurityIndeterminate, nil
+	}
+
+	val := &validation{Validator: v, ctx: ctx, pending: make(map[string]bool)}
+	status, err := val.validateResponse(response)
+	if err != nil {
+		return SecurityBogus, err
+	}
+	return status, nil
+}
+
+// validateResponse validates the answer RRsets, following CNAMEs, then
+// proves the denial of whatever the answer does not contain
+func (val *validation) validateResponse(response *DNSResponse) (SecurityStatus, error) {
+	qtype := response.Question.Type
+	name := canonicalName(response.Question.Name)
+	status := SecuritySecure
+
+	// Denial records count only once their own signatures check out
+	var nsecs, nsec3s []DNSRecord
+	authSigs := signaturesByRRset(response.Authorities)
+	for _, set := range rrsets(response.Authorities) {
+		rtype := set[0].Type
+		if rtype != TypeSOA && rtype != TypeNSEC && rtype != TypeNSEC3 {
+			continue
+		}
+		sigs := authSigs[rrsetKey(set[0])]
+		if len(sigs) == 0 {
+			continue
+		}
+		setStatus, _, err := val.verifyRRset(set, sigs)
+		if err != nil {
+			return SecurityBogus, err
+		}
+		if setStatus != SecuritySecure {
+			continue
+		}
+		switch rtype {
+		case TypeNSEC:
+			nsecs = append(nsecs, set...)
+		case TypeNSEC3:
+			nsec3s = append(nsec3s, set...)
+		}
+	}
+
+	answered := false
+	answerSigs := signaturesByRRset(response.Answers)
+	for _, set := range rrsets(response.Answers) {
+		setStatus, sig, err := val.verifyRRset(set, answerSigs[rrsetKey(set[0])])
+		if err != nil {
+			return SecurityBogus, err
+		}
+		if setStatus == SecurityInsecure {
+			status = SecurityInsecure
+		}
+
+		// Answers synthesized from a wildcard need proof that the name
+		// itself does not exist
+		owner := canonicalName(set[0].Name)
+		if sig != nil && int(sig.Labels) < len(nameLabels(owner)) {
+			if !wildcardProven(owner, int(sig.Labels), nsecs, nsec3s) {
+				return SecurityBogus, fmt.Errorf("%w: no proof for wildcard expansion of %s", ErrDNSSECBogus, owner)
+			}
+		}
+
+		if owner != name {
+			continue
+		}
+		switch {
+		case set[0].Type == qtype:
+			answered = true
+		case set[0].Type == TypeCNAME:
+			cname, err := ParseTarget(set[0])
+			if err != nil {
+				return SecurityBogus, fmt.Errorf("%w: invalid CNAME target", ErrDNSSECBogus)
+			}
+			name = canonicalName(cname.Target)
+		}
+	}
+
+	if answered && response.ResponseCode == RCodeNoError {
+		return status, nil
+	}
+	if status == SecurityInsecure {
+		return status, nil // The chain led into an unsigned zone
+	}
+
+	nxdomain := response.ResponseCode == RCodeNXDomain
+	if len(nsecs) > 0 || len(nsec3s) > 0 {
+		return denialStatus(name, qtype, nxdomain, nsecs, nsec3s)
+	}
+
+	// Without signed denial records the zone has to be provably unsigned
+	return val.unsignedStatus(name)
+}
+
+// verifyRRset checks an RRset against its signatures, returning the
+// signature that verified. Unsigned RRsets are only acceptable in
+// provably insecure zones.
+func (val *validation) verifyRRset(set, sigs []DNSRecord) (SecurityStatus, *RRSIGData, error) {
+	owner := canonicalName(set[0].Name)
+	if len(sigs) == 0 {
+		status, err := val.unsignedStatus(owner)
+		return status, nil, err
+	}
+
+	ownerLabels := len(nameLabels(owner))
+	if strings.HasPrefix(owner, "*.") {
+		ownerLabels--
+	}
+
+	lastErr := fmt.Errorf("%w: no usable signature for %s type %d", ErrDNSSECBogus, displayName(owner), set[0].Type)
+	for _, record := range sigs {
+		sig, err := ParseRRSIG(record)
+		if err != nil {
+			continue
+		}
+		signer := canonicalName(sig.SignerName)
+
+		// DS records are signed by the parent, everything else by the
+		// zone containing the owner
+		if !isSubdomain(owner, signer) || (set[0].Type == TypeDS && owner == signer && owner != "") {
+			continue
+		}
+		if int(sig.Labels) > ownerLabels || !supportedAlgorithm(sig.Algorithm) {
+			continue
+		}
+		if !sig.validAt(val.now()) {
+			lastErr = fmt.Errorf("%w: signature for %s outside its validity period", ErrDNSSECBogus, displayName(owner))
+			continue
+		}
+
+		keys, err := val.zoneKeys(signer)
+		if err != nil {
+			return SecurityBogus, nil, err
+		}
+		if keys.status != SecuritySecure {
+			return keys.status, nil, nil
+		}
+
+		for _, key := range keys.keys {
+			if key.Algorithm != sig.Algorithm || key.KeyTag() != sig.KeyTag {
+				continue
+			}
+			if err := verifyRRSIG(sig, set, key); err != nil {
+				lastErr = err
+				continue
+			}
+			return SecuritySecure, sig, nil
+		}
+	}
+	return SecurityBogus, nil, lastErr
+}
+
+// zoneKeys returns the validated DNSKEYs of a zone, authenticated by its
+// DS records or a trust anchor
+func (val *validation) zoneKeys(zone string) (*zoneKeys, error) {
+	if cached := val.cachedZone(zone); cached != nil {
+		return cached, nil
+	}
+	if val.pending[zone] {
+		return nil, fmt.Errorf("%w: signature loop at %s", ErrDNSSECBogus, displayName(zone))
+	}
+	val.pending[zone] = true
+	defer delete(val.pending, zone)
+
+	if _, ok := val.anchorFor(zone); !ok {
+		return &zoneKeys{status: SecurityIndeterminate}, nil
+	}
+
+	dsSet, ok := val.anchors[zone]
+	ttl := uint32(maxKeyCacheTTL / time.Second)
+	if !ok {
+		var status SecurityStatus
+		var err error
+		dsSet, status, ttl, err = val.delegationSigner(zone)
+		if err != nil {
+			return nil, err
+		}
+		if status != SecuritySecure {
+			return val.cacheZone(zone, &zoneKeys{status: status}, ttl), nil
+		}
+		if dsSet == nil {
+			return nil, fmt.Errorf("%w: %s signs records but has no DS", ErrDNSSECBogus, displayName(zone))
+		}
+	}
+
+	// A zone whose DS records are all unusable is treated as unsigned
+	// (RFC 4035 section 5.2)
+	var usable []*DSData
+	for _, ds := range dsSet {
+		if _, ok := digestHash(ds.DigestType); ok && supportedAlgorithm(ds.Algorithm) {
+			usable = append(usable, ds)
+		}
+	}
+	if len(usable) == 0 {
+		return val.cacheZone(zone, &zoneKeys{status: SecurityInsecure}, ttl), nil
+	}
+
+	response, err := val.lookup(val.ctx, zone, TypeDNSKEY)
+	if err != nil {
+		return nil, fmt.Errorf("%w: fetching DNSKEY for %s: %v", ErrDNSSECBogus, displayName(zone), err)
+	}
+
+	var keySet []DNSRecord
+	var keys, entryKeys []*DNSKEYData
+	for _, record := range response.Answers {
+		if record.Type != TypeDNSKEY || canonicalName(record.Name) != zone {
+			continue
+		}
+		key, err := ParseDNSKEY(record)
+		if err != nil || key.Protocol != 3 || key.Flags&DNSKEYFlagZone == 0 {
+			continue
+		}
+		keySet = append(keySet, record)
+		keys = append(keys, key)
+		if record.TTL < ttl {
+			ttl = record.TTL
+		}
+		for _, ds := range usable {
+			if ds.matches(zone, key) {
+				entryKeys = append(entryKeys, key)
+				break
+			}
+		}
+	}
+	if len(entryKeys) == 0 {
+		return nil, fmt.Errorf("%w: no DNSKEY of %s matches its DS", ErrDNSSECBogus, displayName(zone))
+	}
+
+	// The key set must be signed by a key the DS records vouch for
+	for _, record := range signaturesByRRset(response.Answers)[rrsetKey(keySet[0])] {
+		sig, err := ParseRRSIG(record)
+		if err != nil || canonicalName(sig.SignerName) != zone || !sig.validAt(val.now()) {
+			continue
+		}
+		for _, key := range entryKeys {
+			if key.Algorithm != sig.Algorithm || key.KeyTag() != sig.KeyTag {
+				continue
+			}
+			if verifyRRSIG(sig, keySet, key) == nil {
+				return val.cacheZone(zone, &zoneKeys{status: SecuritySecure, keys: keys}, ttl), nil
+			}
+		}
+	}
+	return nil, fmt.Errorf("%w: DNSKEY set of %s is not signed by a trusted key", ErrDNSSECBogus, displayName(zone))
+}
+
+// delegationSigner fetches and validates the DS records of a name. It
+// returns the DS set of a secure zone cut; no DS with a secure status for
+// a name that is not a zone cut; or an insecure status for an unsigned
+// delegation.
+func (val *validation) delegationSigner(name string) ([]*DSData, SecurityStatus, uint32, error) {
+	response, err := val.lookup(val.ctx, name, TypeDS)
+	if err != nil {
+		return nil, SecurityBogus, 0, fmt.Errorf("%w: fetching DS for %s: %v", ErrDNSSECBogus, displayName(name), err)
+	}
+
+	var dsRecords []DNSRecord
+	ttl := uint32(maxKeyCacheTTL / time.Second)
+	for _, record := range response.Answers {
+		if record.Type == TypeDS && canonicalName(record.Name) == name {
+			dsRecords = append(dsRecords, record)
+			if record.TTL < ttl {
+				ttl = record.TTL
+			}
+		}
+	}
+
+	if len(dsRecords) > 0 {
+		sigs := signaturesByRRset(response.Answers)[rrsetKey(dsRecords[0])]
+		if len(sigs) == 0 {
+			// Only an unsigned parent may serve unsigned DS records
+			status, err := val.unsignedStatus(parentName(name))
+			return nil, status, ttl, err
+		}
+
+		status, _, err := val.verifyRRset(dsRecords, sigs)
+		if err != nil || status != SecuritySecure {
+			return nil, status, ttl, err
+		}
+
+		var dsSet []*DSData
+		for _, record := range dsRecords {
+			if ds, err := ParseDS(record); err == nil {
+				dsSet = append(dsSet, ds)
+			}
+		}
+		return dsSet, SecuritySecure, ttl, nil
+	}
+
+	// No DS: the parent has to prove it
+	status, err := val.validateDenial(response, name)
+	if negTTL, ok := negativeTTL(response); ok && uint32(negTTL/time.Second) < ttl {
+		ttl = uint32(negTTL / time.Second)
+	}
+	return nil, status, ttl, err
+}
+
+// validateDenial checks the proof in a DS response that a name has no DS
+// records. It distinguishes unsigned delegations from names that are not
+// zone cuts at all.
+func (val *validation) validateDenial(response *DNSResponse, name string) (SecurityStatus, error) {
+	var nsecs, nsec3s []DNSRecord
+	sigs := signaturesByRRset(response.Authorities)
+	for _, set := range rrsets(response.Authorities) {
+		if set[0].Type != TypeNSEC && set[0].Type != TypeNSEC3 {
+			continue
+		}
+		recordSigs := sigs[rrsetKey(set[0])]
+		if len(recordSigs) == 0 {
+			continue
+		}
+		status, _, err := val.verifyRRset(set, recordSigs)
+		if err != nil {
+			return SecurityBogus, err
+		}
+		if status != SecuritySecure {
+			return status, nil
+		}
+		if set[0].Type == TypeNSEC {
+			nsecs = append(nsecs, set...)
+		} else {
+			nsec3s = append(nsec3s, set...)
+		}
+	}
+
+	if len(nsecs) == 0 && len(nsec3s) == 0 {
+		// An unsigned answer is only acceptable from an unsigned parent
+		return val.unsignedStatus(parentName(name))
+	}
+
+	for _, record := range nsecs {
+		nsec, err := ParseNSEC(record)
+		if err != nil || canonicalName(record.Name) != name {
+			continue
+		}
+		return delegationStatus(nsec.Types, name)
+	}
+
+	if usable := usableNSEC3(nsec3s); len(usable) > 0 {
+		if _, nsec3 := matchNSEC3(name, usable); nsec3 != nil {
+			return delegationStatus(nsec3.Types, name)
+		}
+		if _, nextCloser, ok := closestEncloser(name, usable); ok {
+			if _, cover := coverNSEC3(nextCloser, usable); cover != nil && cover.Flags&nsec3FlagOptOut != 0 {
+				return SecurityInsecure, nil // Opt-out span may hold unsigned delegations
+			}
+		}
+	} else if len(nsec3s) > 0 {
+		return SecurityInsecure, nil // Only NSEC3 parameters we do not process
+	}
+
+	// The name does not exist (or is otherwise proven absent) and so is
+	// not a zone cut
+	if status, err := denialStatus(name, TypeDS, response.ResponseCode == RCodeNXDomain, nsecs, nsec3s); err == nil {
+		return status, nil
+	}
+	return SecurityBogus, fmt.Errorf("%w: missing proof that %s has no DS", ErrDNSSECBogus, displayName(name))
+}
+
+// delegationStatus interprets the types present at a name without DS
+func delegationStatus(types []uint16, name string) (SecurityStatus, error) {
+	switch {
+	case hasType(types, TypeDS):
+		return SecurityBogus, fmt.Errorf("%w: DS for %s denied but listed", ErrDNSSECBogus, displayName(name))
+	case hasType(types, TypeNS) && !hasType(types, TypeSOA):
+		return SecurityInsecure, nil // Unsigned delegation
+	default:
+		return SecuritySecure, nil // Not a zone cut
+	}
+}
+
+// unsignedStatus decides whether unsigned data at name is acceptable: it
+// is if an unsigned delegation separates name from its trust anchor
+func (val *validation) unsignedStatus(name string) (SecurityStatus, error) {
+	name = canonicalName(name)
+	anchor, ok := val.anchorFor(name)
+	if !ok {
+		return SecurityIndeterminate, nil
+	}
+
+	labels := nameLabels(name)
+	for i := len(nameLabels(anchor)) + 1; i <= len(labels); i++ {
+		candidate := strings.Join(labels[len(labels)-i:], ".")
+
+		if cached := val.cachedZone(candidate); cached != nil {
+			if cached.status != SecuritySecure {
+				return cached.status, nil
+			}
+			continue
+		}
+
+		dsSet, status, ttl, err := val.delegationSigner(candidate)
+		if err != nil {
+			return SecurityBogus, err
+		}
+		if status != SecuritySecure {
+			val.cacheZone(candidate, &zoneKeys{status: status}, ttl)
+			return status, nil
+		}
+		if dsSet != nil {
+			// A signed zone cut; its keys must hold up too
+			keys, err := val.zoneKeys(candidate)
+			if err != nil {
+				return SecurityBogus, err
+			}
+			if keys.status != SecuritySecure {
+				return keys.status, nil
+			}
+		}
+	}
+
+	return SecurityBogus, fmt.Errorf("%w: unsigned data for %s in a signed zone", ErrDNSSECBogus, displayName(name))
+}
+
+// anchorFor returns the closest trust anchor at or above name
+func (v *Validator) anchorFor(name string) (string, bool) {
+	for {
+		if _, ok := v.anchors[name]; ok {
+			return name, true
+		}
+		if name == "" {
+			return "", false
+		}
+		name = parentName(name)
+	}
+}
+
+// cachedZone returns the remembered key set of a zone if still fresh
+func (v *Validator) cachedZone(zone string) *zoneKeys {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	entry, ok := v.zones[zone]
+	if !ok {
+		return nil
+	}
+	if v.now().After(entry.expiresAt) {
+		delete(v.zones, zone)
+		return nil
+	}
+	return entry
+}
+
+// cacheZone remembers a zone's key set for at most ttl seconds
+func (v *Validator) cacheZone(zone string, entry *zoneKeys, ttl uint32) *zoneKeys {
+	lifetime := time.Duration(ttl) * time.Second
+	if lifetime > maxKeyCacheTTL {
+		lifetime = maxKeyCacheTTL
+	}
+	entry.expiresAt = v.now().Add(lifetime)
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.zones[zone] = entry
+	return entry
+}
+
+// denialStatus checks that NSEC or NSEC3 records prove the absence of the
+// name (NXDOMAIN) or of the type at the name (NODATA)
+func denialStatus(name string, qtype uint16, nxdomain bool, nsecs, nsec3s []DNSRecord) (SecurityStatus, error) {
+	if len(nsecs) > 0 {
+		if nsecDenies(name, qtype, nxdomain, nsecs) {
+			return SecuritySecure, nil
+		}
+	}
+
+	if len(nsec3s) > 0 {
+		usable := usableNSEC3(nsec3s)
+		if len(usable) == 0 {
+			return SecurityInsecure, nil
+		}
+		if status, ok := nsec3Denies(name, qtype, nxdomain, usable); ok {
+			return status, nil
+		}
+	}
+
+	what := "type"
+	if nxdomain {
+		what = "name"
+	}
+	return SecurityBogus, fmt.Errorf("%w: missing proof of nonexistent %s for %s", ErrDNSSECBogus, what, displayName(name))
+}
+
+// nsecDenies checks an NSEC denial of existence (RFC 4035 section 5.4)
+func nsecDenies(name string, qtype uint16, nxdomain bool, nsecs []DNSRecord) bool {
+	if !nxdomain {
+		// The name exists without the type
+		for _, record := range nsecs {
+			if canonicalName(record.Name) != name {
+				continue
+			}
+			nsec, err := ParseNSEC(record)
+			return err == nil && !hasType(nsec.Types, qtype) && !hasType(nsec.Types, TypeCNAME)
+		}
+	}
+
+	// The name falls between two existing names...
+	covering := coverNSEC(name, nsecs)
+	if covering == nil {
+		return false
+	}
+	next, _ := ParseNSEC(*covering)
+	encloser := commonAncestor(name, canonicalName(covering.Name))
+	if alt := commonAncestor(name, canonicalName(next.NextName)); len(alt) > len(encloser) {
+		encloser = alt
+	}
+
+	// ...and no wildcard at the closest encloser could answer for it
+	wildcard := "*." + encloser
+	if encloser == "" {
+		wildcard = "*"
+	}
+	if coverNSEC(wildcard, nsecs) != nil {
+		return true
+	}
+	if nxdomain {
+		return false
+	}
+
+	// Wildcard NODATA: the wildcard exists without the type
+	for _, record := range nsecs {
+		if canonicalName(record.Name) != wildcard {
+			continue
+		}
+		nsec, err := ParseNSEC(record)
+		return err == nil && !hasType(nsec.Types, qtype) && !hasType(nsec.Types, TypeCNAME)
+	}
+	return false
+}
+
+// coverNSEC returns the NSEC record whose span strictly contains name
+func coverNSEC(name string, nsecs []DNSRecord) *DNSRecord {
+	for i, record := range nsecs {
+		nsec, err := ParseNSEC(record)
+		if err != nil {
+			continue
+		}
+		owner := canonicalName(record.Name)
+		next := canonicalName(nsec.NextName)
+
+		after := compareCanonical(owner, name) < 0
+		before := compareCanonical(name, next) < 0
+		last := compareCanonical(next, owner) <= 0 // The last NSEC points back to the apex
+		if after && (before || (last && isSubdomain(name, next))) {
+			return &nsecs[i]
+		}
+	}
+	return nil
+}
+
+// commonAncestor returns the longest name both a and b lie at or below
+func commonAncestor(a, b string) string {
+	la, lb := nameLabels(a), nameLabels(b)
+	n := 0
+	for n < len(la) && n < len(lb) && la[len(la)-1-n] == lb[len(lb)-1-n] {
+		n++
+	}
+	return strings.Join(la[len(la)-n:], ".")
+}
+
+// nsec3Record is a parsed NSEC3 record with its hashed owner label
+type nsec3Record struct {
+	hash string // Base32hex owner label
+	zone string
+	data *NSEC3Data
+}
+
+// usableNSEC3 parses NSEC3 records, skipping unknown hash algorithms and
+// excessive iteration counts
+func usableNSEC3(records []DNSRecord) []nsec3Record {
+	var usable []nsec3Record
+	for _, record := range records {
+		nsec3, err := ParseNSEC3(record)
+		if err != nil || nsec3.HashAlgorithm != 1 || nsec3.Iterations > maxNSEC3Iterations {
+			continue
+		}
+		owner := canonicalName(record.Name)
+		i := strings.IndexByte(owner, '.')
+		if i < 0 {
+			continue
+		}
+		usable = append(usable, nsec3Record{hash: owner[:i], zone: owner[i+1:], data: nsec3})
+	}
+	return usable
+}
+
+// matchNSEC3 returns the NSEC3 record whose owner is the hash of name
+func matchNSEC3(name string, records []nsec3Record) (*nsec3Record, *NSEC3Data) {
+	for i, record := range records {
+		if !isSubdomain(name, record.zone) {
+			continue
+		}
+		if nsec3Hash(name, record.data.Iterations, record.data.Salt) == record.hash {
+			return &records[i], record.data
+		}
+	}
+	return nil, nil
+}
+
+// coverNSEC3 returns the NSEC3 record whose hash span strictly contains
+// the hash of name
+func coverNSEC3(name string, records []nsec3Record) (*nsec3Record, *NSEC3Data) {
+	for i, record := range records {
+		if !isSubdomain(name, record.zone) {
+			continue
+		}
+		hash := nsec3Hash(name, record.data.Iterations, record.data.Salt)
+		next := strings.ToLower(nsec3Base32.EncodeToString(record.data.NextHashed))
+
+		after := record.hash < hash
+		before := hash < next
+		if (after && before) || (next <= record.hash && (after || before)) {
+			return &records[i], record.data
+		}
+	}
+	return nil, nil
+}
+
+// closestEncloser finds the longest existing ancestor of name with a
+// matching NSEC3 record and the next closer name below it whose absence
+// must be proven (RFC 5155 section 8.3)
+func closestEncloser(name string, records []nsec3Record) (string, string, bool) {
+	next := name
+	for candidate := name; ; candidate = parentName(candidate) {
+		if _, match := matchNSEC3(candidate, records); match != nil {
+			if candidate == name {
+				return "", "", false // The name exists
+			}
+			return candidate, next, true
+		}
+		if candidate == "" {
+			return "", "", false
+		}
+		next = candidate
+	}
+}
+
+// nsec3Denies checks an NSEC3 denial of existence (RFC 5155 section 8).
+// Proofs relying on an opt-out span are insecure.
+func nsec3Denies(name string, qtype uint16, nxdomain bool, records []nsec3Record) (SecurityStatus, bool) {
+	if !nxdomain {
+		if _, match := matchNSEC3(name, records); match != nil {
+			if hasType(match.Types, qtype) || hasType(match.Types, TypeCNAME) {
+				return SecurityBogus, false
+			}
+			return SecuritySecure, true
+		}
+	}
+
+	encloser, nextCloser, ok := closestEncloser(name, records)
+	if !ok {
+		return SecurityBogus, false
+	}
+	_, cover := coverNSEC3(nextCloser, records)
+	if cover == nil {
+		return SecurityBogus, false
+	}
+	if cover.Flags&nsec3FlagOptOut != 0 {
+		return SecurityInsecure, true
+	}
+
+	wildcard := "*." + encloser
+	if encloser == "" {
+		wildcard = "*"
+	}
+	if _, wildcardCover := coverNSEC3(wildcard, records); wildcardCover != nil {
+		return SecuritySecure, true
+	}
+	if !nxdomain {
+		// Wildcard NODATA
+		if _, match := matchNSEC3(wildcard, records); match != nil &&
+			!hasType(match.Types, qtype) && !hasType(match.Types, TypeCNAME) {
+			return SecuritySecure, true
+		}
+	}
+	return SecurityBogus, false
+}
+
+// wildcardProven checks that the name an answer was synthesized for does
+// not exist itself, given the number of labels the wildcard signature
+// covers (RFC 4035 section 5.3.4, RFC 5155 section 8.8)
+func wildcardProven(owner string, labels int, nsecs, nsec3s []DNSRecord) bool {
+	if coverNSEC(owner, nsecs) != nil {
+		return true
+	}
+
+	ownerLabels := nameLabels(owner)
+	nextCloser := strings.Join(ownerLabels[len(ownerLabels)-labels-1:], ".")
+	_, cover := coverNSEC3(nextCloser, usableNSEC3(nsec3s))
+	return cover != nil
+}
+
+// rrsetKey identifies the RRset a record belongs to
+func rrsetKey(record DNSRecord) string {
+	return canonicalName(record.Name) + "/" + strconv.Itoa(int(record.Type))
+}
+
+// rrsets groups records other than RRSIGs into RRsets, in order of first
+// appearance
+func rrsets(records []DNSRecord) [][]DNSRecord {
+	var sets [][]DNSRecord
+	index := make(map[string]int)
+	for _, record := range records {
+		if record.Type == TypeRRSIG || record.Type == TypeOPT {
+			continue
+		}
+		key := rrsetKey(record)
+		if i, ok := index[key]; ok {
+			sets[i] = append(sets[i], record)
+			continue
+		}
+		index[key] = len(sets)
+		sets = append(sets, []DNSRecord{record})
+	}
+	return sets
+}
+
+// signaturesByRRset groups RRSIG records by the RRset they cover
+func signaturesByRRset(records []DNSRecord) map[string][]DNSRecord {
+	sigs := make(map[string][]DNSRecord)
+	for _, record := range records {
+		if record.Type != TypeRRSIG || len(record.Data) < 2 {
+			continue
+		}
+		covered := DNSRecord{Name: record.Name, Type: uint16(record.Data[0])<<8 | uint16(record.Data[1])}
+		key := rrsetKey(covered)
+		sigs[key] = append(sigs[key], record)
+	}
+	return sigs
+}
+
+// isDNSSECRecord reports whether a record type only matters to validators
+func isDNSSECRecord(rtype uint16) bool {
+	return rtype == TypeRRSIG || rtype == TypeNSEC || rtype == TypeNSEC3
+}
+
+// withoutDNSSEC returns records without signatures and denial records,
+// keeping those of the queried type
+func withoutDNSSEC(records []DNSRecord, qtype uint16) []DNSRecord {
+	filtered := records[:0:0]
+	for _, record := range records {
+		if isDNSSECRecord(record.Type) && record.Type != qtype {
+			continue
+		}
+		filtered = append(filtered, record)
+	}
+	if len(filtered) == len(records) {
+		return records
+	}
+	return filtered
+}
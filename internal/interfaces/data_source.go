@@ -29,6 +29,13 @@ type DataSource interface {
 	GetConnectionInfo() *ConnectionInfo
 }
 
+// RateLimitReporter is implemented by data sources that can report the
+// rate limiting done by the DNS server they read from since the previous
+// report
+type RateLimitReporter interface {
+	GetRateLimitStats(ctx context.Context) (*types.RateLimitStats, error)
+}
+
 // QueryParams represents parameters for DNS query requests
 type QueryParams struct {
 	StartTime    time.Time
@@ -45,6 +52,7 @@ type DataSourceType string
 
 const (
 	DataSourceTypeAPI DataSourceType = "api"
+	DataSourceTypeDNS DataSourceType = "dns" // Query log of the embedded DNS server
 )
 
 // ConnectionInfo provides metadata about the data source connection
@@ -0,0 +1,198 @@
+package metrics
+
+import (
+	"log/slog"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// DNSServerStats is a snapshot of the embedded DNS server's counters,
+// cumulative since it started
+type DNSServerStats struct {
+	StartTime      time.Time
+	AverageLatency time.Duration
+
+	QueriesReceived  int64
+	QueriesAnswered  int64
+	QueriesForwarded int64
+	QueriesBlocked   int64
+	CNAMEBlocked     int64
+	QueriesAllowed   int64
+	LocalAnswers     int64
+	RewrittenAnswers int64
+	CacheHits        int64
+	CacheMisses      int64
+	Errors           int64
+	TruncatedReplies int64
+
+	// Queries by transport
+	UDPQueries int64
+	TCPQueries int64
+	DoTQueries int64
+	DoHQueries int64
+
+	// DNSSEC validation outcomes
+	DNSSECSecure   int64
+	DNSSECInsecure int64
+	DNSSECBogus    int64
+
+	// Rate limit events, by limit and by action taken
+	RateLimitedQueries   int64
+	RateLimitedResponses int64
+	RateLimitDropped     int64
+	RateLimitRefused     int64
+	RateLimitTruncated   int64
+
+	// Upstreams the server forwards to
+	Upstreams []DNSUpstreamStats
+}
+
+// DNSUpstreamStats is a snapshot of one upstream's health, latency and
+// failures as seen by the DNS server's forwarder
+type DNSUpstreamStats struct {
+	Upstream       string
+	Zone           string // Conditional forwarding domains, empty for the default upstreams
+	Healthy        bool
+	Queries        int64
+	Failures       int64
+	AverageLatency time.Duration // Moving average
+	FailureRate    float64       // Moving average, 0..1
+}
+
+// dnsServerCollector reads DNS server counters on every scrape, so they
+// are exported as the server counts them
+type dnsServerCollector struct {
+	stats func() DNSServerStats
+
+	queries   *prometheus.Desc
+	results   *prometheus.Desc
+	protocols *prometheus.Desc
+	dnssec    *prometheus.Desc
+	limited   *prometheus.Desc
+	actions   *prometheus.Desc
+	latency   *prometheus.Desc
+	uptime    *prometheus.Desc
+
+	upstreamQueries     *prometheus.Desc
+	upstreamFailures    *prometheus.Desc
+	upstreamLatency     *prometheus.Desc
+	upstreamFailureRate *prometheus.Desc
+	upstreamHealthy     *prometheus.Desc
+}
+
+// RegisterDNSServer exposes the counters of the embedded DNS server, read
+// from stats whenever metrics are scraped
+func (c *Collector) RegisterDNSServer(stats func() DNSServerStats) error {
+	collector := &dnsServerCollector{
+		stats: stats,
+		queries: prometheus.NewDesc("pihole_analyzer_dns_queries_total",
+			"Total number of queries received by the DNS server", nil, nil),
+		results: prometheus.NewDesc("pihole_analyzer_dns_query_results_total",
+			"Total number of DNS server queries by how they were handled", []string{"result"}, nil),
+		protocols: prometheus.NewDesc("pihole_analyzer_dns_queries_by_protocol_total",
+			"Total number of DNS server queries by transport protocol", []string{"protocol"}, nil),
+		dnssec: prometheus.NewDesc("pihole_analyzer_dns_dnssec_validations_total",
+			"Total number of forwarded answers by DNSSEC validation outcome", []string{"status"}, nil),
+		limited: prometheus.NewDesc("pihole_analyzer_dns_rate_limited_total",
+			"Total number of queries over a rate limit by limit", []string{"limit"}, nil),
+		actions: prometheus.NewDesc("pihole_analyzer_dns_rate_limit_actions_total",
+			"Total number of rate limited queries by action taken", []string{"action"}, nil),
+		latency: prometheus.NewDesc("pihole_analyzer_dns_average_latency_seconds",
+			"Average time taken by the DNS server to answer a query", nil, nil),
+		uptime: prometheus.NewDesc("pihole_analyzer_dns_uptime_seconds",
+			"Time since the DNS server started", nil, nil),
+		upstreamQueries: prometheus.NewDesc("pihole_analyzer_dns_upstream_queries_total",
+			"Total number of queries sent to a DNS upstream", []string{"upstream", "zone"}, nil),
+		upstreamFailures: prometheus.NewDesc("pihole_analyzer_dns_upstream_failures_total",
+			"Total number of failed queries to a DNS upstream", []string{"upstream", "zone"}, nil),
+		upstreamLatency: prometheus.NewDesc("pihole_analyzer_dns_upstream_latency_seconds",
+			"Moving average of a DNS upstream's response time", []string{"upstream", "zone"}, nil),
+		upstreamFailureRate: prometheus.NewDesc("pihole_analyzer_dns_upstream_failure_rate",
+			"Moving average of a DNS upstream's failure rate, from 0 to 1", []string{"upstream", "zone"}, nil),
+		upstreamHealthy: prometheus.NewDesc("pihole_analyzer_dns_upstream_healthy",
+			"Whether a DNS upstream passed its last health check (1) or not (0)", []string{"upstream", "zone"}, nil),
+	}
+
+	if err := c.registry.Register(collector); err != nil {
+		return err
+	}
+
+	c.logger.Info("🔍 DNS server metrics registered",
+		slog.String("component", "metrics"))
+	return nil
+}
+
+// Describe implements prometheus.Collector
+func (d *dnsServerCollector) Describe(ch chan<- *prometheus.Desc) {
+	for _, desc := range []*prometheus.Desc{
+		d.queries, d.results, d.protocols, d.dnssec, d.limited, d.actions, d.latency, d.uptime,
+		d.upstreamQueries, d.upstreamFailures, d.upstreamLatency, d.upstreamFailureRate, d.upstreamHealthy,
+	} {
+		ch <- desc
+	}
+}
+
+// Collect implements prometheus.Collector
+func (d *dnsServerCollector) Collect(ch chan<- prometheus.Metric) {
+	stats := d.stats()
+
+	counter := func(desc *prometheus.Desc, value int64, labels ...string) {
+		ch <- prometheus.MustNewConstMetric(desc, prometheus.CounterValue, float64(value), labels...)
+	}
+
+	counter(d.queries, stats.QueriesReceived)
+
+	counter(d.results, stats.QueriesAnswered, "answered")
+	counter(d.results, stats.QueriesForwarded, "forwarded")
+	counter(d.results, stats.QueriesBlocked, "blocked")
+	counter(d.results, stats.CNAMEBlocked, "cname_blocked")
+	counter(d.results, stats.QueriesAllowed, "allowed")
+	counter(d.results, stats.LocalAnswers, "local")
+	counter(d.results, stats.RewrittenAnswers, "rewritten")
+	counter(d.results, stats.CacheHits, "cache_hit")
+	counter(d.results, stats.CacheMisses, "cache_miss")
+	counter(d.results, stats.Errors, "error")
+	counter(d.results, stats.TruncatedReplies, "truncated")
+
+	counter(d.protocols, stats.UDPQueries, "udp")
+	counter(d.protocols, stats.TCPQueries, "tcp")
+	counter(d.protocols, stats.DoTQueries, "dot")
+	counter(d.protocols, stats.DoHQueries, "doh")
+
+	counter(d.dnssec, stats.DNSSECSecure, "secure")
+	counter(d.dnssec, stats.DNSSECInsecure, "insecure")
+	counter(d.dnssec, stats.DNSSECBogus, "bogus")
+
+	counter(d.limited, stats.RateLimitedQueries, "queries")
+	counter(d.limited, stats.RateLimitedResponses, "responses")
+
+	counter(d.actions, stats.RateLimitDropped, "drop")
+	counter(d.actions, stats.RateLimitRefused, "refuse")
+	counter(d.actions, stats.RateLimitTruncated, "truncate")
+
+	ch <- prometheus.MustNewConstMetric(d.latency, prometheus.GaugeValue, stats.AverageLatency.Seconds())
+
+	uptime := 0.0
+	if !stats.StartTime.IsZero() {
+		uptime = time.Since(stats.StartTime).Seconds()
+	}
+	ch <- prometheus.MustNewConstMetric(d.uptime, prometheus.GaugeValue, uptime)
+
+	gauge := func(desc *prometheus.Desc, value float64, labels ...string) {
+		ch <- prometheus.MustNewConstMetric(desc, prometheus.GaugeValue, value, labels...)
+	}
+
+	for _, upstream := range stats.Upstreams {
+		counter(d.upstreamQueries, upstream.Queries, upstream.Upstream, upstream.Zone)
+		counter(d.upstreamFailures, upstream.Failures, upstream.Upstream, upstream.Zone)
+		gauge(d.upstreamLatency, upstream.AverageLatency.Seconds(), upstream.Upstream, upstream.Zone)
+		gauge(d.upstreamFailureRate, upstream.FailureRate, upstream.Upstream, upstream.Zone)
+
+		healthy := 0.0
+		if upstream.Healthy {
+			healthy = 1
+		}
+		gauge(d.upstreamHealthy, healthy, upstream.Upstream, upstream.Zone)
+	}
+}
@@ -0,0 +1,89 @@
+package metrics
+
+import (
+	"log/slog"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestRegisterDNSServer(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(os.Stdout, &slog.HandlerOptions{Level: slog.LevelError}))
+	collector := New(logger)
+
+	stats := DNSServerStats{
+		StartTime:          time.Now().Add(-time.Minute),
+		AverageLatency:     20 * time.Millisecond,
+		QueriesReceived:    10,
+		QueriesBlocked:     3,
+		UDPQueries:         8,
+		DoHQueries:         2,
+		DNSSECBogus:        1,
+		RateLimitedQueries: 4,
+		RateLimitRefused:   4,
+		Upstreams: []DNSUpstreamStats{
+			{Upstream: "1.1.1.1:53", Healthy: true, Queries: 9, Failures: 1, AverageLatency: 15 * time.Millisecond, FailureRate: 0.1},
+			{Upstream: "192.168.1.1:53", Zone: "home.arpa", Queries: 2},
+		},
+	}
+	if err := collector.RegisterDNSServer(func() DNSServerStats { return stats }); err != nil {
+		t.Fatalf("RegisterDNSServer failed: %v", err)
+	}
+
+	// Counters follow the server on every scrape
+	stats.QueriesReceived = 12
+
+	families, err := collector.GetRegistry().Gather()
+	if err != nil {
+		t.Fatalf("Gather failed: %v", err)
+	}
+
+	values := make(map[string]float64)
+	for _, family := range families {
+		for _, metric := range family.GetMetric() {
+			name := family.GetName()
+			for _, label := range metric.GetLabel() {
+				if label.GetValue() != "" {
+					name += "{" + label.GetValue() + "}"
+				}
+			}
+			if metric.GetCounter() != nil {
+				values[name] = metric.GetCounter().GetValue()
+			} else {
+				values[name] = metric.GetGauge().GetValue()
+			}
+		}
+	}
+
+	expected := map[string]float64{
+		"pihole_analyzer_dns_queries_total":                      12,
+		"pihole_analyzer_dns_query_results_total{blocked}":       3,
+		"pihole_analyzer_dns_queries_by_protocol_total{udp}":     8,
+		"pihole_analyzer_dns_queries_by_protocol_total{doh}":     2,
+		"pihole_analyzer_dns_dnssec_validations_total{bogus}":    1,
+		"pihole_analyzer_dns_rate_limited_total{queries}":        4,
+		"pihole_analyzer_dns_rate_limit_actions_total{refuse}":   4,
+		"pihole_analyzer_dns_average_latency_seconds":            0.02,
+		"pihole_analyzer_dns_rate_limit_actions_total{truncate}": 0,
+
+		"pihole_analyzer_dns_upstream_queries_total{1.1.1.1:53}":                9,
+		"pihole_analyzer_dns_upstream_failures_total{1.1.1.1:53}":               1,
+		"pihole_analyzer_dns_upstream_latency_seconds{1.1.1.1:53}":              0.015,
+		"pihole_analyzer_dns_upstream_failure_rate{1.1.1.1:53}":                 0.1,
+		"pihole_analyzer_dns_upstream_healthy{1.1.1.1:53}":                      1,
+		"pihole_analyzer_dns_upstream_queries_total{192.168.1.1:53}{home.arpa}": 2,
+		"pihole_analyzer_dns_upstream_healthy{192.168.1.1:53}{home.arpa}":       0,
+	}
+	for name, want := range expected {
+		if got, ok := values[name]; !ok || got != want {
+			t.Errorf("%s = %v (present %v), want %v", name, got, ok, want)
+		}
+	}
+	if uptime := values["pihole_analyzer_dns_uptime_seconds"]; uptime < 60 {
+		t.Errorf("Expected uptime of at least a minute, got %v", uptime)
+	}
+
+	if err := collector.RegisterDNSServer(func() DNSServerStats { return stats }); err == nil {
+		t.Error("Expected registering a second DNS server to fail")
+	}
+}
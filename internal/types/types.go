@@ -29,6 +29,8 @@ type PiholeRecord struct {
 	Timestamp string
 	HWAddr    string
 	ReplyTime float64 // Response time in milliseconds
+	Upstream  string  // Upstream server that answered, for forwarded queries
+	CNAME     string  // Blocked CNAME target, for queries blocked by CNAME inspection
 }
 
 // ClientStats stores statistics for each client
@@ -56,6 +58,7 @@ type ClientStats struct {
 	TotalReplyTime float64
 	AvgReplyTime   float64
 	Uniquedomains  int
+	CloakedDomains map[string]int // Blocked CNAME targets behind the client's queries
 }
 
 // DomainStat represents domain statistics
@@ -226,6 +229,16 @@ type AnalysisResult struct {
 	DataSourceType string                  `json:"data_source_type"`
 	Timestamp      string                  `json:"timestamp"`
 	Performance    *QueryPerformance       `json:"performance,omitempty"`
+	RateLimit      *RateLimitStats         `json:"rate_limit,omitempty"`
+}
+
+// RateLimitStats counts queries limited by the embedded DNS server
+type RateLimitStats struct {
+	LimitedQueries   int64 `json:"limited_queries"`   // Over a client's query rate
+	LimitedResponses int64 `json:"limited_responses"` // Over the rate of identical responses
+	Dropped          int64 `json:"dropped"`
+	Refused          int64 `json:"refused"`
+	Truncated        int64 `json:"truncated"`
 }
 
 // QueryParams represents parameters for querying DNS data
@@ -967,6 +980,9 @@ type DNSConfig struct {
 	TCPEnabled bool   `json:"tcp_enabled"`
 	UDPEnabled bool   `json:"udp_enabled"`
 
+	// DNS-over-TLS listener
+	TLS DNSTLSConfig `json:"tls"`
+
 	// Timeouts (in seconds)
 	ReadTimeout  int `json:"read_timeout"`
 	WriteTimeout int `json:"write_timeout"`
@@ -978,6 +994,33 @@ type DNSConfig struct {
 	// Forwarder configuration
 	Forwarder DNSForwarderConfig `json:"forwarder"`
 
+	// Iterative resolution from the root servers instead of forwarding
+	Recursion DNSRecursionConfig `json:"recursion"`
+
+	// Blocklist configuration
+	Blocklist DNSBlocklistConfig `json:"blocklist"`
+
+	// Domain rules and client groups
+	Rules DNSRulesConfig `json:"rules"`
+
+	// Local records and authoritative zones
+	Local DNSLocalRecordsConfig `json:"local"`
+
+	// Group-scoped domain rewrites and safe search
+	Rewrites DNSRewritesConfig `json:"rewrites"`
+
+	// DNSSEC validation of forwarded answers
+	DNSSEC DNSSECConfig `json:"dnssec"`
+
+	// In-memory log of handled queries
+	QueryLog DNSQueryLogConfig `json:"query_log"`
+
+	// Per-client query limits and response rate limiting
+	RateLimit DNSRateLimitConfig `json:"rate_limit"`
+
+	// dnstap logging of client and forwarder messages
+	Dnstap DNSDnstapConfig `json:"dnstap"`
+
 	// Logging
 	LogQueries bool `json:"log_queries"`
 	LogLevel   int  `json:"log_level"`
@@ -987,6 +1030,14 @@ type DNSConfig struct {
 	BufferSize           int `json:"buffer_size"`
 }
 
+// DNSTLSConfig represents DNS-over-TLS listener configuration
+type DNSTLSConfig struct {
+	Enabled  bool   `json:"enabled"`
+	Port     int    `json:"port"`      // DoT port (default: 853)
+	CertFile string `json:"cert_file"` // PEM certificate path
+	KeyFile  string `json:"key_file"`  // PEM private key path
+}
+
 // DNSCacheConfig represents DNS cache configuration
 type DNSCacheConfig struct {
 	Enabled         bool `json:"enabled"`
@@ -1001,23 +1052,239 @@ type DNSCacheConfig struct {
 
 	// Memory limits
 	MaxMemoryMB int `json:"max_memory_mb"`
+
+	// Serve-stale (RFC 8767)
+	ServeStale  bool `json:"serve_stale"`
+	StaleWindow int  `json:"stale_window"` // seconds expired entries are kept
+	StaleTTL    int  `json:"stale_ttl"`    // seconds, TTL of stale answers
+
+	// Prefetching of popular entries
+	Prefetch          bool  `json:"prefetch"`
+	PrefetchThreshold int64 `json:"prefetch_threshold"` // minimum hits
+	PrefetchWindow    int   `json:"prefetch_window"`    // seconds before expiry
+
+	// Negative caching (RFC 2308)
+	NegativeCache  bool `json:"negative_cache"`
+	NegativeMaxTTL int  `json:"negative_max_ttl"` // seconds
 }
 
 // DNSForwarderConfig represents DNS forwarder configuration
 type DNSForwarderConfig struct {
 	Enabled        bool     `json:"enabled"`
-	Upstreams      []string `json:"upstreams"`
-	Timeout        int      `json:"timeout"` // seconds
+	Upstreams      []string `json:"upstreams"` // host:port, tls://host:port or https://host/dns-query
+	Timeout        int      `json:"timeout"`   // seconds
 	Retries        int      `json:"retries"`
 	HealthCheck    bool     `json:"health_check"`
 	HealthInterval int      `json:"health_interval"` // seconds
 
-	// Load balancing: "round_robin", "random", "fastest"
-	LoadBalancing string `json:"load_balancing"`
+	// Load balancing: "round_robin", "random", "fastest", "parallel"
+	LoadBalancing     string `json:"load_balancing"`
+	ParallelUpstreams int    `json:"parallel_upstreams"` // Upstreams raced by "parallel"
 
 	// EDNS0 support
 	EDNS0Enabled bool `json:"edns0_enabled"`
 	UDPSize      int  `json:"udp_size"`
+
+	// Pass the EDNS Client Subnet option upstream instead of stripping it
+	ForwardClientSubnet bool `json:"forward_client_subnet"`
+
+	// CA bundle for verifying DoT/DoH upstreams (system roots if empty)
+	CAFile string `json:"ca_file"`
+
+	// Conditional forwarding rules; the longest matching domain wins
+	Conditional []DNSConditionalForwardConfig `json:"conditional"`
+}
+
+// DNSConditionalForwardConfig sends queries below some domains to dedicated upstreams
+type DNSConditionalForwardConfig struct {
+	Domains        []string `json:"domains"`         // Domain suffixes or networks (CIDR) for reverse zones
+	Upstreams      []string `json:"upstreams"`       // Upstreams for these domains
+	Timeout        int      `json:"timeout"`         // seconds, 0 inherits the forwarder's
+	Retries        int      `json:"retries"`         // Additional attempts
+	HealthCheck    bool     `json:"health_check"`    // Probe these upstreams periodically
+	HealthInterval int      `json:"health_interval"` // seconds, 0 inherits the forwarder's
+	LoadBalancing  string   `json:"load_balancing"`  // Empty inherits the forwarder's
+}
+
+// DNSRecursionConfig represents iterative resolution from the root servers
+type DNSRecursionConfig struct {
+	Enabled           bool     `json:"enabled"`
+	RootHints         []string `json:"root_hints"` // Root server addresses, "ip" or "ip:port"
+	QNAMEMinimisation bool     `json:"qname_minimisation"`
+	Timeout           int      `json:"timeout"`     // seconds, per authoritative server query
+	MaxQueries        int      `json:"max_queries"` // Queries sent to resolve one client query
+	CacheSize         int      `json:"cache_size"`  // Delegations and nameserver addresses kept
+}
+
+// DNSBlocklistConfig represents DNS blocklist (gravity) configuration
+type DNSBlocklistConfig struct {
+	Enabled    bool     `json:"enabled"`
+	Sources    []string `json:"sources"`     // Local list files (hosts, plain domain or Adblock format)
+	BlockedTTL int      `json:"blocked_ttl"` // seconds
+
+	// Answer for blocked queries and per-list overrides keyed by source
+	Sinkhole      DNSSinkholeConfig            `json:"sinkhole"`
+	ListSinkholes map[string]DNSSinkholeConfig `json:"list_sinkholes"`
+
+	CNAMEInspection bool `json:"cname_inspection"` // Block answers whose CNAME targets are blocked
+}
+
+// DNSSinkholeConfig represents how blocked queries are answered
+type DNSSinkholeConfig struct {
+	Mode string `json:"mode"` // null, nxdomain, nodata, refused or ip
+	IPv4 string `json:"ipv4"` // Address for A queries in ip mode
+	IPv6 string `json:"ipv6"` // Address for AAAA queries in ip mode
+}
+
+// DNSRulesConfig represents domain allow/deny rules and client groups
+type DNSRulesConfig struct {
+	Enabled bool                  `json:"enabled"`
+	Groups  []DNSGroupConfig      `json:"groups"`
+	Clients []DNSClientConfig     `json:"clients"`
+	Domains []DNSDomainRuleConfig `json:"domains"`
+
+	// List files blocked for some groups only
+	Lists []DNSGroupListConfig `json:"lists"`
+
+	// Weekly schedules that rules and group lists can be limited to
+	Schedules []DNSScheduleConfig `json:"schedules"`
+}
+
+// DNSGroupConfig represents a client group
+type DNSGroupConfig struct {
+	Name        string            `json:"name"`
+	Enabled     bool              `json:"enabled"`
+	Description string            `json:"description"`
+	Sinkhole    DNSSinkholeConfig `json:"sinkhole"` // Overrides the list answer for the group's clients
+}
+
+// DNSClientConfig assigns a client to groups
+type DNSClientConfig struct {
+	IP          string   `json:"ip"`          // Client IP address
+	Network     string   `json:"network"`     // Client network in CIDR notation
+	MAC         string   `json:"mac"`         // Client MAC address
+	Groups      []string `json:"groups"`      // Group names
+	Description string   `json:"description"` // Optional description
+}
+
+// DNSDomainRuleConfig represents an allow or deny rule for domains
+type DNSDomainRuleConfig struct {
+	Domain  string   `json:"domain"`  // Domain, wildcard (*.example.com) or regex
+	Kind    string   `json:"kind"`    // "exact", "regex" or "wildcard"
+	Action  string   `json:"action"`  // "deny" or "allow"
+	Groups  []string `json:"groups"`  // Groups the rule applies to (default group if empty)
+	Enabled bool     `json:"enabled"` // Whether the rule is active
+	Comment string   `json:"comment"` // Optional comment
+
+	Schedule string `json:"schedule"` // Schedule the rule applies during (always if empty)
+}
+
+// DNSGroupListConfig blocks the domains of a list file for some groups
+type DNSGroupListConfig struct {
+	Source   string   `json:"source"`   // List file in hosts, plain-domain or Adblock format
+	Groups   []string `json:"groups"`   // Groups the list applies to (default group if empty)
+	Enabled  bool     `json:"enabled"`  // Whether the list is active
+	Comment  string   `json:"comment"`  // Optional comment
+	Schedule string   `json:"schedule"` // Schedule the list applies during (always if empty)
+}
+
+// DNSScheduleConfig is a named weekly schedule
+type DNSScheduleConfig struct {
+	Name     string                   `json:"name"`
+	Timezone string                   `json:"timezone"` // IANA timezone, local time if empty
+	Ranges   []DNSScheduleRangeConfig `json:"ranges"`
+	Comment  string                   `json:"comment"`
+}
+
+// DNSScheduleRangeConfig is a daily time range on some days of the week
+type DNSScheduleRangeConfig struct {
+	Days  []string `json:"days"`  // "mon" to "sun", every day if empty
+	Start string   `json:"start"` // "HH:MM"
+	End   string   `json:"end"`   // "HH:MM", before start to run past midnight
+}
+
+// DNSLocalRecordsConfig represents locally served DNS records
+type DNSLocalRecordsConfig struct {
+	Enabled    bool                   `json:"enabled"`
+	Zones      []string               `json:"zones"`       // Zones answered authoritatively (e.g. "lan")
+	Records    []DNSLocalRecordConfig `json:"records"`     // Records from config
+	HostsFiles []string               `json:"hosts_files"` // Files in /etc/hosts format
+	TTL        int                    `json:"ttl"`         // seconds
+}
+
+// DNSRewritesConfig represents domain rewrites and safe search
+type DNSRewritesConfig struct {
+	Enabled    bool                   `json:"enabled"`
+	Rules      []DNSRewriteRuleConfig `json:"rules"`
+	SafeSearch DNSSafeSearchConfig    `json:"safe_search"`
+	TTL        int                    `json:"ttl"` // seconds
+}
+
+// DNSRewriteRuleConfig rewrites a domain to a CNAME target or an address
+type DNSRewriteRuleConfig struct {
+	Domain  string   `json:"domain"` // Exact name, or "*.name" for the name and its subdomains
+	Target  string   `json:"target"` // CNAME target, or an IPv4 or IPv6 address
+	Groups  []string `json:"groups"` // Group names, the default group if empty
+	Enabled bool     `json:"enabled"`
+	Comment string   `json:"comment"`
+}
+
+// DNSSafeSearchConfig enforces safe search for some client groups
+type DNSSafeSearchConfig struct {
+	Enabled  bool     `json:"enabled"`
+	Groups   []string `json:"groups"`   // Group names, the default group if empty
+	Services []string `json:"services"` // google, bing, duckduckgo, youtube; all if empty
+	YouTube  string   `json:"youtube"`  // strict or moderate
+}
+
+// DNSSECConfig represents DNSSEC validation configuration
+type DNSSECConfig struct {
+	Enabled      bool     `json:"enabled"`
+	TrustAnchors []string `json:"trust_anchors"` // DS records, "<owner> <key tag> <algorithm> <digest type> <digest>"
+}
+
+// DNSQueryLogConfig represents the in-memory query log configuration
+type DNSQueryLogConfig struct {
+	Enabled bool `json:"enabled"`
+	Size    int  `json:"size"` // Most recent queries kept
+}
+
+// DNSRateLimitConfig represents per-client query limits and response rate
+// limiting (RRL)
+type DNSRateLimitConfig struct {
+	Enabled               bool     `json:"enabled"`
+	QueriesPerSecond      float64  `json:"queries_per_second"` // Per client prefix, 0 disables
+	Burst                 int      `json:"burst"`
+	Action                string   `json:"action"`                   // "drop", "refuse" or "truncate"
+	QueryIPv4PrefixLength int      `json:"query_ipv4_prefix_length"` // Query limits per address by default (32)
+	QueryIPv6PrefixLength int      `json:"query_ipv6_prefix_length"` // Query limits per address by default (128)
+	ResponsesPerSecond    float64  `json:"responses_per_second"`     // Identical responses, 0 disables
+	ResponseAction        string   `json:"response_action"`
+	IPv4PrefixLength      int      `json:"ipv4_prefix_length"` // RRL prefix
+	IPv6PrefixLength      int      `json:"ipv6_prefix_length"` // RRL prefix
+	Exempt                []string `json:"exempt"`
+	MaxTracked            int      `json:"max_tracked"`
+}
+
+// DNSDnstapConfig represents dnstap logging (protobuf over Frame Streams)
+type DNSDnstapConfig struct {
+	Enabled           bool   `json:"enabled"`
+	Network           string `json:"network"` // "unix", "tcp" or "file"
+	Address           string `json:"address"` // Socket path, host:port or file path
+	Identity          string `json:"identity"`
+	Version           string `json:"version"`
+	ClientMessages    bool   `json:"client_messages"`
+	ForwarderMessages bool   `json:"forwarder_messages"`
+	BufferSize        int    `json:"buffer_size"`        // Frames queued before dropping
+	ReconnectInterval int    `json:"reconnect_interval"` // Seconds between connection attempts
+}
+
+// DNSLocalRecordConfig represents a single local DNS record
+type DNSLocalRecordConfig struct {
+	Name  string `json:"name"`  // Owner name; PTR records may use an address
+	Type  string `json:"type"`  // "A", "AAAA", "CNAME", "PTR", "TXT" or "SRV"
+	Value string `json:"value"` // Address, target name, text or "priority weight port target"
+	TTL   int    `json:"ttl"`   // seconds, 0 for the default
 }
 
 // DHCP Server Configuration and Types
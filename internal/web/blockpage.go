@@ -0,0 +1,322 @@
+package web
+
+import (
+	"encoding/json"
+	"html/template"
+	"log/slog"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"pihole-analyzer/internal/dns"
+)
+
+const (
+	// allowRequestPath receives allow requests posted from the block page
+	allowRequestPath = "/blocked/allow-request"
+
+	// maxAllowRequests bounds the allow requests kept in memory
+	maxAllowRequests = 1000
+
+	// maxAllowRequestNote bounds the note a user can attach to a request
+	maxAllowRequestNote = 500
+)
+
+// BlockExplainer reports why a domain is blocked for a client
+type BlockExplainer interface {
+	BlockReason(name string, client net.Addr) *dns.BlockReason
+}
+
+// AllowRequest is a user's request to allow a blocked domain
+type AllowRequest struct {
+	Domain      string    `json:"domain"`
+	Client      string    `json:"client"`
+	List        string    `json:"list,omitempty"`
+	Rule        string    `json:"rule,omitempty"`
+	Group       string    `json:"group,omitempty"`
+	Schedule    string    `json:"schedule,omitempty"`
+	Note        string    `json:"note,omitempty"`
+	Count       int       `json:"count"`
+	RequestedAt time.Time `json:"requested_at"`
+}
+
+// AllowRequestsResponse represents the response for pending allow requests
+type AllowRequestsResponse struct {
+	Requests  []AllowRequest `json:"requests"`
+	Total     int            `json:"total"`
+	Timestamp string         `json:"timestamp"`
+}
+
+// BlockPageHandler serves a block page to browsers sent to the web server
+// by an "ip" sinkhole and collects their allow requests
+type BlockPageHandler struct {
+	explainer BlockExplainer
+	templates *template.Template
+	logger    *slog.Logger
+
+	mu       sync.Mutex
+	requests []AllowRequest
+}
+
+// NewBlockPageHandler creates a new block page handler
+func NewBlockPageHandler(explainer BlockExplainer, templates *template.Template, logger *slog.Logger) *BlockPageHandler {
+	return &BlockPageHandler{
+		explainer: explainer,
+		templates: templates,
+		logger:    logger,
+	}
+}
+
+// RegisterBlockPage serves the block page for requests whose host is
+// blocked for the client, and registers the allow request endpoints
+func (s *Server) RegisterBlockPage(explainer BlockExplainer) {
+	if explainer == nil {
+		s.logger.Warn("Block explainer is nil, skipping block page registration")
+		return
+	}
+
+	handler := NewBlockPageHandler(explainer, s.templates, s.logger.GetSlogger())
+	s.mux.HandleFunc(allowRequestPath, handler.HandleAllowRequest)
+	s.mux.HandleFunc("/api/blocking/allow-requests", handler.HandleAllowRequests)
+	s.server.Handler = s.loggingMiddleware(handler.Wrap(s.mux))
+
+	s.logger.Info("Block page registered successfully")
+}
+
+// Wrap serves the block page in place of next when the request's host is
+// a domain blocked for the client
+func (h *BlockPageHandler) Wrap(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host := requestHost(r)
+		if host == "" || r.URL.Path == allowRequestPath {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		reason := h.explainer.BlockReason(host, remoteAddr(r))
+		if reason == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		h.serveBlockPage(w, reason)
+	})
+}
+
+// serveBlockPage renders the block page for a blocked domain
+func (h *BlockPageHandler) serveBlockPage(w http.ResponseWriter, reason *dns.BlockReason) {
+	data := struct {
+		Title    string
+		Domain   string
+		List     string
+		Rule     string
+		Group    string
+		Schedule string
+		Action   string
+	}{
+		Title:    "Website blocked",
+		Domain:   reason.Domain,
+		List:     reason.List,
+		Rule:     ruleDescription(reason.Rule),
+		Group:    reason.Group,
+		Schedule: reason.Schedule,
+		Action:   allowRequestPath,
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusForbidden)
+	if err := h.templates.ExecuteTemplate(w, "blocked.html", data); err != nil {
+		h.logger.Error("Failed to execute block page template", slog.String("error", err.Error()))
+	}
+}
+
+// HandleAllowRequest handles POST /blocked/allow-request from the block page
+func (h *BlockPageHandler) HandleAllowRequest(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	domain := strings.TrimSpace(r.FormValue("domain"))
+	if domain == "" {
+		http.Error(w, "Missing domain", http.StatusBadRequest)
+		return
+	}
+
+	// Only domains actually blocked for the client can be requested, and
+	// the reason is looked up again rather than trusted from the form
+	client := remoteAddr(r)
+	reason := h.explainer.BlockReason(domain, client)
+	if reason == nil {
+		http.Error(w, "Domain is not blocked", http.StatusBadRequest)
+		return
+	}
+
+	note := strings.TrimSpace(r.FormValue("note"))
+	if len(note) > maxAllowRequestNote {
+		note = note[:maxAllowRequestNote]
+	}
+
+	h.addRequest(AllowRequest{
+		Domain:      reason.Domain,
+		Client:      clientString(client),
+		List:        reason.List,
+		Rule:        ruleDescription(reason.Rule),
+		Group:       reason.Group,
+		Schedule:    reason.Schedule,
+		Note:        note,
+		RequestedAt: time.Now(),
+	})
+
+	h.logger.Info("Allow request received",
+		slog.String("domain", reason.Domain),
+		slog.String("client", clientString(client)))
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.WriteHeader(http.StatusAccepted)
+	w.Write([]byte("Your request to allow " + reason.Domain + " has been sent to the administrator.\n"))
+}
+
+// HandleAllowRequests handles GET and DELETE /api/blocking/allow-requests
+func (h *BlockPageHandler) HandleAllowRequests(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		requests := h.Requests()
+		h.sendJSON(w, AllowRequestsResponse{
+			Requests:  requests,
+			Total:     len(requests),
+			Timestamp: time.Now().Format(time.RFC3339),
+		})
+
+	case http.MethodDelete:
+		removed := h.removeRequests(r.URL.Query().Get("domain"))
+		h.sendJSON(w, map[string]int{"removed": removed})
+
+	default:
+		h.sendError(w, http.StatusMethodNotAllowed, "Method not allowed")
+	}
+}
+
+// Requests returns the pending allow requests, oldest first
+func (h *BlockPageHandler) Requests() []AllowRequest {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	requests := make([]AllowRequest, len(h.requests))
+	copy(requests, h.requests)
+	return requests
+}
+
+// addRequest records an allow request. Repeated requests for a domain from
+// the same client are counted on the first one, and the oldest requests
+// are dropped once the limit is reached.
+func (h *BlockPageHandler) addRequest(request AllowRequest) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for i := range h.requests {
+		existing := &h.requests[i]
+		if existing.Domain == request.Domain && existing.Client == request.Client {
+			existing.Count++
+			existing.RequestedAt = request.RequestedAt
+			if request.Note != "" {
+				existing.Note = request.Note
+			}
+			return
+		}
+	}
+
+	request.Count = 1
+	if len(h.requests) >= maxAllowRequests {
+		h.requests = h.requests[1:]
+	}
+	h.requests = append(h.requests, request)
+}
+
+// removeRequests drops the requests for a domain, or all of them if domain
+// is empty
+func (h *BlockPageHandler) removeRequests(domain string) int {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	domain = strings.ToLower(strings.TrimSuffix(domain, "."))
+	if domain == "" {
+		removed := len(h.requests)
+		h.requests = nil
+		return removed
+	}
+
+	kept := h.requests[:0]
+	for _, request := range h.requests {
+		if request.Domain != domain {
+			kept = append(kept, request)
+		}
+	}
+	removed := len(h.requests) - len(kept)
+	h.requests = kept
+	return removed
+}
+
+func (h *BlockPageHandler) sendJSON(w http.ResponseWriter, data interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(data); err != nil {
+		h.logger.Error("Failed to encode JSON response", slog.String("error", err.Error()))
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+	}
+}
+
+func (h *BlockPageHandler) sendError(w http.ResponseWriter, statusCode int, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(statusCode)
+	json.NewEncoder(w).Encode(map[string]string{"error": message})
+}
+
+// requestHost returns the request's host name when it may be a blocked
+// domain, or "" for addresses, localhost and single-label names
+func requestHost(r *http.Request) string {
+	host := r.Host
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+
+	if host == "" || host == "localhost" || !strings.Contains(host, ".") {
+		return ""
+	}
+	if net.ParseIP(strings.Trim(host, "[]")) != nil {
+		return ""
+	}
+	return host
+}
+
+// remoteAddr returns the client address of a request
+func remoteAddr(r *http.Request) net.Addr {
+	addr, err := net.ResolveTCPAddr("tcp", r.RemoteAddr)
+	if err != nil {
+		return nil
+	}
+	return addr
+}
+
+// clientString returns a client's IP address for display
+func clientString(client net.Addr) string {
+	if addr, ok := client.(*net.TCPAddr); ok {
+		return addr.IP.String()
+	}
+	return ""
+}
+
+// ruleDescription describes a domain rule for display
+func ruleDescription(rule *dns.DomainRule) string {
+	if rule == nil {
+		return ""
+	}
+	description := rule.Kind + " " + rule.Action + " " + rule.Domain
+	if rule.Comment != "" {
+		description += " (" + rule.Comment + ")"
+	}
+	return description
+}
@@ -0,0 +1,161 @@
+package web
+
+import (
+	"encoding/json"
+	"html/template"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"pihole-analyzer/internal/dns"
+	"pihole-analyzer/internal/logger"
+)
+
+// staticExplainer blocks a fixed set of domains for every client
+type staticExplainer map[string]*dns.BlockReason
+
+func (e staticExplainer) BlockReason(name string, client net.Addr) *dns.BlockReason {
+	return e[strings.TrimSuffix(strings.ToLower(name), ".")]
+}
+
+func newTestBlockPageHandler(t *testing.T) *BlockPageHandler {
+	t.Helper()
+
+	templates, err := template.ParseFS(templatesFS, "templates/*.html")
+	if err != nil {
+		t.Fatalf("Failed to parse templates: %v", err)
+	}
+
+	explainer := staticExplainer{
+		"ads.example.com": {Domain: "ads.example.com", List: "/etc/lists/ads.txt", Status: dns.QueryStatusGravity},
+		"play.games.example": {
+			Domain:   "play.games.example",
+			Rule:     &dns.DomainRule{Domain: "games.example", Kind: dns.RuleKindWildcard, Action: dns.RuleActionDeny},
+			Group:    "kids",
+			Schedule: "school-nights",
+			Status:   dns.QueryStatusDenylist,
+		},
+	}
+
+	testLogger := logger.New(&logger.Config{Level: logger.LevelError})
+	return NewBlockPageHandler(explainer, templates, testLogger.GetSlogger())
+}
+
+func TestBlockPageHandler_Wrap(t *testing.T) {
+	handler := newTestBlockPageHandler(t)
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("dashboard"))
+	})
+	wrapped := handler.Wrap(next)
+
+	tests := []struct {
+		host     string
+		status   int
+		contains []string
+	}{
+		{"ads.example.com", http.StatusForbidden, []string{"ads.example.com", "/etc/lists/ads.txt", allowRequestPath}},
+		{"play.games.example:8080", http.StatusForbidden, []string{"wildcard deny games.example", "kids", "school-nights"}},
+		{"example.com", http.StatusOK, []string{"dashboard"}},
+		{"localhost:8080", http.StatusOK, []string{"dashboard"}},
+		{"192.168.1.2", http.StatusOK, []string{"dashboard"}},
+		{"[fd00::2]:80", http.StatusOK, []string{"dashboard"}},
+	}
+
+	for _, tt := range tests {
+		req := httptest.NewRequest(http.MethodGet, "/some/ad.js", nil)
+		req.Host = tt.host
+		w := httptest.NewRecorder()
+		wrapped.ServeHTTP(w, req)
+
+		if w.Code != tt.status {
+			t.Errorf("%s: expected status %d, got %d", tt.host, tt.status, w.Code)
+		}
+		for _, want := range tt.contains {
+			if !strings.Contains(w.Body.String(), want) {
+				t.Errorf("%s: expected body to contain %q", tt.host, want)
+			}
+		}
+	}
+}
+
+func TestBlockPageHandler_AllowRequests(t *testing.T) {
+	handler := newTestBlockPageHandler(t)
+
+	post := func(domain, note, remote string) int {
+		form := url.Values{"domain": {domain}, "note": {note}}
+		req := httptest.NewRequest(http.MethodPost, allowRequestPath, strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.RemoteAddr = remote
+		w := httptest.NewRecorder()
+		handler.HandleAllowRequest(w, req)
+		return w.Code
+	}
+
+	if code := post("ads.example.com", "breaks checkout", "192.168.1.10:5000"); code != http.StatusAccepted {
+		t.Fatalf("Expected status 202, got %d", code)
+	}
+	if code := post("ADS.example.com.", "", "192.168.1.10:5001"); code != http.StatusAccepted {
+		t.Fatalf("Expected status 202, got %d", code)
+	}
+	if code := post("play.games.example", "", "192.168.1.50:5000"); code != http.StatusAccepted {
+		t.Fatalf("Expected status 202, got %d", code)
+	}
+	if code := post("example.com", "", "192.168.1.10:5000"); code != http.StatusBadRequest {
+		t.Errorf("Expected an unblocked domain to be rejected, got %d", code)
+	}
+	if code := post("", "", "192.168.1.10:5000"); code != http.StatusBadRequest {
+		t.Errorf("Expected a missing domain to be rejected, got %d", code)
+	}
+
+	w := httptest.NewRecorder()
+	handler.HandleAllowRequests(w, httptest.NewRequest(http.MethodGet, "/api/blocking/allow-requests", nil))
+	var response AllowRequestsResponse
+	if err := json.NewDecoder(w.Body).Decode(&response); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if response.Total != 2 {
+		t.Fatalf("Expected 2 allow requests, got %+v", response.Requests)
+	}
+
+	first := response.Requests[0]
+	if first.Domain != "ads.example.com" || first.Client != "192.168.1.10" || first.Count != 2 {
+		t.Errorf("Unexpected first request: %+v", first)
+	}
+	if first.Note != "breaks checkout" || first.List != "/etc/lists/ads.txt" {
+		t.Errorf("Expected the note and list to be kept, got %+v", first)
+	}
+	if second := response.Requests[1]; second.Rule != "wildcard deny games.example" || second.Group != "kids" {
+		t.Errorf("Unexpected second request: %+v", second)
+	}
+
+	w = httptest.NewRecorder()
+	handler.HandleAllowRequests(w, httptest.NewRequest(http.MethodDelete, "/api/blocking/allow-requests?domain=ads.example.com", nil))
+	if w.Code != http.StatusOK || len(handler.Requests()) != 1 {
+		t.Errorf("Expected the request to be removed, got status %d and %d requests", w.Code, len(handler.Requests()))
+	}
+
+	w = httptest.NewRecorder()
+	handler.HandleAllowRequest(w, httptest.NewRequest(http.MethodGet, allowRequestPath, nil))
+	if w.Code != http.StatusMethodNotAllowed {
+		t.Errorf("Expected status 405, got %d", w.Code)
+	}
+}
+
+func TestBlockPageHandler_RequestLimit(t *testing.T) {
+	handler := newTestBlockPageHandler(t)
+
+	for i := 0; i < maxAllowRequests+10; i++ {
+		handler.addRequest(AllowRequest{Domain: "ads.example.com", Client: net.IPv4(10, 0, byte(i>>8), byte(i)).String()})
+	}
+
+	requests := handler.Requests()
+	if len(requests) != maxAllowRequests {
+		t.Fatalf("Expected %d requests, got %d", maxAllowRequests, len(requests))
+	}
+	if requests[0].Client != "10.0.0.10" {
+		t.Errorf("Expected the oldest requests to be dropped, first is %s", requests[0].Client)
+	}
+}
@@ -94,7 +94,7 @@ func (d *DataSourceAdapter) GetAnalysisResult(ctx context.Context) (*types.Analy
 		TotalQueries:   len(records),
 		UniqueClients:  len(clientStats),
 		AnalysisMode:   "web",
-		DataSourceType: "api",
+		DataSourceType: string(d.dataSource.GetDataSourceType()),
 		Timestamp:      time.Now().Format(time.RFC3339),
 	}
 
@@ -168,11 +168,22 @@ func (d *DataSourceAdapter) updateConnectionStatus() {
 	}
 
 	status.Metadata["test_duration_ms"] = fmt.Sprintf("%.2f", status.ResponseTime)
-	status.Metadata["data_source_type"] = "pihole_api"
+	status.Metadata["data_source_type"] = dataSourceTypeName(d.dataSource.GetDataSourceType())
 
 	d.lastStatus = status
 }
 
+// dataSourceTypeName names a data source type in connection metadata
+func dataSourceTypeName(dataSourceType interfaces.DataSourceType) string {
+	switch dataSourceType {
+	case interfaces.DataSourceTypeAPI:
+		return "pihole_api"
+	case interfaces.DataSourceTypeDNS:
+		return "embedded_dns"
+	}
+	return string(dataSourceType)
+}
+
 // analyzeRecordsToClientStats performs basic analysis on the records (fallback method)
 func (d *DataSourceAdapter) analyzeRecordsToClientStats(records []types.PiholeRecord) map[string]*types.ClientStats {
 	clientStats := make(map[string]*types.ClientStats)
@@ -223,6 +234,14 @@ func (d *DataSourceAdapter) analyzeRecordsToClientStats(records []types.PiholeRe
 
 		// Track status codes
 		stats.StatusCodes[record.Status]++
+
+		// Track trackers cloaked behind CNAMEs
+		if record.CNAME != "" {
+			if stats.CloakedDomains == nil {
+				stats.CloakedDomains = make(map[string]int)
+			}
+			stats.CloakedDomains[record.CNAME]++
+		}
 	}
 
 	// Calculate unique domain counts
@@ -0,0 +1,176 @@
+package web
+
+import (
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"pihole-analyzer/internal/dns"
+)
+
+// DNSRecordsHandler manages local DNS records at runtime
+type DNSRecordsHandler struct {
+	records dns.DNSLocalRecords
+	logger  *slog.Logger
+}
+
+// NewDNSRecordsHandler creates a new local DNS records handler
+func NewDNSRecordsHandler(records dns.DNSLocalRecords, logger *slog.Logger) *DNSRecordsHandler {
+	return &DNSRecordsHandler{
+		records: records,
+		logger:  logger,
+	}
+}
+
+// DNSRecord is the JSON form of a local DNS record
+type DNSRecord struct {
+	Name   string `json:"name"`
+	Type   string `json:"type"`
+	Value  string `json:"value"`
+	TTL    int    `json:"ttl,omitempty"` // seconds, 0 for the default
+	Source string `json:"source,omitempty"`
+}
+
+// DNSRecordsResponse represents the response for local DNS records
+type DNSRecordsResponse struct {
+	Records   []DNSRecord           `json:"records"`
+	Stats     *dns.LocalRecordStats `json:"stats"`
+	Total     int                   `json:"total"`
+	Timestamp string                `json:"timestamp"`
+}
+
+// RegisterDNSRecordRoutes registers the local DNS records API
+func (s *Server) RegisterDNSRecordRoutes(records dns.DNSLocalRecords) {
+	if records == nil {
+		s.logger.Warn("Local DNS records are nil, skipping DNS record route registration")
+		return
+	}
+
+	handler := NewDNSRecordsHandler(records, s.logger.GetSlogger())
+	s.mux.HandleFunc("/api/dns/records", handler.HandleRecords)
+
+	s.logger.Info("DNS record routes registered successfully")
+}
+
+// HandleRecords handles GET, POST and DELETE /api/dns/records
+func (h *DNSRecordsHandler) HandleRecords(w http.ResponseWriter, r *http.Request) {
+	h.logger.Debug("Handling local DNS records request", slog.String("method", r.Method))
+
+	switch r.Method {
+	case http.MethodGet:
+		h.listRecords(w)
+
+	case http.MethodPost:
+		var record DNSRecord
+		if err := json.NewDecoder(r.Body).Decode(&record); err != nil {
+			h.sendError(w, http.StatusBadRequest, "Invalid JSON")
+			return
+		}
+
+		local, ok := h.toLocalRecord(w, record)
+		if !ok {
+			return
+		}
+		if err := h.records.Add(local); err != nil {
+			status := http.StatusInternalServerError
+			if errors.Is(err, dns.ErrInvalidLocalRecord) {
+				status = http.StatusBadRequest
+			}
+			h.sendError(w, status, err.Error())
+			return
+		}
+
+		h.logger.Info("Local DNS record added",
+			slog.String("name", record.Name),
+			slog.String("type", record.Type))
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(record)
+
+	case http.MethodDelete:
+		query := r.URL.Query()
+		local, ok := h.toLocalRecord(w, DNSRecord{
+			Name:  query.Get("name"),
+			Type:  query.Get("type"),
+			Value: query.Get("value"),
+		})
+		if !ok {
+			return
+		}
+
+		removed := h.records.Remove(local)
+		if removed == 0 {
+			h.sendError(w, http.StatusNotFound, "Record not found")
+			return
+		}
+
+		h.logger.Info("Local DNS records removed",
+			slog.String("name", local.Name),
+			slog.Int("removed", removed))
+		h.sendJSON(w, map[string]int{"removed": removed})
+
+	default:
+		h.sendError(w, http.StatusMethodNotAllowed, "Method not allowed")
+	}
+}
+
+// listRecords writes all local records
+func (h *DNSRecordsHandler) listRecords(w http.ResponseWriter) {
+	local := h.records.Records()
+
+	records := make([]DNSRecord, 0, len(local))
+	for _, record := range local {
+		records = append(records, DNSRecord{
+			Name:   record.Name,
+			Type:   dns.RecordTypeName(record.Type),
+			Value:  record.Value,
+			TTL:    int(record.TTL / time.Second),
+			Source: record.Source,
+		})
+	}
+
+	h.sendJSON(w, DNSRecordsResponse{
+		Records:   records,
+		Stats:     h.records.GetStats(),
+		Total:     len(records),
+		Timestamp: time.Now().Format(time.RFC3339),
+	})
+}
+
+// toLocalRecord converts a JSON record, writing an error if it is invalid
+func (h *DNSRecordsHandler) toLocalRecord(w http.ResponseWriter, record DNSRecord) (dns.LocalRecord, bool) {
+	if record.Name == "" {
+		h.sendError(w, http.StatusBadRequest, "Missing record name")
+		return dns.LocalRecord{}, false
+	}
+
+	rtype, ok := dns.ParseRecordType(record.Type)
+	if !ok {
+		h.sendError(w, http.StatusBadRequest, "Unsupported record type")
+		return dns.LocalRecord{}, false
+	}
+
+	return dns.LocalRecord{
+		Name:   record.Name,
+		Type:   rtype,
+		Value:  record.Value,
+		TTL:    time.Duration(record.TTL) * time.Second,
+		Source: dns.LocalSourceAPI,
+	}, true
+}
+
+func (h *DNSRecordsHandler) sendJSON(w http.ResponseWriter, data interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(data); err != nil {
+		h.logger.Error("Failed to encode JSON response", slog.String("error", err.Error()))
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+	}
+}
+
+func (h *DNSRecordsHandler) sendError(w http.ResponseWriter, statusCode int, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(statusCode)
+	json.NewEncoder(w).Encode(map[string]string{"error": message})
+}
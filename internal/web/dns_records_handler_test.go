@@ -0,0 +1,83 @@
+package web
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"pihole-analyzer/internal/dns"
+	"pihole-analyzer/internal/logger"
+)
+
+func newTestDNSRecordsHandler() *DNSRecordsHandler {
+	testLogger := logger.New(&logger.Config{Level: logger.LevelError})
+	records := dns.NewLocalRecords(dns.LocalRecordsConfig{
+		Enabled: true,
+		Zones:   []string{"lan"},
+		Records: []dns.LocalRecordConfig{{Name: "nas.lan", Type: "A", Value: "192.168.1.10"}},
+		TTL:     time.Minute,
+	})
+	records.Load()
+	return NewDNSRecordsHandler(records, testLogger.GetSlogger())
+}
+
+func TestDNSRecordsHandler(t *testing.T) {
+	handler := newTestDNSRecordsHandler()
+
+	body := bytes.NewBufferString(`{"name":"tv.lan","type":"A","value":"192.168.1.30","ttl":120}`)
+	w := httptest.NewRecorder()
+	handler.HandleRecords(w, httptest.NewRequest(http.MethodPost, "/api/dns/records", body))
+	if w.Code != http.StatusCreated {
+		t.Fatalf("Expected status 201, got %d: %s", w.Code, w.Body.String())
+	}
+
+	w = httptest.NewRecorder()
+	handler.HandleRecords(w, httptest.NewRequest(http.MethodGet, "/api/dns/records", nil))
+	var response DNSRecordsResponse
+	if err := json.NewDecoder(w.Body).Decode(&response); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if response.Total != 2 {
+		t.Fatalf("Expected 2 records, got %d", response.Total)
+	}
+	for _, record := range response.Records {
+		if record.Name == "tv.lan" && (record.TTL != 120 || record.Source != dns.LocalSourceAPI) {
+			t.Errorf("Unexpected added record %+v", record)
+		}
+	}
+
+	invalid := []string{
+		`{"name":"bad.lan","type":"A","value":"not-an-ip"}`,
+		`{"name":"bad.lan","type":"WKS","value":"x"}`,
+		`{"type":"A","value":"192.168.1.1"}`,
+		`not json`,
+	}
+	for _, payload := range invalid {
+		w = httptest.NewRecorder()
+		handler.HandleRecords(w, httptest.NewRequest(http.MethodPost, "/api/dns/records", bytes.NewBufferString(payload)))
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("Expected status 400 for %s, got %d", payload, w.Code)
+		}
+	}
+
+	w = httptest.NewRecorder()
+	handler.HandleRecords(w, httptest.NewRequest(http.MethodDelete, "/api/dns/records?name=tv.lan&type=A", nil))
+	if w.Code != http.StatusOK {
+		t.Errorf("Expected status 200, got %d", w.Code)
+	}
+
+	w = httptest.NewRecorder()
+	handler.HandleRecords(w, httptest.NewRequest(http.MethodDelete, "/api/dns/records?name=tv.lan&type=A", nil))
+	if w.Code != http.StatusNotFound {
+		t.Errorf("Expected status 404, got %d", w.Code)
+	}
+
+	w = httptest.NewRecorder()
+	handler.HandleRecords(w, httptest.NewRequest(http.MethodPut, "/api/dns/records", nil))
+	if w.Code != http.StatusMethodNotAllowed {
+		t.Errorf("Expected status 405, got %d", w.Code)
+	}
+}
@@ -0,0 +1,183 @@
+package web
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"pihole-analyzer/internal/dns"
+	"pihole-analyzer/internal/logger"
+)
+
+const (
+	// dohContentType is the RFC 8484 media type for DNS wire-format messages
+	dohContentType = "application/dns-message"
+
+	// dohMaxMessageSize is the largest DNS message accepted over DoH
+	dohMaxMessageSize = 65535
+)
+
+// DoHHandler serves DNS-over-HTTPS (RFC 8484) requests
+type DoHHandler struct {
+	dnsServer dns.DNSServer
+	parser    dns.DNSParser
+	tap       *dns.Dnstap // The DNS server's dnstap output, if any
+	logger    *logger.Logger
+}
+
+// NewDoHHandler creates a new DNS-over-HTTPS handler
+func NewDoHHandler(dnsServer dns.DNSServer, logger *logger.Logger) *DoHHandler {
+	handler := &DoHHandler{
+		dnsServer: dnsServer,
+		parser:    dns.NewParser(),
+		logger:    logger,
+	}
+	if server, ok := dnsServer.(*dns.Server); ok {
+		handler.tap = server.Dnstap()
+	}
+	return handler
+}
+
+// RegisterDoHRoutes registers the DNS-over-HTTPS endpoint with the HTTP server
+func (s *Server) RegisterDoHRoutes(dnsServer dns.DNSServer) {
+	if dnsServer == nil {
+		s.logger.Warn("DNS server is nil, skipping DoH route registration")
+		return
+	}
+
+	handler := NewDoHHandler(dnsServer, s.logger)
+	s.mux.HandleFunc("/dns-query", handler.HandleDNSQuery)
+
+	s.logger.Info("DoH route registered successfully")
+}
+
+// HandleDNSQuery handles GET /dns-query?dns=<base64url> and POST /dns-query
+func (h *DoHHandler) HandleDNSQuery(w http.ResponseWriter, r *http.Request) {
+	received := time.Now()
+	var message []byte
+	var err error
+
+	switch r.Method {
+	case http.MethodGet:
+		message, err = decodeDoHParam(r.URL.Query().Get("dns"))
+		if err != nil {
+			http.Error(w, "Invalid dns parameter", http.StatusBadRequest)
+			return
+		}
+	case http.MethodPost:
+		if !strings.HasPrefix(r.Header.Get("Content-Type"), dohContentType) {
+			http.Error(w, "Unsupported media type", http.StatusUnsupportedMediaType)
+			return
+		}
+		message, err = io.ReadAll(io.LimitReader(r.Body, dohMaxMessageSize+1))
+		if err != nil {
+			http.Error(w, "Failed to read request body", http.StatusBadRequest)
+			return
+		}
+		if len(message) > dohMaxMessageSize {
+			http.Error(w, "DNS message too large", http.StatusRequestEntityTooLarge)
+			return
+		}
+	default:
+		w.Header().Set("Allow", "GET, POST")
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	query, err := h.parser.ParseQuery(message)
+	if err != nil {
+		h.logger.DebugFields("Invalid DoH query", map[string]any{
+			"remote_addr": r.RemoteAddr,
+			"error":       err.Error(),
+		})
+		http.Error(w, "Invalid DNS message", http.StatusBadRequest)
+		return
+	}
+
+	query.Client = dohClientAddr(r)
+	query.Protocol = "doh"
+
+	localAddr, _ := r.Context().Value(http.LocalAddrContextKey).(net.Addr)
+	if h.tap != nil {
+		h.tap.ClientQuery(query, localAddr, message, received)
+	}
+
+	response, err := h.dnsServer.HandleQuery(r.Context(), query)
+	if errors.Is(err, dns.ErrRateLimited) {
+		http.Error(w, "Too many DNS queries", http.StatusTooManyRequests)
+		return
+	}
+	if err != nil {
+		h.logger.ErrorFields("Failed to process DoH query", map[string]any{
+			"remote_addr": r.RemoteAddr,
+			"error":       err.Error(),
+		})
+		http.Error(w, "Failed to process DNS query", http.StatusInternalServerError)
+		return
+	}
+
+	responseData, err := h.parser.SerializeResponse(response)
+	if err != nil {
+		h.logger.ErrorFields("Failed to serialize DoH response", map[string]any{
+			"remote_addr": r.RemoteAddr,
+			"error":       err.Error(),
+		})
+		http.Error(w, "Failed to serialize DNS response", http.StatusInternalServerError)
+		return
+	}
+
+	if h.tap != nil {
+		h.tap.ClientResponse(query, localAddr, responseData, received, time.Now())
+	}
+
+	w.Header().Set("Content-Type", dohContentType)
+	w.Header().Set("Cache-Control", fmt.Sprintf("max-age=%d", dohMaxAge(response)))
+	w.Header().Set("Content-Length", strconv.Itoa(len(responseData)))
+	w.WriteHeader(http.StatusOK)
+	w.Write(responseData)
+}
+
+// decodeDoHParam decodes the base64url "dns" parameter. RFC 8484 requires
+// unpadded encoding; padding is tolerated.
+func decodeDoHParam(param string) ([]byte, error) {
+	if param == "" {
+		return nil, fmt.Errorf("missing dns parameter")
+	}
+	return base64.RawURLEncoding.DecodeString(strings.TrimRight(param, "="))
+}
+
+// dohMaxAge returns the HTTP freshness lifetime for a response: the smallest
+// TTL of the answer and authority records (RFC 8484 section 5.1)
+func dohMaxAge(response *dns.DNSResponse) uint32 {
+	var minTTL uint32
+	found := false
+
+	for _, records := range [][]dns.DNSRecord{response.Answers, response.Authorities} {
+		for _, record := range records {
+			if !found || record.TTL < minTTL {
+				minTTL = record.TTL
+				found = true
+			}
+		}
+	}
+
+	return minTTL
+}
+
+// dohClientAddr converts the HTTP remote address into a client address for
+// the DNS server's client matching and statistics
+func dohClientAddr(r *http.Request) net.Addr {
+	host, port, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return &net.TCPAddr{IP: net.ParseIP(r.RemoteAddr)}
+	}
+
+	portNum, _ := strconv.Atoi(port)
+	return &net.TCPAddr{IP: net.ParseIP(host), Port: portNum}
+}
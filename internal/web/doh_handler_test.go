@@ -0,0 +1,146 @@
+package web
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"pihole-analyzer/internal/dns"
+	"pihole-analyzer/internal/logger"
+)
+
+// mockDNSServer answers every query with a fixed A record and remembers
+// the last query it handled
+type mockDNSServer struct {
+	lastQuery *dns.DNSQuery
+}
+
+func (m *mockDNSServer) Start(ctx context.Context) error { return nil }
+func (m *mockDNSServer) Stop(ctx context.Context) error  { return nil }
+func (m *mockDNSServer) GetStats() *dns.ServerStats      { return &dns.ServerStats{} }
+
+func (m *mockDNSServer) HandleQuery(ctx context.Context, query *dns.DNSQuery) (*dns.DNSResponse, error) {
+	m.lastQuery = query
+	return &dns.DNSResponse{
+		ID:       query.ID,
+		Question: query.Question,
+		Answers: []dns.DNSRecord{
+			{Name: query.Question.Name, Type: dns.TypeA, Class: dns.ClassIN, TTL: 300, Data: []byte{192, 0, 2, 1}},
+			{Name: query.Question.Name, Type: dns.TypeA, Class: dns.ClassIN, TTL: 60, Data: []byte{192, 0, 2, 2}},
+		},
+		ResponseCode: dns.RCodeNoError,
+	}, nil
+}
+
+func newTestDoHHandler() (*DoHHandler, *mockDNSServer) {
+	testLogger := logger.New(&logger.Config{Level: logger.LevelError})
+	server := &mockDNSServer{}
+	return NewDoHHandler(server, testLogger), server
+}
+
+func encodeTestDNSQuery(t *testing.T) []byte {
+	t.Helper()
+
+	data, err := dns.NewParser().SerializeQuery(&dns.DNSQuery{
+		ID:       0,
+		Question: dns.DNSQuestion{Name: "example.com", Type: dns.TypeA, Class: dns.ClassIN},
+	})
+	if err != nil {
+		t.Fatalf("Failed to serialize query: %v", err)
+	}
+	return data
+}
+
+func checkDoHResponse(t *testing.T, w *httptest.ResponseRecorder) {
+	t.Helper()
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+	if ct := w.Header().Get("Content-Type"); ct != dohContentType {
+		t.Errorf("Expected Content-Type %s, got %s", dohContentType, ct)
+	}
+	if cc := w.Header().Get("Cache-Control"); cc != "max-age=60" {
+		t.Errorf("Expected Cache-Control max-age=60, got %s", cc)
+	}
+
+	response, err := dns.NewParser().ParseResponse(w.Body.Bytes())
+	if err != nil {
+		t.Fatalf("Failed to parse DoH response: %v", err)
+	}
+	if len(response.Answers) != 2 {
+		t.Errorf("Expected 2 answers, got %d", len(response.Answers))
+	}
+}
+
+func TestDoHHandler_Get(t *testing.T) {
+	handler, server := newTestDoHHandler()
+
+	param := base64.RawURLEncoding.EncodeToString(encodeTestDNSQuery(t))
+	req := httptest.NewRequest(http.MethodGet, "/dns-query?dns="+param, nil)
+	req.RemoteAddr = "192.168.1.20:40000"
+	w := httptest.NewRecorder()
+
+	handler.HandleDNSQuery(w, req)
+	checkDoHResponse(t, w)
+
+	if server.lastQuery.Protocol != "doh" {
+		t.Errorf("Expected protocol doh, got %s", server.lastQuery.Protocol)
+	}
+	if server.lastQuery.Client.String() != "192.168.1.20:40000" {
+		t.Errorf("Expected client 192.168.1.20:40000, got %s", server.lastQuery.Client)
+	}
+}
+
+func TestDoHHandler_Post(t *testing.T) {
+	handler, server := newTestDoHHandler()
+
+	req := httptest.NewRequest(http.MethodPost, "/dns-query", bytes.NewReader(encodeTestDNSQuery(t)))
+	req.Header.Set("Content-Type", dohContentType)
+	w := httptest.NewRecorder()
+
+	handler.HandleDNSQuery(w, req)
+	checkDoHResponse(t, w)
+
+	if server.lastQuery.Question.Name != "example.com" {
+		t.Errorf("Expected question example.com, got %s", server.lastQuery.Question.Name)
+	}
+}
+
+func TestDoHHandler_BadRequests(t *testing.T) {
+	handler, _ := newTestDoHHandler()
+
+	tests := []struct {
+		name        string
+		method      string
+		target      string
+		body        []byte
+		contentType string
+		status      int
+	}{
+		{"missing parameter", http.MethodGet, "/dns-query", nil, "", http.StatusBadRequest},
+		{"invalid base64", http.MethodGet, "/dns-query?dns=!!!", nil, "", http.StatusBadRequest},
+		{"short message", http.MethodGet, "/dns-query?dns=AAAA", nil, "", http.StatusBadRequest},
+		{"wrong content type", http.MethodPost, "/dns-query", []byte{0}, "application/json", http.StatusUnsupportedMediaType},
+		{"wrong method", http.MethodPut, "/dns-query", nil, "", http.StatusMethodNotAllowed},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, tt.target, bytes.NewReader(tt.body))
+			if tt.contentType != "" {
+				req.Header.Set("Content-Type", tt.contentType)
+			}
+			w := httptest.NewRecorder()
+
+			handler.HandleDNSQuery(w, req)
+
+			if w.Code != tt.status {
+				t.Errorf("Expected status %d, got %d", tt.status, w.Code)
+			}
+		})
+	}
+}
@@ -0,0 +1,251 @@
+package web
+
+import (
+	"encoding/json"
+	"errors"
+	"html/template"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"pihole-analyzer/internal/dns"
+)
+
+// SchedulesHandler shows blocking schedules and manages their temporary
+// overrides
+type SchedulesHandler struct {
+	rules     dns.DNSRuleEngine
+	templates *template.Template
+	logger    *slog.Logger
+}
+
+// NewSchedulesHandler creates a new schedules handler
+func NewSchedulesHandler(rules dns.DNSRuleEngine, templates *template.Template, logger *slog.Logger) *SchedulesHandler {
+	return &SchedulesHandler{
+		rules:     rules,
+		templates: templates,
+		logger:    logger,
+	}
+}
+
+// Schedule is the JSON form of a schedule and its current state
+type Schedule struct {
+	Name      string                    `json:"name"`
+	Timezone  string                    `json:"timezone"`
+	Ranges    []dns.ScheduleRangeConfig `json:"ranges"`
+	Comment   string                    `json:"comment,omitempty"`
+	Active    bool                      `json:"active"`    // State in effect
+	Scheduled bool                      `json:"scheduled"` // State without the override
+	Next      string                    `json:"next,omitempty"`
+	Override  *ScheduleOverride         `json:"override,omitempty"`
+	Groups    []string                  `json:"groups"`
+	Rules     int                       `json:"rules"`
+	Lists     int                       `json:"lists"`
+}
+
+// ScheduleOverride is the JSON form of a temporary override
+type ScheduleOverride struct {
+	Active bool   `json:"active"`
+	Until  string `json:"until"`
+}
+
+// SchedulesResponse represents the response for blocking schedules
+type SchedulesResponse struct {
+	Schedules []Schedule `json:"schedules"`
+	Total     int        `json:"total"`
+	Timestamp string     `json:"timestamp"`
+}
+
+// ScheduleOverrideRequest forces a schedule active or inactive for a
+// duration, e.g. {"schedule": "school-nights", "active": false,
+// "duration": "30m"} to unblock for 30 minutes
+type ScheduleOverrideRequest struct {
+	Schedule string `json:"schedule"`
+	Active   bool   `json:"active"`
+	Duration string `json:"duration"`
+}
+
+// RegisterScheduleRoutes registers the schedules page and API
+func (s *Server) RegisterScheduleRoutes(rules dns.DNSRuleEngine) {
+	if rules == nil {
+		s.logger.Warn("DNS rule engine is nil, skipping schedule route registration")
+		return
+	}
+
+	handler := NewSchedulesHandler(rules, s.templates, s.logger.GetSlogger())
+	s.mux.HandleFunc("/api/dns/schedules", handler.HandleSchedules)
+	s.mux.HandleFunc("/api/dns/schedules/override", handler.HandleOverride)
+	s.mux.HandleFunc("/schedules", handler.HandleSchedulesPage)
+
+	s.logger.Info("Schedule routes registered successfully")
+}
+
+// HandleSchedules handles GET /api/dns/schedules
+func (h *SchedulesHandler) HandleSchedules(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		h.sendError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	schedules := h.schedules()
+	h.sendJSON(w, SchedulesResponse{
+		Schedules: schedules,
+		Total:     len(schedules),
+		Timestamp: time.Now().Format(time.RFC3339),
+	})
+}
+
+// HandleOverride handles POST and DELETE /api/dns/schedules/override
+func (h *SchedulesHandler) HandleOverride(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodPost:
+		var request ScheduleOverrideRequest
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			h.sendError(w, http.StatusBadRequest, "Invalid JSON")
+			return
+		}
+
+		duration, err := time.ParseDuration(request.Duration)
+		if err != nil {
+			h.sendError(w, http.StatusBadRequest, "Invalid duration")
+			return
+		}
+
+		state, err := h.rules.OverrideSchedule(request.Schedule, request.Active, duration)
+		if err != nil {
+			status := http.StatusInternalServerError
+			switch {
+			case errors.Is(err, dns.ErrUnknownSchedule):
+				status = http.StatusNotFound
+			case errors.Is(err, dns.ErrInvalidSchedule):
+				status = http.StatusBadRequest
+			}
+			h.sendError(w, status, err.Error())
+			return
+		}
+
+		h.logger.Info("Schedule overridden",
+			slog.String("schedule", request.Schedule),
+			slog.Bool("active", request.Active),
+			slog.Duration("duration", duration))
+		h.sendJSON(w, toSchedule(*state))
+
+	case http.MethodDelete:
+		name := r.URL.Query().Get("schedule")
+		if !h.rules.ClearScheduleOverride(name) {
+			h.sendError(w, http.StatusNotFound, "No override for schedule")
+			return
+		}
+
+		h.logger.Info("Schedule override cleared", slog.String("schedule", name))
+		h.sendJSON(w, map[string]string{"cleared": name})
+
+	default:
+		h.sendError(w, http.StatusMethodNotAllowed, "Method not allowed")
+	}
+}
+
+// HandleSchedulesPage handles the schedules web interface page
+func (h *SchedulesHandler) HandleSchedulesPage(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		h.sendError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	type scheduleRow struct {
+		Schedule
+		Times         []string
+		NextChange    string
+		OverrideUntil string
+	}
+
+	var rows []scheduleRow
+	for _, state := range h.rules.Schedules() {
+		location := state.Schedule.Location()
+		row := scheduleRow{Schedule: toSchedule(state)}
+		for _, r := range state.Schedule.Ranges {
+			row.Times = append(row.Times, rangeDescription(r))
+		}
+		if !state.Next.IsZero() {
+			row.NextChange = state.Next.In(location).Format("Mon 15:04")
+		}
+		if state.Override != nil {
+			row.OverrideUntil = state.Override.Until.In(location).Format("Mon 15:04")
+		}
+		rows = append(rows, row)
+	}
+
+	data := struct {
+		Title     string
+		Schedules []scheduleRow
+	}{
+		Title:     "Blocking Schedules",
+		Schedules: rows,
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := h.templates.ExecuteTemplate(w, "schedules.html", data); err != nil {
+		h.logger.Error("Failed to execute schedules template", slog.String("error", err.Error()))
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+	}
+}
+
+// schedules returns the JSON form of every schedule
+func (h *SchedulesHandler) schedules() []Schedule {
+	states := h.rules.Schedules()
+
+	schedules := make([]Schedule, 0, len(states))
+	for _, state := range states {
+		schedules = append(schedules, toSchedule(state))
+	}
+	return schedules
+}
+
+// toSchedule converts a schedule state to its JSON form
+func toSchedule(state dns.ScheduleState) Schedule {
+	schedule := Schedule{
+		Name:      state.Schedule.Name,
+		Timezone:  state.Schedule.Location().String(),
+		Ranges:    state.Schedule.Ranges,
+		Comment:   state.Schedule.Comment,
+		Active:    state.Active,
+		Scheduled: state.Scheduled,
+		Groups:    state.Groups,
+		Rules:     state.Rules,
+		Lists:     state.Lists,
+	}
+	if !state.Next.IsZero() {
+		schedule.Next = state.Next.Format(time.RFC3339)
+	}
+	if state.Override != nil {
+		schedule.Override = &ScheduleOverride{
+			Active: state.Override.Active,
+			Until:  state.Override.Until.Format(time.RFC3339),
+		}
+	}
+	return schedule
+}
+
+// rangeDescription describes a schedule range, e.g. "sun, mon 21:00-07:00"
+func rangeDescription(r dns.ScheduleRangeConfig) string {
+	days := "every day"
+	if len(r.Days) > 0 {
+		days = strings.Join(r.Days, ", ")
+	}
+	return days + " " + r.Start + "-" + r.End
+}
+
+func (h *SchedulesHandler) sendJSON(w http.ResponseWriter, data interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(data); err != nil {
+		h.logger.Error("Failed to encode JSON response", slog.String("error", err.Error()))
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+	}
+}
+
+func (h *SchedulesHandler) sendError(w http.ResponseWriter, statusCode int, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(statusCode)
+	json.NewEncoder(w).Encode(map[string]string{"error": message})
+}
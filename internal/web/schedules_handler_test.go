@@ -0,0 +1,126 @@
+package web
+
+import (
+	"bytes"
+	"encoding/json"
+	"html/template"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"pihole-analyzer/internal/dns"
+	"pihole-analyzer/internal/logger"
+)
+
+func newTestSchedulesHandler(t *testing.T) *SchedulesHandler {
+	t.Helper()
+
+	templates, err := template.ParseFS(templatesFS, "templates/*.html")
+	if err != nil {
+		t.Fatalf("Failed to parse templates: %v", err)
+	}
+
+	rules := dns.NewRuleEngine(nil)
+	err = rules.Load(dns.RulesConfig{
+		Enabled: true,
+		Groups: []dns.GroupConfig{
+			{Name: dns.DefaultGroupName, Enabled: true},
+			{Name: "kids", Enabled: true},
+		},
+		Domains: []dns.DomainRuleConfig{
+			{Domain: "chat.example", Action: dns.RuleActionDeny, Groups: []string{"kids"}, Enabled: true, Schedule: "school-nights"},
+		},
+		Schedules: []dns.ScheduleConfig{{
+			Name:     "school-nights",
+			Timezone: "UTC",
+			Ranges:   []dns.ScheduleRangeConfig{{Days: []string{"sun", "mon", "tue", "wed", "thu"}, Start: "21:00", End: "07:00"}},
+			Comment:  "Bedtime",
+		}},
+	})
+	if err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+
+	testLogger := logger.New(&logger.Config{Level: logger.LevelError})
+	return NewSchedulesHandler(rules, templates, testLogger.GetSlogger())
+}
+
+func TestSchedulesHandler(t *testing.T) {
+	handler := newTestSchedulesHandler(t)
+
+	w := httptest.NewRecorder()
+	handler.HandleSchedules(w, httptest.NewRequest(http.MethodGet, "/api/dns/schedules", nil))
+	var response SchedulesResponse
+	if err := json.NewDecoder(w.Body).Decode(&response); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if response.Total != 1 {
+		t.Fatalf("Expected 1 schedule, got %d", response.Total)
+	}
+	schedule := response.Schedules[0]
+	if schedule.Name != "school-nights" || schedule.Timezone != "UTC" || schedule.Rules != 1 || schedule.Next == "" {
+		t.Errorf("Unexpected schedule: %+v", schedule)
+	}
+	if len(schedule.Groups) != 1 || schedule.Groups[0] != "kids" || schedule.Override != nil {
+		t.Errorf("Unexpected schedule: %+v", schedule)
+	}
+
+	// Unblock for 30 minutes, whatever the time of the test
+	body := bytes.NewBufferString(`{"schedule":"school-nights","active":false,"duration":"30m"}`)
+	w = httptest.NewRecorder()
+	handler.HandleOverride(w, httptest.NewRequest(http.MethodPost, "/api/dns/schedules/override", body))
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+	var overridden Schedule
+	if err := json.NewDecoder(w.Body).Decode(&overridden); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if overridden.Active || overridden.Override == nil || overridden.Override.Active || overridden.Override.Until == "" {
+		t.Errorf("Unexpected overridden schedule: %+v", overridden)
+	}
+
+	w = httptest.NewRecorder()
+	handler.HandleSchedulesPage(w, httptest.NewRequest(http.MethodGet, "/schedules", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", w.Code)
+	}
+	for _, want := range []string{"school-nights", "Bedtime", "sun, mon, tue, wed, thu 21:00-07:00", "kids", "Overridden until", "Resume schedule"} {
+		if !strings.Contains(w.Body.String(), want) {
+			t.Errorf("Expected the page to contain %q", want)
+		}
+	}
+
+	invalid := map[string]int{
+		`{"schedule":"bedtime","active":false,"duration":"30m"}`:        http.StatusNotFound,
+		`{"schedule":"school-nights","active":false,"duration":"0s"}`:   http.StatusBadRequest,
+		`{"schedule":"school-nights","active":false,"duration":"soon"}`: http.StatusBadRequest,
+		`not json`: http.StatusBadRequest,
+	}
+	for payload, status := range invalid {
+		w = httptest.NewRecorder()
+		handler.HandleOverride(w, httptest.NewRequest(http.MethodPost, "/api/dns/schedules/override", bytes.NewBufferString(payload)))
+		if w.Code != status {
+			t.Errorf("Expected status %d for %s, got %d", status, payload, w.Code)
+		}
+	}
+
+	w = httptest.NewRecorder()
+	handler.HandleOverride(w, httptest.NewRequest(http.MethodDelete, "/api/dns/schedules/override?schedule=school-nights", nil))
+	if w.Code != http.StatusOK {
+		t.Errorf("Expected status 200, got %d", w.Code)
+	}
+
+	w = httptest.NewRecorder()
+	handler.HandleOverride(w, httptest.NewRequest(http.MethodDelete, "/api/dns/schedules/override?schedule=school-nights", nil))
+	if w.Code != http.StatusNotFound {
+		t.Errorf("Expected status 404, got %d", w.Code)
+	}
+
+	w = httptest.NewRecorder()
+	handler.HandleSchedules(w, httptest.NewRequest(http.MethodPost, "/api/dns/schedules", nil))
+	if w.Code != http.StatusMethodNotAllowed {
+		t.Errorf("Expected status 405, got %d", w.Code)
+	}
+}
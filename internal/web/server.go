@@ -23,6 +23,7 @@ type Server struct {
 	config     *Config
 	dataSource DataSourceProvider
 	server     *http.Server
+	mux        *http.ServeMux
 	templates  *template.Template
 	wsManager  *WebSocketManager
 }
@@ -88,6 +89,7 @@ func NewServer(config *Config, dataSource DataSourceProvider, logger *logger.Log
 
 	// Setup HTTP server
 	mux := http.NewServeMux()
+	server.mux = mux
 	server.setupRoutes(mux)
 
 	server.server = &http.Server{